@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var agentExecTimeoutFlag time.Duration
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Talk to the QEMU guest agent of a running VM",
+	Long: `Talk to the QEMU guest agent (qemu-ga) running in the guest. Set guest_agent = true
+on the VM to have qqmgr add the agent channel, or add a unix socket chardev with
+id 'qga0' connected to an org.qemu.guest_agent.0 virtserialport yourself.`,
+}
+
+var agentPingCmd = &cobra.Command{
+	Use:   "ping [vm-name]",
+	Short: "Check that the guest agent responds",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		withGuestAgent(vmName, 10*time.Second, func(ctx context.Context, agent *internal.QGAClient) int {
+			if err := agent.Ping(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "Error pinging guest agent: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Guest agent of VM '%s' is responding\n", vmName)
+			return 0
+		})
+	},
+}
+
+var agentInfoCmd = &cobra.Command{
+	Use:   "info [vm-name]",
+	Short: "Show the guest agent version and the commands it supports",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		withGuestAgent(vmName, 10*time.Second, func(ctx context.Context, agent *internal.QGAClient) int {
+			info, err := agent.Info(ctx)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error querying guest agent: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Guest agent version: %s\n", info.Version)
+			fmt.Printf("Supported commands:\n")
+			for _, command := range info.SupportedCommands {
+				state := ""
+				if !command.Enabled {
+					state = " (disabled)"
+				}
+				fmt.Printf("  %s%s\n", command.Name, state)
+			}
+			return 0
+		})
+	},
+}
+
+var agentExecCmd = &cobra.Command{
+	Use:   "exec [vm-name] -- command...",
+	Short: "Run a command in the guest via the guest agent",
+	Long: `Run a command in the guest via the guest agent's guest-exec, without needing SSH.
+The command is not run by a shell, its output is printed once it exited and the
+exit code of qqmgr is the exit code of the command.
+
+Example:
+  qqmgr agent exec test-vm -- /usr/bin/systemctl is-system-running`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		withGuestAgent(vmName, agentExecTimeoutFlag, func(ctx context.Context, agent *internal.QGAClient) int {
+			status, err := agent.Run(ctx, args[1], args[2:], 200*time.Millisecond)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error running command in guest: %v\n", err)
+				return 1
+			}
+
+			os.Stdout.Write(status.OutData)
+			os.Stderr.Write(status.ErrData)
+			if status.OutTruncated || status.ErrTruncated {
+				fmt.Fprintf(os.Stderr, "Warning: output was truncated by the guest agent\n")
+			}
+			switch {
+			case status.ExitCode != nil:
+				return *status.ExitCode
+			case status.Signal != nil:
+				fmt.Fprintf(os.Stderr, "Command killed by signal %d\n", *status.Signal)
+				return 128 + *status.Signal
+			}
+			return 0
+		})
+	},
+}
+
+// withGuestAgent connects to the VM's guest agent, runs fn bounded by timeout
+// and exits with the code it returns
+func withGuestAgent(vmName string, timeout time.Duration, fn func(ctx context.Context, agent *internal.QGAClient) int) {
+	cfg, err := config.LoadConfig(configFile, configOverlays...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Create AppContext
+	appCtx, err := internal.NewAppContext(cfg, configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Resolve VM configuration
+	vmEntry, err := resolveVMEntry(appCtx, vmName)
+	appCtx.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	agent, err := vm.NewManager(vmEntry).GuestAgentClient(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: VM '%s': %v\n", vmName, err)
+		os.Exit(1)
+	}
+
+	exitCode := fn(ctx, agent)
+	agent.Close()
+	os.Exit(exitCode)
+}
+
+func init() {
+	agentExecCmd.Flags().DurationVar(&agentExecTimeoutFlag, "timeout", 5*time.Minute, "Give up waiting for the command after this duration")
+	for _, sub := range []*cobra.Command{agentPingCmd, agentInfoCmd, agentExecCmd} {
+		addExternalQEMUFlags(sub)
+		agentCmd.AddCommand(sub)
+	}
+	rootCmd.AddCommand(agentCmd)
+}
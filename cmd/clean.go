@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var cleanDryRunFlag bool
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove runtime directories of VMs and images no longer in the configuration",
+	Long: `Remove runtime directories of VMs and images which are no longer defined in the configuration file.
+Directories whose PID file points at a running process are never removed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		runtimeDir, err := config.GetRuntimeDir(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error determining runtime directory: %v\n", err)
+			os.Exit(1)
+		}
+
+		orphans, err := vm.FindOrphans(runtimeDir, cfg.ListAllVMs(), cfg.ListImages())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding orphaned runtime directories: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(orphans) == 0 {
+			fmt.Println("No orphaned runtime directories found")
+			return
+		}
+
+		failed := false
+		for _, orphan := range orphans {
+			if orphan.Live {
+				fmt.Printf("Skipping %s (%s '%s' still running with PID %d)\n", orphan.Path, orphan.Kind, orphan.Name, *orphan.PID)
+				continue
+			}
+
+			if cleanDryRunFlag {
+				fmt.Printf("Would remove %s\n", orphan.Path)
+				continue
+			}
+
+			if err := os.RemoveAll(orphan.Path); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing %s: %v\n", orphan.Path, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("Removed %s\n", orphan.Path)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	cleanCmd.Flags().BoolVar(&cleanDryRunFlag, "dry-run", false, "Only report orphaned directories, do not remove them")
+	rootCmd.AddCommand(cleanCmd)
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+var (
+	colorFlag   string
+	noColorFlag bool
+)
+
+const (
+	colorReset = "\033[0m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+)
+
+// shouldUseColor decides whether to colorize output. --no-color and
+// --color=never always win, --color=always forces color, and otherwise color
+// is used on terminals unless NO_COLOR is set (https://no-color.org)
+func shouldUseColor(mode string, noColor bool, noColorEnv string, isTTY bool) bool {
+	if noColor {
+		return false
+	}
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return noColorEnv == "" && isTTY
+}
+
+// isTerminal reports whether f is a character device such as a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorEnabled reports whether stdout output should be colorized
+func colorEnabled() bool {
+	return shouldUseColor(colorFlag, noColorFlag, os.Getenv("NO_COLOR"), isTerminal(os.Stdout))
+}
+
+// colorize wraps s in the given color code if color output is enabled
+func colorize(s, color string) string {
+	if !colorEnabled() {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// stateText returns text colored green if ok and red otherwise
+func stateText(text string, ok bool) string {
+	if ok {
+		return colorize(text, colorGreen)
+	}
+	return colorize(text, colorRed)
+}
+
+// newTable returns a writer aligning tab-separated columns, call Flush when done
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+// validateColorFlag checks the --color value
+func validateColorFlag() error {
+	switch colorFlag {
+	case "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("invalid --color value '%s', expected auto, always or never", colorFlag)
+}
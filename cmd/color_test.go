@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import "testing"
+
+func TestShouldUseColor(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		noColor    bool
+		noColorEnv string
+		isTTY      bool
+		want       bool
+	}{
+		{"auto on terminal", "auto", false, "", true, true},
+		{"auto when piped", "auto", false, "", false, false},
+		{"auto with NO_COLOR", "auto", false, "1", true, false},
+		{"always when piped", "always", false, "", false, true},
+		{"always overrides NO_COLOR", "always", false, "1", false, true},
+		{"never on terminal", "never", false, "", true, false},
+		{"--no-color on terminal", "auto", true, "", true, false},
+		{"--no-color beats always", "always", true, "", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldUseColor(tt.mode, tt.noColor, tt.noColorEnv, tt.isTTY); got != tt.want {
+				t.Errorf("shouldUseColor(%q, %v, %q, %v) = %v, want %v", tt.mode, tt.noColor, tt.noColorEnv, tt.isTTY, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var cpuCmd = &cobra.Command{
+	Use:   "cpu",
+	Short: "Hotplug vCPUs of a running VM",
+	Long: `Add or remove vCPUs of a running VM. The VM must be started with spare CPU
+slots, e.g. '-smp 2,maxcpus=8', and the guest must support CPU hotplug.`,
+}
+
+var cpuAddCmd = &cobra.Command{
+	Use:   "add [vm-name]",
+	Short: "Plug a vCPU into the next free slot",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		withCPUHotplug(vmName, func(ctx context.Context, qmpClient *internal.QMPClient) {
+			slot, id, err := qmpClient.AddCPU(ctx)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error adding vCPU: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Added vCPU '%s' (socket %d, core %d, thread %d) to VM '%s'\n", id,
+				slot.Props["socket-id"], slot.Props["core-id"], slot.Props["thread-id"], vmName)
+		})
+	},
+}
+
+var cpuDelCmd = &cobra.Command{
+	Use:   "del [vm-name] [cpu-id]",
+	Short: "Unplug a hotplugged vCPU",
+	Long: `Unplug a vCPU added by 'qqmgr cpu add', by default the last one added. The
+guest must release the CPU, so it may take a moment to disappear. Boot CPUs
+cannot be unplugged.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		id := ""
+		if len(args) > 1 {
+			id = args[1]
+		}
+		withCPUHotplug(vmName, func(ctx context.Context, qmpClient *internal.QMPClient) {
+			id, err := qmpClient.DelCPU(ctx, id)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing vCPU: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Requested removal of vCPU '%s' from VM '%s'\n", id, vmName)
+		})
+	},
+}
+
+// withCPUHotplug connects to the VM and runs fn, after checking the VM was
+// started with spare CPU slots
+func withCPUHotplug(vmName string, fn func(ctx context.Context, qmpClient *internal.QMPClient)) {
+	cfg, err := config.LoadConfig(configFile, configOverlays...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Create AppContext
+	appCtx, err := internal.NewAppContext(cfg, configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+		os.Exit(1)
+	}
+	defer appCtx.Close()
+
+	// Resolve VM configuration
+	vmEntry, err := resolveVMEntry(appCtx, vmName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The command line of a QEMU started by another tool is unknown
+	if err := checkCPUHotplug(vmEntry.GetFullCommand()); err != nil && socketFlag == "" {
+		fmt.Fprintf(os.Stderr, "Error: VM '%s' %v\n", vmName, err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer qmpClient.Close()
+
+	fn(ctx, qmpClient)
+}
+
+// checkCPUHotplug checks the QEMU arguments leave room for hotplugged vCPUs,
+// which requires -smp with maxcpus=N
+func checkCPUHotplug(args []string) error {
+	for i, arg := range args {
+		if arg != "-smp" || i+1 >= len(args) {
+			continue
+		}
+		for _, opt := range strings.Split(args[i+1], ",") {
+			if strings.HasPrefix(opt, "maxcpus=") {
+				return nil
+			}
+		}
+	}
+	return errors.New("has no spare CPU slots, start it with '-smp N,maxcpus=M' to hotplug vCPUs")
+}
+
+func init() {
+	addExternalQEMUFlags(cpuAddCmd)
+	addExternalQEMUFlags(cpuDelCmd)
+	cpuCmd.AddCommand(cpuAddCmd)
+	cpuCmd.AddCommand(cpuDelCmd)
+	rootCmd.AddCommand(cpuCmd)
+}
@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import "testing"
+
+func TestCheckCPUHotplug(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"maxcpus", []string{"-m", "1G", "-smp", "2,maxcpus=4"}, false},
+		{"maxcpus with other options", []string{"-smp", "cpus=2,sockets=4,maxcpus=4"}, false},
+		{"no maxcpus", []string{"-smp", "2"}, true},
+		{"no smp", []string{"-m", "1G"}, true},
+		{"smp without value", []string{"-smp"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCPUHotplug(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkCPUHotplug(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
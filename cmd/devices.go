@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var devicesJSONFlag bool
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices [vm-name]",
+	Short: "Show the PCI device tree of a virtual machine",
+	Long:  `Show the PCI buses of a running virtual machine and the devices on them, including devices behind bridges.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		buses, err := vm.NewManager(vmEntry).PCIDevices(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error querying PCI devices: %v\n", err)
+			os.Exit(1)
+		}
+
+		if devicesJSONFlag {
+			jsonData, err := json.MarshalIndent(buses, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonData))
+			return
+		}
+
+		printPCITree(os.Stdout, buses)
+	},
+}
+
+func init() {
+	devicesCmd.Flags().BoolVar(&devicesJSONFlag, "json", false, "Output in JSON format")
+	addExternalQEMUFlags(devicesCmd)
+	rootCmd.AddCommand(devicesCmd)
+}
+
+// printPCITree prints each bus with its devices as a tree, nesting the devices behind bridges
+func printPCITree(w io.Writer, buses []internal.PCIBus) {
+	for _, bus := range buses {
+		fmt.Fprintf(w, "bus %02x\n", bus.Bus)
+		printPCIDevices(w, bus.Devices, "")
+	}
+}
+
+// printPCIDevices prints devices as tree branches below prefix
+func printPCIDevices(w io.Writer, devices []internal.PCIDevice, prefix string) {
+	for i, dev := range devices {
+		branch, indent := "├── ", "│   "
+		if i == len(devices)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		desc := dev.ClassInfo.Desc
+		if desc == "" {
+			desc = fmt.Sprintf("class %04x", dev.ClassInfo.Class)
+		}
+		line := fmt.Sprintf("%s %04x:%04x %s", dev.Address(), dev.ID.Vendor, dev.ID.Device, desc)
+		if dev.QdevID != "" {
+			line += fmt.Sprintf(" (id: %s)", dev.QdevID)
+		}
+		fmt.Fprintf(w, "%s%s%s\n", prefix, branch, line)
+
+		if dev.Bridge != nil {
+			printPCIDevices(w, dev.Bridge.Devices, prefix+indent)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpPagingFlag  bool
+	dumpTimeoutFlag time.Duration
+)
+
+var dumpCmd = &cobra.Command{
+	Use:   "dump [vm-name] [output]",
+	Short: "Dump the guest memory to an ELF core file",
+	Long: `Dump the guest memory to an ELF core file for offline analysis with crash or gdb,
+e.g. of a hung guest. QEMU writes the file, so it must be writable by the QEMU
+process. Progress is printed until the dump completes.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		// QEMU may run in another working directory
+		output, err := filepath.Abs(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving output path: %v\n", err)
+			os.Exit(1)
+		}
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx := context.Background()
+		if dumpTimeoutFlag > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, dumpTimeoutFlag)
+			defer cancel()
+		}
+
+		qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		if err := qmpClient.DumpGuestMemory(ctx, output, dumpPagingFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting memory dump: %v\n", err)
+			if errors.Is(err, internal.ErrCommandNotFound) {
+				fmt.Fprintf(os.Stderr, "This QEMU build does not support dump-guest-memory\n")
+			}
+			os.Exit(1)
+		}
+
+		fmt.Printf("Dumping memory of VM '%s' to %s\n", vmName, output)
+		_, err = qmpClient.WaitForDump(ctx, time.Second, func(status internal.DumpStatus) {
+			printDumpProgress(os.Stdout, status)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error dumping memory: %v\n", err)
+			if errors.Is(err, internal.ErrDumpFailed) {
+				fmt.Fprintf(os.Stderr, "Check 'qqmgr stderr %s' for why QEMU could not write the dump\n", vmName)
+			}
+			os.Exit(1)
+		}
+
+		fmt.Printf("Memory dump written to %s\n", output)
+	},
+}
+
+// printDumpProgress prints a line with the progress of a memory dump
+func printDumpProgress(w io.Writer, status internal.DumpStatus) {
+	fmt.Fprintf(w, "%-9s %5.1f%%  %s / %s\n", status.Status, status.Percent(),
+		formatRate(float64(status.Completed)), formatRate(float64(status.Total)))
+}
+
+func init() {
+	dumpCmd.Flags().BoolVar(&dumpPagingFlag, "paging", false, "Resolve guest virtual addresses using the guest page tables (slower)")
+	dumpCmd.Flags().DurationVar(&dumpTimeoutFlag, "timeout", 0, "Give up waiting for the dump after this long (default: wait until done)")
+	addExternalQEMUFlags(dumpCmd)
+	rootCmd.AddCommand(dumpCmd)
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	eventsFollowFlag    bool
+	eventsReconnectFlag bool
+	eventsDurationFlag  time.Duration
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events [vm-name]",
+	Short: "Print the QMP events of a running VM",
+	Long: `Print the QMP events QEMU emits for a running virtual machine, such as RESET,
+STOP, RESUME or SHUTDOWN, as they arrive. Listens for --duration unless --follow
+is given, which keeps printing until interrupted.
+
+With --reconnect, --follow survives the QMP connection dropping, e.g. while the
+VM is restarted: the connection is re-established with backoff and a
+[reconnected] line is printed. It gives up once the QMP socket stays removed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		if eventsReconnectFlag && !eventsFollowFlag {
+			fmt.Fprintf(os.Stderr, "Error: --reconnect requires --follow\n")
+			os.Exit(1)
+		}
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if !eventsFollowFlag {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, eventsDurationFlag)
+			defer cancel()
+		}
+
+		err = vm.NewManager(vmEntry).FollowEvents(ctx, eventsReconnectFlag,
+			func(event *internal.QMPEvent) {
+				fmt.Println(formatEvent(*event))
+			},
+			func() {
+				fmt.Println("[reconnected]")
+			})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	eventsCmd.Flags().BoolVarP(&eventsFollowFlag, "follow", "f", false, "Keep printing events until interrupted")
+	eventsCmd.Flags().BoolVar(&eventsReconnectFlag, "reconnect", false, "With --follow, reconnect when the QMP connection drops")
+	eventsCmd.Flags().DurationVar(&eventsDurationFlag, "duration", 10*time.Second, "How long to listen for events without --follow")
+	addExternalQEMUFlags(eventsCmd)
+	rootCmd.AddCommand(eventsCmd)
+}
@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [vm-name]",
+	Short: "Print the complete runtime state of a VM as JSON",
+	Long: `Print everything known about a VM as one JSON document, e.g. for dashboards:
+the resolved QEMU command and environment, the status, socket readiness, SSH
+access, a recorded guest panic and live QMP details. Parts that cannot be
+determined, such as the QMP details of a stopped VM, are left empty and the
+reason is listed under "errors".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		export := vm.NewManager(vmEntry).Export(ctx)
+		jsonData, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	},
+}
+
+func init() {
+	addExternalQEMUFlags(exportCmd)
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"path/filepath"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	socketFlag  string
+	pidFromFlag string
+)
+
+// addExternalQEMUFlags registers --socket and --pid-from, which point a command at a QEMU
+// started outside qqmgr
+func addExternalQEMUFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&socketFlag, "socket", "", "Use this QMP socket instead of the VM's own, e.g. for a QEMU started by another tool")
+	cmd.Flags().StringVar(&pidFromFlag, "pid-from", "", "Read the QEMU PID from this file instead of the VM's own PID file")
+}
+
+// resolveVMEntry resolves a VM and injects the --socket/--pid-from paths. With --socket
+// the VM does not need to be configured, its remaining runtime files go to vm.<name>
+// in the runtime directory.
+func resolveVMEntry(appCtx *internal.AppContext, vmName string) (*config.VmEntry, error) {
+	vmEntry, err := appCtx.ResolveVM(vmName)
+	if err != nil {
+		if _, configured := appCtx.Config.VMs[vmName]; configured || socketFlag == "" {
+			return nil, err
+		}
+		runtimeDir, err := config.GetRuntimeDir(appCtx.ConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		vmEntry = &config.VmEntry{
+			Name:    vmName,
+			DataDir: filepath.Join(runtimeDir, "vm."+vmName),
+		}
+	}
+
+	vmEntry.QmpSocket = socketFlag
+	vmEntry.PidFile = pidFromFlag
+	return vmEntry, nil
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var fdsetsJSONFlag bool
+
+var fdsetsCmd = &cobra.Command{
+	Use:   "fdsets [vm-name]",
+	Short: "Show the file descriptor sets of a virtual machine",
+	Long: `Show the fdsets QEMU holds, the file descriptors passed to it with add-fd or
+-add-fd which options such as -blockdev reference as /dev/fdset/<id>. Meant for
+debugging advanced storage setups, e.g. LUKS keys or disks handed over as fds.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		fdsets, err := vm.NewManager(vmEntry).Fdsets(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error querying fdsets: %v\n", err)
+			os.Exit(1)
+		}
+
+		if fdsetsJSONFlag {
+			jsonData, err := json.MarshalIndent(fdsets, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonData))
+			return
+		}
+
+		printFdsets(os.Stdout, fdsets)
+	},
+}
+
+func init() {
+	fdsetsCmd.Flags().BoolVar(&fdsetsJSONFlag, "json", false, "Output in JSON format")
+	addExternalQEMUFlags(fdsetsCmd)
+	rootCmd.AddCommand(fdsetsCmd)
+}
+
+// printFdsets prints each fdset with the descriptors in it
+func printFdsets(w io.Writer, fdsets []internal.Fdset) {
+	if len(fdsets) == 0 {
+		fmt.Fprintln(w, "No fdsets")
+		return
+	}
+	for _, fdset := range fdsets {
+		fmt.Fprintf(w, "fdset %d (/dev/fdset/%d)\n", fdset.ID, fdset.ID)
+		for _, fd := range fdset.FDs {
+			if fd.Opaque != "" {
+				fmt.Fprintf(w, "  fd %d: %s\n", fd.FD, fd.Opaque)
+			} else {
+				fmt.Fprintf(w, "  fd %d\n", fd.FD)
+			}
+		}
+	}
+}
@@ -29,7 +29,7 @@ var gdbCmd = &cobra.Command{
 		gdbFlags := args[1:]
 
 		// Load configuration
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
 			os.Exit(1)
@@ -72,6 +72,13 @@ var gdbCmd = &cobra.Command{
 			return
 		}
 
+		// Pick the QEMU binary, honoring a per-VM arch override
+		qemuBin, err := appCtx.Config.ResolveQemuBin(vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving QEMU binary: %v\n", err)
+			os.Exit(1)
+		}
+
 		// Create runtime directory
 		if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
 			fmt.Fprintf(os.Stderr, "Error creating runtime directory: %v\n", err)
@@ -82,7 +89,7 @@ var gdbCmd = &cobra.Command{
 		vmutil.DeleteLogFiles(vmEntry)
 
 		// Generate and launch GDB
-		if err := launchGDB(appCtx.Config.Qemu.Bin, vmEntry, gdbFlags); err != nil {
+		if err := launchGDB(qemuBin, vmEntry, gdbFlags); err != nil {
 			fmt.Fprintf(os.Stderr, "Error launching GDB: %v\n", err)
 			os.Exit(1)
 		}
@@ -5,7 +5,6 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/spf13/cobra"
 )
@@ -13,8 +12,11 @@ import (
 var getCmd = &cobra.Command{
 	Use:   "get [vm-name] [remote-path] [local-path]",
 	Short: "Copy a file from a virtual machine",
-	Long:  `Copy a file from a virtual machine to the local system using SCP.`,
-	Args:  cobra.ExactArgs(3),
+	Long: `Copy a file from a virtual machine to the local system using SCP.
+If local-path is -, the file is streamed to stdout instead, e.g.
+
+  qqmgr get myvm /var/log/syslog - | grep error`,
+	Args: cobra.ExactArgs(3),
 	Run: func(cmd *cobra.Command, args []string) {
 		vmName := args[0]
 		remotePath := args[1]
@@ -40,32 +42,37 @@ var getCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		fmt.Printf("Successfully copied %s from VM %s to %s\n", remotePath, vmName, localPath)
+		// Keep stdout to the file's contents when streaming
+		if localPath != streamPath {
+			fmt.Printf("Successfully copied %s from VM %s to %s\n", remotePath, vmName, localPath)
+		}
 	},
 }
 
 func init() {
+	addSSHTimeoutFlags(getCmd)
 	rootCmd.AddCommand(getCmd)
 }
 
 // executeSCPGet runs the SCP command to copy a file from VM to local
 func executeSCPGet(sshConfigPath string, sshPort int64, remotePath, localPath string) error {
-	// Build SCP command arguments
-	args := []string{
-		"-F", sshConfigPath, // Use generated SSH config
+	return runSSHCommand(scpGetCommand(sshConfigPath, sshPort, remotePath, localPath))
+}
+
+// scpGetCommand returns the command copying remotePath to localPath, which streams
+// the file to stdout through ssh and cat if localPath is -
+func scpGetCommand(sshConfigPath string, sshPort int64, remotePath, localPath string) (string, []string) {
+	args := sshBaseArgs(sshConfigPath, sshConnectTimeoutFlag)
+	if localPath == streamPath {
+		return "ssh", append(args,
+			"-p", fmt.Sprintf("%d", sshPort),
+			"localhost", "cat "+shellQuote(remotePath),
+		)
+	}
+
+	return "scp", append(args,
 		"-P", fmt.Sprintf("%d", sshPort), // SCP port (capital P)
 		fmt.Sprintf("localhost:%s", remotePath), // Remote file path
 		localPath,                               // Local file path
-	}
-
-	// Create command
-	scpCmd := exec.Command("scp", args...)
-
-	// Set up stdin/stdout/stderr
-	scpCmd.Stdin = os.Stdin
-	scpCmd.Stdout = os.Stdout
-	scpCmd.Stderr = os.Stderr
-
-	// Execute SCP command
-	return scpCmd.Run()
+	)
 }
@@ -4,13 +4,27 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
 
 	"qqmgr/internal"
 	"qqmgr/internal/config"
+	"qqmgr/internal/downloader"
+	"qqmgr/internal/img"
 
 	"github.com/spf13/cobra"
 )
 
+var imgBuildWaitQMPFlag time.Duration
+var imgBuildOutputDirFlag string
+var imgBuildDownloadLimitFlag string
+var imgBuildHeadCheckFlag bool
+var imgBuildNoCacheFlag bool
+var imgBuildParallelDownloadsFlag int
+var imgBuildTimingsFlag bool
+
 var imgBuildCmd = &cobra.Command{
 	Use:   "build [image-name]",
 	Short: "Build a VM image",
@@ -20,12 +34,23 @@ var imgBuildCmd = &cobra.Command{
 		imgName := args[0]
 
 		// Load configuration
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
 			fmt.Printf("Error loading config: %v\n", err)
 			return
 		}
 
+		// Apply --set/--set-int overrides to the image env
+		overrides, err := parseSetFlags()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		if err := cfg.OverrideImageEnv(imgName, overrides); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
 		// Create AppContext
 		appCtx, err := internal.NewAppContext(cfg, configFile)
 		if err != nil {
@@ -34,24 +59,85 @@ var imgBuildCmd = &cobra.Command{
 		}
 		defer appCtx.Close()
 
-		// Build the image
-		fmt.Printf("Building image '%s'...\n", imgName)
-		if err := appCtx.BuildImage(imgName); err != nil {
-			fmt.Printf("Error building image: %v\n", err)
+		appCtx.ImgManager.SetQMPWaitTimeout(imgBuildWaitQMPFlag)
+		appCtx.ImgManager.SetNoCache(imgBuildNoCacheFlag)
+		appCtx.ImgManager.SetDownloadHeadCheck(imgBuildHeadCheckFlag)
+		if imgBuildParallelDownloadsFlag < 0 {
+			fmt.Printf("Error: --parallel-downloads must not be negative\n")
 			return
 		}
+		appCtx.ImgManager.SetParallelDownloads(imgBuildParallelDownloadsFlag)
+
+		if imgBuildDownloadLimitFlag != "" {
+			limit, err := downloader.ParseRate(imgBuildDownloadLimitFlag)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+			appCtx.ImgManager.SetDownloadLimit(limit)
+		}
 
-		// Get the image path
-		imagePath, err := appCtx.GetImagePath(imgName)
+		// Build the image
+		infof(os.Stdout, "Building image '%s'...\n", imgName)
+		result, err := appCtx.BuildImage(imgName)
+		if imgBuildTimingsFlag && result != nil {
+			// Also shown for failed builds, to see how far they got
+			defer printBuildTimings(os.Stdout, result.Timings)
+		}
 		if err != nil {
-			fmt.Printf("Error getting image path: %v\n", err)
+			fmt.Printf("Error building image: %v\n", err)
 			return
 		}
 
-		fmt.Printf("Image built successfully: %s\n", imagePath)
+		fmt.Printf("Image built successfully: %s\n", result.ImagePath)
+
+		// Additionally place a copy of the image in the requested directory
+		if imgBuildOutputDirFlag != "" {
+			imgConfig, err := cfg.GetImage(imgName)
+			if err != nil {
+				fmt.Printf("Error getting image config: %v\n", err)
+				return
+			}
+			outputPath := filepath.Join(imgBuildOutputDirFlag, imgName+".img")
+			if err := appCtx.ImgManager.ExportImage(imgName, imgConfig, outputPath); err != nil {
+				fmt.Printf("Error exporting image: %v\n", err)
+				return
+			}
+			fmt.Printf("Image copied to: %s\n", outputPath)
+		}
 	},
 }
 
+// printBuildTimings prints the time spent in each executed build stage and the total
+func printBuildTimings(w io.Writer, timings map[string]time.Duration) {
+	if len(timings) == 0 {
+		return
+	}
+
+	var total time.Duration
+	fmt.Fprintln(w)
+	table := newTable(w)
+	fmt.Fprintln(table, "STAGE\tDURATION")
+	for _, stage := range img.CloudInitStages {
+		elapsed, ok := timings[stage]
+		if !ok {
+			continue
+		}
+		total += elapsed
+		fmt.Fprintf(table, "%s\t%s\n", stage, elapsed.Round(time.Millisecond))
+	}
+	fmt.Fprintf(table, "total\t%s\n", total.Round(time.Millisecond))
+	table.Flush()
+}
+
 func init() {
+	imgBuildCmd.Flags().StringVar(&imgBuildDownloadLimitFlag, "download-limit", "", "Limit download bandwidth per second, e.g. 500K or 5MB (default unlimited)")
+	imgBuildCmd.Flags().IntVar(&imgBuildParallelDownloadsFlag, "parallel-downloads", 0, "How many sources to download at once (default: the image's parallel_downloads, or 1)")
+	imgBuildCmd.Flags().BoolVar(&imgBuildNoCacheFlag, "no-cache", false, "Re-download the base image and sources even if they are in the download cache")
+	imgBuildCmd.Flags().BoolVar(&imgBuildHeadCheckFlag, "head-check", false, "Check download URLs with a HEAD request before downloading, to fail early on missing files")
+	imgBuildCmd.Flags().BoolVar(&imgBuildTimingsFlag, "timings", false, "Print the time spent in each build stage")
+	imgBuildCmd.Flags().StringVar(&imgBuildOutputDirFlag, "output-dir", "", "Also copy the built image to <dir>/<image-name>.img")
+	addSetFlags(imgBuildCmd, "an image env entry")
+	imgBuildCmd.Flags().DurationVar(&imgBuildWaitQMPFlag, "wait-qmp", 30*time.Second, "How long to wait for the build VM's QMP socket, failing fast if QEMU exits meanwhile (0 disables)")
 	imgCmd.AddCommand(imgBuildCmd)
 }
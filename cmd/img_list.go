@@ -5,24 +5,34 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 
+	"qqmgr/internal"
 	"qqmgr/internal/config"
+	"qqmgr/internal/img"
 
 	"github.com/spf13/cobra"
 )
 
+var imgListVerboseFlag bool
+
 var imgListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List configured images",
 	Long:  `List all images defined in the configuration file.`,
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
 			fmt.Printf("Error loading config: %v\n", err)
 			return
 		}
 
+		if imgListVerboseFlag {
+			listImagesVerbose(cfg)
+			return
+		}
+
 		if jsonOutput {
 			// JSON output
 			images := cfg.ListImages()
@@ -65,7 +75,61 @@ var imgListCmd = &cobra.Command{
 	},
 }
 
+// listImagesVerbose lists images along with their build state, output path and recorded manifest
+func listImagesVerbose(cfg *config.Config) {
+	appCtx, err := internal.NewAppContext(cfg, configFile)
+	if err != nil {
+		fmt.Printf("Error creating app context: %v\n", err)
+		return
+	}
+	defer appCtx.Close()
+
+	result := collectImageStatuses(appCtx, cfg)
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fmt.Printf("Error marshaling JSON: %v\n", err)
+			return
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+
+	fmt.Println("Configured Images:")
+	if len(result) == 0 {
+		fmt.Println("  No images configured")
+	}
+	for _, status := range result {
+		built := "not built"
+		if status.Built {
+			built = "built"
+		}
+		fmt.Printf("  %s\t%s\t%s\t%s\t%s\n", status.Name, status.Builder, status.ImgSize, built, status.Path)
+		if status.Error != "" {
+			fmt.Printf("    error: %s\n", status.Error)
+		}
+	}
+}
+
+// collectImageStatuses returns the build state of every configured image
+func collectImageStatuses(appCtx *internal.AppContext, cfg *config.Config) []img.ImageStatus {
+	images := cfg.ListImages()
+	sort.Strings(images)
+	result := make([]img.ImageStatus, 0, len(images))
+	for _, name := range images {
+		imgConfig, err := cfg.GetImage(name)
+		if err != nil {
+			result = append(result, img.ImageStatus{Name: name, Error: err.Error()})
+			continue
+		}
+		result = append(result, appCtx.ImgManager.GetImageStatus(name, imgConfig))
+	}
+	return result
+}
+
 func init() {
 	imgListCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	imgListCmd.Flags().BoolVarP(&imgListVerboseFlag, "verbose", "v", false, "Include build state, image path and recorded manifest")
 	imgCmd.AddCommand(imgListCmd)
 }
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var imgPruneStagesCmd = &cobra.Command{
+	Use:   "prune-stages [image-name]",
+	Short: "Remove intermediate build files of a built image",
+	Long: `Remove the downloaded base image copy (stage1.img) and the cloud-init ISO of a
+built cloud-init image. stage2.img is kept as the final image is an overlay on top
+of it. A later build stays up to date; if a stage has to be rebuilt, the pruned
+files are recreated first (the base image from the download cache).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		imgName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		imgConfig, err := cfg.GetImage(imgName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		removed, err := appCtx.ImgManager.PruneStages(imgName, imgConfig)
+		for _, path := range removed {
+			fmt.Printf("Removed %s\n", path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning stages: %v\n", err)
+			os.Exit(1)
+		}
+		if len(removed) == 0 {
+			fmt.Printf("Nothing to prune for image '%s'\n", imgName)
+		}
+	},
+}
+
+func init() {
+	imgCmd.AddCommand(imgPruneStagesCmd)
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var imgRenderOutputDirFlag string
+
+var imgRenderCmd = &cobra.Command{
+	Use:   "render [image-name]",
+	Short: "Render an image's cloud-init templates",
+	Long: `Run the env hook and render the cloud-init templates of an image without building it.
+Each rendered file is printed with a header, or written to --output-dir if given.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		imgName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+			os.Exit(1)
+		}
+
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		imgConfig, err := cfg.GetImage(imgName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		outputDir := imgRenderOutputDirFlag
+		if outputDir == "" {
+			outputDir, err = os.MkdirTemp("", "qqmgr-render-")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating temporary directory: %v\n", err)
+				os.Exit(1)
+			}
+			defer os.RemoveAll(outputDir)
+		} else if err := os.MkdirAll(outputDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating output directory: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := appCtx.ImgManager.RenderTemplates(imgName, imgConfig, outputDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rendering templates: %v\n", err)
+			os.Exit(1)
+		}
+
+		for i, tmplConfig := range imgConfig.Templates {
+			outputPath := filepath.Join(outputDir, tmplConfig.Output)
+			if imgRenderOutputDirFlag != "" {
+				fmt.Printf("%s -> %s\n", tmplConfig.Template, outputPath)
+				continue
+			}
+
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading rendered file: %v\n", err)
+				os.Exit(1)
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("==> %s (%s) <==\n", tmplConfig.Output, tmplConfig.Template)
+			fmt.Print(string(data))
+		}
+	},
+}
+
+func init() {
+	imgRenderCmd.Flags().StringVar(&imgRenderOutputDirFlag, "output-dir", "", "Write rendered files to this directory instead of printing them")
+	imgCmd.AddCommand(imgRenderCmd)
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	iostatIntervalFlag time.Duration
+	iostatCountFlag    int
+)
+
+var iostatCmd = &cobra.Command{
+	Use:   "iostat [vm-name]",
+	Short: "Show disk I/O rates of a virtual machine",
+	Long: `Sample the block device counters of a virtual machine and print read/write
+throughput and IOPS for each interval. Runs until interrupted unless --count is given.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		if iostatIntervalFlag <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: --interval must be positive\n")
+			os.Exit(1)
+		}
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		if err := runIOStat(ctx, qmpClient, iostatIntervalFlag, iostatCountFlag); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// runIOStat prints block I/O rates every interval, count times or until ctx is done if count is 0
+func runIOStat(ctx context.Context, qmpClient *internal.QMPClient, interval time.Duration, count int) error {
+	prev, err := qmpClient.QueryBlockStats(ctx)
+	if err != nil {
+		return err
+	}
+	prevTime := time.Now()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for i := 0; count == 0 || i < count; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		cur, err := qmpClient.QueryBlockStats(ctx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		table := newTable(os.Stdout)
+		fmt.Fprintf(table, "%s\n", now.Format("15:04:05"))
+		fmt.Fprintln(table, "DEVICE\tREAD/s\tWRITE/s\tR IOPS\tW IOPS")
+		for _, r := range internal.BlockRates(prev, cur, now.Sub(prevTime)) {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%.1f\t%.1f\n", r.Device, formatRate(r.ReadBytesPS), formatRate(r.WriteBytesPS), r.ReadIOPS, r.WriteIOPS)
+		}
+		table.Flush()
+		fmt.Println()
+
+		prev, prevTime = cur, now
+	}
+	return nil
+}
+
+// formatRate formats a bytes per second rate using binary units
+func formatRate(bytesPerSecond float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for bytesPerSecond >= 1024 && i < len(units)-1 {
+		bytesPerSecond /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", bytesPerSecond, units[i])
+}
+
+func init() {
+	iostatCmd.Flags().DurationVarP(&iostatIntervalFlag, "interval", "i", time.Second, "Time between samples")
+	iostatCmd.Flags().IntVarP(&iostatCountFlag, "count", "n", 0, "Number of reports to print (0 runs until interrupted)")
+	addExternalQEMUFlags(iostatCmd)
+	rootCmd.AddCommand(iostatCmd)
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var jobsJSONFlag bool
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs [vm-name]",
+	Short: "Show progress of running block jobs",
+	Long:  `Show the progress of block jobs (mirror, commit, stream, backup) running in a virtual machine.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		jobs, err := vm.NewManager(vmEntry).BlockJobs(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error querying block jobs: %v\n", err)
+			os.Exit(1)
+		}
+
+		if jobsJSONFlag {
+			result := make([]map[string]interface{}, 0, len(jobs))
+			for _, job := range jobs {
+				result = append(result, map[string]interface{}{
+					"device":  job.Device,
+					"type":    job.Type,
+					"offset":  job.Offset,
+					"len":     job.Len,
+					"percent": job.Percent(),
+					"busy":    job.Busy,
+					"paused":  job.Paused,
+					"ready":   job.Ready,
+				})
+			}
+			jsonData, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonData))
+			return
+		}
+
+		if len(jobs) == 0 {
+			fmt.Printf("No block jobs running on VM '%s'\n", vmName)
+			return
+		}
+
+		fmt.Printf("%-16s %-10s %8s  %s\n", "DEVICE", "TYPE", "PROGRESS", "STATE")
+		for _, job := range jobs {
+			state := "running"
+			if job.Paused {
+				state = "paused"
+			} else if job.Ready {
+				state = "ready"
+			}
+			fmt.Printf("%-16s %-10s %7.1f%%  %s\n", job.Device, job.Type, job.Percent(), state)
+		}
+	},
+}
+
+func init() {
+	jobsCmd.Flags().BoolVar(&jobsJSONFlag, "json", false, "Output in JSON format")
+	addExternalQEMUFlags(jobsCmd)
+	rootCmd.AddCommand(jobsCmd)
+}
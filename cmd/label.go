@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"qqmgr/internal/tail"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	prefixFlag    bool
+	labelFlag     string
+	fromStartFlag bool
+	grepFlag      string
+	invertFlag    bool
+)
+
+// addPrefixFlags registers the --prefix and --label flags shared by serial, stdout and stderr
+func addPrefixFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&prefixFlag, "prefix", false, "Prefix each line with the VM name, e.g. to tell apart several --follow sessions")
+	cmd.Flags().StringVar(&labelFlag, "label", "", "Prefix each line with this label instead of the VM name (implies --prefix)")
+}
+
+// outputLabel returns the label to prefix output lines with, or "" for no prefix
+func outputLabel(vmName string) string {
+	if labelFlag != "" {
+		return labelFlag
+	}
+	if prefixFlag {
+		return vmName
+	}
+	return ""
+}
+
+// addFromStartFlag registers the --from-start flag shared by serial, stdout and stderr
+func addFromStartFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&fromStartFlag, "from-start", false, "Follow the output from the beginning of the file, showing everything printed before attaching (implies --follow)")
+}
+
+// addGrepFlags registers the --grep and --invert flags shared by serial, stdout and stderr
+func addGrepFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&grepFlag, "grep", "", "Only show lines matching this regular expression, -n counts matching lines")
+	cmd.Flags().BoolVar(&invertFlag, "invert", false, "Only show lines not matching --grep")
+}
+
+// outputFilter returns the filter selected by --grep and --invert, nil for none
+func outputFilter() (*tail.Filter, error) {
+	return tail.NewFilter(grepFlag, invertFlag)
+}
@@ -3,9 +3,13 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 
+	"qqmgr/internal"
 	"qqmgr/internal/config"
 
 	"github.com/spf13/cobra"
@@ -17,7 +21,7 @@ var listCmd = &cobra.Command{
 	Long:  `List all virtual machines defined in the configuration file.`,
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
 			fmt.Printf("Error loading config: %v\n", err)
 			return
@@ -44,14 +48,33 @@ var listCmd = &cobra.Command{
 		} else {
 			// Human-readable output
 			fmt.Println("Configured VMs:")
-			vms := cfg.ListVMs()
-			if len(vms) == 0 {
+			if len(cfg.ListVMs()) == 0 {
 				fmt.Println("  No VMs configured")
-			} else {
-				for _, name := range vms {
-					fmt.Printf("  %s\n", name)
+				return
+			}
+
+			appCtx, err := internal.NewAppContext(cfg, configFile)
+			if err != nil {
+				fmt.Printf("Error creating app context: %v\n", err)
+				return
+			}
+			defer appCtx.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			table := newTable(os.Stdout)
+			for _, s := range collectVMSummaries(ctx, appCtx, cfg) {
+				state := stateText("stopped", false)
+				if s.Running {
+					state = stateText("running", true)
+				}
+				if s.Error != "" {
+					state = "error: " + s.Error
 				}
+				fmt.Fprintf(table, "  %s\t%s\n", s.Name, state)
 			}
+			table.Flush()
 		}
 	},
 }
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var mediaFormatFlag string
+
+var mediaCmd = &cobra.Command{
+	Use:   "media [vm-name] <device> <iso>",
+	Short: "Change the medium of a removable device",
+	Long: `Swap the medium in a removable device such as a CD-ROM drive of a running VM,
+e.g. to insert the next disc of a multi-stage OS install. The device is the block
+device name as shown by 'info block' in the QEMU monitor (e.g. ide1-cd0).`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName, device := args[0], args[1]
+
+		isoPath, err := filepath.Abs(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving medium path: %v\n", err)
+			os.Exit(1)
+		}
+		if _, err := os.Stat(isoPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		if err := qmpClient.ChangeMedium(ctx, device, isoPath, mediaFormatFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error changing medium: %v\n", err)
+			if errors.Is(err, internal.ErrDeviceNotFound) {
+				fmt.Fprintf(os.Stderr, "Run 'info block' in the QEMU monitor to list the removable devices of VM '%s'\n", vmName)
+			}
+			os.Exit(1)
+		}
+
+		fmt.Printf("Inserted %s into %s\n", isoPath, device)
+	},
+}
+
+func init() {
+	mediaCmd.Flags().StringVar(&mediaFormatFlag, "format", "raw", "Image format of the medium (empty lets QEMU probe it)")
+	addExternalQEMUFlags(mediaCmd)
+	rootCmd.AddCommand(mediaCmd)
+}
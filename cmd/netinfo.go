@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var netinfoJSONFlag bool
+
+var netinfoCmd = &cobra.Command{
+	Use:   "netinfo [vm-name] [device]",
+	Short: "Show the MAC address and receive filters of a VM's NICs",
+	Long: `Show the MAC address and receive filter state (promiscuous mode, unicast,
+multicast and VLAN filtering) of the NICs of a running VM, or of the NIC with the
+given device ID only.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		device := ""
+		if len(args) > 1 {
+			device = args[1]
+		}
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		filters, err := qmpClient.QueryRxFilter(ctx, device)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error querying NICs: %v\n", err)
+			os.Exit(1)
+		}
+
+		if netinfoJSONFlag {
+			jsonData, err := json.MarshalIndent(filters, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonData))
+			return
+		}
+
+		if len(filters) == 0 {
+			fmt.Println("No NICs with receive filters found")
+			return
+		}
+		printRxFilters(os.Stdout, filters)
+	},
+}
+
+func init() {
+	netinfoCmd.Flags().BoolVar(&netinfoJSONFlag, "json", false, "Output in JSON format")
+	addExternalQEMUFlags(netinfoCmd)
+	rootCmd.AddCommand(netinfoCmd)
+}
+
+// printRxFilters prints the MAC address and filter state of each NIC
+func printRxFilters(w io.Writer, filters []internal.RxFilter) {
+	for i, f := range filters {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s\n", f.Name)
+		table := newTable(w)
+		fmt.Fprintf(table, "  MAC:\t%s\n", f.MainMAC)
+		fmt.Fprintf(table, "  Promiscuous:\t%t\n", f.Promiscuous)
+		fmt.Fprintf(table, "  Broadcast:\t%t\n", f.BroadcastAllowed)
+		fmt.Fprintf(table, "  Unicast:\t%s%s\n", f.Unicast, filterTable(f.UnicastTable, f.UnicastOverflow))
+		fmt.Fprintf(table, "  Multicast:\t%s%s\n", f.Multicast, filterTable(f.MulticastTable, f.MulticastOverflow))
+		vlans := make([]string, len(f.VlanTable))
+		for j, id := range f.VlanTable {
+			vlans[j] = fmt.Sprintf("%d", id)
+		}
+		fmt.Fprintf(table, "  VLAN:\t%s%s\n", f.Vlan, filterTable(vlans, false))
+		table.Flush()
+	}
+}
+
+// filterTable formats the entries of a filter table for appending to its mode
+func filterTable(entries []string, overflow bool) string {
+	if len(entries) == 0 && !overflow {
+		return ""
+	}
+	s := " (" + strings.Join(entries, ", ")
+	if overflow {
+		if len(entries) > 0 {
+			s += ", "
+		}
+		s += "table overflowed"
+	}
+	return s + ")"
+}
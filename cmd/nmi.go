@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var nmiCmd = &cobra.Command{
+	Use:   "nmi [vm-name]",
+	Short: "Inject a non-maskable interrupt into a running VM",
+	Long: `Inject an NMI into a running virtual machine, e.g. to make the guest kernel
+panic and write a crash dump. The guest must be configured to act on NMIs, on
+Linux e.g. with kernel.unknown_nmi_panic=1 and kdump set up, otherwise the NMI
+is merely logged or ignored.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		// A paused guest would only see the NMI once resumed
+		status, err := qmpClient.QueryStatus(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error querying VM status: %v\n", err)
+			os.Exit(1)
+		}
+		if !status.Running {
+			fmt.Fprintf(os.Stderr, "Error: VM '%s' is not running (status: %s)\n", vmName, status.Status)
+			os.Exit(1)
+		}
+
+		if err := qmpClient.InjectNMI(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error injecting NMI: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Injected NMI into VM '%s'\n", vmName)
+	},
+}
+
+func init() {
+	addExternalQEMUFlags(nmiCmd)
+	rootCmd.AddCommand(nmiCmd)
+}
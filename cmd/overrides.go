@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"qqmgr/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	setFlag    []string
+	setIntFlag []string
+)
+
+// addSetFlags registers the repeatable --set and --set-int override flags on cmd
+func addSetFlags(cmd *cobra.Command, target string) {
+	cmd.Flags().StringArrayVar(&setFlag, "set", nil, "Override "+target+" with key=value (string), repeatable")
+	cmd.Flags().StringArrayVar(&setIntFlag, "set-int", nil, "Override "+target+" with key=value (integer), repeatable")
+}
+
+// parseSetFlags returns the overrides given through --set and --set-int
+func parseSetFlags() (map[string]interface{}, error) {
+	return config.ParseOverrides(setFlag, setIntFlag)
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/img"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var overviewJSONFlag bool
+
+// vmSummary is the running state of a configured VM
+type vmSummary struct {
+	Name    string `json:"name"`
+	Running bool   `json:"running"`
+	Alive   bool   `json:"alive"`
+	PID     *int   `json:"pid,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// overview is the combined report of all VMs and images in the config
+type overview struct {
+	VMs    []vmSummary       `json:"vms"`
+	Images []img.ImageStatus `json:"images"`
+}
+
+var overviewCmd = &cobra.Command{
+	Use:   "overview",
+	Short: "Show all VMs and images with their status",
+	Long:  `Show every configured VM with its running status and every image with its build status in a single report.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		report := buildOverview(ctx, appCtx, cfg)
+
+		if overviewJSONFlag {
+			jsonData, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonData))
+			return
+		}
+
+		table := newTable(os.Stdout)
+		fmt.Fprintln(table, "VMs:")
+		if len(report.VMs) == 0 {
+			fmt.Fprintln(table, "  No VMs configured")
+		}
+		for _, s := range report.VMs {
+			state := stateText("stopped", false)
+			if s.Running && s.PID != nil {
+				state = stateText(fmt.Sprintf("running (PID: %d)", *s.PID), true)
+			} else if s.Running {
+				state = stateText("running", true)
+			}
+			fmt.Fprintf(table, "  %s\t%s\n", s.Name, state)
+			if s.Error != "" {
+				fmt.Fprintf(table, "    error: %s\n", s.Error)
+			}
+		}
+
+		fmt.Fprintln(table, "Images:")
+		if len(report.Images) == 0 {
+			fmt.Fprintln(table, "  No images configured")
+		}
+		for _, s := range report.Images {
+			built := stateText("not built", false)
+			if s.Built {
+				built = stateText("built", true)
+			}
+			fmt.Fprintf(table, "  %s\t%s\t%s\n", s.Name, s.Builder, built)
+			if s.Error != "" {
+				fmt.Fprintf(table, "    error: %s\n", s.Error)
+			}
+		}
+		table.Flush()
+	},
+}
+
+// buildOverview collects the status of every configured VM and image
+func buildOverview(ctx context.Context, appCtx *internal.AppContext, cfg *config.Config) overview {
+	return overview{
+		VMs:    collectVMSummaries(ctx, appCtx, cfg),
+		Images: collectImageStatuses(appCtx, cfg),
+	}
+}
+
+// collectVMSummaries returns the running state of every configured VM
+func collectVMSummaries(ctx context.Context, appCtx *internal.AppContext, cfg *config.Config) []vmSummary {
+	names := cfg.ListVMs()
+	sort.Strings(names)
+	result := make([]vmSummary, 0, len(names))
+	for _, name := range names {
+		summary := vmSummary{Name: name}
+		vmEntry, err := appCtx.ResolveVM(name)
+		if err != nil {
+			summary.Error = err.Error()
+			result = append(result, summary)
+			continue
+		}
+		status, err := vm.NewManager(vmEntry).GetStatus(ctx)
+		if err != nil {
+			summary.Error = err.Error()
+		} else {
+			summary.Running = status.IsRunning
+			summary.Alive = status.IsAlive
+			summary.PID = status.PID
+		}
+		result = append(result, summary)
+	}
+	return result
+}
+
+func init() {
+	overviewCmd.Flags().BoolVar(&overviewJSONFlag, "json", false, "Output in JSON format")
+	rootCmd.AddCommand(overviewCmd)
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+)
+
+func TestOverviewJSONShape(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.web]
+cmd = ["-m 512"]
+
+[vm.web.ssh]
+port = 2089
+
+[vm.db]
+cmd = ["-m 1024"]
+
+[vm.db.ssh]
+port = 2090
+
+[img.disk]
+builder = "raw"
+img_size = "1G"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	appCtx, err := internal.NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+
+	data, err := json.Marshal(buildOverview(context.Background(), appCtx, cfg))
+	if err != nil {
+		t.Fatalf("Failed to marshal overview: %v", err)
+	}
+
+	var got struct {
+		VMs []struct {
+			Name    string `json:"name"`
+			Running *bool  `json:"running"`
+		} `json:"vms"`
+		Images []struct {
+			Name  string `json:"name"`
+			Built *bool  `json:"built"`
+		} `json:"images"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Failed to parse overview JSON: %v", err)
+	}
+
+	if len(got.VMs) != 2 || got.VMs[0].Name != "db" || got.VMs[1].Name != "web" {
+		t.Fatalf("Expected VMs [db web], got %+v (%s)", got.VMs, data)
+	}
+	for _, v := range got.VMs {
+		if v.Running == nil || *v.Running {
+			t.Errorf("Expected VM %s to report running=false, got %s", v.Name, data)
+		}
+	}
+	if len(got.Images) != 1 || got.Images[0].Name != "disk" {
+		t.Fatalf("Expected images [disk], got %s", data)
+	}
+	if got.Images[0].Built == nil || *got.Images[0].Built {
+		t.Errorf("Expected image disk to report built=false, got %s", data)
+	}
+}
@@ -5,21 +5,33 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
 
 	"github.com/spf13/cobra"
 )
 
+var putAppendFlag bool
+
 var putCmd = &cobra.Command{
 	Use:   "put [vm-name] [local-path] [remote-path]",
 	Short: "Copy a file to a virtual machine",
-	Long:  `Copy a local file to a virtual machine using SCP.`,
-	Args:  cobra.ExactArgs(3),
+	Long: `Copy a local file to a virtual machine using SCP.
+If local-path is -, stdin is streamed to the remote file instead, e.g.
+
+  tar c src | qqmgr put myvm - /tmp/src.tar
+
+With --append, the local file (or stdin) is added to the end of the remote
+file instead of replacing it.`,
+	Args: cobra.ExactArgs(3),
 	Run: func(cmd *cobra.Command, args []string) {
 		vmName := args[0]
 		localPath := args[1]
 		remotePath := args[2]
 
+		if err := checkPutAppend(localPath, putAppendFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
 		// Load configuration and get VM status
 		cfg, _, status, err := loadVMAndCheckStatus(vmName)
 		if err != nil {
@@ -35,16 +47,25 @@ var putCmd = &cobra.Command{
 		}
 
 		// Execute SCP command to upload file
-		if err := executeSCPPut(sshConfigPath, sshPort, localPath, remotePath); err != nil {
+		if err := executeSCPPut(sshConfigPath, sshPort, localPath, remotePath, putAppendFlag); err != nil {
 			fmt.Fprintf(os.Stderr, "Error executing SCP: %v\n", err)
 			os.Exit(1)
 		}
 
+		if localPath == streamPath {
+			localPath = "stdin"
+		}
+		if putAppendFlag {
+			fmt.Printf("Successfully appended %s to %s on VM %s\n", localPath, remotePath, vmName)
+			return
+		}
 		fmt.Printf("Successfully copied %s to %s on VM %s\n", localPath, remotePath, vmName)
 	},
 }
 
 func init() {
+	putCmd.Flags().BoolVar(&putAppendFlag, "append", false, "Append to the remote file instead of overwriting it (not for directories)")
+	addSSHTimeoutFlags(putCmd)
 	rootCmd.AddCommand(putCmd)
 }
 
@@ -52,28 +73,53 @@ func init() {
 func isLocalPathDirectory(path string) bool {
 	info, err := os.Stat(path)
 	// best effort
-	out := err == nil && info.IsDir()
-	fmt.Printf("isLocalPathDirectory\n")
-	if err != nil {
-		fmt.Printf("   err caught\n")
-	} else {
-		fmt.Printf("   err is nil\n")
-	}
-	if info.IsDir() {
-		fmt.Printf("isDir TRUE")
+	return err == nil && info.IsDir()
+}
+
+// checkPutAppend rejects appending a directory, which can only be copied recursively
+func checkPutAppend(localPath string, appendMode bool) error {
+	if appendMode && isLocalPathDirectory(localPath) {
+		return fmt.Errorf("--append cannot be used with directory %s, directories are copied recursively", localPath)
 	}
-	fmt.Printf("isLocalPathDirectory: %b\n", out)
-	return out
+	return nil
 }
 
 // executeSCPPut runs the SCP command to copy a file from local to VM
-func executeSCPPut(sshConfigPath string, sshPort int64, localPath, remotePath string) error {
-	// Build SCP command arguments
-	args := []string{
-		"-F", sshConfigPath, // Use generated SSH config
-		"-P", fmt.Sprintf("%d", sshPort), // SCP port (capital P)
+func executeSCPPut(sshConfigPath string, sshPort int64, localPath, remotePath string, appendMode bool) error {
+	name, args := scpPutCommand(sshConfigPath, sshPort, localPath, remotePath, appendMode)
+	if name == "scp" || localPath == streamPath {
+		return runSSHCommand(name, args)
 	}
 
+	// Appending streams the local file through ssh
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer file.Close()
+	return runSSHCommandWithStdin(name, args, file)
+}
+
+// scpPutCommand returns the command copying localPath to remotePath. If localPath
+// is - or appendMode is set, the data is streamed through ssh to cat, which
+// replaces the remote file or, when appending, adds to its end.
+func scpPutCommand(sshConfigPath string, sshPort int64, localPath, remotePath string, appendMode bool) (string, []string) {
+	args := sshBaseArgs(sshConfigPath, sshConnectTimeoutFlag)
+	if localPath == streamPath || appendMode {
+		redirect := "cat > "
+		if appendMode {
+			redirect = "cat >> "
+		}
+		return "ssh", append(args,
+			"-p", fmt.Sprintf("%d", sshPort),
+			"localhost", redirect+shellQuote(remotePath),
+		)
+	}
+
+	args = append(args,
+		"-P", fmt.Sprintf("%d", sshPort), // SCP port (capital P)
+	)
+
 	if isLocalPathDirectory(localPath) {
 		args = append(args, "-r")
 	}
@@ -83,14 +129,5 @@ func executeSCPPut(sshConfigPath string, sshPort int64, localPath, remotePath st
 		fmt.Sprintf("localhost:%s", remotePath),
 	)
 
-	// Create command
-	scpCmd := exec.Command("scp", args...)
-
-	// Set up stdin/stdout/stderr
-	scpCmd.Stdin = os.Stdin
-	scpCmd.Stdout = os.Stdout
-	scpCmd.Stderr = os.Stderr
-
-	// Execute SCP command
-	return scpCmd.Run()
+	return "scp", args
 }
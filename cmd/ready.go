@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/tail"
+	"qqmgr/internal/vm"
+)
+
+// readyPollInterval is the pause between attempts of the SSH readiness checks
+var readyPollInterval = 2 * time.Second
+
+// waitVMReady blocks until the VM's ready_check passes or its timeout expires
+func waitVMReady(ctx context.Context, appCtx *internal.AppContext, vmEntry *config.VmEntry) error {
+	check := vmEntry.ReadyCheck
+	if check == nil {
+		return fmt.Errorf("VM '%s' has no ready_check configured", vmEntry.Name)
+	}
+	timeout := check.TimeoutDuration()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case check.Serial != "":
+		re, reErr := check.SerialRegexp()
+		if reErr != nil {
+			return reErr
+		}
+		err = waitSerialMatch(ctx, vmEntry.SerialFilePath(), re)
+	default:
+		command := check.Command
+		if command == "" {
+			command = "test -e " + shellQuote(check.File)
+		}
+
+		sshConfigPath, sshErr := internal.GenerateSSHConfig(appCtx, vmEntry.Name)
+		if sshErr != nil {
+			return fmt.Errorf("generating SSH config: %w", sshErr)
+		}
+		status, statusErr := vm.NewManager(vmEntry).GetStatus(ctx)
+		if statusErr != nil {
+			return fmt.Errorf("checking VM status: %w", statusErr)
+		}
+		sshPort, ok := status.SSHPort.(int64)
+		if !ok {
+			return fmt.Errorf("SSH port not configured for VM '%s'", vmEntry.Name)
+		}
+		err = waitSSHCommand(ctx, sshConfigPath, sshPort, command)
+	}
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("VM '%s' did not become ready within %s", vmEntry.Name, timeout)
+	}
+	return err
+}
+
+// waitSerialMatch follows the serial log from its start until a line matches re.
+// A line is matched while it is still being written too, so prompts without a
+// trailing newline are found.
+func waitSerialMatch(ctx context.Context, serialPath string, re *regexp.Regexp) error {
+	// QEMU creates the log shortly after starting
+	for {
+		if _, err := os.Stat(serialPath); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	followCtx, matched := context.WithCancel(ctx)
+	defer matched()
+
+	line := ""
+	err := tail.FollowFileFunc(followCtx, serialPath, true, func(chunk string) {
+		line += chunk
+		if re.MatchString(line) {
+			matched()
+		}
+		if line[len(line)-1] == '\n' {
+			line = ""
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("following serial log: %w", err)
+	}
+	// FollowFileFunc returns nil when cancelled, tell a match from the timeout
+	return ctx.Err()
+}
+
+// waitSSHCommand runs command in the VM over SSH until it exits 0
+func waitSSHCommand(ctx context.Context, sshConfigPath string, sshPort int64, command string) error {
+	return pollSSHCommand(ctx, sshConfigPath, sshPort, command, true)
+}
+
+// pollSSHCommand runs command in the VM over SSH until it succeeds, or with
+// !succeed until it fails, e.g. to see the guest's sshd go away
+func pollSSHCommand(ctx context.Context, sshConfigPath string, sshPort int64, command string, succeed bool) error {
+	args := append(sshBaseArgs(sshConfigPath, 5),
+		"-o", "BatchMode=yes",
+		"-p", fmt.Sprintf("%d", sshPort),
+		"localhost", command,
+	)
+	for {
+		if err := exec.CommandContext(ctx, "ssh", args...).Run(); (err == nil) == succeed {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
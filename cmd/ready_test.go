@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+)
+
+func TestWaitSerialMatch(t *testing.T) {
+	serialPath := filepath.Join(t.TempDir(), "serial.log")
+	re := regexp.MustCompile(`^test-vm login: `)
+
+	// The log appears after the wait started and the prompt has no trailing newline
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(serialPath, []byte("[    0.000000] Linux version 6.8\n"), 0644)
+		time.Sleep(200 * time.Millisecond)
+		file, err := os.OpenFile(serialPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		fmt.Fprint(file, "test-vm ")
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprint(file, "login: ")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := waitSerialMatch(ctx, serialPath, re); err != nil {
+		t.Fatalf("Expected the login prompt to match, got %v", err)
+	}
+
+	// A pattern that never shows up runs into the timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := waitSerialMatch(ctx, serialPath, regexp.MustCompile(`Cloud-init .* finished`)); err == nil {
+		t.Fatal("Expected a timeout for a pattern that never matches")
+	}
+}
+
+func TestWaitVMReadySSH(t *testing.T) {
+	defer func(interval time.Duration) { readyPollInterval = interval }(readyPollInterval)
+	readyPollInterval = 10 * time.Millisecond
+
+	tempDir := t.TempDir()
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create bin dir: %v", err)
+	}
+
+	// Mock ssh logs the remote command and fails the first two attempts, and "false" always
+	sshLog := filepath.Join(tempDir, "ssh.log")
+	sshScript := fmt.Sprintf(`#!/bin/sh
+for last in "$@"; do :; done
+echo "$last" >> %s
+[ "$last" != false ] && [ $(wc -l < %s) -ge 3 ]
+`, sshLog, sshLog)
+	if err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(sshScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.cmd]
+cmd = ["-nodefaults"]
+ssh = { port = 2222 }
+ready_check = { command = "systemctl is-system-running --wait" }
+
+[vm.file]
+cmd = ["-nodefaults"]
+ssh = { port = 2223 }
+ready_check = { file = "/var/lib/cloud/instance/boot-finished" }
+
+[vm.slow]
+cmd = ["-nodefaults"]
+ssh = { port = 2224 }
+ready_check = { command = "false", timeout = 1 }
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	appCtx, err := internal.NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+
+	tests := []struct {
+		vm      string
+		command string
+	}{
+		{"cmd", "systemctl is-system-running --wait"},
+		{"file", "test -e '/var/lib/cloud/instance/boot-finished'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.vm, func(t *testing.T) {
+			os.Remove(sshLog)
+			vmEntry, err := appCtx.ResolveVM(tt.vm)
+			if err != nil {
+				t.Fatalf("Failed to resolve VM: %v", err)
+			}
+			if err := waitVMReady(context.Background(), appCtx, vmEntry); err != nil {
+				t.Fatalf("waitVMReady() failed: %v", err)
+			}
+
+			logged, err := os.ReadFile(sshLog)
+			if err != nil {
+				t.Fatalf("Expected the check to run via ssh: %v", err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(logged)), "\n")
+			if len(lines) != 3 || lines[2] != tt.command {
+				t.Errorf("Expected three attempts of %q, got %q", tt.command, lines)
+			}
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		vmEntry, err := appCtx.ResolveVM("slow")
+		if err != nil {
+			t.Fatalf("Failed to resolve VM: %v", err)
+		}
+		err = waitVMReady(context.Background(), appCtx, vmEntry)
+		if err == nil || !strings.Contains(err.Error(), "did not become ready within 1s") {
+			t.Errorf("Expected a timeout error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var rebootTimeoutFlag time.Duration
+
+// rebootSSHDownTimeout bounds the wait for SSH to go away after a reset
+var rebootSSHDownTimeout = 30 * time.Second
+
+var rebootCmd = &cobra.Command{
+	Use:   "reboot [vm-name]",
+	Short: "Reset a running VM and wait for SSH to come back",
+	Long: `Reset a running virtual machine via QMP system_reset and wait until the guest's
+SSH server answers again, e.g. after a kernel upgrade. The reset is a hard
+reset, the guest is not shut down first.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), rebootTimeoutFlag)
+		defer cancel()
+
+		start := time.Now()
+		err = rebootVM(ctx, appCtx, vmEntry, func(step string) {
+			infof(os.Stdout, "%s...\n", step)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error rebooting VM '%s': %v\n", vmName, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("VM '%s' is reachable again after %s\n", vmName, time.Since(start).Round(time.Second))
+	},
+}
+
+// rebootVM resets the running guest and waits until its SSH server answers
+// again. After QEMU confirms the reset, SSH is given a moment to go away before
+// waiting for it to come back, so the check does not pass against the guest's
+// old sshd. progress is called before each step.
+func rebootVM(ctx context.Context, appCtx *internal.AppContext, vmEntry *config.VmEntry, progress func(step string)) error {
+	sshPort, ok := vmEntry.SSHPort()
+	if !ok {
+		return fmt.Errorf("SSH port not configured for VM '%s'", vmEntry.Name)
+	}
+	sshConfigPath, err := internal.GenerateSSHConfig(appCtx, vmEntry.Name)
+	if err != nil {
+		return fmt.Errorf("generating SSH config: %w", err)
+	}
+
+	progress("Resetting guest")
+	if err := vm.NewManager(vmEntry).Reset(ctx); err != nil {
+		return err
+	}
+
+	// A guest that resets faster than we poll is not waited for
+	progress(fmt.Sprintf("Waiting for SSH on port %d to go down", sshPort))
+	downCtx, cancel := context.WithTimeout(ctx, rebootSSHDownTimeout)
+	err = pollSSHCommand(downCtx, sshConfigPath, sshPort, "true", false)
+	cancel()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	progress(fmt.Sprintf("Waiting for SSH on port %d to come back", sshPort))
+	if err := waitSSHCommand(ctx, sshConfigPath, sshPort, "true"); err != nil {
+		return fmt.Errorf("SSH did not come back: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	rebootCmd.Flags().DurationVar(&rebootTimeoutFlag, "timeout", 5*time.Minute, "How long to wait for SSH to come back")
+	addExternalQEMUFlags(rebootCmd)
+	rootCmd.AddCommand(rebootCmd)
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+)
+
+// serveResetQMP answers QMP on socketPath for a running guest, confirming
+// system_reset with a RESET event
+func serveResetQMP(t *testing.T, socketPath string) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create QMP socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprintln(conn, `{"QMP":{"version":{},"capabilities":[]}}`)
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					switch {
+					case strings.Contains(scanner.Text(), "query-status"):
+						fmt.Fprintln(conn, `{"return":{"running":true,"singlestep":false,"status":"running"}}`)
+					case strings.Contains(scanner.Text(), "system_reset"):
+						fmt.Fprintln(conn, `{"return":{}}`)
+						fmt.Fprintln(conn, `{"event":"RESET","data":{"guest":false,"reason":"host-qmp-system-reset"},"timestamp":{"seconds":1700000000,"microseconds":0}}`)
+					default:
+						fmt.Fprintln(conn, `{"return":{}}`)
+					}
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestRebootVM(t *testing.T) {
+	defer func(interval time.Duration) { readyPollInterval = interval }(readyPollInterval)
+	readyPollInterval = 10 * time.Millisecond
+
+	tempDir := t.TempDir()
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create bin dir: %v", err)
+	}
+
+	// Mock ssh succeeds while the guest's sshd is up
+	upFile := filepath.Join(tempDir, "sshd-up")
+	sshScript := fmt.Sprintf("#!/bin/sh\ntest -e %s\n", upFile)
+	if err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(sshScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+	if err := os.WriteFile(upFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to mark sshd up: %v", err)
+	}
+
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.test-vm]
+cmd = ["-nodefaults"]
+ssh = { port = 2222 }
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	appCtx, err := internal.NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+	vmEntry, err := appCtx.ResolveVM("test-vm")
+	if err != nil {
+		t.Fatalf("Failed to resolve VM: %v", err)
+	}
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		t.Fatalf("Failed to create runtime directory: %v", err)
+	}
+	serveResetQMP(t, vmEntry.QmpSocketPath())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The guest's sshd goes away with the reset and comes back a little later
+	var steps []string
+	err = rebootVM(ctx, appCtx, vmEntry, func(step string) {
+		steps = append(steps, step)
+		if strings.Contains(step, "go down") {
+			os.Remove(upFile)
+			time.AfterFunc(200*time.Millisecond, func() { os.WriteFile(upFile, nil, 0644) })
+		}
+	})
+	if err != nil {
+		t.Fatalf("rebootVM() failed: %v", err)
+	}
+
+	want := []string{
+		"Resetting guest",
+		"Waiting for SSH on port 2222 to go down",
+		"Waiting for SSH on port 2222 to come back",
+	}
+	if strings.Join(steps, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected steps %q, got %q", want, steps)
+	}
+	if _, err := os.Stat(upFile); err != nil {
+		t.Error("rebootVM() returned before SSH came back")
+	}
+
+	// Without an SSH port there is nothing to wait for
+	noSSH := *vmEntry
+	noSSH.Vars = nil
+	if err := rebootVM(ctx, appCtx, &noSSH, func(string) {}); err == nil || !strings.Contains(err.Error(), "SSH port not configured") {
+		t.Errorf("Expected an SSH port error, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var resumeTimeoutFlag int
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [vm-name]",
+	Short: "Resume a paused virtual machine",
+	Long: `Resume a paused virtual machine and wait until QEMU reports it running again.
+Fails if the VM is still stopped after the timeout, e.g. because it paused again
+on an I/O error from a full disk.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		timeout := time.Duration(resumeTimeoutFlag) * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), timeout+10*time.Second)
+		defer cancel()
+
+		qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		if _, err := qmpClient.Resume(ctx, 200*time.Millisecond, timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error resuming VM '%s': %v\n", vmName, err)
+			if errors.Is(err, internal.ErrNotResumed) {
+				fmt.Fprintf(os.Stderr, "Check 'qqmgr status %s' and the QEMU logs for why it stays stopped\n", vmName)
+			}
+			os.Exit(1)
+		}
+
+		fmt.Printf("VM '%s' is running\n", vmName)
+	},
+}
+
+func init() {
+	resumeCmd.Flags().IntVar(&resumeTimeoutFlag, "timeout", 10, "Seconds to wait for the VM to run again")
+	addExternalQEMUFlags(resumeCmd)
+	rootCmd.AddCommand(resumeCmd)
+}
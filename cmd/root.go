@@ -6,12 +6,25 @@ import (
 	"fmt"
 	"os"
 
+	"qqmgr/internal/config"
+
 	"github.com/spf13/cobra"
 )
 
 var (
-	configFile string
-	debugFlag  bool
+	// configFiles holds the -c flags. The first is the base configuration
+	// (configFile) the runtime directory is derived from, the others
+	// (configOverlays) are merged on top of it in order.
+	configFiles    []string
+	configFile     string
+	configOverlays []string
+
+	debugFlag bool
+	traceFlag string
+	// traceStdoutFlag mirrors traces to the terminal, on stderr to keep command output clean
+	traceStdoutFlag bool
+	// strictFlag turns unknown configuration keys into errors
+	strictFlag bool
 )
 
 var rootCmd = &cobra.Command{
@@ -19,6 +32,30 @@ var rootCmd = &cobra.Command{
 	Short: "Quick QEMU Manager - A CLI tool for managing QEMU virtual machines",
 	Long: `qqmgr is a CLI tool for managing QEMU virtual machines in development contexts.
 It provides simple commands to start, stop, and manage VMs defined in configuration files.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(configFiles) > 0 {
+			configFile, configOverlays = configFiles[0], configFiles[1:]
+		}
+		// --trace takes precedence over QQMGR_TRACE, which NewAppContext reads
+		if traceFlag != "" {
+			if err := os.Setenv("QQMGR_TRACE", traceFlag); err != nil {
+				return err
+			}
+		}
+		if traceStdoutFlag {
+			if err := os.Setenv("QQMGR_TRACE_STDOUT", "1"); err != nil {
+				return err
+			}
+		}
+		if err := validateColorFlag(); err != nil {
+			return err
+		}
+		// validate reports unknown keys itself, alongside its other findings
+		if strictFlag && cmd != validateCmd {
+			return checkStrictConfig()
+		}
+		return nil
+	},
 }
 
 func Execute() {
@@ -28,8 +65,25 @@ func Execute() {
 	}
 }
 
+// checkStrictConfig fails if the configuration has keys the decoder ignored, such
+// as misspelled settings. A configuration which fails to load is left for the
+// command to report.
+func checkStrictConfig() error {
+	cfg, err := config.LoadConfig(configFile, configOverlays...)
+	if err != nil {
+		return nil
+	}
+	return cfg.CheckUnknownKeys()
+}
+
 func init() {
 	// Global flags
-	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Configuration file path (default: ./qqmgr.toml or ~/.config/qqmgr/conf.toml)")
+	rootCmd.PersistentFlags().StringArrayVarP(&configFiles, "config", "c", nil, "Configuration file path (default: nearest qqmgr.toml in current or parent dirs, or ~/.config/qqmgr/conf.toml), repeat to merge further files on top, later ones win")
 	rootCmd.PersistentFlags().BoolVarP(&debugFlag, "debug", "d", false, "Enable debug output")
+	rootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "Only print errors and results, no progress or informational messages")
+	rootCmd.PersistentFlags().StringVar(&traceFlag, "trace", "", "Comma-separated trace categories to log, overrides QQMGR_TRACE and [trace] patterns")
+	rootCmd.PersistentFlags().BoolVar(&traceStdoutFlag, "trace-stdout", false, "Also print traces to the terminal (stderr), same as QQMGR_TRACE_STDOUT=1")
+	rootCmd.PersistentFlags().BoolVar(&strictFlag, "strict", false, "Fail on unknown configuration keys, e.g. misspelled settings, instead of ignoring them")
+	rootCmd.PersistentFlags().StringVar(&colorFlag, "color", "auto", "Colorize output: auto, always or never (auto honors NO_COLOR and disables color when not a terminal)")
+	rootCmd.PersistentFlags().BoolVar(&noColorFlag, "no-color", false, "Disable colored output, same as --color=never")
 }
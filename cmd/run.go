@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+	"qqmgr/internal/vmutil"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	runSSHWaitFlag     time.Duration
+	runStopTimeoutFlag int
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [vm-name] -- command...",
+	Short: "Build, start a VM, run a command in it via SSH and stop it again",
+	Long: `Build any image the VM depends on, start the VM, wait for SSH, run the command
+in the VM and stop the VM again, even if a step fails. The exit code of qqmgr
+is the exit code of the remote command.
+
+Example:
+  qqmgr run test-vm -- make test`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		command := strings.Join(args[1:], " ")
+
+		// Load configuration
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Interrupts abort the current step, the VM is still stopped afterwards
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		exitCode, err := runInVM(ctx, appCtx, vmName, command)
+		stop()
+		appCtx.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(exitCode)
+	},
+}
+
+func init() {
+	addSSHTimeoutFlags(runCmd)
+	runCmd.Flags().DurationVar(&runSSHWaitFlag, "ssh-wait", 5*time.Minute, "How long to wait for SSH to become reachable after starting the VM")
+	runCmd.Flags().IntVar(&runStopTimeoutFlag, "stop-timeout", 20, "Timeout in seconds for the graceful shutdown before the VM is killed")
+	rootCmd.AddCommand(runCmd)
+}
+
+// runInVM builds the VM's images, starts it, runs command over SSH and stops the VM
+// again. Returns the exit code of the remote command.
+func runInVM(ctx context.Context, appCtx *internal.AppContext, vmName string, command string) (int, error) {
+	// Build the images referenced by the VM's cmd
+	deps, err := appCtx.Config.VMImageDependencies(vmName)
+	if err != nil {
+		return 0, err
+	}
+	for _, imgName := range deps {
+		infof(os.Stderr, "Building image '%s'...\n", imgName)
+		if _, err := appCtx.BuildImage(imgName); err != nil {
+			return 0, fmt.Errorf("building image '%s': %w", imgName, err)
+		}
+	}
+
+	vmEntry, err := appCtx.ResolveVM(vmName)
+	if err != nil {
+		return 0, fmt.Errorf("resolving VM configuration: %w", err)
+	}
+	warnIfDisabled(appCtx.Config, vmName)
+	if err := validateVMArguments(vmEntry.Cmd); err != nil {
+		return 0, fmt.Errorf("validating VM arguments: %w", err)
+	}
+
+	// Hold the VM's lock until QEMU is up, like start
+	lock, err := vmutil.LockVM(vmEntry)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	// Refuse to take over a VM we did not start, we would stop it afterwards
+	manager := vm.NewManager(vmEntry)
+	if err := manager.EnsureStopped(ctx); err != nil {
+		return 0, err
+	}
+	if err := vmutil.CheckHostPorts(vmEntry, appCtx.Config.HostPortOwners(vmName)); err != nil {
+		return 0, err
+	}
+	if err := vmEntry.CheckHugepages(); err != nil {
+		return 0, err
+	}
+
+	qemuBin, err := appCtx.Config.ResolveQemuBin(vmName)
+	if err != nil {
+		return 0, fmt.Errorf("resolving QEMU binary: %w", err)
+	}
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		return 0, fmt.Errorf("creating runtime directory: %w", err)
+	}
+	if vmEntry.KeepLogs {
+		if err := vmutil.RotateLogFiles(vmEntry); err != nil {
+			return 0, fmt.Errorf("rotating log files: %w", err)
+		}
+	} else {
+		vmutil.DeleteLogFiles(vmEntry)
+	}
+
+	infof(os.Stderr, "Starting VM '%s'...\n", vmName)
+	err = startVM(qemuBin, vmEntry)
+	lock.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("starting VM: %w", err)
+	}
+	defer stopRunVM(manager, vmName)
+
+	sshConfigPath, err := internal.GenerateSSHConfig(appCtx, vmName)
+	if err != nil {
+		return 0, fmt.Errorf("generating SSH config: %w", err)
+	}
+	status, err := manager.GetStatus(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("checking VM status: %w", err)
+	}
+	sshPort, ok := status.SSHPort.(int64)
+	if !ok {
+		return 0, fmt.Errorf("SSH port not configured for VM '%s'", vmName)
+	}
+
+	// Fail fast if the guest panics instead of waiting for SSH or the command to time out
+	runCtx, cancelRun := context.WithCancelCause(ctx)
+	watchDone := manager.WatchPanic(runCtx, func(event *internal.QMPEvent) {
+		cancelRun(fmt.Errorf("%w: %v", vm.ErrGuestPanicked, event.Data))
+	})
+	// End the watch before the deferred stop
+	defer func() {
+		cancelRun(nil)
+		<-watchDone
+	}()
+
+	infof(os.Stderr, "Waiting for SSH on port %d...\n", sshPort)
+	err = waitForSSH(runCtx, sshConfigPath, sshPort, runSSHWaitFlag)
+	if cause := context.Cause(runCtx); errors.Is(cause, vm.ErrGuestPanicked) {
+		return 0, cause
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	exitCode, err := runRemoteCommand(runCtx, sshConfigPath, sshPort, command)
+	if cause := context.Cause(runCtx); errors.Is(cause, vm.ErrGuestPanicked) {
+		return 0, cause
+	}
+	return exitCode, err
+}
+
+// stopRunVM stops a VM started by run, reporting but not failing on errors
+func stopRunVM(manager *vm.Manager, vmName string) {
+	infof(os.Stderr, "Stopping VM '%s'...\n", vmName)
+	timeout := time.Duration(runStopTimeoutFlag) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+5*time.Second)
+	defer cancel()
+
+	if _, err := manager.Stop(ctx, timeout, true); err != nil {
+		fmt.Fprintf(os.Stderr, "Error stopping VM '%s': %v\n", vmName, err)
+	}
+}
+
+// waitForSSH polls the VM with a no-op ssh command until it succeeds or timeout expires
+func waitForSSH(ctx context.Context, sshConfigPath string, sshPort int64, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := waitSSHCommand(ctx, sshConfigPath, sshPort, "true"); err != nil {
+		return fmt.Errorf("SSH did not become reachable within %s", timeout)
+	}
+	return nil
+}
+
+// runRemoteCommand runs command in the VM attached to the terminal and returns its exit code
+func runRemoteCommand(ctx context.Context, sshConfigPath string, sshPort int64, command string) (int, error) {
+	if sshDeadlineFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sshDeadlineFlag)
+		defer cancel()
+	}
+
+	args := append(sshBaseArgs(sshConfigPath, sshConnectTimeoutFlag),
+		"-p", fmt.Sprintf("%d", sshPort),
+		"localhost", command,
+	)
+	sshCmd := exec.CommandContext(ctx, "ssh", args...)
+	sshCmd.Stdin = os.Stdin
+	sshCmd.Stdout = os.Stdout
+	sshCmd.Stderr = os.Stderr
+
+	err := sshCmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, fmt.Errorf("ssh did not finish within %s", sshDeadlineFlag)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("running ssh: %w", err)
+	}
+	return 0, nil
+}
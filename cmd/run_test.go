@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+)
+
+func TestRunInVMWithMockQEMUAndSSH(t *testing.T) {
+	tempDir := t.TempDir()
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create bin dir: %v", err)
+	}
+
+	// Mock QEMU writes its PID file, creates the QMP socket path and keeps running until killed
+	mockQEMU := filepath.Join(binDir, "qemu-system-x86_64")
+	qemuScript := `#!/bin/sh
+prev=""
+for arg in "$@"; do
+    if [ "$prev" = "-pidfile" ]; then
+        echo $$ > "$arg"
+    fi
+    if [ "$prev" = "-qmp" ]; then
+        qmp="${arg#unix:}"
+        touch "${qmp%%,*}"
+    fi
+    prev="$arg"
+done
+exec sleep 30
+`
+	if err := os.WriteFile(mockQEMU, []byte(qemuScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU: %v", err)
+	}
+
+	// Mock ssh answers the readiness probe and fails the real command with code 7
+	sshLog := filepath.Join(tempDir, "ssh.log")
+	sshScript := fmt.Sprintf(`#!/bin/sh
+for last in "$@"; do :; done
+if [ "$last" = "true" ]; then
+    exit 0
+fi
+echo "$last" >> %s
+exit 7
+`, sshLog)
+	if err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(sshScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := fmt.Sprintf(`[qemu]
+bin = "%s"
+
+[vm.test]
+cmd = ["-nodefaults"]
+
+[vm.test.ssh]
+port = 2222
+`, mockQEMU)
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	appCtx, err := internal.NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+
+	exitCode, err := runInVM(context.Background(), appCtx, "test", "make test")
+	if err != nil {
+		t.Fatalf("runInVM() failed: %v", err)
+	}
+	if exitCode != 7 {
+		t.Errorf("Expected exit code 7 from the remote command, got %d", exitCode)
+	}
+
+	logged, err := os.ReadFile(sshLog)
+	if err != nil {
+		t.Fatalf("Expected the command to be run via ssh: %v", err)
+	}
+	if strings.TrimSpace(string(logged)) != "make test" {
+		t.Errorf("Expected ssh to run 'make test', got %q", logged)
+	}
+
+	// The VM must have been stopped again
+	vmEntry, err := appCtx.ResolveVM("test")
+	if err != nil {
+		t.Fatalf("Failed to resolve VM: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, err := vm.NewManager(vmEntry).GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get VM status: %v", err)
+	}
+	if status.IsRunning {
+		t.Error("Expected the VM to be stopped after run")
+	}
+}
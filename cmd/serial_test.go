@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"qqmgr/internal/config"
+	"qqmgr/internal/tail"
 )
 
 func TestShowLastLines(t *testing.T) {
@@ -45,9 +46,9 @@ func TestShowLastLines(t *testing.T) {
 	tempFile.Close()
 
 	// Test showing last 5 lines
-	err = showLastLines(tempFile.Name(), 5)
+	err = tail.ShowLastLines(tempFile.Name(), 5)
 	if err != nil {
-		t.Fatalf("showLastLines() failed: %v", err)
+		t.Fatalf("tail.ShowLastLines() failed: %v", err)
 	}
 }
 
@@ -74,9 +75,9 @@ func TestShowLastLinesWithFewerLines(t *testing.T) {
 	tempFile.Close()
 
 	// Test showing last 10 lines (should show all 3)
-	err = showLastLines(tempFile.Name(), 10)
+	err = tail.ShowLastLines(tempFile.Name(), 10)
 	if err != nil {
-		t.Fatalf("showLastLines() failed: %v", err)
+		t.Fatalf("tail.ShowLastLines() failed: %v", err)
 	}
 }
 
@@ -90,19 +91,19 @@ func TestShowLastLinesWithEmptyFile(t *testing.T) {
 	tempFile.Close()
 
 	// Test showing last 5 lines from empty file
-	err = showLastLines(tempFile.Name(), 5)
+	err = tail.ShowLastLines(tempFile.Name(), 5)
 	if err != nil {
-		t.Fatalf("showLastLines() failed: %v", err)
+		t.Fatalf("tail.ShowLastLines() failed: %v", err)
 	}
 }
 
 func TestShowLastLinesWithNonexistentFile(t *testing.T) {
 	// Test with a file that doesn't exist
-	err := showLastLines("/nonexistent/file", 5)
+	err := tail.ShowLastLines("/nonexistent/file", 5)
 	if err == nil {
-		t.Error("showLastLines() should fail with nonexistent file")
+		t.Error("tail.ShowLastLines() should fail with nonexistent file")
 	}
-	if !strings.Contains(err.Error(), "failed to open serial file") {
+	if !strings.Contains(err.Error(), "failed to open file") {
 		t.Errorf("Expected error about opening file, got: %v", err)
 	}
 }
@@ -124,7 +125,7 @@ func TestFollowSerialOutput(t *testing.T) {
 	// Start following in a goroutine
 	done := make(chan error, 1)
 	go func() {
-		done <- followSerialOutput(tempFile.Name())
+		done <- tail.FollowFileOutput(tempFile.Name())
 	}()
 
 	// Wait a bit for the follow to start
@@ -153,7 +154,7 @@ func TestFollowSerialOutput(t *testing.T) {
 	select {
 	case err := <-done:
 		if err != nil {
-			t.Errorf("followSerialOutput() failed unexpectedly: %v", err)
+			t.Errorf("FollowFileOutput() failed unexpectedly: %v", err)
 		}
 	default:
 		// This is expected - the follow should still be running
@@ -191,9 +192,9 @@ func TestDisplaySerialOutput(t *testing.T) {
 	}
 
 	// Test displaying last lines
-	err = displaySerialOutput(vmEntry, false, 5)
+	err = tail.DisplayFileOutput(vmEntry.SerialFilePath(), false, false, tail.Lines{Count: 5}, nil, "")
 	if err != nil {
-		t.Fatalf("displaySerialOutput() failed: %v", err)
+		t.Fatalf("DisplayFileOutput() failed: %v", err)
 	}
 }
 
@@ -217,12 +218,12 @@ func TestDisplaySerialOutputWithNonexistentFile(t *testing.T) {
 	}
 
 	// Test with nonexistent serial file
-	err = displaySerialOutput(vmEntry, false, 5)
+	err = tail.DisplayFileOutput(vmEntry.SerialFilePath(), false, false, tail.Lines{Count: 5}, nil, "")
 	if err == nil {
-		t.Error("displaySerialOutput() should fail with nonexistent serial file")
+		t.Error("DisplayFileOutput() should fail with nonexistent serial file")
 	}
-	if !strings.Contains(err.Error(), "serial file not found") {
-		t.Errorf("Expected error about serial file not found, got: %v", err)
+	if !strings.Contains(err.Error(), "file not found") {
+		t.Errorf("Expected error about file not found, got: %v", err)
 	}
 }
 
@@ -294,9 +295,9 @@ vm_port = 22
 	}
 
 	// Test the serial command functionality
-	// We'll test the displaySerialOutput function directly since it's the core functionality
-	err = displaySerialOutput(vmEntry, false, 2)
+	// We'll test DisplayFileOutput on the serial file directly since it's the core functionality
+	err = tail.DisplayFileOutput(vmEntry.SerialFilePath(), false, false, tail.Lines{Count: 2}, nil, "")
 	if err != nil {
-		t.Fatalf("displaySerialOutput() failed: %v", err)
+		t.Fatalf("DisplayFileOutput() failed: %v", err)
 	}
 }
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotFreezeFlag  bool
+	snapshotTimeoutFlag time.Duration
+)
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage internal snapshots of a running VM",
+}
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create [vm-name] [snapshot-name]",
+	Short: "Take an internal snapshot of a running VM",
+	Long: `Take an internal snapshot of the VM's state and disks with savevm. All writable
+disks of the VM must be qcow2 images, the snapshot is stored inside them.
+
+With --freeze, the guest's filesystems are frozen through the guest agent while
+the snapshot is taken, so it holds them in a consistent state. They are thawed
+afterwards, also if taking the snapshot failed. Without a responding guest agent
+a warning is printed and the snapshot is taken unfrozen.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName, snapshotName := args[0], args[1]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		ctx, cancel := context.WithTimeout(ctx, snapshotTimeoutFlag)
+		defer cancel()
+
+		err = vm.NewManager(vmEntry).CreateSnapshot(ctx, snapshotName, snapshotFreezeFlag, func(warning string) {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating snapshot: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created snapshot '%s' of VM '%s'\n", snapshotName, vmName)
+	},
+}
+
+func init() {
+	snapshotCreateCmd.Flags().BoolVar(&snapshotFreezeFlag, "freeze", false, "Freeze the guest filesystems via the guest agent while snapshotting")
+	snapshotCreateCmd.Flags().DurationVar(&snapshotTimeoutFlag, "timeout", 10*time.Minute, "Give up waiting for the snapshot after this duration")
+	addExternalQEMUFlags(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
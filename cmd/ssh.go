@@ -5,6 +5,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -17,6 +18,12 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	sshConnectTimeoutFlag int
+	sshDeadlineFlag       time.Duration
+	sshExitMasterFlag     bool
+)
+
 var sshCmd = &cobra.Command{
 	Use:   "ssh [vm-name] [command]",
 	Short: "Connect to a virtual machine via SSH",
@@ -58,6 +65,16 @@ var sshCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// Ask a running ControlMaster connection to exit
+		if sshExitMasterFlag {
+			args := append(sshBaseArgs(sshConfigPath, 0), "-p", fmt.Sprintf("%d", sshPort), "-O", "exit", "localhost")
+			if err := runSSHCommand("ssh", args); err != nil {
+				fmt.Fprintf(os.Stderr, "Error stopping SSH master connection: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+
 		// Execute SSH command
 		if err := executeSSH(sshConfigPath, sshPort, command); err != nil {
 			fmt.Fprintf(os.Stderr, "Error executing SSH: %v\n", err)
@@ -67,13 +84,70 @@ var sshCmd = &cobra.Command{
 }
 
 func init() {
+	addSSHTimeoutFlags(sshCmd)
+	sshCmd.Flags().BoolVar(&sshExitMasterFlag, "exit-master", false, "Stop the ControlMaster connection to the VM (ssh -O exit) instead of connecting")
 	rootCmd.AddCommand(sshCmd)
 }
 
+// addSSHTimeoutFlags registers the connection timeout and deadline flags shared by ssh, get and put
+func addSSHTimeoutFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&sshConnectTimeoutFlag, "connect-timeout", 10, "Seconds to wait for the SSH connection to be established (0 uses the ssh default)")
+	cmd.Flags().DurationVar(&sshDeadlineFlag, "deadline", 0, "Kill the command if it has not finished within this duration (e.g. 5m, 0 disables)")
+}
+
+// sshBaseArgs returns the options shared by all ssh and scp invocations
+func sshBaseArgs(sshConfigPath string, connectTimeout int) []string {
+	args := []string{
+		"-F", sshConfigPath, // Use generated SSH config
+	}
+	if connectTimeout > 0 {
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", connectTimeout))
+	}
+	return args
+}
+
+// runSSHCommand runs ssh or scp with the given arguments attached to the terminal,
+// killing it once the --deadline expires
+func runSSHCommand(name string, args []string) error {
+	return runSSHCommandWithStdin(name, args, os.Stdin)
+}
+
+// runSSHCommandWithStdin is runSSHCommand with the command reading from stdin
+func runSSHCommandWithStdin(name string, args []string, stdin io.Reader) error {
+	ctx := context.Background()
+	if sshDeadlineFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sshDeadlineFlag)
+		defer cancel()
+	}
+
+	// Create command
+	sshCmd := exec.CommandContext(ctx, name, args...)
+
+	// Set up stdin/stdout/stderr for interactive session
+	sshCmd.Stdin = stdin
+	sshCmd.Stdout = os.Stdout
+	sshCmd.Stderr = os.Stderr
+
+	err := sshCmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s did not finish within %s", name, sshDeadlineFlag)
+	}
+	return err
+}
+
+// streamPath is the local path of get and put that stands for stdout and stdin
+const streamPath = "-"
+
+// shellQuote quotes s for use as a single word in a remote shell command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 // loadVMAndCheckStatus loads configuration, resolves VM, and checks if it's running
 func loadVMAndCheckStatus(vmName string) (*config.Config, *config.VmEntry, *vm.Status, error) {
 	// Load configuration
-	cfg, err := config.LoadConfig(configFile)
+	cfg, err := config.LoadConfig(configFile, configOverlays...)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("loading configuration: %w", err)
 	}
@@ -137,25 +211,17 @@ func getSSHConnectionInfo(cfg *config.Config, vmName string, status *vm.Status)
 // executeSSH runs the SSH command with the generated config
 func executeSSH(sshConfigPath string, sshPort int64, command string) error {
 	// Build SSH command arguments
-	args := []string{
-		"-F", sshConfigPath, // Use generated SSH config
+	args := sshBaseArgs(sshConfigPath, sshConnectTimeoutFlag)
+	args = append(args,
 		"-p", fmt.Sprintf("%d", sshPort), // SSH port
 		"localhost", // Connect to localhost (port forwarding)
-	}
+	)
 
 	// Add command if provided
 	if command != "" {
 		args = append(args, command)
 	}
 
-	// Create command
-	sshCmd := exec.Command("ssh", args...)
-
-	// Set up stdin/stdout/stderr for interactive session
-	sshCmd.Stdin = os.Stdin
-	sshCmd.Stdout = os.Stdout
-	sshCmd.Stderr = os.Stderr
-
 	// Execute SSH command
-	return sshCmd.Run()
+	return runSSHCommand("ssh", args)
 }
@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSHBaseArgs(t *testing.T) {
+	tests := []struct {
+		name           string
+		connectTimeout int
+		want           []string
+	}{
+		{
+			name:           "with connect timeout",
+			connectTimeout: 10,
+			want:           []string{"-F", "/tmp/ssh_config", "-o", "ConnectTimeout=10"},
+		},
+		{
+			name:           "ssh default timeout",
+			connectTimeout: 0,
+			want:           []string{"-F", "/tmp/ssh_config"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sshBaseArgs("/tmp/ssh_config", tt.connectTimeout)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sshBaseArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunSSHCommandDeadline(t *testing.T) {
+	oldDeadline := sshDeadlineFlag
+	defer func() { sshDeadlineFlag = oldDeadline }()
+	sshDeadlineFlag = 100 * time.Millisecond
+
+	start := time.Now()
+	err := runSSHCommand("sleep", []string{"5"})
+	if err == nil || !strings.Contains(err.Error(), "did not finish within") {
+		t.Errorf("runSSHCommand() error = %v, want deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("runSSHCommand() took %s, want it killed at the deadline", elapsed)
+	}
+}
+
+func TestStreamingCopyCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    func() (string, []string)
+		wantName string
+		wantLast string
+	}{
+		{
+			name:     "get to stdout",
+			build:    func() (string, []string) { return scpGetCommand("/tmp/ssh_config", 2222, "/var/log/syslog", "-") },
+			wantName: "ssh",
+			wantLast: "cat '/var/log/syslog'",
+		},
+		{
+			name:     "get to file",
+			build:    func() (string, []string) { return scpGetCommand("/tmp/ssh_config", 2222, "/var/log/syslog", "syslog") },
+			wantName: "scp",
+			wantLast: "syslog",
+		},
+		{
+			name:     "put from stdin",
+			build:    func() (string, []string) { return scpPutCommand("/tmp/ssh_config", 2222, "-", "/tmp/it's.tar", false) },
+			wantName: "ssh",
+			wantLast: `cat > '/tmp/it'\''s.tar'`,
+		},
+		{
+			name: "put from file",
+			build: func() (string, []string) {
+				return scpPutCommand("/tmp/ssh_config", 2222, "src.tar", "/tmp/src.tar", false)
+			},
+			wantName: "scp",
+			wantLast: "localhost:/tmp/src.tar",
+		},
+		{
+			name: "append from file",
+			build: func() (string, []string) {
+				return scpPutCommand("/tmp/ssh_config", 2222, "build.log", "/var/log/all.log", true)
+			},
+			wantName: "ssh",
+			wantLast: "cat >> '/var/log/all.log'",
+		},
+		{
+			name: "append from stdin",
+			build: func() (string, []string) {
+				return scpPutCommand("/tmp/ssh_config", 2222, "-", "/var/log/all.log", true)
+			},
+			wantName: "ssh",
+			wantLast: "cat >> '/var/log/all.log'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, args := tt.build()
+			if name != tt.wantName {
+				t.Errorf("Expected %s, got %s", tt.wantName, name)
+			}
+			if last := args[len(args)-1]; last != tt.wantLast {
+				t.Errorf("Expected last argument %q, got %q (args: %v)", tt.wantLast, last, args)
+			}
+		})
+	}
+}
+
+func TestCheckPutAppend(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "build.log")
+	if err := os.WriteFile(file, []byte("log\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := checkPutAppend(dir, true); err == nil {
+		t.Error("Expected appending a directory to fail")
+	}
+	for _, tt := range []struct {
+		path       string
+		appendMode bool
+	}{{dir, false}, {file, true}, {"-", true}} {
+		if err := checkPutAppend(tt.path, tt.appendMode); err != nil {
+			t.Errorf("checkPutAppend(%s, %v) error = %v", tt.path, tt.appendMode, err)
+		}
+	}
+}
+
+func TestFormatSSHConnection(t *testing.T) {
+	conn := sshConnection{Name: "test-vm", Host: "localhost", Port: 2089, ConfigPath: "/run/qqmgr/vm.test-vm/ssh.conf"}
+	withUser := conn
+	withUser.User = "dev"
+	spaced := conn
+	spaced.ConfigPath = "/home/me/my vms/ssh.conf"
+
+	tests := []struct {
+		name    string
+		conn    sshConnection
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "url", conn: conn, format: "url", want: "ssh://localhost:2089"},
+		{name: "url with user", conn: withUser, format: "url", want: "ssh://dev@localhost:2089"},
+		{name: "cmd", conn: conn, format: "cmd", want: "ssh -F /run/qqmgr/vm.test-vm/ssh.conf -p 2089 localhost"},
+		{name: "cmd quotes the config path", conn: spaced, format: "cmd", want: "ssh -F '/home/me/my vms/ssh.conf' -p 2089 localhost"},
+		{
+			name:   "json",
+			conn:   withUser,
+			format: "json",
+			want: `{
+  "name": "test-vm",
+  "host": "localhost",
+  "port": 2089,
+  "user": "dev",
+  "ssh_config": "/run/qqmgr/vm.test-vm/ssh.conf"
+}`,
+		},
+		{name: "unknown format", conn: conn, format: "yaml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatSSHConnection(tt.conn, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("formatSSHConnection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("formatSSHConnection() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var sshURLFormatFlag string
+
+// sshConnection is how to reach a VM over SSH, as printed by ssh-url
+type sshConnection struct {
+	Name       string `json:"name"`
+	Host       string `json:"host"`
+	Port       int64  `json:"port"`
+	User       string `json:"user,omitempty"`
+	ConfigPath string `json:"ssh_config"`
+}
+
+// URL returns the connection as ssh://[user@]host:port
+func (c sshConnection) URL() string {
+	user := ""
+	if c.User != "" {
+		user = c.User + "@"
+	}
+	return fmt.Sprintf("ssh://%s%s:%d", user, c.Host, c.Port)
+}
+
+// Command returns the ssh command line connecting with the generated config
+func (c sshConnection) Command() string {
+	return fmt.Sprintf("ssh -F %s -p %d %s", shellWord(c.ConfigPath), c.Port, c.Host)
+}
+
+var sshURLCmd = &cobra.Command{
+	Use:   "ssh-url [vm-name]",
+	Short: "Print how to connect to a VM via SSH, without connecting",
+	Long: `Print the SSH connection info of a virtual machine for use by other tools,
+without connecting. The SSH config is generated as for 'qqmgr ssh', the VM does
+not need to be running.
+
+Formats:
+  url   ssh://[user@]localhost:<port>
+  cmd   ssh -F <ssh-config> -p <port> localhost
+  json  name, host, port, user and ssh_config`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := appCtx.ResolveVM(vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		sshPort, ok := vmEntry.SSHPort()
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: SSH port not configured for VM '%s'\n", vmName)
+			os.Exit(1)
+		}
+
+		sshConfigPath, err := internal.GenerateSSHConfig(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating SSH config: %v\n", err)
+			os.Exit(1)
+		}
+
+		// The user may come from [vm.x.ssh].user or a User option, like in the SSH config
+		sshOptions, err := internal.GetSSHOptions(cfg, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sshUser, _ := sshOptions["User"].(string)
+
+		output, err := formatSSHConnection(sshConnection{
+			Name:       vmName,
+			Host:       "localhost",
+			Port:       sshPort,
+			User:       sshUser,
+			ConfigPath: sshConfigPath,
+		}, sshURLFormatFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(output)
+	},
+}
+
+// formatSSHConnection renders conn in format, one of url, cmd or json
+func formatSSHConnection(conn sshConnection, format string) (string, error) {
+	switch format {
+	case "url":
+		return conn.URL(), nil
+	case "cmd":
+		return conn.Command(), nil
+	case "json":
+		data, err := json.MarshalIndent(conn, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("unknown format '%s', expected url, cmd or json", format)
+}
+
+// shellWord returns s quoted for a shell if it contains anything but
+// characters which are safe unquoted
+func shellWord(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./:@%+=,") == "" {
+		return s
+	}
+	return shellQuote(s)
+}
+
+func init() {
+	sshURLCmd.Flags().StringVar(&sshURLFormatFlag, "format", "url", "Output format: url, cmd or json")
+	rootCmd.AddCommand(sshURLCmd)
+}
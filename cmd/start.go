@@ -5,19 +5,25 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"qqmgr/internal"
 	"qqmgr/internal/config"
+	"qqmgr/internal/tail"
 	"qqmgr/internal/vm"
 	"qqmgr/internal/vmutil"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var startCmd = &cobra.Command{
@@ -27,14 +33,35 @@ var startCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		vmName := args[0]
+		if startWaitReadyFlag && foregroundFlag {
+			fmt.Fprintf(os.Stderr, "Error: --wait-ready cannot be combined with --foreground\n")
+			os.Exit(1)
+		}
 
 		// Load configuration
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
 			os.Exit(1)
 		}
 
+		// Apply --set/--set-int overrides to the VM variables
+		overrides, err := parseSetFlags()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := cfg.OverrideVMVars(vmName, overrides); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Merge the selected profile into the VM's cmd
+		if err := cfg.ApplyProfile(vmName, startProfileFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
 		// Create AppContext
 		appCtx, err := internal.NewAppContext(cfg, configFile)
 		if err != nil {
@@ -49,6 +76,11 @@ var startCmd = &cobra.Command{
 			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
 			os.Exit(1)
 		}
+		warnIfDisabled(cfg, vmName)
+		if startWaitReadyFlag && vmEntry.ReadyCheck == nil {
+			fmt.Fprintf(os.Stderr, "Error: --wait-ready needs a ready_check on VM '%s'\n", vmName)
+			os.Exit(1)
+		}
 
 		// Validate arguments to prevent conflicts with auto-injected args
 		if err := validateVMArguments(vmEntry.Cmd); err != nil {
@@ -56,19 +88,58 @@ var startCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		// Catch a hostfwd copied from another VM, ssh would silently use the wrong port
+		warnings, err := vmEntry.SSHForwardWarnings()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not check hostfwd rules: %v\n", err)
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+
+		// Serialize with other start/stop invocations of this VM, so a concurrent
+		// start waits here and then finds the VM running
+		lock, err := vmutil.LockVM(vmEntry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer lock.Unlock()
+
 		// Create VM manager
 		manager := vm.NewManager(vmEntry)
 
-		// Check if VM is already running
-		status, err := manager.GetStatus(context.Background())
+		// Adopt a VM that is already running, e.g. after qqmgr was restarted, without
+		// touching its logs. Scripts can tell this apart by the exit code
+		adopted, err := adoptRunningVM(context.Background(), appCtx, manager)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error checking VM status: %v\n", err)
 			os.Exit(1)
 		}
+		if adopted != nil {
+			if startJSONFlag {
+				printStartResult(*adopted)
+			} else {
+				printAdoptedVM(os.Stdout, *adopted)
+			}
+			os.Exit(exitCodeAlreadyRunning)
+		}
 
-		if status.IsRunning {
-			fmt.Printf("VM '%s' is already running (PID: %d)\n", vmName, *status.PID)
-			return
+		// A taken hostfwd port would only show up as a bind error in QEMU's log
+		if err := vmutil.CheckHostPorts(vmEntry, cfg.HostPortOwners(vmName)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := vmEntry.CheckHugepages(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Pick the QEMU binary, honoring a per-VM arch override
+		qemuBin, err := appCtx.Config.ResolveQemuBin(vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving QEMU binary: %v\n", err)
+			os.Exit(1)
 		}
 
 		// Create runtime directory
@@ -77,23 +148,154 @@ var startCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// Delete existing stdout/stderr log files since we will create new ones
-		vmutil.DeleteLogFiles(vmEntry)
+		// Keep the previous stdout/stderr logs as *.1 if asked to, otherwise
+		// delete them since we will create new ones
+		if appendLogsFlag || vmEntry.KeepLogs {
+			if err := vmutil.RotateLogFiles(vmEntry); err != nil {
+				fmt.Fprintf(os.Stderr, "Error rotating log files: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			vmutil.DeleteLogFiles(vmEntry)
+		}
+
+		// In foreground mode, block until QEMU exits and mirror its exit code
+		if foregroundFlag {
+			// Release the lock once QEMU runs, stop must not wait for it to exit
+			exitCode, err := runVMForeground(qemuBin, vmEntry, lock.Unlock)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error running VM: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(exitCode)
+		}
 
 		// Start the VM
-		if err := startVM(appCtx.Config.Qemu.Bin, vmEntry); err != nil {
+		if err := startVM(qemuBin, vmEntry); err != nil {
 			fmt.Fprintf(os.Stderr, "Error starting VM: %v\n", err)
 			os.Exit(1)
 		}
 
+		// Other start/stop invocations need not wait for the guest to boot
+		if startWaitReadyFlag {
+			lock.Unlock()
+			infof(os.Stderr, "Waiting for VM '%s' to become ready...\n", vmName)
+			if err := waitVMReady(context.Background(), appCtx, vmEntry); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if startJSONFlag {
+			printStartResult(startResult{Name: vmName, Started: true})
+			return
+		}
 		fmt.Printf("VM '%s' started successfully\n", vmName)
 	},
 }
 
+// exitCodeAlreadyRunning is the exit code of start when the VM was already running
+const exitCodeAlreadyRunning = 3
+
+var (
+	foregroundFlag bool
+	appendLogsFlag bool
+	startJSONFlag  bool
+
+	startWaitReadyFlag bool
+	startProfileFlag   string
+)
+
+// startResult is the --json output of start, an adopted VM also reports how to reach it
+type startResult struct {
+	Name           string      `json:"name"`
+	Started        bool        `json:"started"`
+	AlreadyRunning bool        `json:"already_running"`
+	PID            *int        `json:"pid,omitempty"`
+	SSHPort        interface{} `json:"ssh_port,omitempty"`
+	SSHConfig      string      `json:"ssh_config,omitempty"`
+	QMPSocket      string      `json:"qmp_socket,omitempty"`
+	SerialFile     string      `json:"serial_file,omitempty"`
+}
+
+// adoptRunningVM returns the connection info of the VM if it is already running
+// and nil if it is not. The running VM's logs are left alone, its SSH config is
+// regenerated if it went missing.
+func adoptRunningVM(ctx context.Context, appCtx *internal.AppContext, manager *vm.Manager) (*startResult, error) {
+	status, err := manager.GetStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM status: %w", err)
+	}
+	if !status.IsRunning {
+		return nil, nil
+	}
+
+	if _, err := os.Stat(status.SSHConfig); os.IsNotExist(err) && status.SSHPort != nil {
+		if _, err := internal.GenerateSSHConfig(appCtx, status.Name); err != nil {
+			return nil, fmt.Errorf("regenerating SSH config: %w", err)
+		}
+	}
+
+	return &startResult{
+		Name:           status.Name,
+		AlreadyRunning: true,
+		PID:            status.PID,
+		SSHPort:        status.SSHPort,
+		SSHConfig:      status.SSHConfig,
+		QMPSocket:      status.QMPSocket,
+		SerialFile:     status.SerialFile,
+	}, nil
+}
+
+// printAdoptedVM prints how to reach a VM that was already running
+func printAdoptedVM(w io.Writer, result startResult) {
+	if result.PID != nil {
+		fmt.Fprintf(w, "VM '%s' is %v (PID: %d)\n", result.Name, vm.ErrAlreadyRunning, *result.PID)
+	} else {
+		fmt.Fprintf(w, "VM '%s' is %v\n", result.Name, vm.ErrAlreadyRunning)
+	}
+	if result.SSHPort != nil {
+		fmt.Fprintf(w, "  SSH port:   %v\n", result.SSHPort)
+		fmt.Fprintf(w, "  SSH config: %s\n", result.SSHConfig)
+	}
+	fmt.Fprintf(w, "  QMP socket: %s\n", result.QMPSocket)
+	fmt.Fprintf(w, "  Serial log: %s\n", result.SerialFile)
+}
+
+// printStartResult prints the result of start as JSON
+func printStartResult(result startResult) {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshaling JSON: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
 func init() {
+	startCmd.Flags().BoolVar(&foregroundFlag, "foreground", false, "Run QEMU in the foreground, streaming serial output until it exits")
+	// --wait-for-shutdown is the older name of --foreground
+	startCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "wait-for-shutdown" {
+			name = "foreground"
+		}
+		return pflag.NormalizedName(name)
+	})
+	startCmd.Flags().BoolVar(&appendLogsFlag, "append-logs", false, "Keep the previous QEMU stdout/stderr logs as qemu-stdout.log.1/qemu-stderr.log.1 instead of deleting them")
+	startCmd.Flags().BoolVar(&startWaitReadyFlag, "wait-ready", false, "Wait for the VM's ready_check to pass before reporting success")
+	startCmd.Flags().BoolVar(&startJSONFlag, "json", false, fmt.Sprintf("Print the result as JSON; an already running VM still exits with code %d", exitCodeAlreadyRunning))
+	startCmd.Flags().StringVar(&startProfileFlag, "profile", "", "Start with the VM's [vm.<name>.profile.<profile>] merged into its cmd")
+	addSetFlags(startCmd, "a VM variable")
 	rootCmd.AddCommand(startCmd)
 }
 
+// warnIfDisabled warns that a VM disabled in the config is started by name anyway
+func warnIfDisabled(cfg *config.Config, vmName string) {
+	if vmConfig, ok := cfg.VMs[vmName]; ok && !vmConfig.IsEnabled() {
+		fmt.Fprintf(os.Stderr, "Warning: VM '%s' is disabled in the configuration (enabled = false)\n", vmName)
+	}
+}
+
 // validateVMArguments checks that the user hasn't specified arguments that conflict with auto-injected ones
 func validateVMArguments(cmd []string) error {
 	conflictingArgs := []string{"-serial", "-qmp", "-monitor", "-pidfile"}
@@ -115,7 +317,7 @@ func validateVMArguments(cmd []string) error {
 }
 
 // startVM starts the QEMU process with proper error handling
-func startVM(qemuBin string, vmEntry *config.VmEntry) error {
+func startVM(qemuBin string, vmEntry *config.VmEntry) (err error) {
 	// Get the full command with auto-injected arguments
 	fullCmd := vmEntry.GetFullCommand()
 
@@ -128,10 +330,31 @@ func startVM(qemuBin string, vmEntry *config.VmEntry) error {
 		for i, arg := range fullCmd {
 			fmt.Fprintf(os.Stderr, "  [%d] %s\n", i, arg)
 		}
+		for key, value := range vmEntry.Env {
+			fmt.Fprintf(os.Stderr, "DEBUG: Environment: %s=%s\n", key, value)
+		}
 	}
 
-	// Build the command
+	// A panic recorded for the previous run no longer applies
+	os.Remove(vmEntry.PanicFilePath())
+
+	// The TPM emulator must be listening before QEMU connects to it, and is
+	// stopped again if QEMU does not come up
+	if vmEntry.TPM {
+		manager := vm.NewManager(vmEntry)
+		if err := manager.StartTPM(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				manager.StopTPM()
+			}
+		}()
+	}
+
+	// Build the command, with [vm.x.env] on top of our environment
 	cmd := exec.Command(qemuBin, fullCmd...)
+	cmd.Env = vmEntry.Environ(os.Environ())
 
 	// Create log files for QEMU stdout/stderr
 	stdoutFile, err := os.Create(vmEntry.QemuStdoutPath())
@@ -216,3 +439,134 @@ func startVM(qemuBin string, vmEntry *config.VmEntry) error {
 		return nil
 	}
 }
+
+// runVMForeground starts QEMU, streams the serial output to stdout and blocks until
+// QEMU exits. The first interrupt requests a graceful powerdown via QMP, the second
+// kills the process. onStarted, if not nil, is called once QEMU was started.
+// Returns the exit code of the QEMU process.
+func runVMForeground(qemuBin string, vmEntry *config.VmEntry, onStarted func()) (int, error) {
+	fullCmd := vmEntry.GetFullCommand()
+
+	if debugFlag {
+		fmt.Fprintf(os.Stderr, "DEBUG: Full QEMU command:\n")
+		fmt.Fprintf(os.Stderr, "  %s %s\n", qemuBin, strings.Join(fullCmd, " "))
+	}
+
+	os.Remove(vmEntry.PanicFilePath())
+
+	// swtpm only exits by itself once QEMU connected and disconnected again
+	if vmEntry.TPM {
+		manager := vm.NewManager(vmEntry)
+		if err := manager.StartTPM(); err != nil {
+			return 0, err
+		}
+		defer manager.StopTPM()
+	}
+
+	cmd := exec.Command(qemuBin, fullCmd...)
+	cmd.Env = vmEntry.Environ(os.Environ())
+	// Keep QEMU out of the terminal's process group, Ctrl+C is handled by us
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdoutFile, err := os.Create(vmEntry.QemuStdoutPath())
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stdout log file: %w", err)
+	}
+	defer stdoutFile.Close()
+
+	stderrFile, err := os.Create(vmEntry.QemuStderrPath())
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stderr log file: %w", err)
+	}
+	defer stderrFile.Close()
+
+	cmd.Stdout = stdoutFile
+	cmd.Stderr = io.MultiWriter(stderrFile, os.Stderr)
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start QEMU process: %w", err)
+	}
+	if onStarted != nil {
+		onStarted()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	// Stream the serial log once QEMU has created it, until QEMU exited and the
+	// rest of the log was written
+	stopFollow := make(chan struct{})
+	var follower sync.WaitGroup
+	follower.Add(1)
+	go func() {
+		defer follower.Done()
+		exists := func() bool {
+			_, err := os.Stat(vmEntry.SerialFilePath())
+			return err == nil
+		}
+		for !exists() {
+			select {
+			case <-stopFollow:
+				if !exists() {
+					return
+				}
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		if err := tail.FollowFileUntil(context.Background(), vmEntry.SerialFilePath(), true, stopFollow, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error following serial output: %v\n", err)
+		}
+	}()
+
+	interrupts := 0
+	for {
+		select {
+		case err := <-done:
+			close(stopFollow)
+			follower.Wait()
+
+			if err == nil {
+				return 0, nil
+			}
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return exitErr.ExitCode(), nil
+			}
+			return 0, fmt.Errorf("failed waiting for QEMU process: %w", err)
+
+		case <-sigCh:
+			interrupts++
+			if interrupts == 1 {
+				fmt.Fprintf(os.Stderr, "\nRequesting VM powerdown (interrupt again to kill)...\n")
+				go requestPowerdown(vmEntry)
+			} else {
+				fmt.Fprintf(os.Stderr, "\nKilling QEMU process...\n")
+				_ = cmd.Process.Kill()
+			}
+		}
+	}
+}
+
+// requestPowerdown asks the guest to shut down via a QMP system_powerdown
+func requestPowerdown(vmEntry *config.VmEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	qmpClient := internal.NewQMPClient(vmEntry.QmpSocketPath())
+	if err := qmpClient.Connect(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to QMP: %v\n", err)
+		return
+	}
+	defer qmpClient.Close()
+
+	if _, err := qmpClient.SendCommand(ctx, map[string]interface{}{
+		"execute": "system_powerdown",
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending system_powerdown: %v\n", err)
+	}
+}
@@ -3,13 +3,23 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"qqmgr/internal"
 	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
 )
 
 func TestValidateVMArguments(t *testing.T) {
@@ -95,8 +105,13 @@ func TestStartVM(t *testing.T) {
 		DataDir: filepath.Join(tempDir, "vm.test-vm"),
 	}
 
+	// Create runtime directory
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		t.Fatalf("Failed to create runtime directory: %v", err)
+	}
+
 	// Test that startVM fails with invalid QEMU binary
-	err = startVM(vmEntry)
+	err = startVM("qemu-system-x86_64", vmEntry)
 	if err == nil {
 		t.Error("startVM() should fail with invalid QEMU binary")
 	}
@@ -145,7 +160,7 @@ exit 1
 	defer os.Setenv("PATH", originalPath)
 
 	// Test that startVM captures stderr output
-	err = startVM(vmEntry)
+	err = startVM("qemu-system-x86_64", vmEntry)
 	if err == nil {
 		t.Error("startVM() should fail with mock QEMU")
 	}
@@ -253,7 +268,7 @@ sleep 10
 			return
 		}
 
-		vmEntry, err := cfg.ResolveVM("test-vm", configFile)
+		vmEntry, err := cfg.ResolveVM("test-vm", configFile, nil)
 		if err != nil {
 			t.Errorf("Failed to resolve VM: %v", err)
 			return
@@ -331,7 +346,7 @@ exit 1
 	defer os.Setenv("PATH", originalPath)
 
 	// Test that startVM captures and reports the error
-	err = startVM(vmEntry)
+	err = startVM("qemu-system-x86_64", vmEntry)
 	if err == nil {
 		t.Error("startVM() should fail with invalid QEMU arguments")
 	}
@@ -345,3 +360,403 @@ exit 1
 	}
 	// No longer require 'Use -help for help' since the mock QEMU does not output it
 }
+
+func TestRunVMForeground(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Mock QEMU writes to the serial file passed via -serial, then exits with code 3
+	// right after its last line
+	mockQEMU := filepath.Join(tempDir, "qemu-system-x86_64")
+	mockScript := `#!/bin/sh
+serial=""
+prev=""
+for arg in "$@"; do
+    if [ "$prev" = "-serial" ]; then
+        serial="${arg#file:}"
+    fi
+    prev="$arg"
+done
+echo "guest booted" > "$serial"
+sleep 0.3
+echo "reboot: Power down" >> "$serial"
+exit 3
+`
+	if err := os.WriteFile(mockQEMU, []byte(mockScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU: %v", err)
+	}
+
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		Cmd:     []string{"-nodefaults"},
+		DataDir: filepath.Join(tempDir, "vm.test-vm"),
+	}
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		t.Fatalf("Failed to create runtime directory: %v", err)
+	}
+
+	// Capture stdout to check the serial output is streamed
+	originalStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stdout = w
+
+	exitCode, err := runVMForeground(mockQEMU, vmEntry, nil)
+
+	os.Stdout = originalStdout
+	w.Close()
+	output, _ := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("runVMForeground() failed: %v", err)
+	}
+	if exitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", exitCode)
+	}
+	if !strings.Contains(string(output), "guest booted") {
+		t.Errorf("Expected serial output to be streamed, got: %q", string(output))
+	}
+	if !strings.HasSuffix(string(output), "reboot: Power down\n") {
+		t.Errorf("Expected the last serial line written before exiting, got: %q", string(output))
+	}
+
+	// --wait-for-shutdown is the older name of --foreground
+	defer func() { foregroundFlag = false }()
+	if err := startCmd.Flags().Parse([]string{"--wait-for-shutdown"}); err != nil || !foregroundFlag {
+		t.Errorf("Expected --wait-for-shutdown to set --foreground, got %v", err)
+	}
+}
+
+// TestAdoptRunningVM tests that start adopts a VM left running by an earlier qqmgr
+// instead of clobbering its logs
+func TestAdoptRunningVM(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.test]
+cmd = ["-nodefaults"]
+
+[vm.test.ssh]
+port = 2222
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	appCtx, err := internal.NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+
+	vmEntry, err := appCtx.ResolveVM("test")
+	if err != nil {
+		t.Fatalf("Failed to resolve VM: %v", err)
+	}
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		t.Fatalf("Failed to create runtime directory: %v", err)
+	}
+	manager := vm.NewManager(vmEntry)
+
+	// Nothing to adopt while the VM is stopped
+	adopted, err := adoptRunningVM(context.Background(), appCtx, manager)
+	if err != nil {
+		t.Fatalf("adoptRunningVM() failed: %v", err)
+	}
+	if adopted != nil {
+		t.Fatalf("Expected nothing to adopt, got %+v", adopted)
+	}
+
+	// A sleep process stands in for the QEMU started before qqmgr was restarted
+	qemu := exec.Command("sleep", "30")
+	if err := qemu.Start(); err != nil {
+		t.Fatalf("Failed to start mock QEMU: %v", err)
+	}
+	defer func() {
+		qemu.Process.Kill()
+		qemu.Wait()
+	}()
+	if err := os.WriteFile(vmEntry.PidFilePath(), []byte(fmt.Sprintf("%d", qemu.Process.Pid)), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	if err := os.WriteFile(vmEntry.QemuStdoutPath(), []byte("live output\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	adopted, err = adoptRunningVM(context.Background(), appCtx, manager)
+	if err != nil {
+		t.Fatalf("adoptRunningVM() failed: %v", err)
+	}
+	if adopted == nil || !adopted.AlreadyRunning || adopted.Started {
+		t.Fatalf("Expected the running VM to be adopted, got %+v", adopted)
+	}
+	if adopted.PID == nil || *adopted.PID != qemu.Process.Pid {
+		t.Errorf("Expected PID %d, got %v", qemu.Process.Pid, adopted.PID)
+	}
+	if adopted.SSHPort != int64(2222) {
+		t.Errorf("Expected SSH port 2222, got %v", adopted.SSHPort)
+	}
+	if _, err := os.Stat(adopted.SSHConfig); err != nil {
+		t.Errorf("Expected the missing SSH config to be regenerated: %v", err)
+	}
+	if data, err := os.ReadFile(vmEntry.QemuStdoutPath()); err != nil || string(data) != "live output\n" {
+		t.Errorf("Expected the live log to be kept, got %q (%v)", data, err)
+	}
+
+	var buf bytes.Buffer
+	printAdoptedVM(&buf, *adopted)
+	if !strings.Contains(buf.String(), "SSH port:   2222") {
+		t.Errorf("Expected the connection info to be printed, got:\n%s", buf.String())
+	}
+}
+
+// TestValidateVMs tests that validate reports resolve errors and hostfwd mismatches
+func TestValidateVMs(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.good]
+cmd = ["-netdev user,id=net0,hostfwd=tcp::{{.vm.ssh.port}}-:22"]
+
+[vm.good.ssh]
+port = 2222
+
+[vm.copied]
+cmd = ["-netdev user,id=net0,hostfwd=tcp::2222-:22"]
+
+[vm.copied.ssh]
+port = 2223
+
+[vm.broken]
+cmd = ["-serial stdio"]
+
+[vm.broken.ssh]
+port = 2224
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	appCtx, err := internal.NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+
+	errs, warnings := validateVMs(appCtx, []string{"broken", "copied", "good"})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "VM 'broken'") {
+		t.Errorf("Expected an error for the conflicting -serial, got %v", errs)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "VM 'copied'") {
+		t.Errorf("Expected a hostfwd warning for 'copied' only, got %v", warnings)
+	}
+}
+
+func TestStartVMEnvironment(t *testing.T) {
+	tempDir := t.TempDir()
+	envFile := filepath.Join(tempDir, "qemu.env")
+
+	// The mock QEMU records its environment and exits
+	mockQEMU := filepath.Join(tempDir, "qemu-system-x86_64")
+	mockScript := fmt.Sprintf(`#!/bin/sh
+env > %s
+echo "done" >&2
+exit 1
+`, envFile)
+	if err := os.WriteFile(mockQEMU, []byte(mockScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU: %v", err)
+	}
+
+	t.Setenv("QQMGR_TEST_INHERITED", "parent")
+	t.Setenv("QEMU_AUDIO_DRV", "pa")
+
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		Cmd:     []string{"-nodefaults"},
+		DataDir: filepath.Join(tempDir, "vm.test-vm"),
+		Env: map[string]string{
+			"QEMU_AUDIO_DRV": "none",
+			"DISPLAY":        ":1",
+		},
+	}
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		t.Fatalf("Failed to create runtime directory: %v", err)
+	}
+
+	checkEnv := func(how string) {
+		data, err := os.ReadFile(envFile)
+		if err != nil {
+			t.Fatalf("Failed to read environment of mock QEMU (%s): %v", how, err)
+		}
+		environ := strings.Split(string(data), "\n")
+		for _, want := range []string{"QEMU_AUDIO_DRV=none", "DISPLAY=:1", "QQMGR_TEST_INHERITED=parent"} {
+			found := false
+			for _, entry := range environ {
+				if entry == want {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Expected %s in the QEMU environment (%s), got:\n%s", want, how, data)
+			}
+		}
+		if strings.Contains(string(data), "QEMU_AUDIO_DRV=pa") {
+			t.Errorf("Expected the VM env to replace the inherited QEMU_AUDIO_DRV (%s), got:\n%s", how, data)
+		}
+	}
+
+	if err := startVM(mockQEMU, vmEntry); err == nil {
+		t.Fatal("startVM() should fail with the exiting mock QEMU")
+	}
+	checkEnv("startVM")
+
+	os.Remove(envFile)
+	if _, err := runVMForeground(mockQEMU, vmEntry, nil); err != nil {
+		t.Fatalf("runVMForeground() failed: %v", err)
+	}
+	checkEnv("runVMForeground")
+}
+
+// TestStartVMStopsTPM tests that the swtpm started for a VM is stopped again
+// when QEMU fails to start, it would never exit by itself otherwise
+func TestStartVMStopsTPM(t *testing.T) {
+	tempDir := t.TempDir()
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create bin dir: %v", err)
+	}
+
+	// Mock swtpm daemonizes like the real one, writing its PID file and socket,
+	// and records being terminated
+	stopped := filepath.Join(tempDir, "swtpm-stopped")
+	swtpmScript := fmt.Sprintf(`#!/bin/sh
+if [ "$1" = daemon ]; then
+    trap 'touch %s; exit 0' TERM
+    echo $$ > "$2"
+    touch "$3"
+    while :; do sleep 0.1; done
+fi
+prev=""
+for arg in "$@"; do
+    case "$prev" in
+    --pid) pidfile="${arg#file=}" ;;
+    --ctrl) socket="${arg#*path=}" ;;
+    esac
+    prev="$arg"
+done
+"$0" daemon "$pidfile" "$socket" >/dev/null 2>&1 &
+`, stopped)
+	if err := os.WriteFile(filepath.Join(binDir, "swtpm"), []byte(swtpmScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock swtpm: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+
+	mockQEMU := filepath.Join(tempDir, "qemu-system-x86_64")
+	if err := os.WriteFile(mockQEMU, []byte("#!/bin/sh\necho 'bad option' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU: %v", err)
+	}
+
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		Cmd:     []string{"-nodefaults"},
+		DataDir: filepath.Join(tempDir, "vm.test-vm"),
+		TPM:     true,
+	}
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		t.Fatalf("Failed to create runtime directory: %v", err)
+	}
+
+	if err := startVM(mockQEMU, vmEntry); err == nil {
+		t.Fatal("startVM() should fail with the exiting mock QEMU")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(stopped); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected swtpm to be stopped after QEMU failed to start")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := os.Stat(vmEntry.TPMSocketPath()); err == nil {
+		t.Error("Expected the swtpm socket to be removed")
+	}
+}
+
+// TestStartLock tests that concurrent starts of a VM launch QEMU only once, the
+// second start waits for the first and then finds the VM running
+func TestStartLock(t *testing.T) {
+	tempDir := t.TempDir()
+	launches := filepath.Join(tempDir, "launches")
+
+	// Mock QEMU records its launch, writes its PID file, creates the QMP socket path
+	// and keeps running until killed
+	mockQEMU := filepath.Join(tempDir, "qemu-system-x86_64")
+	mockScript := fmt.Sprintf(`#!/bin/sh
+echo $$ >> %s
+prev=""
+for arg in "$@"; do
+    if [ "$prev" = "-pidfile" ]; then
+        echo $$ > "$arg"
+    fi
+    if [ "$prev" = "-qmp" ]; then
+        qmp="${arg#unix:}"
+        touch "${qmp%%%%,*}"
+    fi
+    prev="$arg"
+done
+exec sleep 30
+`, launches)
+	if err := os.WriteFile(mockQEMU, []byte(mockScript), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU: %v", err)
+	}
+
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := fmt.Sprintf(`[qemu]
+bin = "%s"
+
+[vm.test]
+cmd = ["-nodefaults"]
+
+[vm.test.ssh]
+port = 2222
+`, mockQEMU)
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	exitCodes := make(chan int, 2)
+	for range 2 {
+		go func() {
+			_, _, exitCode := runQqmgr(t, "-c", configPath, "start", "test")
+			exitCodes <- exitCode
+		}()
+	}
+	codes := []int{<-exitCodes, <-exitCodes}
+
+	data, err := os.ReadFile(launches)
+	if err != nil {
+		t.Fatalf("Expected QEMU to be launched: %v", err)
+	}
+	pids := strings.Fields(string(data))
+	for _, pid := range pids {
+		if n, err := strconv.Atoi(pid); err == nil {
+			syscall.Kill(n, syscall.SIGKILL)
+		}
+	}
+
+	if len(pids) != 1 {
+		t.Errorf("Expected QEMU to be launched once, got %d launches", len(pids))
+	}
+	slices.Sort(codes)
+	if !slices.Equal(codes, []int{0, exitCodeAlreadyRunning}) {
+		t.Errorf("Expected one start to succeed and one to find the VM running, got exit codes %v", codes)
+	}
+}
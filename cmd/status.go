@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"qqmgr/internal"
@@ -17,16 +19,22 @@ import (
 )
 
 var jsonOutput bool
+var statusRawFlag bool
+var statusWatchFlag time.Duration
 
 var statusCmd = &cobra.Command{
 	Use:   "status [vm-name]",
 	Short: "Show virtual machine status",
-	Long:  `Show the running status, ports, and socket information for a virtual machine.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Show the running status, ports, and socket information for a virtual machine.
+
+With --watch, the status is printed again every interval until interrupted.
+Each check connects to QMP only briefly, so other commands like stop can
+still reach the VM in between.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		vmName := args[0]
 
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
 			fmt.Printf("Error loading config: %v\n", err)
 			return
@@ -41,7 +49,7 @@ var statusCmd = &cobra.Command{
 		defer appCtx.Close()
 
 		// Resolve VM configuration
-		vmEntry, err := appCtx.ResolveVM(vmName)
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
 		if err != nil {
 			fmt.Printf("Error resolving VM '%s': %v\n", vmName, err)
 			return
@@ -55,6 +63,28 @@ var statusCmd = &cobra.Command{
 		// Create VM manager
 		manager := vm.NewManager(vmEntry)
 
+		if statusWatchFlag > 0 {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			clearScreen := !jsonOutput && isTerminal(os.Stdout)
+			first := true
+			manager.WatchStatus(ctx, statusWatchFlag, func(status *vm.Status, err error) {
+				switch {
+				case clearScreen:
+					fmt.Print("\033[H\033[2J")
+				case !first:
+					fmt.Println()
+				}
+				first = false
+				if err != nil {
+					fmt.Printf("Error getting VM status: %v\n", err)
+					return
+				}
+				printStatus(ctx, manager, vmEntry, vmName, status)
+			})
+			return
+		}
+
 		// Get VM status with QMP-based checking
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -64,82 +94,139 @@ var statusCmd = &cobra.Command{
 			fmt.Printf("Error getting VM status: %v\n", err)
 			return
 		}
+		printStatus(ctx, manager, vmEntry, vmName, status)
+	},
+}
 
-		if jsonOutput {
-			// JSON output
-			result := map[string]interface{}{
-				"name":          status.Name,
-				"pid":           status.PID,
-				"pid_file":      status.PIDFile,
-				"running":       status.IsRunning,
-				"alive":         status.IsAlive,
-				"qmp_connected": status.QMPConnected,
-				"ssh": map[string]interface{}{
-					"port":   status.SSHPort,
-					"config": status.SSHConfig,
-				},
-				"serial_file":    status.SerialFile,
-				"qmp_socket":     status.QMPSocket,
-				"monitor_socket": status.MonitorSocket,
-				"qemu_stdout":    getLogFilePath(vmEntry.QemuStdoutPath(), ""),
-				"qemu_stderr":    getLogFilePath(vmEntry.QemuStderrPath(), ""),
-			}
+// printStatus prints status as JSON or human-readable, with the raw QMP responses if requested
+func printStatus(ctx context.Context, manager *vm.Manager, vmEntry *config.VmEntry, vmName string, status *vm.Status) {
+	// Collect the complete QMP responses for deep debugging
+	var raw map[string]interface{}
+	if statusRawFlag {
+		var err error
+		raw, err = manager.RawQMPStatus(ctx)
+		if err != nil {
+			raw = map[string]interface{}{"error": err.Error()}
+		}
+	}
+
+	if jsonOutput {
+		// JSON output
+		result := map[string]interface{}{
+			"name":          status.Name,
+			"pid":           status.PID,
+			"pid_file":      status.PIDFile,
+			"running":       status.IsRunning,
+			"alive":         status.IsAlive,
+			"qmp_connected": status.QMPConnected,
+			"ssh": map[string]interface{}{
+				"port":   status.SSHPort,
+				"config": status.SSHConfig,
+			},
+			"serial_file":    status.SerialFile,
+			"qmp_socket":     status.QMPSocket,
+			"monitor_socket": status.MonitorSocket,
+			"qemu_stdout":    getLogFilePath(vmEntry.QemuStdoutPath(), ""),
+			"qemu_stderr":    getLogFilePath(vmEntry.QemuStderrPath(), ""),
+		}
 
-			// Add status details if available
-			if status.StatusDetails != nil {
-				result["status_details"] = status.StatusDetails
+		// Add status details if available
+		if status.StatusDetails != nil {
+			result["status_details"] = status.StatusDetails
+		}
+		if status.VMStatus != nil {
+			result["vm_status"] = status.VMStatus
+		}
+		result["guest_panicked"] = status.Panicked
+		if status.PanicInfo != nil {
+			result["panic_info"] = status.PanicInfo
+		}
+		if raw != nil {
+			result["raw"] = raw
+		}
+		result["sockets_ready"] = manager.SocketsReady(ctx)
+
+		jsonData, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fmt.Printf("Error marshaling JSON: %v\n", err)
+			return
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		// Human-readable output
+		fmt.Printf("Status for VM: %s\n", vmName)
+		fmt.Printf("  Configured: yes\n")
+
+		if status.IsRunning {
+			if status.PID != nil {
+				fmt.Printf("  Running: %s (PID: %d)\n", stateText("yes", true), *status.PID)
+			} else {
+				fmt.Printf("  Running: %s\n", stateText("yes", true))
 			}
 
-			jsonData, err := json.MarshalIndent(result, "", "  ")
-			if err != nil {
-				fmt.Printf("Error marshaling JSON: %v\n", err)
-				return
+			if status.IsAlive {
+				fmt.Printf("  Alive: %s (QMP responsive)\n", stateText("yes", true))
+			} else {
+				fmt.Printf("  Alive: %s (QMP not responsive)\n", stateText("no", false))
 			}
-			fmt.Println(string(jsonData))
 		} else {
-			// Human-readable output
-			fmt.Printf("Status for VM: %s\n", vmName)
-			fmt.Printf("  Configured: yes\n")
-
-			if status.IsRunning {
-				if status.PID != nil {
-					fmt.Printf("  Running: yes (PID: %d)\n", *status.PID)
-				} else {
-					fmt.Printf("  Running: yes\n")
-				}
+			fmt.Printf("  Running: %s\n", stateText("no", false))
+		}
 
-				if status.IsAlive {
-					fmt.Printf("  Alive: yes (QMP responsive)\n")
-				} else {
-					fmt.Printf("  Alive: no (QMP not responsive)\n")
-				}
-			} else {
-				fmt.Printf("  Running: no\n")
+		if status.Panicked {
+			fmt.Printf("  %s\n", stateText("GUEST PANICKED", false))
+			if status.PanicInfo != nil {
+				info, _ := json.Marshal(status.PanicInfo)
+				fmt.Printf("  Panic Info: %s\n", info)
 			}
+		}
 
-			if status.QMPConnected {
-				fmt.Printf("  QMP: connected\n")
-			} else {
-				fmt.Printf("  QMP: not connected\n")
+		if status.QMPConnected {
+			fmt.Printf("  QMP: connected\n")
+		} else {
+			fmt.Printf("  QMP: not connected\n")
+		}
+
+		fmt.Printf("  SSH Port: %v\n", status.SSHPort)
+		fmt.Printf("  SSH Config: %s\n", vmEntry.SshConfigPath())
+		fmt.Printf("  PID File: %s\n", status.PIDFile)
+		fmt.Printf("  Serial File: %s\n", status.SerialFile)
+		fmt.Printf("  QMP Socket: %s\n", status.QMPSocket)
+		fmt.Printf("  Monitor Socket: %s\n", status.MonitorSocket)
+		fmt.Printf("  QEMU Stdout: %s\n", getLogFilePath(vmEntry.QemuStdoutPath(), "<not captured>"))
+		fmt.Printf("  QEMU Stderr: %s\n", getLogFilePath(vmEntry.QemuStderrPath(), "<not captured>"))
+
+		// Show status details if available
+		if status.VMStatus != nil && status.VMStatus.Status != "" {
+			fmt.Printf("  VM Status: %s\n", formatVMStatus(status.VMStatus))
+			if status.VMStatus.Reason != "" {
+				fmt.Printf("  Reason: %s\n", status.VMStatus.Reason)
 			}
+		}
 
-			fmt.Printf("  SSH Port: %v\n", status.SSHPort)
-			fmt.Printf("  SSH Config: %s\n", vmEntry.SshConfigPath())
-			fmt.Printf("  PID File: %s\n", status.PIDFile)
-			fmt.Printf("  Serial File: %s\n", status.SerialFile)
-			fmt.Printf("  QMP Socket: %s\n", status.QMPSocket)
-			fmt.Printf("  Monitor Socket: %s\n", status.MonitorSocket)
-			fmt.Printf("  QEMU Stdout: %s\n", getLogFilePath(vmEntry.QemuStdoutPath(), "<not captured>"))
-			fmt.Printf("  QEMU Stderr: %s\n", getLogFilePath(vmEntry.QemuStderrPath(), "<not captured>"))
-
-			// Show status details if available
-			if status.StatusDetails != nil {
-				if statusStr, ok := status.StatusDetails["status"].(string); ok {
-					fmt.Printf("  VM Status: %s\n", statusStr)
-				}
+		if raw != nil {
+			rawData, err := json.MarshalIndent(raw, "", "  ")
+			if err != nil {
+				fmt.Printf("Error marshaling raw QMP output: %v\n", err)
+				return
 			}
+			fmt.Printf("  Raw QMP:\n%s\n", rawData)
 		}
-	},
+	}
+}
+
+// formatVMStatus describes the QEMU run state, calling out states which need attention
+func formatVMStatus(s *internal.VMStatus) string {
+	switch {
+	case s.IsPanicked():
+		return stateText(s.Status+" (guest kernel panicked, see the serial log)", false)
+	case s.IsShutdown():
+		return stateText(s.Status+" (guest powered off, QEMU still running)", false)
+	case s.Running:
+		return stateText(s.Status, true)
+	default:
+		return s.Status
+	}
 }
 
 // getLogFilePath returns the log file path if it exists, otherwise returns fallback
@@ -152,5 +239,8 @@ func getLogFilePath(path, fallback string) string {
 
 func init() {
 	statusCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	statusCmd.Flags().DurationVar(&statusWatchFlag, "watch", 0, "Print the status again every interval, e.g. 1s, until interrupted")
+	statusCmd.Flags().BoolVar(&statusRawFlag, "raw", false, "Include complete QMP query-status, query-kvm and query-current-machine responses")
+	addExternalQEMUFlags(statusCmd)
 	rootCmd.AddCommand(statusCmd)
 }
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"testing"
+
+	"qqmgr/internal"
+)
+
+func TestFormatVMStatus(t *testing.T) {
+	colorFlag = "never"
+	defer func() { colorFlag = "auto" }()
+
+	tests := []struct {
+		status *internal.VMStatus
+		want   string
+	}{
+		{&internal.VMStatus{Status: "running", Running: true}, "running"},
+		{&internal.VMStatus{Status: "paused"}, "paused"},
+		{&internal.VMStatus{Status: "shutdown"}, "shutdown (guest powered off, QEMU still running)"},
+		{&internal.VMStatus{Status: "guest-panicked"}, "guest-panicked (guest kernel panicked, see the serial log)"},
+	}
+
+	for _, tt := range tests {
+		if got := formatVMStatus(tt.status); got != tt.want {
+			t.Errorf("formatVMStatus(%q) = %q, want %q", tt.status.Status, got, tt.want)
+		}
+	}
+}
@@ -17,20 +17,34 @@ import (
 
 var (
 	stdoutFollowFlag bool
-	stdoutLinesFlag  int
+	stdoutLinesFlag  = tail.Lines{Count: 10}
 )
 
 var stdoutCmd = &cobra.Command{
 	Use:   "stdout [vm-name]",
 	Short: "Display QEMU stdout",
 	Long: `Display QEMU stdout output. 
-By default, shows the last 10 lines. Use --follow to continuously monitor output.`,
+By default, shows the last 10 lines. Use --follow to continuously monitor output,
+or --from-start to print the whole file before following it.
+
+Like tail, -n N shows the last N lines and -n +N starts at line N. With --follow
+the selected lines are printed before the new ones, -n 0 only prints new lines.
+
+--grep only shows lines matching a regular expression, or with --invert those
+not matching it, and -n then counts the shown lines.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		vmName := args[0]
 
+		// Reject an invalid --grep pattern before doing anything else
+		filter, err := outputFilter()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
 		// Load configuration
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
 			os.Exit(1)
@@ -67,7 +81,7 @@ By default, shows the last 10 lines. Use --follow to continuously monitor output
 		}
 
 		// Display stdout output
-		if err := tail.DisplayFileOutput(vmEntry.QemuStdoutPath(), stdoutFollowFlag, stdoutLinesFlag); err != nil {
+		if err := tail.DisplayFileOutput(vmEntry.QemuStdoutPath(), stdoutFollowFlag, fromStartFlag, stdoutLinesFlag, filter, outputLabel(vmName)); err != nil {
 			fmt.Fprintf(os.Stderr, "Error displaying stdout output: %v\n", err)
 			os.Exit(1)
 		}
@@ -76,6 +90,9 @@ By default, shows the last 10 lines. Use --follow to continuously monitor output
 
 func init() {
 	stdoutCmd.Flags().BoolVarP(&stdoutFollowFlag, "follow", "f", false, "Follow the stdout output (like tail -f)")
-	stdoutCmd.Flags().IntVarP(&stdoutLinesFlag, "lines", "n", 10, "Number of lines to show (default: 10)")
+	stdoutCmd.Flags().VarP(&stdoutLinesFlag, "lines", "n", "Show the last N lines, or from line N on with +N; 0 with --follow only shows new lines")
+	addPrefixFlags(stdoutCmd)
+	addGrepFlags(stdoutCmd)
+	addFromStartFlag(stdoutCmd)
 	rootCmd.AddCommand(stdoutCmd)
 }
@@ -4,19 +4,24 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"qqmgr/internal"
 	"qqmgr/internal/config"
 	"qqmgr/internal/vm"
+	"qqmgr/internal/vmutil"
 
 	"github.com/spf13/cobra"
 )
 
 var forceFlag bool
 var timeoutFlag int
+var captureEventsFlag bool
+var stopDryRunFlag bool
 
 var stopCmd = &cobra.Command{
 	Use:   "stop [vm-name]",
@@ -25,30 +30,41 @@ var stopCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		vmName := args[0]
-		fmt.Printf("Stopping VM: %s\n", vmName)
+		infof(os.Stdout, "Stopping VM: %s\n", vmName)
 
 		// Load configuration
-		cfg, err := config.LoadConfig(configFile)
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
 		if err != nil {
-			fmt.Printf("Error loading config: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 			os.Exit(1)
 		}
 
 		// Create AppContext
 		appCtx, err := internal.NewAppContext(cfg, configFile)
 		if err != nil {
-			fmt.Printf("Error creating app context: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
 			os.Exit(1)
 		}
 		defer appCtx.Close()
 
 		// Resolve VM configuration
-		vmEntry, err := appCtx.ResolveVM(vmName)
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
 		if err != nil {
-			fmt.Printf("Error resolving VM '%s': %v\n", vmName, err)
+			fmt.Fprintf(os.Stderr, "Error resolving VM '%s': %v\n", vmName, err)
 			os.Exit(1)
 		}
 
+		// Wait for a start or stop of this VM in progress to finish, a dry run
+		// only looks
+		if !stopDryRunFlag {
+			lock, err := vmutil.LockVM(vmEntry)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			defer lock.Unlock()
+		}
+
 		// Create VM manager
 		manager := vm.NewManager(vmEntry)
 
@@ -59,7 +75,7 @@ var stopCmd = &cobra.Command{
 		// Get initial status
 		status, err := manager.GetStatus(ctx)
 		if err != nil {
-			fmt.Printf("Error getting VM status: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error getting VM status: %v\n", err)
 			os.Exit(1)
 		}
 
@@ -68,24 +84,32 @@ var stopCmd = &cobra.Command{
 			return
 		}
 
+		if stopDryRunFlag {
+			printStopPlan(manager, status, vmName)
+			return
+		}
+
 		if status.PID != nil {
-			fmt.Printf("VM is running with PID: %d\n", *status.PID)
+			infof(os.Stdout, "VM is running with PID: %d\n", *status.PID)
 		} else {
-			fmt.Printf("VM is running (PID not available)\n")
+			infof(os.Stdout, "VM is running (PID not available)\n")
 		}
 
 		// Stop the VM
-		fmt.Printf("Attempting to stop VM...\n")
-		success, err := manager.Stop(ctx, time.Duration(timeoutFlag)*time.Second, forceFlag)
+		infof(os.Stdout, "Attempting to stop VM...\n")
+		success, events, err := manager.StopWithEvents(ctx, time.Duration(timeoutFlag)*time.Second, forceFlag)
+		if captureEventsFlag {
+			printStopEvents(os.Stdout, events)
+		}
 		if err != nil {
-			fmt.Printf("Failed to stop VM: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to stop VM: %v\n", err)
 			os.Exit(1)
 		}
 
 		if success {
 			fmt.Printf("VM '%s' stopped successfully\n", vmName)
 		} else {
-			fmt.Printf("Failed to stop VM '%s'\n", vmName)
+			fmt.Fprintf(os.Stderr, "Failed to stop VM '%s'\n", vmName)
 			os.Exit(1)
 		}
 	},
@@ -94,5 +118,50 @@ var stopCmd = &cobra.Command{
 func init() {
 	stopCmd.Flags().BoolVar(&forceFlag, "force", true, "Force kill if graceful shutdown fails")
 	stopCmd.Flags().IntVar(&timeoutFlag, "timeout", 20, "Timeout in seconds for graceful shutdown")
+	stopCmd.Flags().BoolVar(&captureEventsFlag, "capture-events", false, "Print the QMP events observed during the shutdown attempt")
+	stopCmd.Flags().BoolVar(&stopDryRunFlag, "dry-run", false, "Print the steps stopping the VM would take, without taking them")
+	addExternalQEMUFlags(stopCmd)
 	rootCmd.AddCommand(stopCmd)
 }
+
+// printStopPlan prints the steps stopping the running VM would take given its status
+func printStopPlan(manager *vm.Manager, status *vm.Status, vmName string) {
+	steps, err := manager.PlanStop(status, time.Duration(timeoutFlag)*time.Second, forceFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error planning stop: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Stopping VM '%s' would:\n", vmName)
+	for i, step := range steps {
+		fmt.Printf("  %d. %s\n", i+1, step)
+	}
+}
+
+// printStopEvents prints the QMP events observed while stopping the VM, in order of arrival
+func printStopEvents(w io.Writer, events []internal.QMPEvent) {
+	if len(events) == 0 {
+		fmt.Fprintf(w, "No QMP events observed during shutdown\n")
+		return
+	}
+
+	fmt.Fprintf(w, "QMP events observed during shutdown:\n")
+	for _, event := range events {
+		fmt.Fprintf(w, "  %s\n", formatEvent(event))
+	}
+}
+
+// formatEvent renders a QMP event as its time, name and data on one line
+func formatEvent(event internal.QMPEvent) string {
+	line := event.Event
+	if event.Time != nil {
+		ts := time.Unix(event.Time.Seconds, event.Time.Microseconds*1000)
+		line = ts.Format("15:04:05.000") + " " + line
+	}
+	if len(event.Data) > 0 {
+		if data, err := json.Marshal(event.Data); err == nil {
+			line += " " + string(data)
+		}
+	}
+	return line
+}
@@ -3,12 +3,15 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"qqmgr/internal"
 	"qqmgr/internal/config"
 	"qqmgr/internal/vm"
 )
@@ -243,3 +246,22 @@ func BenchmarkStopCommandStatus(b *testing.B) {
 		}
 	}
 }
+
+// TestPrintStopEvents tests the report of --capture-events
+func TestPrintStopEvents(t *testing.T) {
+	var buf bytes.Buffer
+	printStopEvents(&buf, nil)
+	if !strings.Contains(buf.String(), "No QMP events") {
+		t.Errorf("Expected a note that no events were observed, got %q", buf.String())
+	}
+
+	buf.Reset()
+	printStopEvents(&buf, []internal.QMPEvent{
+		{Event: "POWERDOWN"},
+		{Event: "SHUTDOWN", Data: map[string]interface{}{"reason": "guest-shutdown"}},
+	})
+	out := buf.String()
+	if !strings.Contains(out, "  POWERDOWN\n") || !strings.Contains(out, `SHUTDOWN {"reason":"guest-shutdown"}`) {
+		t.Errorf("Expected both events to be reported, got:\n%s", out)
+	}
+}
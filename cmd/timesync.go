@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var timeSyncCmd = &cobra.Command{
+	Use:   "time-sync [vm-name]",
+	Short: "Bring the guest clock of a VM back in line with the host",
+	Long: `Reset QEMU's RTC reinjection, dropping the timer interrupts which piled up
+while the VM was paused, and set the guest clock to the host time via the QEMU
+guest agent. The guest agent is used if the VM has a guest agent channel on a
+unix socket chardev with id 'qga0', e.g.
+
+  -chardev socket,path=/tmp/qga.sock,server=on,wait=off,id=qga0
+  -device virtio-serial -device virtserialport,chardev=qga0,name=org.qemu.guest_agent.0
+
+Without a guest agent only the RTC reinjection is reset. Useful after resume.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		result, err := vm.NewManager(vmEntry).SyncTime(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error syncing time: %v\n", err)
+			os.Exit(1)
+		}
+
+		if result.RTCReset {
+			fmt.Printf("Reset RTC reinjection of VM '%s'\n", vmName)
+		} else {
+			fmt.Printf("RTC reinjection reset not supported by this QEMU target, skipped\n")
+		}
+		switch {
+		case result.ClockSet:
+			fmt.Printf("Set guest clock to host time via the guest agent\n")
+		case result.AgentSocket == "":
+			fmt.Printf("No guest agent channel (chardev '%s'), guest clock not set\n", config.GuestAgentChardevID)
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: guest clock not set: %s\n", result.AgentError)
+		}
+
+		// Nothing was done at all
+		if !result.RTCReset && !result.ClockSet {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	addExternalQEMUFlags(timeSyncCmd)
+	rootCmd.AddCommand(timeSyncCmd)
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [vm-name...]",
+	Short: "Check the configuration for errors",
+	Long: `Load the configuration and resolve every VM (or the given ones), reporting
+errors and warnings such as a hostfwd rule forwarding the guest's SSH port from
+another host port than ssh.port. Exits with code 1 if any errors are found.
+
+Keys which do not correspond to any setting, e.g. a misspelled 'cmdd', are
+reported as warnings, or as errors with --strict.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		vmNames := args
+		if len(vmNames) == 0 {
+			vmNames = cfg.ListAllVMs()
+			sort.Strings(vmNames)
+		}
+
+		errs, warnings := validateVMs(appCtx, vmNames)
+		unknownErrs, unknownWarnings := validateUnknownKeys(cfg, strictFlag)
+		errs = append(unknownErrs, errs...)
+		warnings = append(unknownWarnings, warnings...)
+		for _, warning := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("Configuration OK (%d VMs checked)\n", len(vmNames))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
+
+// validateVMs resolves each VM and returns the errors and warnings found
+func validateVMs(appCtx *internal.AppContext, vmNames []string) ([]error, []string) {
+	var errs []error
+	var warnings []string
+	for _, vmName := range vmNames {
+		vmEntry, err := appCtx.ResolveVM(vmName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("VM '%s': %w", vmName, err))
+			continue
+		}
+		if err := validateVMArguments(vmEntry.Cmd); err != nil {
+			errs = append(errs, fmt.Errorf("VM '%s': %w", vmName, err))
+		}
+		if err := vmEntry.CheckHugepages(); err != nil {
+			errs = append(errs, fmt.Errorf("VM '%s': %w", vmName, err))
+		}
+		if vmEntry.TPM {
+			if err := vm.CheckSwtpm(); err != nil {
+				errs = append(errs, fmt.Errorf("VM '%s': %w", vmName, err))
+			}
+		}
+		vmWarnings, err := vmEntry.SSHForwardWarnings()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("VM '%s': could not check hostfwd rules: %v", vmName, err))
+		}
+		warnings = append(warnings, vmWarnings...)
+	}
+	return errs, warnings
+}
+
+// validateUnknownKeys reports the keys of the configuration the decoder ignored,
+// as errors if strict and as warnings otherwise
+func validateUnknownKeys(cfg *config.Config, strict bool) ([]error, []string) {
+	var errs []error
+	var warnings []string
+	for _, key := range cfg.UnknownKeys() {
+		if strict {
+			errs = append(errs, fmt.Errorf("unknown configuration key '%s'", key))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("unknown configuration key '%s' is ignored", key))
+		}
+	}
+	return errs, warnings
+}
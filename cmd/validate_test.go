@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestStrictFlag tests that misspelled keys are warned about by validate and
+// rejected with --strict
+func TestStrictFlag(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.test]
+cmdd = ["-nodefaults"]
+
+[vm.test.ssh]
+port = 2222
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	_, stderr, code := runQqmgr(t, "-c", configPath, "validate")
+	if code != 0 || !strings.Contains(stderr, "Warning: unknown configuration key 'vm.test.cmdd'") {
+		t.Errorf("Expected a warning and exit code 0, got %d: %q", code, stderr)
+	}
+
+	_, stderr, code = runQqmgr(t, "-c", configPath, "validate", "--strict")
+	if code != 1 || !strings.Contains(stderr, "Error: unknown configuration key 'vm.test.cmdd'") {
+		t.Errorf("Expected an error and exit code 1, got %d: %q", code, stderr)
+	}
+
+	// Other commands refuse to run with --strict
+	stdout, stderr, code := runQqmgr(t, "-c", configPath, "--strict", "stop", "test")
+	if code != 1 || !strings.Contains(stdout+stderr, "unknown configuration keys: vm.test.cmdd") {
+		t.Errorf("Expected stop to fail on the unknown key, got %d: %q %q", code, stdout, stderr)
+	}
+}
+
+// TestConfigOverlayFlag tests that a repeated -c merges the files in order
+func TestConfigOverlayFlag(t *testing.T) {
+	tempDir := t.TempDir()
+	basePath := filepath.Join(tempDir, "base.toml")
+	base := `[vm.test]
+cmd = ["-nodefaults"]
+
+[vm.test.ssh]
+port = 2222
+`
+	overridePath := filepath.Join(tempDir, "override.toml")
+	override := `[vm.extra]
+cmd = ["-nodefaults"]
+
+[vm.extra.ssh]
+port = 2223
+`
+	for path, content := range map[string]string{basePath: base, overridePath: override} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	stdout, stderr, code := runQqmgr(t, "-c", basePath, "-c", overridePath, "validate")
+	if code != 0 || !strings.Contains(stdout, "Configuration OK (2 VMs checked)") {
+		t.Errorf("Expected both files' VMs to be checked, got %d: %q %q", code, stdout, stderr)
+	}
+}
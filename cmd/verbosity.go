@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"fmt"
+	"io"
+)
+
+var quietFlag bool
+
+// verbosity selects how much a command prints besides errors and its result
+type verbosity int
+
+const (
+	verbosityQuiet  verbosity = iota // Errors and results only
+	verbosityNormal                  // Progress and informational messages as well
+	verbosityDebug                   // Debug output as well
+)
+
+// outputVerbosity returns the verbosity selected by --quiet and --debug, --quiet wins
+func outputVerbosity() verbosity {
+	switch {
+	case quietFlag:
+		return verbosityQuiet
+	case debugFlag:
+		return verbosityDebug
+	}
+	return verbosityNormal
+}
+
+// infof prints an informational message, such as progress, to w unless --quiet
+// is set. Errors and the result of a command are printed directly instead.
+func infof(w io.Writer, format string, args ...interface{}) {
+	if outputVerbosity() >= verbosityNormal {
+		fmt.Fprintf(w, format, args...)
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestHelperProcess runs qqmgr with the arguments following "--" when invoked
+// by runQqmgr, commands exit the process so they cannot run in the test itself
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("QQMGR_TEST_HELPER") != "1" {
+		t.Skip("helper process for tests running qqmgr commands")
+	}
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	rootCmd.SetArgs(args)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runQqmgr runs qqmgr in a child process, returning its stdout, stderr and exit code
+func runQqmgr(t *testing.T, args ...string) (string, string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestHelperProcess$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), "QQMGR_TEST_HELPER=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Run()
+	return stdout.String(), stderr.String(), cmd.ProcessState.ExitCode()
+}
+
+// TestQuietFlag tests that --quiet drops informational output but keeps results and errors
+func TestQuietFlag(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.test]
+cmd = ["-nodefaults"]
+
+[vm.test.ssh]
+port = 2222
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	stdout, _, _ := runQqmgr(t, "-c", configPath, "stop", "test")
+	if !strings.Contains(stdout, "Stopping VM: test") {
+		t.Errorf("Expected progress output without --quiet, got: %q", stdout)
+	}
+
+	stdout, _, _ = runQqmgr(t, "-c", configPath, "--quiet", "stop", "test")
+	if strings.Contains(stdout, "Stopping VM") {
+		t.Errorf("Expected --quiet to drop progress output, got: %q", stdout)
+	}
+	if !strings.Contains(stdout, "VM 'test' is not running") {
+		t.Errorf("Expected --quiet to keep the result, got: %q", stdout)
+	}
+
+	stdout, stderr, _ := runQqmgr(t, "-c", configPath, "-q", "stop", "missing")
+	if strings.Contains(stdout, "Stopping VM") {
+		t.Errorf("Expected -q to drop progress output, got: %q", stdout)
+	}
+	if !strings.Contains(stderr, "Error resolving VM 'missing'") {
+		t.Errorf("Expected -q to keep error output, got stderr: %q", stderr)
+	}
+}
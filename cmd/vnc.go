@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var vncPasswordFlag string
+
+var vncCmd = &cobra.Command{
+	Use:   "vnc [vm-name]",
+	Short: "Manage the VNC display of a running VM",
+	Long: `Manage the VNC display of a running VM. Without flags, prints where the VNC and
+SPICE displays listen as reported by QEMU (query-vnc, query-spice), falling back
+to the VM's -vnc argument if QEMU cannot be queried.
+
+--password sets the display password, so the display can be exposed beyond
+localhost. The VM must be started with password authentication enabled, e.g.
+'-vnc :0,password=on'. A password of '-' is read from stdin, keeping it out of
+the process list.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		setPassword := cmd.Flags().Changed("password")
+		var password string
+		if setPassword {
+			var err error
+			password, err = readVNCPassword(vncPasswordFlag, os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		manager := vm.NewManager(vmEntry)
+		if !setPassword {
+			endpoints, err := manager.DisplayEndpoints(ctx)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			printDisplayEndpoints(os.Stdout, vmName, endpoints)
+			return
+		}
+
+		qmpClient, err := manager.QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		if err := qmpClient.SetVNCPassword(ctx, password); err != nil {
+			fmt.Fprintf(os.Stderr, "Error setting VNC password: %v\n", err)
+			fmt.Fprintf(os.Stderr, "The VM needs a VNC display with password authentication, e.g. '-vnc :0,password=on'\n")
+			os.Exit(1)
+		}
+
+		fmt.Printf("VNC password of VM '%s' set\n", vmName)
+	},
+}
+
+// printDisplayEndpoints prints one line per display endpoint of the VM
+func printDisplayEndpoints(w io.Writer, vmName string, endpoints []vm.DisplayEndpoint) {
+	if len(endpoints) == 0 {
+		fmt.Fprintf(w, "VM '%s' has no VNC or SPICE display\n", vmName)
+		return
+	}
+	for _, endpoint := range endpoints {
+		kind := "tcp"
+		if endpoint.Socket != "" {
+			kind = "unix"
+		}
+		note := ""
+		if endpoint.FromConfig {
+			note = " (from the VM's -vnc argument, QEMU could not be queried)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s%s\n", strings.ToUpper(endpoint.Protocol), kind, endpoint.Address(), note)
+	}
+}
+
+// readVNCPassword returns the --password value, reading the first line of stdin
+// for "-"
+func readVNCPassword(value string, stdin io.Reader) (string, error) {
+	if value != "-" {
+		return value, nil
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func init() {
+	vncCmd.Flags().StringVar(&vncPasswordFlag, "password", "", "Set the VNC display password instead of printing the endpoints, '-' reads it from stdin")
+	addExternalQEMUFlags(vncCmd)
+	rootCmd.AddCommand(vncCmd)
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+	"qqmgr/internal/vm"
+
+	"github.com/spf13/cobra"
+)
+
+var wakeupCmd = &cobra.Command{
+	Use:   "wakeup [vm-name]",
+	Short: "Wake up a suspended virtual machine",
+	Long: `Wake up a virtual machine whose guest suspended itself to RAM, e.g. through
+the guest agent's guest-suspend-ram or ACPI sleep. Unlike a VM paused by QEMU,
+which 'qqmgr resume' continues, a suspended guest only runs again once woken up.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+
+		cfg, err := config.LoadConfig(configFile, configOverlays...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Create AppContext
+		appCtx, err := internal.NewAppContext(cfg, configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating app context: %v\n", err)
+			os.Exit(1)
+		}
+		defer appCtx.Close()
+
+		// Resolve VM configuration
+		vmEntry, err := resolveVMEntry(appCtx, vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving VM configuration: %v\n", err)
+			os.Exit(1)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		qmpClient, err := vm.NewManager(vmEntry).QMPClient(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer qmpClient.Close()
+
+		// Only a suspended guest can be woken up, a paused one needs resume
+		status, err := qmpClient.QueryStatus(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error querying VM status: %v\n", err)
+			os.Exit(1)
+		}
+		if status.Status != "suspended" {
+			fmt.Fprintf(os.Stderr, "Error: VM '%s' is not suspended (status: %s)\n", vmName, status.Status)
+			if status.Status == "paused" {
+				fmt.Fprintf(os.Stderr, "Use 'qqmgr resume %s' to continue a paused VM\n", vmName)
+			}
+			os.Exit(1)
+		}
+
+		if err := qmpClient.SystemWakeup(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error waking up VM '%s': %v\n", vmName, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Woke up VM '%s'\n", vmName)
+	},
+}
+
+func init() {
+	addExternalQEMUFlags(wakeupCmd)
+	rootCmd.AddCommand(wakeupCmd)
+}
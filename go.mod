@@ -5,8 +5,9 @@ module qqmgr
 go 1.23.10
 
 require (
-	github.com/BurntSushi/toml v1.5.0 // indirect
-	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
-	github.com/spf13/pflag v1.0.6 // indirect
+	github.com/BurntSushi/toml v1.5.0
+	github.com/spf13/cobra v1.9.1
+	github.com/spf13/pflag v1.0.6
 )
+
+require github.com/inconshreveable/mousetrap v1.1.0 // indirect
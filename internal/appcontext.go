@@ -5,11 +5,14 @@ package internal
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"qqmgr/internal/config"
 	"qqmgr/internal/img"
 	"qqmgr/internal/trace"
+	"strings"
+	"time"
 )
 
 // AppContext holds the configuration and runtime context for VM operations
@@ -28,14 +31,33 @@ func NewAppContext(cfg *config.Config, configPath string) (*AppContext, error) {
 		return nil, fmt.Errorf("failed to determine runtime directory: %w", err)
 	}
 
-	// Set up tracing
+	// Get config directory for image manager, relative paths in the config
+	// are resolved against the directory of the config file actually in use
+	foundPath, err := config.FindConfigPath(configPath)
+	if err != nil {
+		return nil, err
+	}
+	configDir := filepath.Dir(foundPath)
+
+	// Set up tracing, QQMGR_TRACE (also set by --trace) overrides the config's patterns
 	var tracer trace.Tracer
-	if traceEnv := os.Getenv("QQMGR_TRACE"); traceEnv != "" {
-		// Create trace file in runtime directory
+	if patterns := tracePatterns(os.Getenv("QQMGR_TRACE"), cfg.Trace); len(patterns) > 0 {
+		// Create trace file in runtime directory unless the config names one
 		tracePath := filepath.Join(runtimeDir, "trace.log")
-		patterns := []string{traceEnv} // Use the env var as pattern
+		if cfg.Trace.File != "" {
+			tracePath = cfg.Trace.File
+			if !filepath.IsAbs(tracePath) {
+				tracePath = filepath.Join(configDir, tracePath)
+			}
+		}
 
-		tracer, err = trace.NewTraceLoggerWithFile(patterns, tracePath)
+		// QQMGR_TRACE_STDOUT=1 (also set by --trace-stdout) mirrors traces to the terminal
+		var mirror io.Writer
+		if os.Getenv("QQMGR_TRACE_STDOUT") == "1" {
+			mirror = os.Stderr
+		}
+
+		tracer, err = trace.NewTraceLoggerWithFileMirror(patterns, tracePath, mirror)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create tracer: %w", err)
 		}
@@ -43,14 +65,9 @@ func NewAppContext(cfg *config.Config, configPath string) (*AppContext, error) {
 		tracer = trace.NewNoOpTracer()
 	}
 
-	// Get config directory for image manager
-	configDir := filepath.Dir(configPath)
-	if configPath == "qqmgr.toml" {
-		configDir = "."
-	}
-
 	// Create image manager
 	imgManager := img.NewManager(configDir, runtimeDir, cfg.Qemu.Bin, cfg.Qemu.Img, tracer)
+	imgManager.SetPowerdownFunc(powerdownBuildVM)
 
 	return &AppContext{
 		Config:     cfg,
@@ -60,6 +77,20 @@ func NewAppContext(cfg *config.Config, configPath string) (*AppContext, error) {
 	}, nil
 }
 
+// tracePatterns returns the comma-separated patterns of env if set, else the config's patterns
+func tracePatterns(env string, cfg config.TraceConfig) []string {
+	if env == "" {
+		return cfg.Patterns
+	}
+	var patterns []string
+	for _, pattern := range strings.Split(env, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
 // ResolveVM resolves template variables in VM configuration and returns a VmEntry
 func (ctx *AppContext) ResolveVM(vmName string) (*config.VmEntry, error) {
 	// Build image map for template resolution
@@ -89,10 +120,13 @@ func (ctx *AppContext) GetImagePath(imgName string) (string, error) {
 }
 
 // BuildImage builds a specific image
-func (ctx *AppContext) BuildImage(imgName string) error {
+func (ctx *AppContext) BuildImage(imgName string) (*img.BuildResult, error) {
 	imgConfig, err := ctx.Config.GetImage(imgName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := ctx.Config.CheckImageBinaries(imgName); err != nil {
+		return nil, err
 	}
 	return ctx.ImgManager.BuildImage(context.Background(), imgName, imgConfig)
 }
@@ -100,3 +134,17 @@ func (ctx *AppContext) BuildImage(imgName string) error {
 func (ctx *AppContext) Close() {
 	ctx.Tracer.Close()
 }
+
+// powerdownBuildVM gracefully shuts down an image build VM over its QMP socket
+func powerdownBuildVM(ctx context.Context, qmpSocket string) error {
+	client := NewQMPClient(qmpSocket)
+	if err := client.Connect(ctx); err != nil {
+		return fmt.Errorf("failed to connect to build VM QMP socket: %w", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Shutdown(ctx, 1*time.Second, 20*time.Second, false); err != nil {
+		return fmt.Errorf("failed to power down build VM: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"qqmgr/internal/config"
+	"qqmgr/internal/trace"
+)
+
+func TestTracePatternsPrecedence(t *testing.T) {
+	cfg := config.TraceConfig{Patterns: []string{"qemu", "iso"}}
+
+	tests := []struct {
+		name string
+		env  string
+		cfg  config.TraceConfig
+		want []string
+	}{
+		{"config only", "", cfg, []string{"qemu", "iso"}},
+		{"env overrides config", "download", cfg, []string{"download"}},
+		{"env is comma separated", "qemu, cloud-*", cfg, []string{"qemu", "cloud-*"}},
+		{"nothing set", "", config.TraceConfig{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tracePatterns(tt.env, tt.cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tracePatterns(%q) = %v, want %v", tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAppContextTraceFromConfig(t *testing.T) {
+	t.Setenv("QQMGR_TRACE", "")
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[trace]
+patterns = ["qemu"]
+file = "logs/trace.log"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	appCtx, err := NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+
+	if _, ok := appCtx.Tracer.(*trace.TraceLogger); !ok {
+		t.Fatalf("Expected config patterns to enable a trace logger, got %T", appCtx.Tracer)
+	}
+	if !appCtx.Tracer.EnabledForCategory("qemu") || appCtx.Tracer.EnabledForCategory("iso") {
+		t.Errorf("Expected only 'qemu' to be traced, patterns: %v", appCtx.Tracer.GetPatterns())
+	}
+
+	appCtx.Tracer.Trace("qemu", "hello")
+	if _, err := os.Stat(filepath.Join(tempDir, "logs", "trace.log")); err != nil {
+		t.Errorf("Expected trace file relative to the config file: %v", err)
+	}
+}
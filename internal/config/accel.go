@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"os"
+	"runtime"
+)
+
+// accelProbe describes the host capabilities accelerator detection relies on
+type accelProbe struct {
+	goos          string
+	kvmAccessible func() bool
+}
+
+// hostAccelProbe probes the capabilities of the running host
+var hostAccelProbe = accelProbe{
+	goos:          runtime.GOOS,
+	kvmAccessible: kvmAccessible,
+}
+
+// kvmAccessible returns true if /dev/kvm can be opened for reading and writing
+func kvmAccessible() bool {
+	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// DetectAccel returns the best QEMU accelerator available on this host
+func DetectAccel() string {
+	return hostAccelProbe.detect()
+}
+
+// detect picks kvm if /dev/kvm is usable, hvf on macOS and tcg otherwise
+func (p accelProbe) detect() string {
+	if p.goos == "linux" && p.kvmAccessible() {
+		return "kvm"
+	}
+	if p.goos == "darwin" {
+		return "hvf"
+	}
+	return "tcg"
+}
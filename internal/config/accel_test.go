@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAccelProbeDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		kvm  bool
+		want string
+	}{
+		{name: "linux with kvm", goos: "linux", kvm: true, want: "kvm"},
+		{name: "linux without kvm", goos: "linux", kvm: false, want: "tcg"},
+		{name: "macos", goos: "darwin", kvm: false, want: "hvf"},
+		{name: "other", goos: "freebsd", kvm: false, want: "tcg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := accelProbe{
+				goos:          tt.goos,
+				kvmAccessible: func() bool { return tt.kvm },
+			}
+			if got := probe.detect(); got != tt.want {
+				t.Errorf("detect() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTuningAccelExpansion(t *testing.T) {
+	noKVM := accelProbe{goos: "linux", kvmAccessible: func() bool { return false }}
+
+	got, err := TuningConfig{Accel: "auto"}.expandWithProbe([]string{"-machine q35"}, noKVM)
+	if err != nil {
+		t.Fatalf("expandWithProbe() unexpected error: %v", err)
+	}
+	if want := []string{"-accel tcg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expandWithProbe() = %v, want %v", got, want)
+	}
+
+	got, err = TuningConfig{Accel: "kvm"}.expandWithProbe(nil, noKVM)
+	if err != nil {
+		t.Fatalf("expandWithProbe() unexpected error: %v", err)
+	}
+	if want := []string{"-accel kvm"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expandWithProbe() with explicit accel = %v, want %v", got, want)
+	}
+
+	if _, err := (TuningConfig{Accel: "auto"}).expandWithProbe([]string{"-machine q35,accel=kvm"}, noKVM); err == nil {
+		t.Error("expandWithProbe() should fail when cmd also sets accel")
+	}
+
+	if _, err := (TuningConfig{Accel: "auto", KVM: true}).expandWithProbe(nil, noKVM); err == nil {
+		t.Error("expandWithProbe() should fail when both kvm and accel are set")
+	}
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// checkBinary verifies that bin, configured as setting, names an executable
+// either in PATH or by path, so a missing QEMU install is reported up front
+// instead of failing deep inside exec
+func checkBinary(setting, bin, example string) error {
+	if bin == "" {
+		return fmt.Errorf("%s is not set, add e.g. %s to the config", setting, example)
+	}
+	if _, err := exec.LookPath(bin); err != nil {
+		return fmt.Errorf("%s = %q cannot be run, install QEMU or fix the path in the config: %w", setting, bin, err)
+	}
+	return nil
+}
+
+// CheckImageBinaries verifies the binaries building an image needs: qemu-img
+// always, and [qemu].bin when a cloud-init image boots a VM to customize it
+func (c *Config) CheckImageBinaries(imgName string) error {
+	img, err := c.GetImage(imgName)
+	if err != nil {
+		return err
+	}
+
+	if err := checkBinary("[qemu].img", c.Qemu.Img, `img = "qemu-img" under [qemu]`); err != nil {
+		return err
+	}
+	if img.Builder == "cloud-init" && len(img.BuildArgs) > 0 {
+		return checkBinary("[qemu].bin", c.Qemu.Bin, `bin = "qemu-system-x86_64" under [qemu]`)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckBinary(t *testing.T) {
+	binDir := t.TempDir()
+	executable := filepath.Join(binDir, "qemu-system-x86_64")
+	if err := os.WriteFile(executable, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to create fake QEMU binary: %v", err)
+	}
+	notExecutable := filepath.Join(binDir, "qemu-img")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("Failed to create fake qemu-img: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+
+	tests := []struct {
+		name    string
+		bin     string
+		wantErr string
+	}{
+		{name: "empty", bin: "", wantErr: "is not set"},
+		{name: "missing from PATH", bin: "qemu-system-riscv64", wantErr: "cannot be run"},
+		{name: "missing path", bin: filepath.Join(binDir, "missing"), wantErr: "cannot be run"},
+		{name: "not executable", bin: notExecutable, wantErr: "cannot be run"},
+		{name: "found in PATH", bin: "qemu-system-x86_64"},
+		{name: "absolute path", bin: executable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBinary("[qemu].bin", tt.bin, `bin = "qemu-system-x86_64"`)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkBinary(%q) error = %v", tt.bin, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkBinary(%q) error = %v, want %q", tt.bin, err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), "[qemu].bin") {
+				t.Errorf("Expected the error to name the setting, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckImageBinaries(t *testing.T) {
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "qemu-img"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to create fake qemu-img: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+
+	config := &Config{
+		Qemu: QemuConfig{Bin: "qemu-system-x86_64", Img: "qemu-img"},
+		Images: map[string]ImageConfig{
+			"disk":   {Builder: "raw"},
+			"plain":  {Builder: "cloud-init"},
+			"custom": {Builder: "cloud-init", BuildArgs: []string{"-m 1G"}},
+		},
+	}
+
+	// Only images booting a customization VM need QEMU itself
+	for _, imgName := range []string{"disk", "plain"} {
+		if err := config.CheckImageBinaries(imgName); err != nil {
+			t.Errorf("CheckImageBinaries(%s) error = %v", imgName, err)
+		}
+	}
+	if err := config.CheckImageBinaries("custom"); err == nil || !strings.Contains(err.Error(), "[qemu].bin") {
+		t.Errorf("CheckImageBinaries(custom) error = %v, want missing [qemu].bin", err)
+	}
+
+	config.Qemu.Img = ""
+	if err := config.CheckImageBinaries("disk"); err == nil || !strings.Contains(err.Error(), "[qemu].img is not set") {
+		t.Errorf("CheckImageBinaries() without qemu-img error = %v, want unset [qemu].img", err)
+	}
+}
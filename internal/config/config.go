@@ -6,7 +6,10 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -19,6 +22,23 @@ type Config struct {
 	Images map[string]ImageConfig `toml:"img"`
 	Vars   map[string]interface{} `toml:"vars"`
 	SSH    map[string]interface{} `toml:"ssh"`
+
+	Defaults DefaultsConfig `toml:"defaults"`
+	Trace    TraceConfig    `toml:"trace"`
+
+	// unknownKeys are the keys of the config file the decoder did not consume
+	unknownKeys []string
+}
+
+// TraceConfig holds the project's default tracing, QQMGR_TRACE and --trace take precedence
+type TraceConfig struct {
+	Patterns []string `toml:"patterns"` // Trace categories to enable, e.g. ["qemu", "iso"]
+	File     string   `toml:"file"`     // Trace log path, relative to the config file (default: <runtime dir>/trace.log)
+}
+
+// DefaultsConfig holds values merged underneath every VM at load time
+type DefaultsConfig struct {
+	VM VMConfig `toml:"vm"`
 }
 
 type QemuConfig struct {
@@ -27,9 +47,11 @@ type QemuConfig struct {
 }
 
 type SSHConfig struct {
-	Port    int64                  `toml:"port"`
-	VMPort  int64                  `toml:"vm_port"`
-	Options map[string]interface{} `toml:"-"` // All other SSH options
+	Port      int64                  `toml:"port"`
+	VMPort    int64                  `toml:"vm_port"`
+	User      string                 `toml:"user"`       // Account to log in as, written as the User directive
+	ProxyJump string                 `toml:"proxy_jump"` // Bastion host(s) to connect through, written as the ProxyJump directive
+	Options   map[string]interface{} `toml:"-"`          // All other SSH options
 }
 
 // UnmarshalTOML implements custom unmarshaling to capture all SSH options
@@ -53,6 +75,14 @@ func (s *SSHConfig) UnmarshalTOML(data interface{}) error {
 				if vmPort, ok := v.(int64); ok {
 					s.VMPort = vmPort
 				}
+			case "user":
+				if user, ok := v.(string); ok {
+					s.User = user
+				}
+			case "proxy_jump":
+				if jump, ok := v.(string); ok {
+					s.ProxyJump = jump
+				}
 			default:
 				// Store all other options
 				s.Options[k] = v
@@ -64,9 +94,131 @@ func (s *SSHConfig) UnmarshalTOML(data interface{}) error {
 }
 
 type VMConfig struct {
-	Cmd  []string               `toml:"cmd"`
-	Vars map[string]interface{} `toml:"vars"`
-	SSH  SSHConfig              `toml:"ssh"`
+	Arch   string                 `toml:"arch"` // Optional, selects qemu-system-<arch> over [qemu].bin
+	Cmd    []string               `toml:"cmd"`
+	Vars   map[string]interface{} `toml:"vars"`
+	Env    map[string]interface{} `toml:"env"` // Environment of the QEMU process, values are templates like cmd
+	SSH    SSHConfig              `toml:"ssh"`
+	Tuning TuningConfig           `toml:"tuning"`
+
+	ReadyCheck *ReadyCheckConfig `toml:"ready_check"` // What start --wait-ready waits for
+	GuestAgent bool              `toml:"guest_agent"` // Inject a QEMU guest agent channel on qga.socket
+
+	KeepLogs bool  `toml:"keep_logs"` // Rotate QEMU logs to *.1 on start instead of deleting them
+	Enabled  *bool `toml:"enabled"`   // false hides the VM from listings, it can still be started by name
+
+	Profiles map[string]ProfileConfig `toml:"profile"` // Variations selected with start --profile
+}
+
+// IsEnabled reports whether the VM takes part in listings, VMs are enabled unless set otherwise
+func (v VMConfig) IsEnabled() bool {
+	return v.Enabled == nil || *v.Enabled
+}
+
+// TuningConfig holds optional typed knobs which expand to common QEMU arguments
+type TuningConfig struct {
+	KVM   bool   `toml:"kvm"`   // -enable-kvm
+	Accel string `toml:"accel"` // -accel <accel>, "auto" picks kvm, hvf or tcg for this host
+	RTC   string `toml:"rtc"`   // -rtc base=<rtc>
+	CPU   string `toml:"cpu"`   // -cpu <cpu>
+
+	Hugepages string `toml:"hugepages"` // Back guest RAM by files in this hugetlbfs mount, requires memory
+	Memory    string `toml:"memory"`    // -m <memory>, the size of the hugepages memory backend
+
+	TPM bool `toml:"tpm"` // Attach a TPM 2.0 emulated by swtpm, see VmEntry.TPMSocketPath
+}
+
+// expand returns the QEMU arguments for the tuning knobs which are set, failing
+// if an argument controlling the same setting is also given in cmd
+func (t TuningConfig) expand(cmd []string) ([]string, error) {
+	return t.expandWithProbe(cmd, hostAccelProbe)
+}
+
+// expandWithProbe is expand with the host capabilities used for accel = "auto"
+func (t TuningConfig) expandWithProbe(cmd []string, probe accelProbe) ([]string, error) {
+	type knob struct {
+		name      string
+		args      string
+		conflicts []string
+	}
+
+	var knobs []knob
+	if t.KVM && t.Accel != "" {
+		return nil, fmt.Errorf("tuning options 'kvm' and 'accel' are mutually exclusive")
+	}
+	if t.KVM {
+		knobs = append(knobs, knob{"kvm", "-enable-kvm", []string{"-enable-kvm", "-accel", "accel="}})
+	}
+	if t.Accel != "" {
+		accel := t.Accel
+		if accel == "auto" {
+			accel = probe.detect()
+		}
+		knobs = append(knobs, knob{"accel", "-accel " + accel, []string{"-enable-kvm", "-accel", "accel="}})
+	}
+	if t.RTC != "" {
+		knobs = append(knobs, knob{"rtc", "-rtc base=" + t.RTC, []string{"-rtc"}})
+	}
+	if t.CPU != "" {
+		knobs = append(knobs, knob{"cpu", "-cpu " + t.CPU, []string{"-cpu"}})
+	}
+	if t.Hugepages != "" {
+		if t.Memory == "" {
+			return nil, fmt.Errorf("tuning option 'hugepages' requires 'memory' to be set")
+		}
+		args := fmt.Sprintf("-object memory-backend-file,id=mem0,size=%s,mem-path=%s,share=on,prealloc=on -machine memory-backend=mem0 -m %s",
+			t.Memory, t.Hugepages, t.Memory)
+		knobs = append(knobs, knob{"hugepages", args, []string{"-m", "memory-backend="}})
+	} else if t.Memory != "" {
+		return nil, fmt.Errorf("tuning option 'memory' is only used together with 'hugepages'")
+	}
+
+	var expanded []string
+	for _, k := range knobs {
+		if err := checkTuningConflicts(k.name, k.conflicts, cmd); err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, k.args)
+	}
+
+	return expanded, nil
+}
+
+// checkTuningConflicts fails if cmd contains one of the arguments conflicting
+// with the tuning option name, those ending in '=' match within an argument
+func checkTuningConflicts(name string, conflicts, cmd []string) error {
+	for _, cmdPart := range cmd {
+		for _, part := range strings.Fields(cmdPart) {
+			for _, conflicting := range conflicts {
+				if part == conflicting || (strings.HasSuffix(conflicting, "=") && strings.Contains(part, conflicting)) {
+					return fmt.Errorf("tuning option '%s' conflicts with '%s' in cmd, remove one of them", name, part)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TPMChardevID is the id of the chardev connecting QEMU to swtpm
+const TPMChardevID = "chrtpm"
+
+// tpmArgs returns the QEMU arguments attaching the swtpm listening on
+// socketPath as a TPM, or none if tpm is not set. The TIS device is the
+// memory-mapped variant on arm, which lacks an ISA bus.
+func (t TuningConfig) tpmArgs(cmd []string, arch, socketPath string) ([]string, error) {
+	if !t.TPM {
+		return nil, nil
+	}
+	if err := checkTuningConflicts("tpm", []string{"-tpmdev"}, cmd); err != nil {
+		return nil, err
+	}
+
+	device := "tpm-tis"
+	if arch == "aarch64" || arch == "arm" {
+		device = "tpm-tis-device"
+	}
+	return []string{fmt.Sprintf("-chardev socket,id=%s,path=%s -tpmdev emulator,id=tpm0,chardev=%s -device %s,tpmdev=tpm0",
+		TPMChardevID, socketPath, TPMChardevID, device)}, nil
 }
 
 // ImageConfig represents the configuration for an image
@@ -75,16 +227,61 @@ type ImageConfig struct {
 	ImgSize   string                 `toml:"img_size"`
 	BaseImg   *BaseImageConfig       `toml:"base_img,omitempty"`
 	Env       map[string]interface{} `toml:"env,omitempty"`
+	EnvFile   string                 `toml:"env_file,omitempty"` // Dotenv file relative to the config file, env entries win over it
 	EnvHook   *EnvHookConfig         `toml:"env_hook,omitempty"`
 	Templates []TemplateConfig       `toml:"templates,omitempty"`
 	Sources   []SourceConfig         `toml:"sources,omitempty"`
 	BuildArgs []string               `toml:"build_args,omitempty"`
+	Output    string                 `toml:"output,omitempty"` // Optional stable path the built image is copied to
+	CloudInit *CloudInitConfig       `toml:"cloud_init,omitempty"`
+	PostBuild *EnvHookConfig         `toml:"post_build,omitempty"` // Script run after a build which changed the image, gets the image path and manifest on stdin
+
+	ParallelDownloads int `toml:"parallel_downloads,omitempty"` // How many sources are downloaded at once, default 1
+}
+
+// Datasources a cloud-init ISO can be written for
+const (
+	CloudInitNoCloud     = "nocloud"
+	CloudInitConfigDrive = "configdrive"
+)
+
+// CloudInitConfig selects the cloud-init datasource the ISO of a cloud-init image
+// is laid out for
+type CloudInitConfig struct {
+	Datasource  string `toml:"datasource"`   // "nocloud" (default) or "configdrive"
+	VolumeLabel string `toml:"volume_label"` // Defaults to cidata (nocloud) or config-2 (configdrive)
+}
+
+// ISODatasource returns the configured datasource, nocloud if none is set
+func (c *CloudInitConfig) ISODatasource() string {
+	if c == nil || c.Datasource == "" {
+		return CloudInitNoCloud
+	}
+	return c.Datasource
+}
+
+// ISOVolumeLabel returns the volume label of the ISO, the one cloud-init looks
+// for with the datasource unless volume_label overrides it
+func (c *CloudInitConfig) ISOVolumeLabel() string {
+	if c != nil && c.VolumeLabel != "" {
+		return c.VolumeLabel
+	}
+	if c.ISODatasource() == CloudInitConfigDrive {
+		return "config-2"
+	}
+	return "cidata"
 }
 
 // BaseImageConfig represents configuration for a base image
 type BaseImageConfig struct {
-	URL       string `toml:"url"`
-	SHA256Sum string `toml:"sha256sum"`
+	URL       string   `toml:"url"`
+	URLs      []string `toml:"urls"` // Optional mirrors, tried in order after url
+	SHA256Sum string   `toml:"sha256sum"`
+}
+
+// Mirrors returns all URLs the base image can be downloaded from, in order
+func (b *BaseImageConfig) Mirrors() []string {
+	return mirrorList(b.URL, b.URLs)
 }
 
 // EnvHookConfig represents configuration for an environment hook
@@ -101,9 +298,29 @@ type TemplateConfig struct {
 
 // SourceConfig represents configuration for an additional source
 type SourceConfig struct {
-	URL       string `toml:"url"`
-	SHA256Sum string `toml:"sha256sum"`
-	Filename  string `toml:"filename"`
+	URL       string   `toml:"url"`
+	URLs      []string `toml:"urls"` // Optional mirrors, tried in order after url
+	SHA256Sum string   `toml:"sha256sum"`
+	Filename  string   `toml:"filename"`
+}
+
+// Mirrors returns all URLs the source can be downloaded from, in order
+func (s *SourceConfig) Mirrors() []string {
+	return mirrorList(s.URL, s.URLs)
+}
+
+// mirrorList combines a primary url and a list of mirrors, skipping empty entries
+func mirrorList(url string, urls []string) []string {
+	var mirrors []string
+	if url != "" {
+		mirrors = append(mirrors, url)
+	}
+	for _, mirror := range urls {
+		if mirror != "" {
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	return mirrors
 }
 
 // VmEntry represents a resolved VM configuration with runtime information
@@ -112,10 +329,68 @@ type VmEntry struct {
 	Cmd     []string               // Resolved command arguments
 	Vars    map[string]interface{} // VM variables
 	DataDir string                 // Runtime directory for this VM
+
+	KeepLogs   bool              // Rotate the previous QEMU logs on start instead of deleting them
+	Env        map[string]string // Resolved [vm.x.env], set on top of the inherited environment of QEMU
+	ReadyCheck *ReadyCheckConfig // Optional check for start --wait-ready
+	GuestAgent bool              // Inject a guest agent channel, see GuestAgentSocketPath
+	TPM        bool              // Cmd attaches a TPM emulated by swtpm, which must be started first
+	Hugepages  string            // hugetlbfs mount backing guest RAM, see CheckHugepages
+
+	// Optional runtime paths used instead of the ones in DataDir, to control a QEMU started by another tool
+	QmpSocket string
+	PidFile   string
+}
+
+// CheckHugepages fails if the VM's guest RAM is backed by hugepages and their
+// mount is missing. It is checked before starting QEMU rather than on resolving,
+// so stopping or inspecting a VM works on hosts without the mount.
+func (v *VmEntry) CheckHugepages() error {
+	if v.Hugepages == "" {
+		return nil
+	}
+	info, err := os.Stat(v.Hugepages)
+	if err != nil {
+		return fmt.Errorf("tuning option 'hugepages': %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("tuning option 'hugepages': %s is not a directory", v.Hugepages)
+	}
+	return nil
+}
+
+// Environ returns base, an environment in os.Environ form, with the VM's env
+// variables set on top, replacing inherited values of the same name
+func (v *VmEntry) Environ(base []string) []string {
+	if len(v.Env) == 0 {
+		return base
+	}
+
+	environ := make([]string, 0, len(base)+len(v.Env))
+	for _, entry := range base {
+		key, _, _ := strings.Cut(entry, "=")
+		if _, overridden := v.Env[key]; !overridden {
+			environ = append(environ, entry)
+		}
+	}
+
+	keys := make([]string, 0, len(v.Env))
+	for key := range v.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		environ = append(environ, key+"="+v.Env[key])
+	}
+	return environ
 }
 
 // PidFilePath returns the path to the PID file
 func (v *VmEntry) PidFilePath() string {
+	if v.PidFile != "" {
+		absPath, _ := filepath.Abs(v.PidFile)
+		return absPath
+	}
 	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "pid"))
 	return absPath
 }
@@ -128,6 +403,10 @@ func (v *VmEntry) SerialFilePath() string {
 
 // QmpSocketPath returns the path to the QMP socket
 func (v *VmEntry) QmpSocketPath() string {
+	if v.QmpSocket != "" {
+		absPath, _ := filepath.Abs(v.QmpSocket)
+		return absPath
+	}
 	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "qmp.socket"))
 	return absPath
 }
@@ -138,12 +417,61 @@ func (v *VmEntry) MonitorSocketPath() string {
 	return absPath
 }
 
+// GuestAgentSocketPath returns the path to the socket of the injected guest agent channel
+func (v *VmEntry) GuestAgentSocketPath() string {
+	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "qga.socket"))
+	return absPath
+}
+
+// TPMSocketPath returns the path to the control socket of the VM's swtpm
+func (v *VmEntry) TPMSocketPath() string {
+	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "swtpm.sock"))
+	return absPath
+}
+
+// TPMStateDir returns the directory swtpm keeps the VM's TPM state in, it
+// survives restarts like the disks do
+func (v *VmEntry) TPMStateDir() string {
+	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "tpm"))
+	return absPath
+}
+
+// PanicFilePath returns the path where a GUEST_PANICKED event is recorded
+func (v *VmEntry) PanicFilePath() string {
+	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "panic.json"))
+	return absPath
+}
+
+// LockFilePath returns the path to the lock file serializing start and stop of the VM
+func (v *VmEntry) LockFilePath() string {
+	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, ".lock"))
+	return absPath
+}
+
 // SshConfigPath returns the path to the SSH config file
 func (v *VmEntry) SshConfigPath() string {
 	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "ssh.conf"))
 	return absPath
 }
 
+// SSHPort returns the host port forwarded to the VM's SSH server, from
+// vm.ssh.port or the older ssh_host variable, and false if there is none
+func (v *VmEntry) SSHPort() (int64, bool) {
+	if sshData, ok := v.Vars["ssh"].(map[string]interface{}); ok {
+		if port, ok := sshData["port"].(int64); ok {
+			return port, true
+		}
+	}
+	port, ok := v.Vars["ssh_host"].(int64)
+	return port, ok
+}
+
+// SshControlDir returns the directory holding SSH ControlMaster sockets
+func (v *VmEntry) SshControlDir() string {
+	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "ssh"))
+	return absPath
+}
+
 // QemuStdoutPath returns the path to the QEMU stdout log file
 func (v *VmEntry) QemuStdoutPath() string {
 	absPath, _ := filepath.Abs(filepath.Join(v.DataDir, "qemu-stdout.log"))
@@ -156,9 +484,12 @@ func (v *VmEntry) QemuStderrPath() string {
 	return absPath
 }
 
+// GuestAgentChardevID is the id of the chardev carrying the guest agent channel
+const GuestAgentChardevID = "qga0"
+
 // GetAutoInjectedArgs returns the auto-injected QEMU arguments as specified in the design
 func (v *VmEntry) GetAutoInjectedArgs() []string {
-	return []string{
+	args := []string{
 		"-pidfile", v.PidFilePath(),
 		"-monitor",
 		fmt.Sprintf("unix:%s,server,nowait", v.MonitorSocketPath()),
@@ -167,6 +498,16 @@ func (v *VmEntry) GetAutoInjectedArgs() []string {
 		"-qmp",
 		fmt.Sprintf("unix:%s,server,nowait", v.QmpSocketPath()),
 	}
+	if v.GuestAgent {
+		args = append(args,
+			"-chardev",
+			fmt.Sprintf("socket,path=%s,server,nowait,id=%s", v.GuestAgentSocketPath(), GuestAgentChardevID),
+			"-device", "virtio-serial",
+			"-device",
+			fmt.Sprintf("virtserialport,chardev=%s,name=org.qemu.guest_agent.0", GuestAgentChardevID),
+		)
+	}
+	return args
 }
 
 // GetFullCommand returns the complete command with auto-injected arguments
@@ -195,8 +536,12 @@ func GlobalConfigPath() (string, error) {
 	return filepath.Join(homeDir, ".config", "qqmgr", "conf.toml"), nil
 }
 
+// CeilingEnvVar names an environment variable holding a directory at which the
+// upward search for a project config stops (the directory itself is still searched)
+const CeilingEnvVar = "QQMGR_CONFIG_CEILING"
+
 // FindConfigPath determines the configuration file path to use
-// It checks in order: provided path, current directory, global location
+// It checks in order: provided path, current directory and its parents, global location
 func FindConfigPath(providedPath string) (string, error) {
 	// If a path is provided, use it
 	if providedPath != "" {
@@ -211,6 +556,11 @@ func FindConfigPath(providedPath string) (string, error) {
 		return "qqmgr.toml", nil
 	}
 
+	// Walk up the parent directories, like git does for .git
+	if path, ok := findConfigInParents(); ok {
+		return path, nil
+	}
+
 	// Try global config
 	globalPath, err := GlobalConfigPath()
 	if err == nil {
@@ -219,16 +569,57 @@ func FindConfigPath(providedPath string) (string, error) {
 		}
 	}
 
-	return "", fmt.Errorf("no configuration file found (looked for ./qqmgr.toml and %s)", globalPath)
+	return "", fmt.Errorf("no configuration file found (looked for qqmgr.toml in current and parent directories and %s)", globalPath)
+}
+
+// findConfigInParents searches the parents of the current directory for a qqmgr.toml,
+// stopping at the filesystem root or the directory named by CeilingEnvVar
+func findConfigInParents() (string, bool) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+
+	ceiling := ""
+	if env := os.Getenv(CeilingEnvVar); env != "" {
+		if abs, err := filepath.Abs(env); err == nil {
+			ceiling = filepath.Clean(abs)
+		}
+	}
+
+	dir := filepath.Clean(cwd)
+	for dir != ceiling {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Reached the filesystem root
+			return "", false
+		}
+		dir = parent
+
+		candidate := filepath.Join(dir, "qqmgr.toml")
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+
+	return "", false
 }
 
-// LoadConfig loads configuration from the determined path
-func LoadConfig(configPath string) (*Config, error) {
+// LoadConfig loads configuration from the determined path, with the overlay
+// files merged on top in order
+func LoadConfig(configPath string, overlays ...string) (*Config, error) {
 	path, err := FindConfigPath(configPath)
 	if err != nil {
 		return nil, err
 	}
-	return LoadFromFile(path)
+	paths := []string{path}
+	for _, overlay := range overlays {
+		if _, err := os.Stat(overlay); err != nil {
+			return nil, fmt.Errorf("provided config file not found: %s", overlay)
+		}
+		paths = append(paths, overlay)
+	}
+	return LoadFromFiles(paths)
 }
 
 // GetRuntimeDir determines the runtime directory based on config file location
@@ -238,34 +629,231 @@ func GetRuntimeDir(configPath string) (string, error) {
 		return "", err
 	}
 
+	// Normalize so the same config file always maps to the same runtime
+	// directory, regardless of the CWD or how the file was referenced
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve config path %s: %w", path, err)
+	}
+
 	// if using the global config file
 	globalPath, err := GlobalConfigPath()
-	if err == nil && globalPath == path {
+	if err == nil && filepath.Clean(globalPath) == absPath {
 		return filepath.Join(filepath.Dir(globalPath), "qqmgr"), nil
 	}
 
 	// otherwise, expect a directory (matching the config file name) under .qqmgr
-	return filepath.Join(filepath.Dir(path), ".qqmgr", filepath.Base(configPath)), nil
+	return filepath.Join(filepath.Dir(absPath), ".qqmgr", filepath.Base(absPath)), nil
 }
 
 // LoadFromFile loads configuration from a specific file path
 func LoadFromFile(path string) (*Config, error) {
 	var config Config
-	if _, err := toml.DecodeFile(path, &config); err != nil {
+	meta, err := toml.DecodeFile(path, &config)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode config file %s: %w", path, err)
 	}
+	return config.finishLoading(meta)
+}
+
+// LoadFromFiles loads the first file with the others merged on top in order.
+// Tables are merged key by key and any other value of a later file replaces
+// the earlier one, so an override file only needs the keys it changes.
+func LoadFromFiles(paths []string) (*Config, error) {
+	if len(paths) == 1 {
+		return LoadFromFile(paths[0])
+	}
+
+	merged := make(map[string]interface{})
+	for _, path := range paths {
+		var layer map[string]interface{}
+		if _, err := toml.DecodeFile(path, &layer); err != nil {
+			return nil, fmt.Errorf("failed to decode config file %s: %w", path, err)
+		}
+		mergeTables(merged, layer)
+	}
+
+	// Decode the merged tables like a single file, re-encoded as TOML
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(merged); err != nil {
+		return nil, fmt.Errorf("failed to merge config files: %w", err)
+	}
+	var config Config
+	meta, err := toml.Decode(buf.String(), &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode merged config files %s: %w", strings.Join(paths, ", "), err)
+	}
+	return config.finishLoading(meta)
+}
+
+// mergeTables merges src into dst, recursing into tables present in both
+func mergeTables(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcTable, srcIsTable := v.(map[string]interface{})
+		dstTable, dstIsTable := dst[k].(map[string]interface{})
+		if srcIsTable && dstIsTable {
+			mergeTables(dstTable, srcTable)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// finishLoading applies the defaults to a decoded configuration and validates it
+func (c *Config) finishLoading(meta toml.MetaData) (*Config, error) {
+	for _, key := range meta.Undecoded() {
+		c.unknownKeys = append(c.unknownKeys, key.String())
+	}
+
+	// Merge [defaults.vm] underneath each VM before validating
+	c.applyVMDefaults(meta)
 
 	// Validate SSH configuration for all VMs
-	if err := config.validateSSHConfig(); err != nil {
+	if err := c.validateSSHConfig(); err != nil {
 		return nil, fmt.Errorf("SSH configuration validation failed: %w", err)
 	}
 
+	if err := c.validateReadyChecks(); err != nil {
+		return nil, err
+	}
+
+	if err := c.validateProfiles(); err != nil {
+		return nil, err
+	}
+
 	// Validate image configurations
-	if err := config.validateImageConfig(); err != nil {
+	if err := c.validateImageConfig(); err != nil {
 		return nil, fmt.Errorf("image configuration validation failed: %w", err)
 	}
 
-	return &config, nil
+	return c, nil
+}
+
+// UnknownKeys returns the keys of the config file which do not correspond to any
+// setting, e.g. misspelled ones, which the decoder silently ignores
+func (c *Config) UnknownKeys() []string {
+	return c.unknownKeys
+}
+
+// CheckUnknownKeys returns an error listing the unknown keys, if there are any
+func (c *Config) CheckUnknownKeys() error {
+	if len(c.unknownKeys) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unknown configuration keys: %s", strings.Join(c.unknownKeys, ", "))
+}
+
+// applyVMDefaults merges [defaults.vm] into every VM, values set on the VM win.
+// Bools are looked up in meta, as a VM setting one to false is indistinguishable
+// from not setting it otherwise.
+func (c *Config) applyVMDefaults(meta toml.MetaData) {
+	defaults := c.Defaults.VM
+	for vmName, vm := range c.VMs {
+		defined := func(key ...string) bool {
+			return meta.IsDefined(append([]string{"vm", vmName}, key...)...)
+		}
+
+		if vm.Arch == "" {
+			vm.Arch = defaults.Arch
+		}
+		if !defined("keep_logs") {
+			vm.KeepLogs = defaults.KeepLogs
+		}
+		if vm.Enabled == nil {
+			vm.Enabled = defaults.Enabled
+		}
+		if vm.ReadyCheck == nil {
+			vm.ReadyCheck = defaults.ReadyCheck
+		}
+		if !defined("guest_agent") {
+			vm.GuestAgent = defaults.GuestAgent
+		}
+
+		// Default cmd entries are a prefix to the VM's own
+		if len(defaults.Cmd) > 0 {
+			vm.Cmd = append(append([]string{}, defaults.Cmd...), vm.Cmd...)
+		}
+
+		vm.Vars = mergeMissing(vm.Vars, defaults.Vars)
+		vm.Env = mergeMissing(vm.Env, defaults.Env)
+
+		// Default profiles are available to every VM unless it defines one of the same name
+		for name, profile := range defaults.Profiles {
+			if _, exists := vm.Profiles[name]; !exists {
+				if vm.Profiles == nil {
+					vm.Profiles = make(map[string]ProfileConfig, len(defaults.Profiles))
+				}
+				vm.Profiles[name] = profile
+			}
+		}
+
+		// The host port must be unique per VM, so only vm_port is inherited
+		if vm.SSH.VMPort == 0 {
+			vm.SSH.VMPort = defaults.SSH.VMPort
+		}
+		if vm.SSH.User == "" {
+			vm.SSH.User = defaults.SSH.User
+		}
+		if vm.SSH.ProxyJump == "" {
+			vm.SSH.ProxyJump = defaults.SSH.ProxyJump
+		}
+		vm.SSH.Options = mergeMissing(vm.SSH.Options, defaults.SSH.Options)
+
+		// kvm and accel pick the same setting, a VM setting either ignores both defaults
+		if !defined("tuning", "kvm") && vm.Tuning.Accel == "" {
+			vm.Tuning.KVM = defaults.Tuning.KVM
+			vm.Tuning.Accel = defaults.Tuning.Accel
+		}
+		if vm.Tuning.RTC == "" {
+			vm.Tuning.RTC = defaults.Tuning.RTC
+		}
+		if vm.Tuning.CPU == "" {
+			vm.Tuning.CPU = defaults.Tuning.CPU
+		}
+		// hugepages and memory describe one memory backend, inherit them together
+		if vm.Tuning.Hugepages == "" && vm.Tuning.Memory == "" {
+			vm.Tuning.Hugepages = defaults.Tuning.Hugepages
+			vm.Tuning.Memory = defaults.Tuning.Memory
+		}
+		if !defined("tuning", "tpm") {
+			vm.Tuning.TPM = defaults.Tuning.TPM
+		}
+
+		c.VMs[vmName] = vm
+	}
+}
+
+// mergeMissing returns dst with every key of src it does not already have
+func mergeMissing(dst, src map[string]interface{}) map[string]interface{} {
+	if len(src) == 0 {
+		return dst
+	}
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range src {
+		merged[k] = v
+	}
+	for k, v := range dst {
+		merged[k] = v
+	}
+	return merged
+}
+
+// validateProxyJump checks a proxy_jump value: "none" or a comma-separated list
+// of [user@]host[:port] hops, as ssh's ProxyJump takes them
+func validateProxyJump(jump string) error {
+	if jump == "" || jump == "none" {
+		return nil
+	}
+	for _, hop := range strings.Split(jump, ",") {
+		if hop == "" || strings.ContainsAny(hop, " \t\n") {
+			return fmt.Errorf("invalid proxy_jump %q: expected comma-separated [user@]host[:port] hops", jump)
+		}
+		host := hop[strings.LastIndex(hop, "@")+1:]
+		if host == "" || strings.HasPrefix(host, ":") {
+			return fmt.Errorf("invalid proxy_jump %q: hop %q has no host", jump, hop)
+		}
+	}
+	return nil
 }
 
 // validateSSHConfig ensures all VMs have proper SSH configuration
@@ -279,6 +867,10 @@ func (c *Config) validateSSHConfig() error {
 			vm.SSH.VMPort = 22
 		}
 
+		if err := validateProxyJump(vm.SSH.ProxyJump); err != nil {
+			return fmt.Errorf("VM '%s': %w", vmName, err)
+		}
+
 		// Initialize Options map if not present
 		if vm.SSH.Options == nil {
 			vm.SSH.Options = make(map[string]interface{})
@@ -320,8 +912,10 @@ func (c *Config) ResolveVM(vmName string, configPath string, imgMap map[string]i
 
 	// Add SSH configuration under "vm.ssh" key
 	vmData["ssh"] = map[string]interface{}{
-		"port":    vm.SSH.Port,
-		"vm_port": vm.SSH.VMPort,
+		"port":       vm.SSH.Port,
+		"vm_port":    vm.SSH.VMPort,
+		"user":       vm.SSH.User,
+		"proxy_jump": vm.SSH.ProxyJump,
 	}
 
 	// Add VM data under "vm" key
@@ -332,49 +926,145 @@ func (c *Config) ResolveVM(vmName string, configPath string, imgMap map[string]i
 
 	var resolved []string
 	for _, cmdPart := range vm.Cmd {
-		// First pass: resolve VM variables
-		tmpl := template.New("cmd")
-		tmpl, err := tmpl.Parse(cmdPart)
+		part, err := renderTemplate(cmdPart, data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse template in command: %w", err)
-		}
-
-		var buf bytes.Buffer
-		err = tmpl.Execute(&buf, data)
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute template: %w", err)
-		}
-
-		// Second pass: resolve any remaining global variables
-		intermediate := buf.String()
-		tmpl2 := template.New("cmd2")
-		tmpl2, err = tmpl2.Parse(intermediate)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse intermediate template: %w", err)
+			return nil, err
 		}
+		resolved = append(resolved, part)
+	}
 
-		var finalBuf bytes.Buffer
-		err = tmpl2.Execute(&finalBuf, data)
+	env := make(map[string]string, len(vm.Env))
+	for key, value := range vm.Env {
+		rendered, err := renderTemplate(fmt.Sprint(value), data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to execute final template: %w", err)
+			return nil, fmt.Errorf("env %s: %w", key, err)
 		}
+		env[key] = rendered
+	}
 
-		resolved = append(resolved, finalBuf.String())
+	// Append arguments from the [vm.x.tuning] table
+	tuningArgs, err := vm.Tuning.expand(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("VM '%s': %w", vmName, err)
 	}
+	resolved = append(resolved, tuningArgs...)
 
 	// Create VM-specific runtime directory
 	vmDataDir := filepath.Join(runtimeDir, "vm."+vmName)
 
-	return &VmEntry{
+	entry := &VmEntry{
 		Name:    vmName,
-		Cmd:     resolved,
 		Vars:    vmData, // Store the resolved VM data including SSH
 		DataDir: vmDataDir,
-	}, nil
+
+		KeepLogs:   vm.KeepLogs,
+		Env:        env,
+		ReadyCheck: vm.ReadyCheck,
+		GuestAgent: vm.GuestAgent,
+		TPM:        vm.Tuning.TPM,
+		Hugepages:  vm.Tuning.Hugepages,
+	}
+
+	tpmArgs, err := vm.Tuning.tpmArgs(resolved, vm.Arch, entry.TPMSocketPath())
+	if err != nil {
+		return nil, fmt.Errorf("VM '%s': %w", vmName, err)
+	}
+	entry.Cmd = append(resolved, tpmArgs...)
+
+	return entry, nil
+}
+
+// renderTemplate resolves a cmd or env template. The result is rendered a second
+// time, so variables may themselves refer to other variables.
+func renderTemplate(text string, data map[string]interface{}) (string, error) {
+	// First pass: resolve VM variables
+	tmpl := template.New("cmd")
+	tmpl, err := tmpl.Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template in command: %w", err)
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	// Second pass: resolve any remaining global variables
+	intermediate := buf.String()
+	tmpl2 := template.New("cmd2")
+	tmpl2, err = tmpl2.Parse(intermediate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse intermediate template: %w", err)
+	}
+
+	var finalBuf bytes.Buffer
+	err = tmpl2.Execute(&finalBuf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute final template: %w", err)
+	}
+
+	return finalBuf.String(), nil
+}
+
+// ResolveQemuBin returns the QEMU binary to launch a VM with.
+// VMs with an `arch` use qemu-system-<arch> from PATH, all others use [qemu].bin,
+// which must be set and name an executable
+func (c *Config) ResolveQemuBin(vmName string) (string, error) {
+	vm, exists := c.VMs[vmName]
+	if !exists {
+		return "", fmt.Errorf("VM '%s' not found in configuration", vmName)
+	}
+
+	if vm.Arch == "" {
+		if err := checkBinary("[qemu].bin", c.Qemu.Bin, `bin = "qemu-system-x86_64" under [qemu]`); err != nil {
+			return "", err
+		}
+		return c.Qemu.Bin, nil
+	}
+
+	binName := "qemu-system-" + vm.Arch
+	binPath, err := exec.LookPath(binName)
+	if err != nil {
+		return "", fmt.Errorf("VM '%s' has arch '%s' but %s was not found in PATH: %w", vmName, vm.Arch, binName, err)
+	}
+	return binPath, nil
+}
+
+// VMImageDependencies returns the sorted names of the images referenced by a VM's
+// cmd template, either as {{.img.name}} or {{index .img "name"}}
+func (c *Config) VMImageDependencies(vmName string) ([]string, error) {
+	vm, exists := c.VMs[vmName]
+	if !exists {
+		return nil, fmt.Errorf("VM '%s' not found in configuration", vmName)
+	}
+
+	cmd := strings.Join(vm.Cmd, " ")
+	var deps []string
+	for imgName := range c.Images {
+		quoted := regexp.QuoteMeta(imgName)
+		ref := regexp.MustCompile(`\.img\.` + quoted + `\b|index\s+\.img\s+"` + quoted + `"`)
+		if ref.MatchString(cmd) {
+			deps = append(deps, imgName)
+		}
+	}
+	sort.Strings(deps)
+	return deps, nil
 }
 
-// ListVMs returns a list of configured VM names
+// ListVMs returns a list of configured VM names, skipping disabled VMs
 func (c *Config) ListVMs() []string {
+	var vms []string
+	for name, vm := range c.VMs {
+		if vm.IsEnabled() {
+			vms = append(vms, name)
+		}
+	}
+	return vms
+}
+
+// ListAllVMs returns a list of configured VM names, including disabled VMs
+func (c *Config) ListAllVMs() []string {
 	var vms []string
 	for name := range c.VMs {
 		vms = append(vms, name)
@@ -419,6 +1109,76 @@ func (c *Config) validateImageConfig() error {
 		if img.Builder == "cloud-init" && img.BaseImg == nil {
 			return fmt.Errorf("cloud-init image '%s' missing required base_img configuration", imgName)
 		}
+
+		if err := validateISOFilenames(img); err != nil {
+			return fmt.Errorf("image '%s': %w", imgName, err)
+		}
+
+		if img.EnvFile != "" && img.Builder != "cloud-init" {
+			return fmt.Errorf("image '%s': env_file is only used by cloud-init images", imgName)
+		}
+
+		if img.ParallelDownloads < 0 {
+			return fmt.Errorf("image '%s': parallel_downloads must not be negative", imgName)
+		}
+
+		if img.PostBuild != nil && img.PostBuild.Script == "" {
+			return fmt.Errorf("image '%s': post_build requires a script", imgName)
+		}
+
+		if img.CloudInit != nil {
+			if img.Builder != "cloud-init" {
+				return fmt.Errorf("image '%s': cloud_init is only used by cloud-init images", imgName)
+			}
+			if err := img.CloudInit.validate(); err != nil {
+				return fmt.Errorf("image '%s': %w", imgName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the datasource is known and the label fits an ISO volume ID
+func (c *CloudInitConfig) validate() error {
+	switch c.Datasource {
+	case "", CloudInitNoCloud, CloudInitConfigDrive:
+	default:
+		return fmt.Errorf("invalid cloud_init datasource '%s' (must be '%s' or '%s')", c.Datasource, CloudInitNoCloud, CloudInitConfigDrive)
+	}
+	if len(c.VolumeLabel) > 32 {
+		return fmt.Errorf("cloud_init volume_label '%s' is longer than 32 characters", c.VolumeLabel)
+	}
+	return nil
+}
+
+// isoFilenamePattern matches names which can be grafted into the cloud-init ISO as-is
+var isoFilenamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,63}$`)
+
+// validateISOFilenames ensures template outputs and source filenames, which
+// become files in the cloud-init ISO root, are unique and valid ISO filenames
+func validateISOFilenames(img ImageConfig) error {
+	seen := make(map[string]string)
+
+	check := func(name, origin string) error {
+		if !isoFilenamePattern.MatchString(name) {
+			return fmt.Errorf("%s has invalid ISO filename '%s' (use at most 64 letters, digits, '.', '_' or '-', not starting with '.' or '-')", origin, name)
+		}
+		if prev, exists := seen[name]; exists {
+			return fmt.Errorf("%s and %s both write '%s' to the cloud-init ISO", prev, origin, name)
+		}
+		seen[name] = origin
+		return nil
+	}
+
+	for _, tmpl := range img.Templates {
+		if err := check(tmpl.Output, fmt.Sprintf("template '%s'", tmpl.Template)); err != nil {
+			return err
+		}
+	}
+	for _, source := range img.Sources {
+		if err := check(source.Filename, fmt.Sprintf("source '%s'", source.URL)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -7,8 +7,11 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
+
+	"github.com/BurntSushi/toml"
 )
 
 func TestFindConfigPath(t *testing.T) {
@@ -88,6 +91,109 @@ img = "qemu-img"`,
 	}
 }
 
+func TestFindConfigPathParentSearch(t *testing.T) {
+	projectDir := t.TempDir()
+	t.Setenv(CeilingEnvVar, projectDir)
+
+	projectConfig := filepath.Join(projectDir, "qqmgr.toml")
+	if err := os.WriteFile(projectConfig, []byte("[qemu]\n"), 0644); err != nil {
+		t.Fatalf("Failed to create project config: %v", err)
+	}
+
+	deepDir := filepath.Join(projectDir, "a", "b", "c")
+	if err := os.MkdirAll(deepDir, 0755); err != nil {
+		t.Fatalf("Failed to create nested directories: %v", err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current working directory: %v", err)
+	}
+	if err := os.Chdir(deepDir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	// Config in an ancestor directory is found from a deep subdirectory
+	gotPath, err := FindConfigPath("")
+	if err != nil {
+		t.Fatalf("FindConfigPath() unexpected error: %v", err)
+	}
+	if gotPath != projectConfig {
+		t.Errorf("FindConfigPath() = %v, want %v", gotPath, projectConfig)
+	}
+
+	// An explicitly provided path still wins
+	explicitConfig := filepath.Join(deepDir, "explicit.toml")
+	if err := os.WriteFile(explicitConfig, []byte("[qemu]\n"), 0644); err != nil {
+		t.Fatalf("Failed to create explicit config: %v", err)
+	}
+	gotPath, err = FindConfigPath(explicitConfig)
+	if err != nil {
+		t.Fatalf("FindConfigPath() unexpected error: %v", err)
+	}
+	if gotPath != explicitConfig {
+		t.Errorf("FindConfigPath() = %v, want %v", gotPath, explicitConfig)
+	}
+
+	// The search does not continue past the ceiling directory
+	t.Setenv(CeilingEnvVar, filepath.Join(projectDir, "a"))
+	t.Setenv("HOME", t.TempDir())
+	if gotPath, err := FindConfigPath(""); err == nil {
+		t.Errorf("FindConfigPath() = %v, expected no config to be found below the ceiling", gotPath)
+	}
+}
+
+func TestGetRuntimeDirNormalization(t *testing.T) {
+	projectDir := t.TempDir()
+	t.Setenv(CeilingEnvVar, projectDir)
+
+	if err := os.WriteFile(filepath.Join(projectDir, "qqmgr.toml"), []byte("[qemu]\n"), 0644); err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	subDir := filepath.Join(projectDir, "sub")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("Failed to create subdirectory: %v", err)
+	}
+
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current working directory: %v", err)
+	}
+	defer os.Chdir(origDir)
+
+	expected := filepath.Join(projectDir, ".qqmgr", "qqmgr.toml")
+
+	tests := []struct {
+		name       string
+		cwd        string
+		configPath string
+	}{
+		{name: "found in cwd", cwd: projectDir, configPath: ""},
+		{name: "relative", cwd: projectDir, configPath: "qqmgr.toml"},
+		{name: "dot relative", cwd: projectDir, configPath: "./qqmgr.toml"},
+		{name: "absolute", cwd: projectDir, configPath: filepath.Join(projectDir, "qqmgr.toml")},
+		{name: "relative from subdir", cwd: subDir, configPath: "../qqmgr.toml"},
+		{name: "found in parent", cwd: subDir, configPath: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chdir(tt.cwd); err != nil {
+				t.Fatalf("Failed to change directory: %v", err)
+			}
+
+			got, err := GetRuntimeDir(tt.configPath)
+			if err != nil {
+				t.Fatalf("GetRuntimeDir(%q) unexpected error: %v", tt.configPath, err)
+			}
+			if got != expected {
+				t.Errorf("GetRuntimeDir(%q) = %v, want %v", tt.configPath, got, expected)
+			}
+		})
+	}
+}
+
 func TestLoadFromFile(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := t.TempDir()
@@ -330,7 +436,7 @@ vm_port = 22`
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := config.ResolveVM(tt.vmName, tt.configPath)
+			got, err := config.ResolveVM(tt.vmName, tt.configPath, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ResolveVM() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -342,7 +448,7 @@ vm_port = 22`
 				if !reflect.DeepEqual(got.Cmd, tt.wantCmd) {
 					t.Errorf("ResolveVM() cmd = %v, want %v", got.Cmd, tt.wantCmd)
 				}
-				expectedDataDir := filepath.Join(tempDir, "vm.test-vm")
+				expectedDataDir := filepath.Join(tempDir, ".qqmgr", "test-config.toml", "vm.test-vm")
 				if got.DataDir != expectedDataDir {
 					t.Errorf("ResolveVM() dataDir = %v, want %v", got.DataDir, expectedDataDir)
 				}
@@ -351,6 +457,449 @@ vm_port = 22`
 	}
 }
 
+func TestResolveVMTuning(t *testing.T) {
+	tempDir := t.TempDir()
+	testConfigFile := filepath.Join(tempDir, "test-config.toml")
+	if err := os.WriteFile(testConfigFile, []byte("[qemu]\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+
+	tuning := TuningConfig{KVM: true, RTC: "utc", CPU: "host"}
+	hugepagesDir := t.TempDir()
+	runtimeDir, err := GetRuntimeDir(testConfigFile)
+	if err != nil {
+		t.Fatalf("GetRuntimeDir() failed: %v", err)
+	}
+	tpmSocket := (&VmEntry{DataDir: filepath.Join(runtimeDir, "vm.test-vm")}).TPMSocketPath()
+
+	tests := []struct {
+		name    string
+		vm      VMConfig
+		wantCmd []string
+		wantErr string
+	}{
+		{
+			name: "expands all knobs",
+			vm: VMConfig{
+				Cmd:    []string{"-m 2048"},
+				Tuning: tuning,
+			},
+			wantCmd: []string{"-m 2048", "-enable-kvm", "-rtc base=utc", "-cpu host"},
+		},
+		{
+			name:    "no tuning leaves cmd untouched",
+			vm:      VMConfig{Cmd: []string{"-m 2048"}},
+			wantCmd: []string{"-m 2048"},
+		},
+		{
+			name: "cpu conflict",
+			vm: VMConfig{
+				Cmd:    []string{"-cpu max -smp 2"},
+				Tuning: TuningConfig{CPU: "host"},
+			},
+			wantErr: "tuning option 'cpu' conflicts with '-cpu'",
+		},
+		{
+			name: "kvm conflicts with machine accel",
+			vm: VMConfig{
+				Cmd:    []string{"-machine q35,accel=kvm"},
+				Tuning: TuningConfig{KVM: true},
+			},
+			wantErr: "tuning option 'kvm' conflicts",
+		},
+		{
+			name: "hugepages memory backend",
+			vm: VMConfig{
+				Cmd:    []string{"-machine q35"},
+				Tuning: TuningConfig{Hugepages: hugepagesDir, Memory: "4G"},
+			},
+			wantCmd: []string{"-machine q35", "-object memory-backend-file,id=mem0,size=4G,mem-path=" + hugepagesDir +
+				",share=on,prealloc=on -machine memory-backend=mem0 -m 4G"},
+		},
+		{
+			name:    "hugepages without memory",
+			vm:      VMConfig{Tuning: TuningConfig{Hugepages: hugepagesDir}},
+			wantErr: "requires 'memory'",
+		},
+		{
+			name:    "tpm",
+			vm:      VMConfig{Cmd: []string{"-machine q35"}, Tuning: TuningConfig{TPM: true}},
+			wantCmd: []string{"-machine q35", "-chardev socket,id=chrtpm,path=" + tpmSocket + " -tpmdev emulator,id=tpm0,chardev=chrtpm -device tpm-tis,tpmdev=tpm0"},
+		},
+		{
+			name:    "tpm on arm",
+			vm:      VMConfig{Arch: "aarch64", Tuning: TuningConfig{TPM: true}},
+			wantCmd: []string{"-chardev socket,id=chrtpm,path=" + tpmSocket + " -tpmdev emulator,id=tpm0,chardev=chrtpm -device tpm-tis-device,tpmdev=tpm0"},
+		},
+		{
+			name: "tpm conflicts with -tpmdev",
+			vm: VMConfig{
+				Cmd:    []string{"-tpmdev passthrough,id=tpm0,path=/dev/tpm0"},
+				Tuning: TuningConfig{TPM: true},
+			},
+			wantErr: "tuning option 'tpm' conflicts with '-tpmdev'",
+		},
+		{
+			name: "hugepages conflicts with -m",
+			vm: VMConfig{
+				Cmd:    []string{"-m 2048"},
+				Tuning: TuningConfig{Hugepages: hugepagesDir, Memory: "4G"},
+			},
+			wantErr: "tuning option 'hugepages' conflicts with '-m'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{VMs: map[string]VMConfig{"test-vm": tt.vm}}
+			got, err := config.ResolveVM("test-vm", testConfigFile, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveVM() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveVM() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Cmd, tt.wantCmd) {
+				t.Errorf("ResolveVM() cmd = %v, want %v", got.Cmd, tt.wantCmd)
+			}
+		})
+	}
+}
+
+func TestVmEntryCheckHugepages(t *testing.T) {
+	hugepagesDir := t.TempDir()
+	notDir := filepath.Join(hugepagesDir, "file")
+	if err := os.WriteFile(notDir, nil, 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		hugepages string
+		wantErr   string
+	}{
+		{name: "no hugepages"},
+		{name: "mounted", hugepages: hugepagesDir},
+		{name: "missing", hugepages: filepath.Join(hugepagesDir, "missing"), wantErr: "tuning option 'hugepages'"},
+		{name: "not a directory", hugepages: notDir, wantErr: "is not a directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&VmEntry{Hugepages: tt.hugepages}).CheckHugepages()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckHugepages() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckHugepages() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Resolving does not depend on the mount, so e.g. stop works without it
+	config := &Config{VMs: map[string]VMConfig{"test-vm": {
+		Tuning: TuningConfig{Hugepages: filepath.Join(hugepagesDir, "missing"), Memory: "4G"},
+	}}}
+	configFile := filepath.Join(t.TempDir(), "test-config.toml")
+	if err := os.WriteFile(configFile, []byte("[qemu]\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	if _, err := config.ResolveVM("test-vm", configFile, nil); err != nil {
+		t.Errorf("ResolveVM() unexpected error: %v", err)
+	}
+}
+
+func TestValidateImageConfigISOFilenames(t *testing.T) {
+	base := func() ImageConfig {
+		return ImageConfig{
+			Builder: "cloud-init",
+			ImgSize: "10G",
+			BaseImg: &BaseImageConfig{URL: "https://example.com/base.qcow2"},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		templates []TemplateConfig
+		sources   []SourceConfig
+		wantErr   string
+	}{
+		{
+			name: "valid names",
+			templates: []TemplateConfig{
+				{Template: "user-data.tpl", Output: "user-data"},
+				{Template: "meta-data.tpl", Output: "meta-data"},
+			},
+			sources: []SourceConfig{{URL: "https://example.com/setup.sh", Filename: "setup.sh"}},
+		},
+		{
+			name: "duplicate template outputs",
+			templates: []TemplateConfig{
+				{Template: "a.tpl", Output: "user-data"},
+				{Template: "b.tpl", Output: "user-data"},
+			},
+			wantErr: "template 'a.tpl' and template 'b.tpl' both write 'user-data'",
+		},
+		{
+			name:      "source collides with template",
+			templates: []TemplateConfig{{Template: "a.tpl", Output: "setup.sh"}},
+			sources:   []SourceConfig{{URL: "https://example.com/setup.sh", Filename: "setup.sh"}},
+			wantErr:   "both write 'setup.sh'",
+		},
+		{
+			name:      "path separator",
+			templates: []TemplateConfig{{Template: "a.tpl", Output: "dir/user-data"}},
+			wantErr:   "template 'a.tpl' has invalid ISO filename 'dir/user-data'",
+		},
+		{
+			name:    "empty source filename",
+			sources: []SourceConfig{{URL: "https://example.com/setup.sh"}},
+			wantErr: "source 'https://example.com/setup.sh' has invalid ISO filename ''",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := base()
+			img.Templates = tt.templates
+			img.Sources = tt.sources
+			config := &Config{Images: map[string]ImageConfig{"test-img": img}}
+
+			err := config.validateImageConfig()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateImageConfig() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateImageConfig() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCloudInitConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		builder   string
+		cloudInit *CloudInitConfig
+		wantErr   string
+		wantLabel string
+	}{
+		{name: "default", builder: "cloud-init", wantLabel: "cidata"},
+		{name: "nocloud", builder: "cloud-init", cloudInit: &CloudInitConfig{Datasource: "nocloud"}, wantLabel: "cidata"},
+		{name: "configdrive", builder: "cloud-init", cloudInit: &CloudInitConfig{Datasource: "configdrive"}, wantLabel: "config-2"},
+		{name: "custom label", builder: "cloud-init", cloudInit: &CloudInitConfig{VolumeLabel: "SEED"}, wantLabel: "SEED"},
+		{
+			name:      "unknown datasource",
+			builder:   "cloud-init",
+			cloudInit: &CloudInitConfig{Datasource: "ec2"},
+			wantErr:   "invalid cloud_init datasource 'ec2'",
+		},
+		{
+			name:      "label too long",
+			builder:   "cloud-init",
+			cloudInit: &CloudInitConfig{VolumeLabel: strings.Repeat("x", 33)},
+			wantErr:   "longer than 32 characters",
+		},
+		{
+			name:      "raw image",
+			builder:   "raw",
+			cloudInit: &CloudInitConfig{Datasource: "nocloud"},
+			wantErr:   "cloud_init is only used by cloud-init images",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := ImageConfig{
+				Builder:   tt.builder,
+				ImgSize:   "10G",
+				BaseImg:   &BaseImageConfig{URL: "https://example.com/base.qcow2"},
+				CloudInit: tt.cloudInit,
+			}
+			config := &Config{Images: map[string]ImageConfig{"test-img": img}}
+
+			err := config.validateImageConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("validateImageConfig() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateImageConfig() unexpected error: %v", err)
+			}
+			if label := tt.cloudInit.ISOVolumeLabel(); label != tt.wantLabel {
+				t.Errorf("ISOVolumeLabel() = %q, want %q", label, tt.wantLabel)
+			}
+		})
+	}
+}
+
+func TestImageMirrors(t *testing.T) {
+	var cfg struct {
+		Img map[string]ImageConfig `toml:"img"`
+	}
+	data := `
+[img.single.base_img]
+url = "https://primary.example.com/base.qcow2"
+
+[img.mirrored.base_img]
+url = "https://primary.example.com/base.qcow2"
+urls = ["https://mirror1.example.com/base.qcow2", "https://mirror2.example.com/base.qcow2"]
+
+[img.list-only.base_img]
+urls = ["https://mirror1.example.com/base.qcow2"]
+`
+	if _, err := toml.Decode(data, &cfg); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+
+	tests := map[string][]string{
+		"single":    {"https://primary.example.com/base.qcow2"},
+		"mirrored":  {"https://primary.example.com/base.qcow2", "https://mirror1.example.com/base.qcow2", "https://mirror2.example.com/base.qcow2"},
+		"list-only": {"https://mirror1.example.com/base.qcow2"},
+	}
+	for name, want := range tests {
+		img := cfg.Img[name]
+		if got := img.BaseImg.Mirrors(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Mirrors() = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoadFromFileVMDefaults(t *testing.T) {
+	content := `[defaults.vm]
+cmd = ["-nodefaults"]
+
+[defaults.vm.vars]
+mem = 2048
+
+[defaults.vm.ssh]
+vm_port = 2222
+User = "root"
+Compression = "yes"
+
+[defaults.vm.tuning]
+kvm = true
+cpu = "host"
+tpm = true
+
+[vm.plain]
+cmd = ["-m {{.vm.mem}}"]
+
+[vm.plain.ssh]
+port = 2089
+
+[vm.custom]
+cmd = ["-m 512"]
+
+[vm.custom.vars]
+mem = 512
+
+[vm.custom.ssh]
+port = 2090
+vm_port = 22
+User = "admin"
+
+[vm.custom.tuning]
+accel = "tcg"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+
+	plain := cfg.VMs["plain"]
+	if plain.SSH.Options["User"] != "root" || plain.SSH.Options["Compression"] != "yes" {
+		t.Errorf("plain: expected default SSH options, got %v", plain.SSH.Options)
+	}
+	if plain.SSH.VMPort != 2222 || plain.SSH.Port != 2089 {
+		t.Errorf("plain: expected port 2089 and vm_port 2222, got %d and %d", plain.SSH.Port, plain.SSH.VMPort)
+	}
+	if want := []string{"-nodefaults", "-m {{.vm.mem}}"}; !reflect.DeepEqual(plain.Cmd, want) {
+		t.Errorf("plain: Cmd = %v, want %v", plain.Cmd, want)
+	}
+	if plain.Vars["mem"] != int64(2048) {
+		t.Errorf("plain: expected default var mem = 2048, got %v", plain.Vars["mem"])
+	}
+	if want := (TuningConfig{KVM: true, CPU: "host", TPM: true}); plain.Tuning != want {
+		t.Errorf("plain: Tuning = %+v, want %+v", plain.Tuning, want)
+	}
+
+	custom := cfg.VMs["custom"]
+	if custom.SSH.Options["User"] != "admin" || custom.SSH.Options["Compression"] != "yes" {
+		t.Errorf("custom: expected User overridden and Compression inherited, got %v", custom.SSH.Options)
+	}
+	if custom.SSH.VMPort != 22 {
+		t.Errorf("custom: expected vm_port 22, got %d", custom.SSH.VMPort)
+	}
+	if custom.Vars["mem"] != int64(512) {
+		t.Errorf("custom: expected var mem = 512, got %v", custom.Vars["mem"])
+	}
+	if want := (TuningConfig{Accel: "tcg", CPU: "host", TPM: true}); custom.Tuning != want {
+		t.Errorf("custom: Tuning = %+v, want %+v", custom.Tuning, want)
+	}
+}
+
+func TestLoadFromFileVMDefaultsFalse(t *testing.T) {
+	content := `[defaults.vm]
+keep_logs = true
+guest_agent = true
+
+[defaults.vm.tuning]
+kvm = true
+tpm = true
+
+[vm.inherits]
+cmd = ["-m 512"]
+ssh = { port = 2089 }
+
+[vm.opts-out]
+cmd = ["-m 512"]
+ssh = { port = 2090 }
+keep_logs = false
+guest_agent = false
+
+[vm.opts-out.tuning]
+kvm = false
+tpm = false
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+
+	inherits := cfg.VMs["inherits"]
+	if !inherits.KeepLogs || !inherits.GuestAgent || !inherits.Tuning.KVM || !inherits.Tuning.TPM {
+		t.Errorf("inherits: expected the true defaults, got %+v", inherits)
+	}
+
+	// A VM explicitly setting false wins over a true default
+	optsOut := cfg.VMs["opts-out"]
+	if optsOut.KeepLogs || optsOut.GuestAgent || optsOut.Tuning.KVM || optsOut.Tuning.TPM {
+		t.Errorf("opts-out: expected its own false settings, got %+v", optsOut)
+	}
+}
+
 func TestListVMs(t *testing.T) {
 	config := &Config{
 		VMs: map[string]VMConfig{
@@ -383,6 +932,187 @@ func TestListVMs(t *testing.T) {
 	}
 }
 
+func TestListVMsSkipsDisabled(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vm.active]
+cmd = ["-nodefaults"]
+
+[vm.active.ssh]
+port = 2222
+
+[vm.parked]
+enabled = false
+cmd = ["-nodefaults"]
+
+[vm.parked.ssh]
+port = 2223
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if got := cfg.ListVMs(); !reflect.DeepEqual(got, []string{"active"}) {
+		t.Errorf("ListVMs() = %v, want only the enabled VM", got)
+	}
+	all := cfg.ListAllVMs()
+	sort.Strings(all)
+	if !reflect.DeepEqual(all, []string{"active", "parked"}) {
+		t.Errorf("ListAllVMs() = %v, want both VMs", all)
+	}
+
+	// A disabled VM can still be resolved by name
+	if cfg.VMs["parked"].IsEnabled() {
+		t.Error("Expected 'parked' to be disabled")
+	}
+	vmEntry, err := cfg.ResolveVM("parked", configPath, nil)
+	if err != nil {
+		t.Fatalf("ResolveVM() of a disabled VM failed: %v", err)
+	}
+	if vmEntry.Name != "parked" {
+		t.Errorf("Expected VM 'parked', got %s", vmEntry.Name)
+	}
+}
+
+func TestResolveVMEnv(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[vars]
+display = ":1"
+
+[defaults.vm.env]
+QEMU_AUDIO_DRV = "none"
+DEBUG = 0
+
+[vm.test]
+cmd = ["-nodefaults"]
+
+[vm.test.vars]
+trace_file = "/tmp/{{.vm.ssh.port}}.trace"
+
+[vm.test.env]
+DISPLAY = "{{.display}}"
+QEMU_TRACE = "{{.vm.trace_file}}"
+DEBUG = 1
+
+[vm.test.ssh]
+port = 2222
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	vmEntry, err := cfg.ResolveVM("test", configPath, nil)
+	if err != nil {
+		t.Fatalf("ResolveVM() error = %v", err)
+	}
+	want := map[string]string{
+		"DISPLAY":        ":1",
+		"QEMU_TRACE":     "/tmp/2222.trace",
+		"QEMU_AUDIO_DRV": "none",
+		"DEBUG":          "1",
+	}
+	if !reflect.DeepEqual(vmEntry.Env, want) {
+		t.Errorf("Env = %v, want %v", vmEntry.Env, want)
+	}
+
+	environ := vmEntry.Environ([]string{"PATH=/usr/bin", "DISPLAY=:0"})
+	wantEnviron := []string{"PATH=/usr/bin", "DEBUG=1", "DISPLAY=:1", "QEMU_AUDIO_DRV=none", "QEMU_TRACE=/tmp/2222.trace"}
+	if !reflect.DeepEqual(environ, wantEnviron) {
+		t.Errorf("Environ() = %v, want %v", environ, wantEnviron)
+	}
+}
+
+func TestResolveQemuBin(t *testing.T) {
+	binDir := t.TempDir()
+	fakeBin := filepath.Join(binDir, "qemu-system-aarch64")
+	for _, bin := range []string{fakeBin, filepath.Join(binDir, "qemu-system-x86_64")} {
+		if err := os.WriteFile(bin, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("Failed to create fake QEMU binary: %v", err)
+		}
+	}
+	t.Setenv("PATH", binDir)
+
+	config := &Config{
+		Qemu: QemuConfig{Bin: "qemu-system-x86_64"},
+		VMs: map[string]VMConfig{
+			"default": {},
+			"arm":     {Arch: "aarch64"},
+			"riscv":   {Arch: "riscv64"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		vmName  string
+		want    string
+		wantErr bool
+	}{
+		{name: "no arch uses qemu.bin", vmName: "default", want: "qemu-system-x86_64"},
+		{name: "arch selects binary from PATH", vmName: "arm", want: fakeBin},
+		{name: "arch binary missing", vmName: "riscv", wantErr: true},
+		{name: "unknown VM", vmName: "nonexistent", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := config.ResolveQemuBin(tt.vmName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveQemuBin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ResolveQemuBin() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVMImageDependencies(t *testing.T) {
+	config := &Config{
+		Images: map[string]ImageConfig{
+			"base":     {},
+			"base-ext": {},
+			"data":     {},
+			"unused":   {},
+		},
+		VMs: map[string]VMConfig{
+			"test": {Cmd: []string{
+				"-drive file={{.img.base}},format=qcow2",
+				`-drive file={{index .img "data"}},format=raw`,
+			}},
+			"none": {Cmd: []string{"-m 1G"}},
+		},
+	}
+
+	deps, err := config.VMImageDependencies("test")
+	if err != nil {
+		t.Fatalf("VMImageDependencies() failed: %v", err)
+	}
+	if want := []string{"base", "data"}; !reflect.DeepEqual(deps, want) {
+		t.Errorf("VMImageDependencies() = %v, want %v", deps, want)
+	}
+
+	deps, err = config.VMImageDependencies("none")
+	if err != nil {
+		t.Fatalf("VMImageDependencies() failed: %v", err)
+	}
+	if len(deps) != 0 {
+		t.Errorf("Expected no dependencies, got %v", deps)
+	}
+
+	if _, err := config.VMImageDependencies("nonexistent"); err == nil {
+		t.Error("Expected error for unknown VM")
+	}
+}
+
 func TestVmEntryMethods(t *testing.T) {
 	entry := &VmEntry{
 		Name:    "test-vm",
@@ -422,6 +1152,31 @@ func TestVmEntryMethods(t *testing.T) {
 			method:   entry.MonitorSocketPath,
 			expected: filepath.Join(cwd, ".qqmgr", "vm.test-vm", "monitor.socket"),
 		},
+		{
+			name:     "SshConfigPath",
+			method:   entry.SshConfigPath,
+			expected: filepath.Join(cwd, ".qqmgr", "vm.test-vm", "ssh.conf"),
+		},
+		{
+			name:     "SshControlDir",
+			method:   entry.SshControlDir,
+			expected: filepath.Join(cwd, ".qqmgr", "vm.test-vm", "ssh"),
+		},
+		{
+			name:     "PanicFilePath",
+			method:   entry.PanicFilePath,
+			expected: filepath.Join(cwd, ".qqmgr", "vm.test-vm", "panic.json"),
+		},
+		{
+			name:     "QemuStdoutPath",
+			method:   entry.QemuStdoutPath,
+			expected: filepath.Join(cwd, ".qqmgr", "vm.test-vm", "qemu-stdout.log"),
+		},
+		{
+			name:     "QemuStderrPath",
+			method:   entry.QemuStderrPath,
+			expected: filepath.Join(cwd, ".qqmgr", "vm.test-vm", "qemu-stderr.log"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -434,6 +1189,27 @@ func TestVmEntryMethods(t *testing.T) {
 	}
 }
 
+func TestVmEntrySSHPort(t *testing.T) {
+	tests := []struct {
+		name   string
+		vars   map[string]interface{}
+		want   int64
+		wantOK bool
+	}{
+		{"vm.ssh.port", map[string]interface{}{"ssh": map[string]interface{}{"port": int64(2222)}}, 2222, true},
+		{"ssh_host", map[string]interface{}{"ssh_host": int64(2089)}, 2089, true},
+		{"not configured", map[string]interface{}{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port, ok := (&VmEntry{Vars: tt.vars}).SSHPort()
+			if port != tt.want || ok != tt.wantOK {
+				t.Errorf("SSHPort() = %d, %t, want %d, %t", port, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
 func TestVmEntryGetAutoInjectedArgs(t *testing.T) {
 	entry := &VmEntry{
 		Name:    "test-vm",
@@ -448,15 +1224,26 @@ func TestVmEntryGetAutoInjectedArgs(t *testing.T) {
 
 	args := entry.GetAutoInjectedArgs()
 	expected := []string{
-		fmt.Sprintf("-pidfile %s", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "pid")),
-		fmt.Sprintf("-monitor unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "monitor.socket")),
-		fmt.Sprintf("-serial file:%s", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "serial")),
-		fmt.Sprintf("-qmp unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "qmp.socket")),
+		"-pidfile", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "pid"),
+		"-monitor", fmt.Sprintf("unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "monitor.socket")),
+		"-serial", fmt.Sprintf("file:%s", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "serial")),
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "qmp.socket")),
 	}
 
 	if !reflect.DeepEqual(args, expected) {
 		t.Errorf("GetAutoInjectedArgs() = %v, want %v", args, expected)
 	}
+
+	// The guest agent channel is only added on request
+	entry.GuestAgent = true
+	expected = append(expected,
+		"-chardev", fmt.Sprintf("socket,path=%s,server,nowait,id=qga0", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "qga.socket")),
+		"-device", "virtio-serial",
+		"-device", "virtserialport,chardev=qga0,name=org.qemu.guest_agent.0",
+	)
+	if args := entry.GetAutoInjectedArgs(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("GetAutoInjectedArgs() with guest agent = %v, want %v", args, expected)
+	}
 }
 
 func TestVmEntryGetFullCommand(t *testing.T) {
@@ -475,11 +1262,11 @@ func TestVmEntryGetFullCommand(t *testing.T) {
 	fullCmd := entry.GetFullCommand()
 	expected := []string{
 		"-nodefaults",
-		"-machine q35",
-		fmt.Sprintf("-pidfile %s", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "pid")),
-		fmt.Sprintf("-monitor unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "monitor.socket")),
-		fmt.Sprintf("-serial file:%s", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "serial")),
-		fmt.Sprintf("-qmp unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "qmp.socket")),
+		"-machine", "q35",
+		"-pidfile", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "pid"),
+		"-monitor", fmt.Sprintf("unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "monitor.socket")),
+		"-serial", fmt.Sprintf("file:%s", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "serial")),
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", filepath.Join(cwd, ".qqmgr", "vm.test-vm", "qmp.socket")),
 	}
 
 	if !reflect.DeepEqual(fullCmd, expected) {
@@ -520,6 +1307,38 @@ cmd = ["-nodefaults"]`,
 			wantErr:  true,
 			errorMsg: "VM 'test-vm' missing required SSH port configuration",
 		},
+		{
+			name: "proxy_jump with several hops",
+			content: `[vm.test-vm]
+cmd = ["-nodefaults"]
+
+[vm.test-vm.ssh]
+port = 2089
+proxy_jump = "jump@bastion.example.com:2200,inner"`,
+			wantErr: false,
+		},
+		{
+			name: "proxy_jump with whitespace",
+			content: `[vm.test-vm]
+cmd = ["-nodefaults"]
+
+[vm.test-vm.ssh]
+port = 2089
+proxy_jump = "user@bastion, inner"`,
+			wantErr:  true,
+			errorMsg: "VM 'test-vm': invalid proxy_jump",
+		},
+		{
+			name: "proxy_jump hop without host",
+			content: `[vm.test-vm]
+cmd = ["-nodefaults"]
+
+[vm.test-vm.ssh]
+port = 2089
+proxy_jump = "user@"`,
+			wantErr:  true,
+			errorMsg: "has no host",
+		},
 		{
 			name: "missing VM port (should default to 22)",
 			content: `[qemu]
@@ -573,3 +1392,110 @@ port = 2089`,
 		})
 	}
 }
+
+// TestLoadFromFileUnknownKeys tests that keys the decoder ignores are reported with their path
+func TestLoadFromFileUnknownKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "qqmgr.toml")
+	content := `[qemu]
+bni = "qemu-system-x86_64"
+
+[vm.test]
+cmdd = ["-nodefaults"]
+
+[vm.test.ssh]
+port = 2222
+StrictHostKeyChecking = "no"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("LoadFromFile() failed: %v", err)
+	}
+	want := []string{"qemu.bni", "vm.test.cmdd"}
+	if !reflect.DeepEqual(cfg.UnknownKeys(), want) {
+		t.Errorf("UnknownKeys() = %v, want %v", cfg.UnknownKeys(), want)
+	}
+	err = cfg.CheckUnknownKeys()
+	if err == nil || !strings.Contains(err.Error(), "qemu.bni, vm.test.cmdd") {
+		t.Errorf("Expected CheckUnknownKeys() to list both keys, got %v", err)
+	}
+}
+
+// TestLoadConfigOverlays tests that later config files are merged on top of the base
+func TestLoadConfigOverlays(t *testing.T) {
+	tempDir := t.TempDir()
+	basePath := filepath.Join(tempDir, "base.toml")
+	base := `[vars]
+mem = "2G"
+cpus = 2
+
+[vm.web]
+cmd = ["-m {{.mem}}"]
+
+[vm.web.vars]
+role = "web"
+tier = "front"
+
+[vm.web.ssh]
+port = 2222
+
+[vm.db]
+cmd = ["-nodefaults"]
+
+[vm.db.ssh]
+port = 2223
+`
+	overridePath := filepath.Join(tempDir, "override.toml")
+	override := `[vars]
+mem = "8G"
+
+[vm.web]
+cmd = ["-m {{.mem}}", "-nographic"]
+
+[vm.web.vars]
+tier = "back"
+
+[vm.cache]
+cmd = ["-nodefaults"]
+
+[vm.cache.ssh]
+port = 2224
+`
+	for path, content := range map[string]string{basePath: base, overridePath: override} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	cfg, err := LoadConfig(basePath, overridePath)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+
+	vms := cfg.ListAllVMs()
+	sort.Strings(vms)
+	if !reflect.DeepEqual(vms, []string{"cache", "db", "web"}) {
+		t.Errorf("Expected the VMs of both files, got %v", vms)
+	}
+	if cfg.Vars["mem"] != "8G" || cfg.Vars["cpus"] != int64(2) {
+		t.Errorf("Expected mem from the override and cpus from the base, got %v", cfg.Vars)
+	}
+	web := cfg.VMs["web"]
+	if !reflect.DeepEqual(web.Cmd, []string{"-m {{.mem}}", "-nographic"}) {
+		t.Errorf("Expected the override to replace cmd, got %v", web.Cmd)
+	}
+	if web.Vars["role"] != "web" || web.Vars["tier"] != "back" {
+		t.Errorf("Expected the VM vars to be merged key by key, got %v", web.Vars)
+	}
+	if web.SSH.Port != 2222 {
+		t.Errorf("Expected ssh.port to be kept from the base, got %d", web.SSH.Port)
+	}
+
+	if _, err := LoadConfig(basePath, filepath.Join(tempDir, "missing.toml")); err == nil {
+		t.Error("Expected a missing overlay to fail")
+	}
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HostFwd is a port forwarding rule of a user-mode netdev, hostfwd=tcp::2222-:22
+type HostFwd struct {
+	Proto     string // tcp or udp, empty means tcp
+	HostAddr  string
+	HostPort  int64
+	GuestAddr string
+	GuestPort int64
+}
+
+// ParseHostFwd parses a hostfwd rule of the form [tcp|udp]:[hostaddr]:hostport-[guestaddr]:guestport
+func ParseHostFwd(rule string) (HostFwd, error) {
+	var fwd HostFwd
+	host, guest, ok := strings.Cut(rule, "-")
+	if !ok {
+		return fwd, fmt.Errorf("invalid hostfwd rule '%s': missing '-' between host and guest", rule)
+	}
+
+	proto, hostAddrPort, ok := strings.Cut(host, ":")
+	if !ok {
+		return fwd, fmt.Errorf("invalid hostfwd rule '%s': host side must be [tcp|udp]:[hostaddr]:hostport", rule)
+	}
+	fwd.Proto = proto
+	if fwd.Proto != "" && fwd.Proto != "tcp" && fwd.Proto != "udp" {
+		return fwd, fmt.Errorf("invalid hostfwd rule '%s': unknown protocol '%s'", rule, fwd.Proto)
+	}
+	hostAddr, hostPort, ok := splitAddrPort(hostAddrPort)
+	if !ok {
+		return fwd, fmt.Errorf("invalid hostfwd rule '%s': host side must be [tcp|udp]:[hostaddr]:hostport", rule)
+	}
+	fwd.HostAddr = hostAddr
+
+	guestAddr, guestPort, ok := splitAddrPort(guest)
+	if !ok {
+		return fwd, fmt.Errorf("invalid hostfwd rule '%s': guest side must be [guestaddr]:guestport", rule)
+	}
+	fwd.GuestAddr = guestAddr
+
+	var err error
+	if fwd.HostPort, err = strconv.ParseInt(hostPort, 10, 64); err != nil {
+		return fwd, fmt.Errorf("invalid hostfwd rule '%s': bad host port '%s'", rule, hostPort)
+	}
+	if fwd.GuestPort, err = strconv.ParseInt(guestPort, 10, 64); err != nil {
+		return fwd, fmt.Errorf("invalid hostfwd rule '%s': bad guest port '%s'", rule, guestPort)
+	}
+	return fwd, nil
+}
+
+// splitAddrPort splits addr:port at the last colon, an IPv6 address must be
+// given in brackets, e.g. [::1]:2222, and is returned without them
+func splitAddrPort(s string) (string, string, bool) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	addr, port := s[:i], s[i+1:]
+	if strings.HasPrefix(addr, "[") {
+		if !strings.HasSuffix(addr, "]") {
+			return "", "", false
+		}
+		return addr[1 : len(addr)-1], port, true
+	}
+	if strings.Contains(addr, ":") {
+		return "", "", false
+	}
+	return addr, port, true
+}
+
+// FindHostFwds returns the hostfwd rules in the options of the arguments in cmd
+func FindHostFwds(cmd []string) ([]HostFwd, error) {
+	var rules []HostFwd
+	for _, arg := range cmd {
+		for _, field := range strings.Fields(arg) {
+			for _, option := range strings.Split(field, ",") {
+				rule, ok := strings.CutPrefix(option, "hostfwd=")
+				if !ok {
+					continue
+				}
+				fwd, err := ParseHostFwd(rule)
+				if err != nil {
+					return nil, err
+				}
+				rules = append(rules, fwd)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// SSHForwardWarnings checks that hostfwd rules forwarding to the guest's SSH port
+// use ssh.port on the host, otherwise qqmgr ssh would connect to the wrong port
+func (v *VmEntry) SSHForwardWarnings() ([]string, error) {
+	rules, err := FindHostFwds(v.Cmd)
+	if err != nil {
+		return nil, err
+	}
+	sshData, _ := v.Vars["ssh"].(map[string]interface{})
+	port, _ := sshData["port"].(int64)
+	vmPort, _ := sshData["vm_port"].(int64)
+	if port == 0 || vmPort == 0 {
+		return nil, nil
+	}
+
+	var mismatched []HostFwd
+	for _, fwd := range rules {
+		if fwd.Proto == "udp" || fwd.GuestPort != vmPort {
+			continue
+		}
+		if fwd.HostPort == port {
+			return nil, nil
+		}
+		mismatched = append(mismatched, fwd)
+	}
+
+	var warnings []string
+	for _, fwd := range mismatched {
+		warnings = append(warnings, fmt.Sprintf(
+			"VM '%s': hostfwd forwards host port %d to guest SSH port %d, but ssh.port is %d",
+			v.Name, fwd.HostPort, vmPort, port))
+	}
+	return warnings, nil
+}
+
+// HostPortOwners maps the host ports other VMs than except are configured to
+// use to their VM's name, taken from ssh.port and the hostfwd rules of their
+// cmd. Rules which only resolve once templates are expanded are skipped.
+func (c *Config) HostPortOwners(except string) map[int64]string {
+	owners := make(map[int64]string)
+	claim := func(port int64, name string) {
+		if _, taken := owners[port]; !taken {
+			owners[port] = name
+		}
+	}
+
+	// Sorted, so a port shared by several VMs is always attributed to the same one
+	names := c.ListAllVMs()
+	sort.Strings(names)
+	for _, name := range names {
+		if name == except {
+			continue
+		}
+		vm := c.VMs[name]
+		if vm.SSH.Port != 0 {
+			claim(vm.SSH.Port, name)
+		}
+		for _, arg := range vm.Cmd {
+			rules, err := FindHostFwds([]string{arg})
+			if err != nil {
+				continue
+			}
+			for _, fwd := range rules {
+				claim(fwd.HostPort, name)
+			}
+		}
+	}
+	return owners
+}
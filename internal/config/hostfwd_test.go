@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHostFwd(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    HostFwd
+		wantErr bool
+	}{
+		{rule: "tcp::2222-:22", want: HostFwd{Proto: "tcp", HostPort: 2222, GuestPort: 22}},
+		{rule: ":127.0.0.1:8080-10.0.2.15:80", want: HostFwd{HostAddr: "127.0.0.1", HostPort: 8080, GuestAddr: "10.0.2.15", GuestPort: 80}},
+		{rule: "udp::5353-:53", want: HostFwd{Proto: "udp", HostPort: 5353, GuestPort: 53}},
+		{rule: "tcp:[::1]:2222-:22", want: HostFwd{Proto: "tcp", HostAddr: "::1", HostPort: 2222, GuestPort: 22}},
+		{rule: "tcp::2222", wantErr: true},
+		{rule: "tcp:::1:2222-:22", wantErr: true},
+		{rule: "sctp::1-:2", wantErr: true},
+		{rule: "tcp::port-:22", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := ParseHostFwd(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHostFwd() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseHostFwd() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSHForwardWarnings(t *testing.T) {
+	ssh := map[string]interface{}{"ssh": map[string]interface{}{"port": int64(2222), "vm_port": int64(22)}}
+	tests := []struct {
+		name     string
+		cmd      []string
+		warnings int
+	}{
+		{"matching", []string{"-netdev user,id=net0,hostfwd=tcp::2222-:22"}, 0},
+		{"mismatching", []string{"-netdev user,id=net0,hostfwd=tcp::2223-:22"}, 1},
+		{"one of several matches", []string{"-nic user,hostfwd=tcp::2223-:22,hostfwd=tcp::2222-:22"}, 0},
+		{"other guest port", []string{"-netdev user,id=net0,hostfwd=tcp::8080-:80"}, 0},
+		{"no user networking", []string{"-netdev tap,id=net0"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &VmEntry{Name: "test", Cmd: tt.cmd, Vars: ssh}
+			warnings, err := entry.SSHForwardWarnings()
+			if err != nil {
+				t.Fatalf("SSHForwardWarnings() error = %v", err)
+			}
+			if len(warnings) != tt.warnings {
+				t.Fatalf("Expected %d warnings, got %v", tt.warnings, warnings)
+			}
+			if tt.warnings > 0 && !strings.Contains(warnings[0], "host port 2223") {
+				t.Errorf("Expected the warning to name the forwarded port, got %s", warnings[0])
+			}
+		})
+	}
+}
+
+func TestHostPortOwners(t *testing.T) {
+	cfg := &Config{VMs: map[string]VMConfig{
+		"foo": {SSH: SSHConfig{Port: 2089}, Cmd: []string{"-nic user,hostfwd=tcp::8080-:80"}},
+		"bar": {SSH: SSHConfig{Port: 2090}, Cmd: []string{"-nic user,hostfwd=tcp::{{.ssh.port}}-:22", "-nic user,hostfwd=tcp::8080-:80"}},
+	}}
+
+	owners := cfg.HostPortOwners("foo")
+	if len(owners) != 2 || owners[2090] != "bar" || owners[8080] != "bar" {
+		t.Errorf("Expected bar's ports only, got %v", owners)
+	}
+
+	// A port configured for several VMs goes to the first one by name
+	owners = cfg.HostPortOwners("")
+	if owners[2089] != "foo" || owners[8080] != "bar" {
+		t.Errorf("Expected 2089 for foo and 8080 for bar, got %v", owners)
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseOverrides parses key=value pairs given on the command line, values in
+// ints are converted to integers while all others are kept as strings
+func ParseOverrides(strs []string, ints []string) (map[string]interface{}, error) {
+	overrides := make(map[string]interface{}, len(strs)+len(ints))
+	for _, s := range strs {
+		key, value, err := splitOverride(s)
+		if err != nil {
+			return nil, err
+		}
+		overrides[key] = value
+	}
+	for _, s := range ints {
+		key, value, err := splitOverride(s)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer value for '%s': %s", key, value)
+		}
+		overrides[key] = n
+	}
+	return overrides, nil
+}
+
+// splitOverride splits "key=value" into its key and value
+func splitOverride(s string) (string, string, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("invalid override '%s', expected key=value", s)
+	}
+	return key, value, nil
+}
+
+// OverrideVMVars sets the given variables on a VM, replacing any configured values
+func (c *Config) OverrideVMVars(vmName string, overrides map[string]interface{}) error {
+	vm, exists := c.VMs[vmName]
+	if !exists {
+		return fmt.Errorf("VM '%s' not found in configuration", vmName)
+	}
+	if vm.Vars == nil {
+		vm.Vars = make(map[string]interface{}, len(overrides))
+	}
+	for k, v := range overrides {
+		vm.Vars[k] = v
+	}
+	c.VMs[vmName] = vm
+	return nil
+}
+
+// OverrideImageEnv sets the given entries in an image's env, replacing any configured values
+func (c *Config) OverrideImageEnv(imgName string, overrides map[string]interface{}) error {
+	img, exists := c.Images[imgName]
+	if !exists {
+		return fmt.Errorf("image '%s' not found in configuration", imgName)
+	}
+	if img.Env == nil {
+		img.Env = make(map[string]interface{}, len(overrides))
+	}
+	for k, v := range overrides {
+		img.Env[k] = v
+	}
+	c.Images[imgName] = img
+	return nil
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseOverrides(t *testing.T) {
+	got, err := ParseOverrides([]string{"disk_size=30G", "empty=", "url=http://x/?a=b"}, []string{"ssh_host=3000"})
+	if err != nil {
+		t.Fatalf("ParseOverrides() error = %v", err)
+	}
+	want := map[string]interface{}{
+		"disk_size": "30G",
+		"empty":     "",
+		"url":       "http://x/?a=b",
+		"ssh_host":  int64(3000),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseOverrides() = %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		strs, ints []string
+	}{
+		{strs: []string{"novalue"}},
+		{strs: []string{"=value"}},
+		{ints: []string{"port=abc"}},
+	} {
+		if _, err := ParseOverrides(tc.strs, tc.ints); err == nil {
+			t.Errorf("ParseOverrides(%v, %v) expected error", tc.strs, tc.ints)
+		}
+	}
+}
+
+func TestOverrideVMVars(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(""), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg := &Config{
+		VMs: map[string]VMConfig{
+			"test-vm": {
+				Cmd:  []string{"-netdev user,id=net0,hostfwd=tcp::{{.vm.ssh_host}}-:22"},
+				Vars: map[string]interface{}{"ssh_host": int64(2089)},
+			},
+		},
+	}
+
+	if err := cfg.OverrideVMVars("test-vm", map[string]interface{}{"ssh_host": "3000"}); err != nil {
+		t.Fatalf("OverrideVMVars() error = %v", err)
+	}
+	entry, err := cfg.ResolveVM("test-vm", configPath, nil)
+	if err != nil {
+		t.Fatalf("ResolveVM() error = %v", err)
+	}
+	if want := []string{"-netdev user,id=net0,hostfwd=tcp::3000-:22"}; !reflect.DeepEqual(entry.Cmd, want) {
+		t.Errorf("ResolveVM() cmd = %v, want %v", entry.Cmd, want)
+	}
+
+	if err := cfg.OverrideVMVars("missing", nil); err == nil {
+		t.Error("OverrideVMVars() expected error for unknown VM")
+	}
+}
+
+func TestOverrideImageEnv(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"configured": {Builder: "cloud-init", Env: map[string]interface{}{"disk_size": "10G", "user": "dev"}},
+			"bare":       {Builder: "cloud-init"},
+		},
+	}
+
+	overrides := map[string]interface{}{"disk_size": "30G"}
+	for _, name := range []string{"configured", "bare"} {
+		if err := cfg.OverrideImageEnv(name, overrides); err != nil {
+			t.Fatalf("OverrideImageEnv(%s) error = %v", name, err)
+		}
+	}
+
+	if want := map[string]interface{}{"disk_size": "30G", "user": "dev"}; !reflect.DeepEqual(cfg.Images["configured"].Env, want) {
+		t.Errorf("configured env = %v, want %v", cfg.Images["configured"].Env, want)
+	}
+	if want := map[string]interface{}{"disk_size": "30G"}; !reflect.DeepEqual(cfg.Images["bare"].Env, want) {
+		t.Errorf("bare env = %v, want %v", cfg.Images["bare"].Env, want)
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ProfileConfig is a named variation of a VM, e.g. with GPU passthrough or more
+// memory, selected with start --profile instead of defining a near-duplicate VM
+type ProfileConfig struct {
+	Cmd []string `toml:"cmd"` // Appended to the VM's cmd, see ApplyProfile
+}
+
+// profileNameRegexp matches valid profile names
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// singleOptions are the QEMU options which take effect only once, a profile
+// giving one of them replaces the VM's own
+var singleOptions = map[string]bool{
+	"-m": true, "-smp": true, "-cpu": true, "-machine": true,
+	"-name": true, "-boot": true, "-rtc": true, "-bios": true, "-kernel": true,
+	"-initrd": true, "-append": true,
+}
+
+// optionAliases maps short option names to the option they are spelled out as
+var optionAliases = map[string]string{
+	"-M": "-machine",
+}
+
+// canonicalOption returns the name option is spelled out as, e.g. -machine for -M
+func canonicalOption(option string) string {
+	if alias, ok := optionAliases[option]; ok {
+		return alias
+	}
+	return option
+}
+
+// ApplyProfile merges the VM's profile into its cmd before resolution. The
+// profile's entries are appended, and options QEMU takes only once, like -m or
+// -smp, replace those of the VM. An empty profile leaves the VM unchanged.
+func (c *Config) ApplyProfile(vmName, profile string) error {
+	if profile == "" {
+		return nil
+	}
+	vm, exists := c.VMs[vmName]
+	if !exists {
+		return fmt.Errorf("VM '%s' not found in configuration", vmName)
+	}
+	p, exists := vm.Profiles[profile]
+	if !exists {
+		if len(vm.Profiles) == 0 {
+			return fmt.Errorf("VM '%s' has no profile '%s', it defines no profiles", vmName, profile)
+		}
+		return fmt.Errorf("VM '%s' has no profile '%s' (available: %s)", vmName, profile, strings.Join(vm.ProfileNames(), ", "))
+	}
+
+	// Only the profile must not repeat an option it replaces. A VM repeating e.g.
+	// -machine keeps all of them unless the profile gives -machine or -M, which
+	// then replaces every one
+	if err := checkSingleOptions(p.Cmd); err != nil {
+		return fmt.Errorf("VM '%s' profile '%s': %w", vmName, profile, err)
+	}
+	vm.Cmd = mergeProfileCmd(vm.Cmd, p.Cmd)
+	c.VMs[vmName] = vm
+	return nil
+}
+
+// ProfileNames returns the names of the VM's profiles in sorted order
+func (v VMConfig) ProfileNames() []string {
+	names := make([]string, 0, len(v.Profiles))
+	for name := range v.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mergeProfileCmd returns cmd with the single options given in profile removed,
+// followed by profile
+func mergeProfileCmd(cmd, profile []string) []string {
+	overridden := make(map[string]bool)
+	for _, part := range profile {
+		for _, group := range optionGroups(part) {
+			if option := canonicalOption(group[0]); singleOptions[option] {
+				overridden[option] = true
+			}
+		}
+	}
+
+	var merged []string
+	for _, part := range cmd {
+		var kept []string
+		for _, group := range optionGroups(part) {
+			if !overridden[canonicalOption(group[0])] {
+				kept = append(kept, group...)
+			}
+		}
+		if len(kept) > 0 {
+			merged = append(merged, strings.Join(kept, " "))
+		}
+	}
+	return append(merged, profile...)
+}
+
+// optionGroups splits a cmd entry into its options, each with the values
+// following it up to the next option
+func optionGroups(part string) [][]string {
+	var groups [][]string
+	for _, field := range strings.Fields(part) {
+		if len(groups) == 0 || strings.HasPrefix(field, "-") {
+			groups = append(groups, []string{field})
+			continue
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], field)
+	}
+	return groups
+}
+
+// checkSingleOptions fails if an option QEMU takes only once is given twice in
+// cmd, under either of its names
+func checkSingleOptions(cmd []string) error {
+	seen := make(map[string]bool)
+	for _, part := range cmd {
+		for _, group := range optionGroups(part) {
+			option := canonicalOption(group[0])
+			if !singleOptions[option] {
+				continue
+			}
+			if seen[option] {
+				return fmt.Errorf("option '%s' is given more than once", option)
+			}
+			seen[option] = true
+		}
+	}
+	return nil
+}
+
+// validateProfiles checks the profile names of every VM
+func (c *Config) validateProfiles() error {
+	for vmName, vm := range c.VMs {
+		for name := range vm.Profiles {
+			if !profileNameRegexp.MatchString(name) {
+				return fmt.Errorf("VM '%s': invalid profile name '%s', use letters, digits, '-' and '_'", vmName, name)
+			}
+		}
+	}
+	return nil
+}
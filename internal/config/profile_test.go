@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[defaults.vm.profile.debug]
+cmd = ["-s"]
+
+[vm.test-vm]
+cmd = ["-m 1G -smp 2", "-nodefaults", "-device virtio-net-pci,netdev=net0"]
+ssh = { port = 2222 }
+
+[vm.test-vm.profile.gpu]
+cmd = ["-m 8G", "-device vfio-pci,host=01:00.0"]
+
+[vm.test-vm.profile.dup]
+cmd = ["-m 2G", "-m 4G"]
+
+[vm.test-vm.tuning]
+cpu = "host"
+
+[vm.test-vm.profile.cpu]
+cmd = ["-cpu max"]
+
+[vm.split-machine]
+cmd = ["-machine q35", "-machine accel=kvm", "-m 1G"]
+ssh = { port = 2223 }
+
+[vm.split-machine.profile.big]
+cmd = ["-m 8G"]
+
+[vm.split-machine.profile.pc]
+cmd = ["-M pc"]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	load := func() *Config {
+		cfg, err := LoadFromFile(configPath)
+		if err != nil {
+			t.Fatalf("LoadFromFile() error = %v", err)
+		}
+		return cfg
+	}
+
+	// -m is replaced, the other entries and the additional -device are kept
+	cfg := load()
+	if err := cfg.ApplyProfile("test-vm", "gpu"); err != nil {
+		t.Fatalf("ApplyProfile() error = %v", err)
+	}
+	want := []string{"-smp 2", "-nodefaults", "-device virtio-net-pci,netdev=net0", "-m 8G", "-device vfio-pci,host=01:00.0"}
+	if got := cfg.VMs["test-vm"].Cmd; !reflect.DeepEqual(got, want) {
+		t.Errorf("Cmd = %q, want %q", got, want)
+	}
+
+	// Profiles of [defaults.vm] are available to every VM
+	cfg = load()
+	if err := cfg.ApplyProfile("test-vm", "debug"); err != nil {
+		t.Fatalf("ApplyProfile() error = %v", err)
+	}
+	if got := cfg.VMs["test-vm"].Cmd; got[len(got)-1] != "-s" {
+		t.Errorf("Expected the default profile to be appended, got %q", got)
+	}
+
+	// No profile leaves the VM as configured
+	cfg = load()
+	if err := cfg.ApplyProfile("test-vm", ""); err != nil {
+		t.Fatalf("ApplyProfile() error = %v", err)
+	}
+	if got := cfg.VMs["test-vm"].Cmd; len(got) != 3 {
+		t.Errorf("Expected the cmd unchanged, got %q", got)
+	}
+
+	err := load().ApplyProfile("test-vm", "missing")
+	if err == nil || !strings.Contains(err.Error(), "available: cpu, debug, dup, gpu") {
+		t.Errorf("Expected an error listing the profiles, got %v", err)
+	}
+	if err := load().ApplyProfile("test-vm", "dup"); err == nil {
+		t.Error("Expected an error for -m given twice by the profile")
+	}
+
+	// QEMU merges a repeated -machine of the VM, a profile not touching it keeps both
+	cfg = load()
+	if err := cfg.ApplyProfile("split-machine", "big"); err != nil {
+		t.Fatalf("ApplyProfile() error = %v", err)
+	}
+	want = []string{"-machine q35", "-machine accel=kvm", "-m 8G"}
+	if got := cfg.VMs["split-machine"].Cmd; !reflect.DeepEqual(got, want) {
+		t.Errorf("Cmd = %q, want %q", got, want)
+	}
+
+	// -M is -machine spelled short, it replaces every -machine of the VM
+	cfg = load()
+	if err := cfg.ApplyProfile("split-machine", "pc"); err != nil {
+		t.Fatalf("ApplyProfile() error = %v", err)
+	}
+	want = []string{"-m 1G", "-M pc"}
+	if got := cfg.VMs["split-machine"].Cmd; !reflect.DeepEqual(got, want) {
+		t.Errorf("Cmd = %q, want %q", got, want)
+	}
+
+	// The merged cmd is conflict-checked against the tuning options on resolution
+	cfg = load()
+	if err := cfg.ApplyProfile("test-vm", "cpu"); err != nil {
+		t.Fatalf("ApplyProfile() error = %v", err)
+	}
+	if _, err := cfg.ResolveVM("test-vm", configPath, nil); err == nil || !strings.Contains(err.Error(), "-cpu") {
+		t.Errorf("Expected the profile's -cpu to conflict with tuning cpu, got %v", err)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[vm.test-vm]
+cmd = ["-m 1G"]
+ssh = { port = 2222 }
+
+[vm.test-vm.profile."no spaces"]
+cmd = ["-m 2G"]
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadFromFile(configPath); err == nil || !strings.Contains(err.Error(), "invalid profile name") {
+		t.Errorf("Expected an invalid profile name error, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// defaultReadyTimeout is how long start --wait-ready waits if the check sets no timeout
+const defaultReadyTimeout = 5 * time.Minute
+
+// ReadyCheckConfig tells start --wait-ready when the guest has finished
+// booting, exactly one of Serial, File and Command must be set
+type ReadyCheckConfig struct {
+	Serial  string `toml:"serial"`  // Regex matched against the lines of the serial log
+	File    string `toml:"file"`    // Path in the guest which must exist, checked over SSH
+	Command string `toml:"command"` // Command run in the guest over SSH which must exit 0
+	Timeout int64  `toml:"timeout"` // Seconds to wait for the check to pass, 300 if unset
+}
+
+// TimeoutDuration returns how long to wait for the check to pass
+func (r *ReadyCheckConfig) TimeoutDuration() time.Duration {
+	if r.Timeout <= 0 {
+		return defaultReadyTimeout
+	}
+	return time.Duration(r.Timeout) * time.Second
+}
+
+// SerialRegexp compiles the serial log pattern, nil if the check is not a serial check
+func (r *ReadyCheckConfig) SerialRegexp() (*regexp.Regexp, error) {
+	if r.Serial == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(r.Serial)
+	if err != nil {
+		return nil, fmt.Errorf("invalid serial pattern: %w", err)
+	}
+	return re, nil
+}
+
+// validate checks that exactly one check is configured and the pattern compiles
+func (r *ReadyCheckConfig) validate() error {
+	set := 0
+	for _, check := range []string{r.Serial, r.File, r.Command} {
+		if check != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of serial, file and command must be set")
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	_, err := r.SerialRegexp()
+	return err
+}
+
+// validateReadyChecks validates the ready_check of every VM
+func (c *Config) validateReadyChecks() error {
+	for vmName, vm := range c.VMs {
+		if vm.ReadyCheck == nil {
+			continue
+		}
+		if err := vm.ReadyCheck.validate(); err != nil {
+			return fmt.Errorf("VM '%s' ready_check: %w", vmName, err)
+		}
+	}
+	return nil
+}
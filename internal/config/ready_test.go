@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadyCheckConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   ReadyCheckConfig
+		wantErr bool
+	}{
+		{"serial", ReadyCheckConfig{Serial: `login: $`}, false},
+		{"file", ReadyCheckConfig{File: "/var/lib/cloud/instance/boot-finished"}, false},
+		{"command", ReadyCheckConfig{Command: "true", Timeout: 60}, false},
+		{"none", ReadyCheckConfig{}, true},
+		{"two checks", ReadyCheckConfig{Serial: "login:", Command: "true"}, true},
+		{"bad pattern", ReadyCheckConfig{Serial: "login: ("}, true},
+		{"negative timeout", ReadyCheckConfig{File: "/done", Timeout: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if got := (&ReadyCheckConfig{File: "/done"}).TimeoutDuration(); got != 5*time.Minute {
+		t.Errorf("Expected the default timeout of 5m, got %s", got)
+	}
+	if got := (&ReadyCheckConfig{File: "/done", Timeout: 90}).TimeoutDuration(); got != 90*time.Second {
+		t.Errorf("Expected a timeout of 90s, got %s", got)
+	}
+}
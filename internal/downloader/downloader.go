@@ -4,16 +4,24 @@ package downloader
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 // Downloader handles downloading files with checksum verification and global caching
 type Downloader struct {
-	cacheDir string // Global cache directory shared across all images
+	cacheDir       string   // Global cache directory shared across all images
+	bandwidthLimit int64    // Maximum download speed in bytes per second, 0 for unlimited
+	headCheck      bool     // Send a HEAD request before downloading, see probe
+	locks          sync.Map // Per-checksum *sync.Mutex, so concurrent downloads of one file fetch it once
 }
 
 // NewDownloader creates a new downloader with the specified cache directory
@@ -23,6 +31,17 @@ func NewDownloader(cacheDir string) *Downloader {
 	}
 }
 
+// SetBandwidthLimit caps the download speed in bytes per second, 0 means unlimited
+func (d *Downloader) SetBandwidthLimit(bytesPerSecond int64) {
+	d.bandwidthLimit = bytesPerSecond
+}
+
+// SetHeadCheck makes downloads send a HEAD request first, to fail on a missing
+// file or an HTML page before streaming a large download
+func (d *Downloader) SetHeadCheck(enabled bool) {
+	d.headCheck = enabled
+}
+
 // GetCachedPath returns the path where a file with the given checksum should be cached
 func (d *Downloader) GetCachedPath(sha256sum string) string {
 	return filepath.Join(d.cacheDir, sha256sum)
@@ -43,10 +62,38 @@ func (d *Downloader) IsCached(sha256sum string) bool {
 	return actualHash == sha256sum
 }
 
-// Download downloads a file from the given URL and verifies its checksum
-func (d *Downloader) Download(url, expectedSHA256 string) (string, error) {
+// DownloadFromMirrors tries each URL in order until one yields a file with the
+// expected checksum. If all fail, the error lists the failure of every mirror.
+// With force set, a cached copy is ignored and replaced by the fresh download.
+func (d *Downloader) DownloadFromMirrors(urls []string, expectedSHA256 string, force bool) (string, error) {
+	if len(urls) == 0 {
+		return "", fmt.Errorf("no download URL configured")
+	}
+
+	var failures []string
+	for _, url := range urls {
+		path, err := d.Download(url, expectedSHA256, force)
+		if err == nil {
+			return path, nil
+		}
+		failures = append(failures, err.Error())
+	}
+
+	if len(failures) == 1 {
+		return "", errors.New(failures[0])
+	}
+	return "", fmt.Errorf("all %d mirrors failed:\n  %s", len(urls), strings.Join(failures, "\n  "))
+}
+
+// Download downloads a file from the given URL and verifies its checksum. With
+// force set, the file is fetched even if the cache already holds it.
+func (d *Downloader) Download(url, expectedSHA256 string, force bool) (string, error) {
+	// Serialize downloads of the same file, the later ones then find it cached
+	unlock := d.lockChecksum(expectedSHA256)
+	defer unlock()
+
 	// Check if file already exists in global cache
-	if d.IsCached(expectedSHA256) {
+	if !force && d.IsCached(expectedSHA256) {
 		return d.GetCachedPath(expectedSHA256), nil
 	}
 
@@ -87,8 +134,24 @@ func (d *Downloader) Download(url, expectedSHA256 string) (string, error) {
 	return finalPath, nil
 }
 
+// lockChecksum locks the file with the given checksum and returns the function unlocking it
+func (d *Downloader) lockChecksum(sha256sum string) func() {
+	mu, _ := d.locks.LoadOrStore(sha256sum, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
 // downloadFile downloads a file from URL to the specified path
 func (d *Downloader) downloadFile(url, destPath string) error {
+	expectedSize := int64(-1)
+	if d.headCheck {
+		size, err := probe(url)
+		if err != nil {
+			return err
+		}
+		expectedSize = size
+	}
+
 	resp, err := http.Get(url)
 	if err != nil {
 		return fmt.Errorf("failed to make HTTP request: %w", err)
@@ -98,6 +161,14 @@ func (d *Downloader) downloadFile(url, destPath string) error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("HTTP request failed with status: %d", resp.StatusCode)
 	}
+	if err := checkContentType(resp); err != nil {
+		return err
+	}
+	// A body of known length fails to read when cut short, compare the size
+	// from HEAD only if the GET response does not tell
+	if resp.ContentLength >= 0 {
+		expectedSize = -1
+	}
 
 	file, err := os.Create(destPath)
 	if err != nil {
@@ -105,14 +176,61 @@ func (d *Downloader) downloadFile(url, destPath string) error {
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, resp.Body)
+	var body io.Reader = resp.Body
+	if d.bandwidthLimit > 0 {
+		body = newRateLimitedReader(resp.Body, d.bandwidthLimit)
+	}
+
+	written, err := io.Copy(file, body)
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
+	if expectedSize >= 0 && written != expectedSize {
+		return fmt.Errorf("incomplete download: got %d of %d bytes", written, expectedSize)
+	}
 
 	return nil
 }
 
+// probe sends a HEAD request for url and returns the Content-Length it
+// reports, -1 if unknown. Servers often answer HEAD poorly, so only a missing
+// file or an HTML page fail the probe, other statuses and errors are ignored
+// and left to the download itself.
+func probe(url string) (int64, error) {
+	resp, err := http.Head(url)
+	if err != nil {
+		return -1, nil
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return -1, fmt.Errorf("HTTP request failed with status: %d", resp.StatusCode)
+	default:
+		return -1, nil
+	}
+	if err := checkContentType(resp); err != nil {
+		return -1, err
+	}
+	return resp.ContentLength, nil
+}
+
+// checkContentType rejects a response which is an HTML page, e.g. the error
+// page a redirect landed on, unless the URL it came from names an HTML file.
+// A missing or unparsable Content-Type passes.
+func checkContentType(resp *http.Response) error {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/html" {
+		return nil
+	}
+	switch strings.ToLower(path.Ext(resp.Request.URL.Path)) {
+	case ".html", ".htm":
+		return nil
+	}
+	return fmt.Errorf("server returned an HTML page from %s instead of the file", resp.Request.URL)
+}
+
 // calculateFileChecksum calculates the SHA256 checksum of a file
 func calculateFileChecksum(filePath string) (string, error) {
 	file, err := os.Open(filePath)
@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package downloader
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadFromMirrors(t *testing.T) {
+	payload := []byte("base image contents")
+	checksum := fmt.Sprintf("%x", sha256.Sum256(payload))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good":
+			w.Write(payload)
+		case "/corrupt":
+			w.Write([]byte("something else"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("falls over to second mirror", func(t *testing.T) {
+		d := NewDownloader(t.TempDir())
+		path, err := d.DownloadFromMirrors([]string{server.URL + "/missing", server.URL + "/good"}, checksum, false)
+		if err != nil {
+			t.Fatalf("DownloadFromMirrors() error: %v", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read downloaded file: %v", err)
+		}
+		if string(data) != string(payload) {
+			t.Errorf("Downloaded content = %q, want %q", data, payload)
+		}
+	})
+
+	t.Run("all mirrors fail", func(t *testing.T) {
+		d := NewDownloader(t.TempDir())
+		_, err := d.DownloadFromMirrors([]string{server.URL + "/missing", server.URL + "/corrupt"}, checksum, false)
+		if err == nil {
+			t.Fatal("DownloadFromMirrors() succeeded, want error")
+		}
+		for _, want := range []string{"all 2 mirrors failed", "status: 404", "checksum mismatch"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("DownloadFromMirrors() error = %v, want it to mention %q", err, want)
+			}
+		}
+	})
+}
+
+func TestDownloadForceBypassesCache(t *testing.T) {
+	payload := []byte("base image contents")
+	checksum := fmt.Sprintf("%x", sha256.Sum256(payload))
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(t.TempDir())
+	if _, err := d.Download(server.URL, checksum, false); err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	if !d.IsCached(checksum) {
+		t.Fatal("Expected the file to be cached after the first download")
+	}
+
+	// A valid cache entry is used as is
+	if _, err := d.Download(server.URL, checksum, false); err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("Expected the cached file to be reused, got %d requests", requests)
+	}
+
+	// force fetches again and replaces the cache entry
+	path, err := d.Download(server.URL, checksum, true)
+	if err != nil {
+		t.Fatalf("Download() with force error: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected a fresh GET with force, got %d requests", requests)
+	}
+	if path != d.GetCachedPath(checksum) || !d.IsCached(checksum) {
+		t.Errorf("Expected the fresh download to overwrite the cache entry at %s, got %s", d.GetCachedPath(checksum), path)
+	}
+}
+
+// TestDownloadConcurrentSameChecksum tests that concurrent downloads of one file fetch it once
+func TestDownloadConcurrentSameChecksum(t *testing.T) {
+	payload := []byte("shared source")
+	checksum := fmt.Sprintf("%x", sha256.Sum256(payload))
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(t.TempDir())
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.Download(server.URL, checksum, false); err != nil {
+				t.Errorf("Download() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected a single request, got %d", n)
+	}
+}
+
+func TestDownloadContentChecks(t *testing.T) {
+	payload := []byte{0x51, 0x46, 0x49, 0xfb, 0x00, 0x00, 0x00, 0x03}
+	checksum := fmt.Sprintf("%x", sha256.Sum256(payload))
+
+	var mu sync.Mutex
+	var gets []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			gets = append(gets, r.URL.Path)
+			mu.Unlock()
+		}
+		switch r.URL.Path {
+		case "/image.qcow2":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(payload)
+		case "/moved.qcow2":
+			http.Redirect(w, r, "/not-found", http.StatusFound)
+		case "/not-found":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<html><body>Not Found</body></html>")
+		case "/expired.qcow2":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case "/login":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html><body>Please log in</body></html>")
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		headCheck bool
+		wantErr   string
+		wantGets  int
+	}{
+		{name: "binary", path: "/image.qcow2", wantGets: 1},
+		{name: "binary with head check", path: "/image.qcow2", headCheck: true, wantGets: 1},
+		{name: "redirect to HTML 404", path: "/moved.qcow2", wantErr: "status: 404", wantGets: 2},
+		// The HEAD request catches the 404 without streaming anything
+		{name: "redirect to HTML 404 with head check", path: "/moved.qcow2", headCheck: true, wantErr: "status: 404", wantGets: 0},
+		{name: "redirect to HTML page", path: "/expired.qcow2", wantErr: "HTML page", wantGets: 2},
+		{name: "redirect to HTML page with head check", path: "/expired.qcow2", headCheck: true, wantErr: "HTML page", wantGets: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			gets = nil
+			mu.Unlock()
+
+			d := NewDownloader(t.TempDir())
+			d.SetHeadCheck(tt.headCheck)
+			_, err := d.Download(server.URL+tt.path, checksum, false)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Download() error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Download() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(gets) != tt.wantGets {
+				t.Errorf("Expected %d GET requests, got %v", tt.wantGets, gets)
+			}
+		})
+	}
+}
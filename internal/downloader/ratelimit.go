@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitedReader throttles reads to a fixed number of bytes per second
+// using a token bucket holding at most one second worth of tokens
+type rateLimitedReader struct {
+	r      io.Reader
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimitedReader wraps r so that reading from it does not exceed bytesPerSecond
+func newRateLimitedReader(r io.Reader, bytesPerSecond int64) *rateLimitedReader {
+	return &rateLimitedReader{
+		r:    r,
+		rate: float64(bytesPerSecond),
+		last: time.Now(),
+	}
+}
+
+// Read reads at most one second worth of data, sleeping as needed to honor the rate
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if burst := int(l.rate); len(p) > burst && burst > 0 {
+		p = p[:burst]
+	}
+
+	n, err := l.r.Read(p)
+	if n > 0 {
+		l.consume(n)
+	}
+	return n, err
+}
+
+// consume takes n tokens from the bucket, sleeping until the bucket is no longer in debt
+func (l *rateLimitedReader) consume(n int) {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+		l.tokens = 0
+		l.last = time.Now()
+	}
+}
+
+// ParseRate parses a bandwidth such as "500K", "5MB" or "1G" into bytes per
+// second. Units are powers of 1024, a plain number is taken as bytes.
+func ParseRate(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(value, "/S")
+	value = strings.TrimSuffix(value, "IB")
+	value = strings.TrimSuffix(value, "B")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bandwidth '%s' (expected e.g. 500K, 5MB or 1G)", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDownloadBandwidthLimit(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 20*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	d := NewDownloader(t.TempDir())
+	d.SetBandwidthLimit(10 * 1024)
+
+	start := time.Now()
+	if _, err := d.Download(server.URL, fmt.Sprintf("%x", sha256.Sum256(payload)), false); err != nil {
+		t.Fatalf("Download() error: %v", err)
+	}
+
+	// 20KiB at 10KiB/s must take close to two seconds
+	if elapsed := time.Since(start); elapsed < 1800*time.Millisecond {
+		t.Errorf("Download() took %s, expected at least 1.8s with the bandwidth limit", elapsed)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1024", want: 1024},
+		{input: "500K", want: 500 * 1024},
+		{input: "5MB", want: 5 * 1024 * 1024},
+		{input: "2MiB/s", want: 2 * 1024 * 1024},
+		{input: "1g", want: 1 << 30},
+		{input: "fast", wantErr: true},
+		{input: "-1M", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRate(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRate(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
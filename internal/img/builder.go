@@ -5,9 +5,12 @@ package img
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"qqmgr/internal/trace"
+	"time"
 )
 
 // ImageBuilder defines the interface for image builders
@@ -15,7 +18,16 @@ type ImageBuilder interface {
 	Build(ctx context.Context) error
 	GetImagePath() string
 	GetStateDir() string
-	GetManifest() (map[string]string, error) // Returns input hashes for caching
+	GetManifest() (map[string]string, error)      // Returns input hashes for caching
+	Export(dst string) error                      // Writes a standalone copy of the built image to dst
+	RecordedManifest() (map[string]string, error) // Returns the input hashes recorded by the last build
+	Rebuilt() bool                                // Reports whether the last Build changed the image
+}
+
+// BuildResult describes a completed image build
+type BuildResult struct {
+	ImagePath string                   // Path to the built image, in the state directory or at its configured output
+	Timings   map[string]time.Duration // Wall-clock time per executed stage, nil for builders without stages
 }
 
 // BaseImageBuilder provides common functionality for image builders
@@ -25,6 +37,9 @@ type BaseImageBuilder struct {
 	qemuBin  string
 	qemuImg  string
 	tracer   trace.Tracer
+
+	// rebuilt is set once Build changed the image, up to date stages leave it unset
+	rebuilt bool
 }
 
 // NewBaseImageBuilder creates a new base image builder
@@ -51,6 +66,11 @@ func (b *BaseImageBuilder) initStateDir() error {
 	return nil
 }
 
+// Rebuilt reports whether the last Build changed the image
+func (b *BaseImageBuilder) Rebuilt() bool {
+	return b.rebuilt
+}
+
 // GetStateDir returns the state directory for this image
 func (b *BaseImageBuilder) GetStateDir() string {
 	return b.stateDir
@@ -121,3 +141,43 @@ func (b *BaseImageBuilder) manifestChanged(currentManifest map[string]string) (b
 
 	return false, nil
 }
+
+// exportNeeded returns true if dst is missing or older than src
+func exportNeeded(src, dst string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return true
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		return true
+	}
+	return dstInfo.ModTime().Before(srcInfo.ModTime())
+}
+
+// copyImageFile copies src to dst, replacing dst atomically
+func copyImageFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	defer in.Close()
+
+	tmpPath := dst + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy image: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return os.Rename(tmpPath, dst)
+}
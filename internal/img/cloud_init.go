@@ -3,15 +3,22 @@
 package img
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -19,14 +26,36 @@ import (
 	"qqmgr/internal/trace"
 )
 
+const (
+	// defaultBuildTimeout bounds how long the customization VM may run
+	defaultBuildTimeout = 10 * time.Minute
+	// defaultShutdownGrace is how long a powered-down build VM gets to exit before SIGKILL
+	defaultShutdownGrace = 30 * time.Second
+	// defaultQMPWaitTimeout is how long to wait for the build VM's QMP socket after start
+	defaultQMPWaitTimeout = 30 * time.Second
+)
+
+// PowerdownFunc asks the QEMU instance listening on qmpSocket to power down gracefully
+type PowerdownFunc func(ctx context.Context, qmpSocket string) error
+
 // CloudInitImageBuilder creates cloud-init images
 type CloudInitImageBuilder struct {
 	*BaseImageBuilder
 	downloader        *downloader.Downloader
 	templateProcessor *TemplateProcessor
 	envHookExecutor   *EnvHookExecutor
+	powerdown         PowerdownFunc
+	buildTimeout      time.Duration
+	shutdownGrace     time.Duration
+	qmpWaitTimeout    time.Duration
+	noCache           bool                     // Re-download the base image and sources, ignoring the download cache
+	parallelDownloads int                      // Sources downloaded at once, overrides parallel_downloads if set
+	timings           map[string]time.Duration // Wall-clock time of each stage run by the last Build
 }
 
+// CloudInitStages lists the stages of a cloud-init build in the order they run
+var CloudInitStages = []string{"download", "prepare", "templates", "iso", "vm"}
+
 // NewCloudInitImageBuilder creates a new cloud-init image builder
 func NewCloudInitImageBuilder(
 	config *ImageConfig,
@@ -40,12 +69,39 @@ func NewCloudInitImageBuilder(
 		downloader:        downloader,
 		templateProcessor: templateProcessor,
 		envHookExecutor:   NewEnvHookExecutor(),
+		buildTimeout:      defaultBuildTimeout,
+		shutdownGrace:     defaultShutdownGrace,
+		qmpWaitTimeout:    defaultQMPWaitTimeout,
 	}
 }
 
+// SetQMPWaitTimeout sets how long to wait for the build VM to answer on QMP
+// after start, failing fast if QEMU exits meanwhile. Zero disables the check.
+func (c *CloudInitImageBuilder) SetQMPWaitTimeout(timeout time.Duration) {
+	c.qmpWaitTimeout = timeout
+}
+
+// SetNoCache makes the build re-download the base image and sources even if they are cached
+func (c *CloudInitImageBuilder) SetNoCache(noCache bool) {
+	c.noCache = noCache
+}
+
+// SetParallelDownloads sets how many sources are downloaded at once, overriding
+// the image's parallel_downloads. Zero keeps the configured value.
+func (c *CloudInitImageBuilder) SetParallelDownloads(n int) {
+	c.parallelDownloads = n
+}
+
+// SetPowerdownFunc sets the function used to gracefully stop a timed out build VM
+func (c *CloudInitImageBuilder) SetPowerdownFunc(powerdown PowerdownFunc) {
+	c.powerdown = powerdown
+}
+
 // Build creates a cloud-init image through the multi-stage process
 func (c *CloudInitImageBuilder) Build(ctx context.Context) error {
 	c.tracer.Trace("cloud-init", "Starting cloud-init image build", "stateDir", c.stateDir)
+	c.timings = make(map[string]time.Duration)
+	c.rebuilt = false
 
 	if err := c.ensureStateDir(); err != nil {
 		return fmt.Errorf("failed to create state directory: %w", err)
@@ -53,31 +109,31 @@ func (c *CloudInitImageBuilder) Build(ctx context.Context) error {
 
 	// Stage 1: Download base image
 	c.tracer.Trace("cloud-init", "Stage 1: Downloading base image")
-	if err := c.downloadBaseImage(); err != nil {
+	if err := c.timeStage("download", func() error { return c.downloadBaseImage(c.noCache) }); err != nil {
 		return fmt.Errorf("failed to download base image: %w", err)
 	}
 
 	// Stage 2: Prepare base image (resize and create overlay)
 	c.tracer.Trace("cloud-init", "Stage 2: Preparing base image")
-	if err := c.prepareBaseImage(); err != nil {
+	if err := c.timeStage("prepare", c.prepareBaseImage); err != nil {
 		return fmt.Errorf("failed to prepare base image: %w", err)
 	}
 
 	// Stage 3: Generate cloud-init files
 	c.tracer.Trace("cloud-init", "Stage 3: Generating cloud-init files")
-	if err := c.generateCloudInitFiles(); err != nil {
+	if err := c.timeStage("templates", c.generateCloudInitFiles); err != nil {
 		return fmt.Errorf("failed to generate cloud-init files: %w", err)
 	}
 
 	// Stage 4: Create cloud-init ISO
 	c.tracer.Trace("cloud-init", "Stage 4: Creating cloud-init ISO")
-	if err := c.createCloudInitISO(); err != nil {
+	if err := c.timeStage("iso", c.createCloudInitISO); err != nil {
 		return fmt.Errorf("failed to create cloud-init ISO: %w", err)
 	}
 
 	// Stage 5: Run VM for customization
 	c.tracer.Trace("cloud-init", "Stage 5: Running VM for customization")
-	if err := c.runVMForCustomization(); err != nil {
+	if err := c.timeStage("vm", c.runVMForCustomization); err != nil {
 		return fmt.Errorf("failed to run VM for customization: %w", err)
 	}
 
@@ -85,18 +141,80 @@ func (c *CloudInitImageBuilder) Build(ctx context.Context) error {
 	return nil
 }
 
+// timeStage runs a build stage and records its wall-clock time, also when it fails
+func (c *CloudInitImageBuilder) timeStage(stage string, run func() error) error {
+	start := time.Now()
+	err := run()
+	elapsed := time.Since(start)
+	c.timings[stage] = elapsed
+	c.tracer.Trace("cloud-init", "Stage finished", "stage", stage, "duration", elapsed.String(), "failed", err != nil)
+	return err
+}
+
+// Timings returns the wall-clock time of each stage run by the last Build, keyed by
+// the names in CloudInitStages. Stages not reached are absent.
+func (c *CloudInitImageBuilder) Timings() map[string]time.Duration {
+	return c.timings
+}
+
 // GetImagePath returns the path to the final image
 func (c *CloudInitImageBuilder) GetImagePath() string {
 	return filepath.Join(c.stateDir, "stage3.img")
 }
 
+// Export flattens the stage3 overlay into a standalone qcow2 image at dst
+func (c *CloudInitImageBuilder) Export(dst string) error {
+	c.tracer.Trace("qemu-img", "Exporting image", "from", c.GetImagePath(), "to", dst)
+	tmpPath := dst + ".tmp"
+	cmd := exec.Command(c.qemuImg, "convert", "-O", "qcow2", c.GetImagePath(), tmpPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("qemu-img convert failed: %s, %w", string(output), err)
+	}
+	return os.Rename(tmpPath, dst)
+}
+
+// RecordedManifest returns the stage manifests saved by the last build, keyed
+// "<stage>.<input>", plus the checksum of the downloaded base image
+func (c *CloudInitImageBuilder) RecordedManifest() (map[string]string, error) {
+	recorded := make(map[string]string)
+
+	if data, err := os.ReadFile(filepath.Join(c.stateDir, "stage1.img.checksum")); err == nil {
+		recorded["base_img.sha256"] = strings.TrimSpace(string(data))
+	}
+
+	paths, err := filepath.Glob(filepath.Join(c.stateDir, "*.manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var manifest map[string]string
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		stage := strings.TrimSuffix(filepath.Base(path), ".manifest.json")
+		for k, v := range manifest {
+			recorded[stage+"."+k] = v
+		}
+	}
+
+	if len(recorded) == 0 {
+		return nil, nil
+	}
+	return recorded, nil
+}
+
 // GetManifest returns the current manifest for this image
 func (c *CloudInitImageBuilder) GetManifest() (map[string]string, error) {
 	return c.calculateManifest()
 }
 
-// downloadBaseImage downloads the base image if needed
-func (c *CloudInitImageBuilder) downloadBaseImage() error {
+// downloadBaseImage downloads the base image if needed, or always if force is set
+func (c *CloudInitImageBuilder) downloadBaseImage(force bool) error {
 	if c.config.BaseImg == nil {
 		return fmt.Errorf("no base image configured")
 	}
@@ -106,7 +224,7 @@ func (c *CloudInitImageBuilder) downloadBaseImage() error {
 	manifestPath := filepath.Join(c.stateDir, "stage1.img.checksum")
 
 	// Check if we need to download
-	if _, err := os.Stat(manifestPath); err == nil {
+	if _, err := os.Stat(manifestPath); err == nil && !force {
 		// Check if checksum matches
 		data, err := os.ReadFile(manifestPath)
 		if err == nil && strings.TrimSpace(string(data)) == c.config.BaseImg.SHA256Sum {
@@ -116,9 +234,17 @@ func (c *CloudInitImageBuilder) downloadBaseImage() error {
 		}
 	}
 
+	return c.fetchBaseImage(force)
+}
+
+// fetchBaseImage downloads the base image (or takes it from the download cache)
+// and copies it to stage1.img
+func (c *CloudInitImageBuilder) fetchBaseImage(force bool) error {
+	manifestPath := filepath.Join(c.stateDir, "stage1.img.checksum")
+
 	// Download the base image
-	c.tracer.Trace("download", "Downloading base image", "url", c.config.BaseImg.URL)
-	downloadedPath, err := c.downloader.Download(c.config.BaseImg.URL, c.config.BaseImg.SHA256Sum)
+	c.tracer.Trace("download", "Downloading base image", "urls", c.config.BaseImg.Mirrors())
+	downloadedPath, err := c.downloader.DownloadFromMirrors(c.config.BaseImg.Mirrors(), c.config.BaseImg.SHA256Sum, force)
 	if err != nil {
 		return fmt.Errorf("failed to download base image: %w", err)
 	}
@@ -160,6 +286,14 @@ func (c *CloudInitImageBuilder) prepareBaseImage() error {
 		return nil
 	}
 
+	// stage1 may have been removed by PruneStages, fetch it again
+	if _, err := os.Stat(stage1Path); os.IsNotExist(err) {
+		c.tracer.Trace("prepare", "stage1 was pruned, fetching base image again")
+		if err := c.fetchBaseImage(c.noCache); err != nil {
+			return fmt.Errorf("failed to restore pruned base image: %w", err)
+		}
+	}
+
 	// Copy stage1 to stage2
 	c.tracer.Trace("prepare", "Copying stage1 to stage2", "from", stage1Path, "to", stage2Path)
 	if err := c.copyFile(stage1Path, stage2Path); err != nil {
@@ -177,6 +311,7 @@ func (c *CloudInitImageBuilder) prepareBaseImage() error {
 	if err := c.createOverlay(stage2Path, stage3Path); err != nil {
 		return fmt.Errorf("failed to create overlay: %w", err)
 	}
+	c.rebuilt = true
 
 	// Save manifest
 	if err := c.saveStageManifest(manifestPath, manifest); err != nil {
@@ -187,6 +322,48 @@ func (c *CloudInitImageBuilder) prepareBaseImage() error {
 	return nil
 }
 
+// templateEnv returns the environment templates are rendered with: the env_file
+// variables overridden by env, then passed through the env hook if configured
+func (c *CloudInitImageBuilder) templateEnv() (map[string]interface{}, error) {
+	configDir := c.templateProcessor.configDir // FIX: use configDir, not stateDir
+
+	env := c.config.Env
+	if c.config.EnvFile != "" {
+		fileEnv, _, err := loadEnvFile(filepath.Join(configDir, c.config.EnvFile))
+		if err != nil {
+			return nil, err
+		}
+		c.tracer.Trace("templates", "Loaded env file", "path", c.config.EnvFile, "envKeys", len(fileEnv))
+		maps.Copy(fileEnv, c.config.Env)
+		env = fileEnv
+	}
+
+	if c.config.EnvHook != nil {
+		c.tracer.Trace("templates", "Executing environment hook", "script", c.config.EnvHook.Script)
+		processedEnv, err := c.envHookExecutor.Execute(c.config.EnvHook, configDir, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute environment hook: %w", err)
+		}
+		env = processedEnv
+		c.tracer.Trace("templates", "Environment hook completed", "envKeys", len(env))
+	}
+	return env, nil
+}
+
+// RenderTemplates renders the cloud-init templates into outputDir without building the image
+func (c *CloudInitImageBuilder) RenderTemplates(outputDir string) error {
+	env, err := c.templateEnv()
+	if err != nil {
+		return err
+	}
+
+	c.tracer.Trace("templates", "Rendering templates", "outputDir", outputDir)
+	if err := c.templateProcessor.ProcessTemplates(c.config.Templates, env, outputDir); err != nil {
+		return fmt.Errorf("failed to process templates: %w", err)
+	}
+	return nil
+}
+
 // generateCloudInitFiles generates cloud-init files from templates
 func (c *CloudInitImageBuilder) generateCloudInitFiles() error {
 	if len(c.config.Templates) == 0 {
@@ -197,16 +374,9 @@ func (c *CloudInitImageBuilder) generateCloudInitFiles() error {
 	c.tracer.Trace("templates", "Generating cloud-init files", "templateCount", len(c.config.Templates))
 
 	// Execute environment hook if present
-	env := c.config.Env
-	if c.config.EnvHook != nil {
-		c.tracer.Trace("templates", "Executing environment hook", "script", c.config.EnvHook.Script)
-		configDir := c.templateProcessor.configDir // FIX: use configDir, not stateDir
-		processedEnv, err := c.envHookExecutor.Execute(c.config.EnvHook, configDir, env)
-		if err != nil {
-			return fmt.Errorf("failed to execute environment hook: %w", err)
-		}
-		env = processedEnv
-		c.tracer.Trace("templates", "Environment hook completed", "envKeys", len(env))
+	env, err := c.templateEnv()
+	if err != nil {
+		return err
 	}
 
 	// Calculate template manifest
@@ -215,6 +385,15 @@ func (c *CloudInitImageBuilder) generateCloudInitFiles() error {
 		return fmt.Errorf("failed to calculate template manifest: %w", err)
 	}
 
+	// The hook may drop env_file variables, so track the file itself as well
+	if c.config.EnvFile != "" {
+		_, hash, err := loadEnvFile(filepath.Join(c.templateProcessor.configDir, c.config.EnvFile))
+		if err != nil {
+			return err
+		}
+		templateManifest["env_file"] = hash
+	}
+
 	// Check if we need to rebuild
 	manifestPath := filepath.Join(c.stateDir, "templates.manifest.json")
 	if c.manifestMatches(manifestPath, templateManifest) {
@@ -262,6 +441,13 @@ func (c *CloudInitImageBuilder) createCloudInitISO() error {
 		manifest[source.Filename] = source.SHA256Sum
 	}
 
+	// Changing the datasource or label changes the ISO, images without
+	// [cloud_init] keep the manifest of a plain NoCloud ISO
+	if c.config.CloudInit != nil {
+		manifest[isoDatasourceKey] = c.config.CloudInit.ISODatasource()
+		manifest[isoVolumeLabelKey] = c.config.CloudInit.ISOVolumeLabel()
+	}
+
 	// Check if we need to rebuild
 	manifestPath := filepath.Join(c.stateDir, "cloud-init.iso.manifest.json")
 	if c.manifestMatches(manifestPath, manifest) {
@@ -277,6 +463,7 @@ func (c *CloudInitImageBuilder) createCloudInitISO() error {
 	if err := c.saveStageManifest(manifestPath, manifest); err != nil {
 		return fmt.Errorf("failed to save ISO manifest: %w", err)
 	}
+	os.Remove(filepath.Join(c.stateDir, prunedISOHashFile))
 
 	return nil
 }
@@ -304,6 +491,9 @@ func (c *CloudInitImageBuilder) runVMForCustomization() error {
 	if hash, err := c.calculateFileHash(isoPath); err == nil {
 		manifest["cloud_init_iso"] = hash
 		fmt.Printf("DEBUG: ISO hash: %s\n", hash)
+	} else if data, err := os.ReadFile(filepath.Join(c.stateDir, prunedISOHashFile)); err == nil {
+		// The ISO was removed by PruneStages, which recorded its hash
+		manifest["cloud_init_iso"] = strings.TrimSpace(string(data))
 	} else {
 		fmt.Printf("DEBUG: Failed to calculate ISO hash: %v\n", err)
 	}
@@ -323,11 +513,25 @@ func (c *CloudInitImageBuilder) runVMForCustomization() error {
 	fmt.Printf("DEBUG: Manifest does not match, running QEMU\n")
 	c.tracer.Trace("vm", "VM manifest does not match, running QEMU")
 
+	// The ISO may have been removed by PruneStages, recreate it for this run
+	if _, err := os.Stat(isoPath); os.IsNotExist(err) {
+		os.Remove(filepath.Join(c.stateDir, "cloud-init.iso.manifest.json"))
+		if err := c.createCloudInitISO(); err != nil {
+			return fmt.Errorf("failed to recreate pruned cloud-init ISO: %w", err)
+		}
+		hash, err := c.calculateFileHash(isoPath)
+		if err != nil {
+			return fmt.Errorf("failed to hash cloud-init ISO: %w", err)
+		}
+		manifest["cloud_init_iso"] = hash
+	}
+
 	// Run QEMU
 	if err := c.runQEMU(); err != nil {
 		fmt.Printf("DEBUG: QEMU failed: %v\n", err)
 		return fmt.Errorf("failed to run QEMU: %w", err)
 	}
+	c.rebuilt = true
 
 	// Save manifest
 	fmt.Printf("DEBUG: Saving manifest to: %s\n", manifestPath)
@@ -341,14 +545,61 @@ func (c *CloudInitImageBuilder) runVMForCustomization() error {
 	return nil
 }
 
+// prunedISOHashFile records the hash of cloud-init.iso once PruneStages removed it
+const prunedISOHashFile = "cloud-init.iso.sha256"
+
+// PruneStages removes the intermediate stage1.img and cloud-init.iso of a completed
+// build and returns the removed paths. stage2.img is kept, it backs the stage3.img
+// overlay. The stage manifests are kept so the next build is still up to date; a
+// stage which has to be rebuilt recreates the pruned files first.
+func (c *CloudInitImageBuilder) PruneStages() ([]string, error) {
+	if _, err := os.Stat(c.GetImagePath()); err != nil {
+		return nil, fmt.Errorf("image is not built: %w", err)
+	}
+	if c.config.BaseImg == nil {
+		return nil, fmt.Errorf("no base image configured")
+	}
+	if !c.manifestMatches(filepath.Join(c.stateDir, "stage2.manifest.json"), map[string]string{
+		"base_img_hash": c.config.BaseImg.SHA256Sum,
+		"img_size":      c.config.ImgSize,
+	}) {
+		return nil, fmt.Errorf("image is out of date with its configuration, rebuild it before pruning")
+	}
+
+	var removed []string
+
+	isoPath := filepath.Join(c.stateDir, "cloud-init.iso")
+	if hash, err := c.calculateFileHash(isoPath); err == nil {
+		if err := os.WriteFile(filepath.Join(c.stateDir, prunedISOHashFile), []byte(hash), 0644); err != nil {
+			return removed, fmt.Errorf("failed to record ISO hash: %w", err)
+		}
+		if err := os.Remove(isoPath); err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w", isoPath, err)
+		}
+		removed = append(removed, isoPath)
+	} else if !os.IsNotExist(err) {
+		return removed, fmt.Errorf("failed to hash %s: %w", isoPath, err)
+	}
+
+	stage1Path := filepath.Join(c.stateDir, "stage1.img")
+	if err := os.Remove(stage1Path); err == nil {
+		removed = append(removed, stage1Path)
+	} else if !os.IsNotExist(err) {
+		return removed, fmt.Errorf("failed to remove %s: %w", stage1Path, err)
+	}
+
+	c.tracer.Trace("prune", "Pruned intermediate stages", "removed", removed)
+	return removed, nil
+}
+
 // Helper methods
 
 func (c *CloudInitImageBuilder) copyFile(src, dst string) error {
 	c.tracer.Trace("file", "Copying file", "from", src, "to", dst)
 	cmd := exec.Command("cp", src, dst)
-	if err := cmd.Run(); err != nil {
-		c.tracer.Trace("file", "File copy failed", "error", err.Error())
-		return err
+	if output, err := cmd.CombinedOutput(); err != nil {
+		c.tracer.Trace("file", "File copy failed", "error", err.Error(), "output", string(output))
+		return fmt.Errorf("cp failed: %s, %w", strings.TrimSpace(string(output)), err)
 	}
 	c.tracer.Trace("file", "File copy completed")
 	return nil
@@ -357,25 +608,72 @@ func (c *CloudInitImageBuilder) copyFile(src, dst string) error {
 func (c *CloudInitImageBuilder) resizeImage(imagePath, size string) error {
 	c.tracer.Trace("qemu-img", "Resizing image", "path", imagePath, "size", size)
 	cmd := exec.Command(c.qemuImg, "resize", imagePath, size)
-	if err := cmd.Run(); err != nil {
-		c.tracer.Trace("qemu-img", "Image resize failed", "error", err.Error())
-		return err
+	if output, err := cmd.CombinedOutput(); err != nil {
+		c.tracer.Trace("qemu-img", "Image resize failed", "error", err.Error(), "output", string(output))
+		return fmt.Errorf("qemu-img resize failed: %s, %w", strings.TrimSpace(string(output)), err)
 	}
 	c.tracer.Trace("qemu-img", "Image resize completed")
 	return nil
 }
 
 func (c *CloudInitImageBuilder) createOverlay(basePath, overlayPath string) error {
-	c.tracer.Trace("qemu-img", "Creating overlay", "base", basePath, "overlay", overlayPath)
-	cmd := exec.Command(c.qemuImg, "create", "-f", "qcow2", "-F", "qcow2", "-b", basePath, overlayPath)
-	if err := cmd.Run(); err != nil {
-		c.tracer.Trace("qemu-img", "Overlay creation failed", "error", err.Error())
+	// A relative backing path would be resolved against QEMU's CWD at VM start
+	absBase, err := filepath.Abs(basePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve backing file path %s: %w", basePath, err)
+	}
+
+	c.tracer.Trace("qemu-img", "Creating overlay", "base", absBase, "overlay", overlayPath)
+	cmd := exec.Command(c.qemuImg, "create", "-f", "qcow2", "-F", "qcow2", "-b", absBase, overlayPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		c.tracer.Trace("qemu-img", "Overlay creation failed", "error", err.Error(), "output", string(output))
+		return fmt.Errorf("qemu-img create failed: %s, %w", strings.TrimSpace(string(output)), err)
+	}
+
+	if err := c.verifyOverlay(overlayPath, absBase); err != nil {
+		c.tracer.Trace("qemu-img", "Overlay verification failed", "error", err.Error())
 		return err
 	}
 	c.tracer.Trace("qemu-img", "Overlay creation completed")
 	return nil
 }
 
+// verifyOverlay checks with 'qemu-img info' that the overlay's backing chain resolves to basePath
+func (c *CloudInitImageBuilder) verifyOverlay(overlayPath, basePath string) error {
+	cmd := exec.Command(c.qemuImg, "info", "--output=json", overlayPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("qemu-img info failed on overlay %s: %w, stderr: %s", overlayPath, err, stderr.String())
+	}
+
+	var info struct {
+		BackingFilename     string `json:"backing-filename"`
+		FullBackingFilename string `json:"full-backing-filename"`
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return fmt.Errorf("failed to parse qemu-img info output for overlay %s: %w", overlayPath, err)
+	}
+
+	backing := info.FullBackingFilename
+	if backing == "" {
+		backing = info.BackingFilename
+	}
+	switch {
+	case backing == "":
+		return fmt.Errorf("overlay %s has no backing file, expected %s", overlayPath, basePath)
+	case !filepath.IsAbs(info.BackingFilename):
+		return fmt.Errorf("overlay %s has relative backing file '%s'", overlayPath, info.BackingFilename)
+	case backing != basePath:
+		return fmt.Errorf("overlay %s is backed by %s, expected %s", overlayPath, backing, basePath)
+	}
+	if _, err := os.Stat(backing); err != nil {
+		return fmt.Errorf("backing file of overlay %s does not resolve: %w", overlayPath, err)
+	}
+	return nil
+}
+
 // prepareAdditionalSources downloads additional sources (no copying needed)
 func (c *CloudInitImageBuilder) prepareAdditionalSources() error {
 	if len(c.config.Sources) == 0 {
@@ -383,42 +681,88 @@ func (c *CloudInitImageBuilder) prepareAdditionalSources() error {
 		return nil
 	}
 
-	c.tracer.Trace("sources", "Preparing additional sources", "sourceCount", len(c.config.Sources))
-
-	for _, source := range c.config.Sources {
-		c.tracer.Trace("sources", "Downloading source", "filename", source.Filename, "url", source.URL)
-		// Download the source file (this ensures it's in the cache)
-		_, err := c.downloader.Download(source.URL, source.SHA256Sum)
-		if err != nil {
-			return fmt.Errorf("failed to download source %s: %w", source.Filename, err)
-		}
-		c.tracer.Trace("sources", "Source downloaded successfully", "filename", source.Filename)
+	workers := c.parallelDownloads
+	if workers == 0 {
+		workers = c.config.ParallelDownloads
+	}
+	workers = max(1, min(workers, len(c.config.Sources)))
+	c.tracer.Trace("sources", "Preparing additional sources", "sourceCount", len(c.config.Sources), "parallel", workers)
+
+	// Download through a pool of workers, keeping every failure
+	indices := make(chan int)
+	errs := make([]error, len(c.config.Sources))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				errs[i] = c.downloadSource(c.config.Sources[i])
+			}
+		}()
+	}
+	for i := range c.config.Sources {
+		indices <- i
 	}
+	close(indices)
+	wg.Wait()
 
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
 	c.tracer.Trace("sources", "All additional sources prepared successfully")
 	return nil
 }
 
-func (c *CloudInitImageBuilder) createISO(isoPath string, manifest map[string]string) error {
-	c.tracer.Trace("iso", "Creating cloud-init ISO", "output", isoPath)
+// downloadSource ensures source is in the download cache
+func (c *CloudInitImageBuilder) downloadSource(source SourceConfig) error {
+	c.tracer.Trace("sources", "Downloading source", "filename", source.Filename, "urls", source.Mirrors())
+	if _, err := c.downloader.DownloadFromMirrors(source.Mirrors(), source.SHA256Sum, c.noCache); err != nil {
+		return fmt.Errorf("failed to download source %s: %w", source.Filename, err)
+	}
+	c.tracer.Trace("sources", "Source downloaded successfully", "filename", source.Filename)
+	return nil
+}
 
-	// Build genisoimage command
-	args := []string{
-		"-output", isoPath,
-		"-volid", "cidata",
-		"-joliet",
-		"-input-charset", "utf-8",
-		"-graft-points",
+// Keys of the ISO stage manifest which are settings rather than files
+const (
+	isoDatasourceKey  = "iso:datasource"
+	isoVolumeLabelKey = "iso:volume_label"
+)
+
+// configDrivePaths maps the NoCloud file names to their place on a ConfigDrive ISO
+var configDrivePaths = map[string]string{
+	"user-data":      "openstack/latest/user_data",
+	"meta-data":      "openstack/latest/meta_data.json",
+	"vendor-data":    "openstack/latest/vendor_data.json",
+	"network-config": "openstack/latest/network_data.json",
+}
+
+// cloudInitISOName returns the path of the file called name in the ISO for
+// datasource. Files without a ConfigDrive place stay in the root.
+func cloudInitISOName(datasource, name string) string {
+	if path, ok := configDrivePaths[name]; ok && datasource == CloudInitConfigDrive {
+		return path
 	}
+	return name
+}
 
-	// Add template files from state directory
+func (c *CloudInitImageBuilder) createISO(isoPath string, manifest map[string]string) error {
+	datasource := c.config.CloudInit.ISODatasource()
+	volumeLabel := c.config.CloudInit.ISOVolumeLabel()
+	c.tracer.Trace("iso", "Creating cloud-init ISO", "output", isoPath, "datasource", datasource, "label", volumeLabel)
+
+	var files []isoFile
 	for filename := range manifest {
+		if filename == isoDatasourceKey || filename == isoVolumeLabelKey {
+			continue
+		}
 		if filename != "cloud_init_iso" { // Skip the ISO itself
 			// Check if this is a template file (exists in state directory)
 			stateFilePath := filepath.Join(c.stateDir, filename)
 			if _, err := os.Stat(stateFilePath); err == nil {
 				// Template file exists in state directory
-				args = append(args, fmt.Sprintf("%s=%s", filename, stateFilePath))
+				files = append(files, isoFile{Name: cloudInitISOName(datasource, filename), Path: stateFilePath})
 				c.tracer.Trace("iso", "Adding template file to ISO", "filename", filename, "path", stateFilePath)
 			} else {
 				// This might be a source file - check if it's in our sources config
@@ -426,7 +770,7 @@ func (c *CloudInitImageBuilder) createISO(isoPath string, manifest map[string]st
 					if source.Filename == filename {
 						// Use the cached file directly
 						cachedPath := c.downloader.GetCachedPath(source.SHA256Sum)
-						args = append(args, fmt.Sprintf("%s=%s", filename, cachedPath))
+						files = append(files, isoFile{Name: cloudInitISOName(datasource, filename), Path: cachedPath})
 						c.tracer.Trace("iso", "Adding source file to ISO", "filename", filename, "path", cachedPath)
 						break
 					}
@@ -436,24 +780,48 @@ func (c *CloudInitImageBuilder) createISO(isoPath string, manifest map[string]st
 	}
 
 	// Check if we have any files to add
-	if len(args) <= 5 { // Only the base args, no files
+	if len(files) == 0 {
 		return fmt.Errorf("no files found to add to ISO")
 	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	// Prefer an installed ISO writer, fall back to writing the ISO ourselves
+	tool := findISOTool()
+	if tool == "" {
+		c.tracer.Trace("iso", "No external ISO writer found, writing ISO natively", "tools", isoTools)
+		if err := writeISO(isoPath, volumeLabel, files); err != nil {
+			return fmt.Errorf("writing ISO: %w", err)
+		}
+		c.tracer.Trace("iso", "Cloud-init ISO created successfully", "writer", "native")
+		return nil
+	}
+
+	// Build genisoimage command
+	args := []string{
+		"-output", isoPath,
+		"-volid", volumeLabel,
+		"-joliet",
+		"-input-charset", "utf-8",
+		"-graft-points",
+	}
+	for _, f := range files {
+		args = append(args, fmt.Sprintf("%s=%s", f.Name, f.Path))
+	}
 
-	c.tracer.Trace("iso", "Running genisoimage", "args", args)
+	c.tracer.Trace("iso", "Running ISO writer", "tool", tool, "args", args)
 
-	cmd := exec.Command("genisoimage", args...)
+	cmd := exec.Command(tool, args...)
 
 	// Capture stderr for debugging
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		c.tracer.Trace("iso", "genisoimage failed", "error", err.Error(), "stderr", stderr.String())
-		return fmt.Errorf("genisoimage failed: %w, stderr: %s", err, stderr.String())
+		c.tracer.Trace("iso", "ISO writer failed", "error", err.Error(), "stderr", stderr.String())
+		return fmt.Errorf("%s failed: %w, stderr: %s", filepath.Base(tool), err, stderr.String())
 	}
 
-	c.tracer.Trace("iso", "Cloud-init ISO created successfully", "size", "check")
+	c.tracer.Trace("iso", "Cloud-init ISO created successfully", "writer", filepath.Base(tool))
 	return nil
 }
 
@@ -462,7 +830,10 @@ func (c *CloudInitImageBuilder) runQEMU() error {
 	c.tracer.Trace("qemu", "Starting QEMU VM for customization")
 
 	// Build the full environment for template rendering
-	env := c.config.Env
+	env := make(map[string]interface{}, len(c.config.Env))
+	for k, v := range c.config.Env {
+		env[k] = v
+	}
 	fmt.Printf("DEBUG: Initial env = %+v\n", env)
 
 	if c.config.EnvHook != nil {
@@ -505,6 +876,11 @@ func (c *CloudInitImageBuilder) runQEMU() error {
 		fmt.Printf("DEBUG: Rendered arg %d: %s\n", i, args[i])
 	}
 
+	// Inject a QMP socket so a timed out build can be powered down gracefully
+	qmpSocket := c.buildQMPSocketPath()
+	os.Remove(qmpSocket)
+	args = append(args, "-qmp", fmt.Sprintf("unix:%s,server,nowait", qmpSocket))
+
 	fmt.Printf("DEBUG: Final QEMU command: %s %v\n", c.qemuBin, args)
 
 	// Print exact command for manual testing
@@ -521,9 +897,11 @@ func (c *CloudInitImageBuilder) runQEMU() error {
 	cmd.Dir = c.stateDir
 	fmt.Printf("DEBUG: QEMU working directory: %s\n", cmd.Dir)
 
-	// Let QEMU write directly to stdout/stderr for better output handling
+	// Let QEMU write directly to stdout/stderr for better output handling,
+	// keeping a copy of stderr to report startup failures
+	var stderrBuf bytes.Buffer
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
 
 	// Start the command
 	fmt.Printf("DEBUG: Starting QEMU process...\n")
@@ -547,6 +925,14 @@ func (c *CloudInitImageBuilder) runQEMU() error {
 		doneCh <- err
 	}()
 
+	// Fail fast if QEMU dies before its QMP socket comes up
+	if c.qmpWaitTimeout > 0 {
+		if err := c.waitForBuildQMP(qmpSocket, doneCh, &stderrBuf); err != nil {
+			c.tracer.Trace("qemu", "QEMU failed during startup", "error", err.Error())
+			return err
+		}
+	}
+
 	// Wait for completion or timeout
 	fmt.Printf("DEBUG: Waiting for QEMU completion or timeout...\n")
 	select {
@@ -556,11 +942,14 @@ func (c *CloudInitImageBuilder) runQEMU() error {
 			c.tracer.Trace("qemu", "QEMU process failed", "error", err.Error())
 			return fmt.Errorf("QEMU process failed: %w", err)
 		}
-	case <-time.After(10 * time.Minute): // 10 minute timeout for VM boot and shutdown
-		fmt.Printf("DEBUG: QEMU process timed out, killing\n")
-		c.tracer.Trace("qemu", "QEMU process timed out, killing")
-		cmd.Process.Kill()
-		return fmt.Errorf("QEMU process timed out after 10 minutes")
+	case <-time.After(c.buildTimeout): // timeout for VM boot and shutdown
+		fmt.Printf("DEBUG: QEMU process timed out, requesting powerdown\n")
+		c.tracer.Trace("qemu", "QEMU process timed out, requesting powerdown", "timeout", c.buildTimeout)
+		if !c.powerdownBuildVM(qmpSocket, doneCh) {
+			c.tracer.Trace("qemu", "QEMU did not power down in time, killing")
+			cmd.Process.Kill()
+		}
+		return fmt.Errorf("QEMU process timed out after %s", c.buildTimeout)
 	}
 
 	fmt.Printf("DEBUG: QEMU process completed successfully\n")
@@ -570,6 +959,66 @@ func (c *CloudInitImageBuilder) runQEMU() error {
 	return nil
 }
 
+// buildQMPSocketPath returns the QMP socket injected into the customization VM
+func (c *CloudInitImageBuilder) buildQMPSocketPath() string {
+	return filepath.Join(c.stateDir, "build-qmp.sock")
+}
+
+// waitForBuildQMP waits until the build VM answers with a QMP greeting. If QEMU
+// exits first, its stderr is returned in the error. Not seeing QMP within
+// qmpWaitTimeout is not fatal, the build then continues as before.
+func (c *CloudInitImageBuilder) waitForBuildQMP(qmpSocket string, doneCh <-chan error, stderr *bytes.Buffer) error {
+	deadline := time.Now().Add(c.qmpWaitTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-doneCh:
+			if err == nil {
+				err = fmt.Errorf("exited with status 0")
+			}
+			return fmt.Errorf("QEMU exited during startup: %v\n%s", err, strings.TrimSpace(stderr.String()))
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		conn, err := net.DialTimeout("unix", qmpSocket, time.Second)
+		if err != nil {
+			continue
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		greeting, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err == nil && strings.Contains(greeting, `"QMP"`) {
+			c.tracer.Trace("qemu", "Build VM QMP socket is up", "socket", qmpSocket)
+			return nil
+		}
+	}
+
+	c.tracer.Trace("qemu", "Build VM QMP socket did not come up in time", "timeout", c.qmpWaitTimeout)
+	return nil
+}
+
+// powerdownBuildVM requests a graceful powerdown of the build VM and reports
+// whether QEMU exited within the grace period
+func (c *CloudInitImageBuilder) powerdownBuildVM(qmpSocket string, doneCh <-chan error) bool {
+	if c.powerdown == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.shutdownGrace)
+	defer cancel()
+
+	if err := c.powerdown(ctx, qmpSocket); err != nil {
+		c.tracer.Trace("qemu", "Failed to request powerdown", "error", err.Error())
+	}
+
+	select {
+	case <-doneCh:
+		c.tracer.Trace("qemu", "QEMU powered down after timeout")
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (c *CloudInitImageBuilder) calculateFileHash(filePath string) (string, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
@@ -0,0 +1,543 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package img
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"qqmgr/internal/config"
+	"qqmgr/internal/downloader"
+	"qqmgr/internal/trace"
+)
+
+func TestRunQEMUPowerdownOnTimeout(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(tempDir, "img.test")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		t.Fatalf("Failed to create state dir: %v", err)
+	}
+
+	// Mock QEMU writes part of the overlay, then finishes it on powerdown (SIGTERM)
+	mockQemu := filepath.Join(tempDir, "mock-qemu")
+	script := `#!/bin/sh
+echo "$@" > args.txt
+echo $$ > qemu.pid
+printf 'partial' > "$1"
+trap 'printf -- '-complete' >> "$1"; exit 0' TERM
+while true; do sleep 0.05; done
+`
+	if err := os.WriteFile(mockQemu, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU script: %v", err)
+	}
+
+	config := &ImageConfig{
+		Builder:   "cloud-init",
+		BuildArgs: []string{"{{.img_self}}"},
+	}
+	builder := NewCloudInitImageBuilder(config, stateDir, mockQemu, "qemu-img", nil, NewTemplateProcessor(tempDir), trace.NewNoOpTracer())
+	builder.buildTimeout = 500 * time.Millisecond
+	builder.shutdownGrace = 5 * time.Second
+	builder.qmpWaitTimeout = 0
+
+	var powerdownSocket string
+	builder.SetPowerdownFunc(func(ctx context.Context, qmpSocket string) error {
+		powerdownSocket = qmpSocket
+		data, err := os.ReadFile(filepath.Join(stateDir, "qemu.pid"))
+		if err != nil {
+			return err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return err
+		}
+		return syscall.Kill(pid, syscall.SIGTERM)
+	})
+
+	err := builder.runQEMU()
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("runQEMU() error = %v, want timeout error", err)
+	}
+
+	if powerdownSocket != builder.buildQMPSocketPath() {
+		t.Errorf("powerdown called with socket %q, want %q", powerdownSocket, builder.buildQMPSocketPath())
+	}
+
+	args, err := os.ReadFile(filepath.Join(stateDir, "args.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read mock QEMU args: %v", err)
+	}
+	if !strings.Contains(string(args), "-qmp unix:"+builder.buildQMPSocketPath()+",server,nowait") {
+		t.Errorf("QMP socket was not injected into build args: %s", args)
+	}
+
+	overlay, err := os.ReadFile(builder.GetImagePath())
+	if err != nil {
+		t.Fatalf("Failed to read overlay: %v", err)
+	}
+	if string(overlay) != "partial-complete" {
+		t.Errorf("overlay = %q, want %q (VM should shut down cleanly)", overlay, "partial-complete")
+	}
+}
+
+func TestRunQEMUFailsFastOnStartupError(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(tempDir, "img.test")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		t.Fatalf("Failed to create state dir: %v", err)
+	}
+
+	mockQemu := filepath.Join(tempDir, "mock-qemu")
+	script := `#!/bin/sh
+echo "qemu-system-x86_64: -bogus: invalid option" >&2
+exit 1
+`
+	if err := os.WriteFile(mockQemu, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU script: %v", err)
+	}
+
+	config := &ImageConfig{
+		Builder:   "cloud-init",
+		BuildArgs: []string{"-bogus"},
+	}
+	builder := NewCloudInitImageBuilder(config, stateDir, mockQemu, "qemu-img", nil, NewTemplateProcessor(tempDir), trace.NewNoOpTracer())
+	builder.buildTimeout = time.Minute
+
+	start := time.Now()
+	err := builder.runQEMU()
+	if err == nil {
+		t.Fatal("runQEMU() succeeded, want startup error")
+	}
+	if !strings.Contains(err.Error(), "exited during startup") || !strings.Contains(err.Error(), "-bogus: invalid option") {
+		t.Errorf("runQEMU() error = %v, want startup error including QEMU stderr", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("runQEMU() took %s, want it to fail fast", elapsed)
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	configDir := t.TempDir()
+	templatePath := filepath.Join(configDir, "user-data.tpl")
+	if err := os.WriteFile(templatePath, []byte("#cloud-config\nhostname: {{.hostname}}\n"), 0644); err != nil {
+		t.Fatalf("Failed to create template: %v", err)
+	}
+
+	config := &ImageConfig{
+		Builder: "cloud-init",
+		Env:     map[string]interface{}{"hostname": "render-test"},
+		Templates: []TemplateConfig{
+			{Template: "user-data.tpl", Output: "user-data"},
+		},
+	}
+	manager := NewManager(configDir, filepath.Join(configDir, ".qqmgr"), "qemu-system-x86_64", "qemu-img", trace.NewNoOpTracer())
+
+	outputDir := t.TempDir()
+	if err := manager.RenderTemplates("test", config, outputDir); err != nil {
+		t.Fatalf("RenderTemplates() error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "user-data"))
+	if err != nil {
+		t.Fatalf("Failed to read rendered file: %v", err)
+	}
+	if want := "#cloud-config\nhostname: render-test\n"; string(data) != want {
+		t.Errorf("Rendered template = %q, want %q", data, want)
+	}
+
+	if err := manager.RenderTemplates("raw", &ImageConfig{Builder: "raw"}, outputDir); err == nil {
+		t.Error("RenderTemplates() on a raw image should fail")
+	}
+}
+
+func TestTemplateEnvFile(t *testing.T) {
+	configDir := t.TempDir()
+	stateDir := t.TempDir()
+	files := map[string]string{
+		"user-data.tpl": "hostname: {{.hostname}}\ndomain: {{.domain}}\n",
+		"build.env":     "hostname=from-file\ndomain=example.com\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(configDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	config := &ImageConfig{
+		Builder:   "cloud-init",
+		Env:       map[string]interface{}{"hostname": "inline"},
+		EnvFile:   "build.env",
+		Templates: []TemplateConfig{{Template: "user-data.tpl", Output: "user-data"}},
+	}
+	builder := NewCloudInitImageBuilder(config, stateDir, "qemu-system-x86_64", "qemu-img", nil, NewTemplateProcessor(configDir), trace.NewNoOpTracer())
+
+	// Inline env wins over the file
+	if err := builder.generateCloudInitFiles(); err != nil {
+		t.Fatalf("generateCloudInitFiles() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(stateDir, "user-data"))
+	if err != nil {
+		t.Fatalf("Failed to read rendered file: %v", err)
+	}
+	if want := "hostname: inline\ndomain: example.com\n"; string(data) != want {
+		t.Errorf("Rendered template = %q, want %q", data, want)
+	}
+
+	// The file's hash is part of the manifest, so editing it triggers a rebuild
+	recorded, err := builder.RecordedManifest()
+	if err != nil {
+		t.Fatalf("RecordedManifest() error = %v", err)
+	}
+	hash := recorded["templates.env_file"]
+	if hash == "" {
+		t.Fatalf("Expected the env file hash in the manifest, got %v", recorded)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "build.env"), []byte("domain=example.org\n"), 0644); err != nil {
+		t.Fatalf("Failed to update env file: %v", err)
+	}
+	if err := builder.generateCloudInitFiles(); err != nil {
+		t.Fatalf("generateCloudInitFiles() error = %v", err)
+	}
+	recorded, _ = builder.RecordedManifest()
+	if recorded["templates.env_file"] == hash {
+		t.Error("Expected the manifest to change with the env file")
+	}
+	if data, _ := os.ReadFile(filepath.Join(stateDir, "user-data")); !strings.Contains(string(data), "example.org") {
+		t.Errorf("Expected the templates to be rendered again, got %q", data)
+	}
+
+	config.EnvFile = "missing.env"
+	if err := builder.generateCloudInitFiles(); err == nil {
+		t.Error("Expected a missing env file to fail")
+	}
+}
+
+func TestRunQEMUEnvOverride(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(tempDir, "img.test")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		t.Fatalf("Failed to create state dir: %v", err)
+	}
+
+	mockQemu := filepath.Join(tempDir, "mock-qemu")
+	if err := os.WriteFile(mockQemu, []byte("#!/bin/sh\necho \"$@\" > args.txt\n"), 0755); err != nil {
+		t.Fatalf("Failed to create mock QEMU script: %v", err)
+	}
+
+	cfg := &config.Config{
+		Images: map[string]ImageConfig{
+			"test": {
+				Builder:   "cloud-init",
+				BuildArgs: []string{"-m {{.mem}}"},
+				Env:       map[string]interface{}{"mem": "1024"},
+			},
+		},
+	}
+	if err := cfg.OverrideImageEnv("test", map[string]interface{}{"mem": "4096"}); err != nil {
+		t.Fatalf("OverrideImageEnv() error = %v", err)
+	}
+	imgConfig, err := cfg.GetImage("test")
+	if err != nil {
+		t.Fatalf("GetImage() error = %v", err)
+	}
+
+	builder := NewCloudInitImageBuilder(imgConfig, stateDir, mockQemu, "qemu-img", nil, NewTemplateProcessor(tempDir), trace.NewNoOpTracer())
+	builder.qmpWaitTimeout = 0
+	if err := builder.runQEMU(); err != nil {
+		t.Fatalf("runQEMU() error = %v", err)
+	}
+
+	args, err := os.ReadFile(filepath.Join(stateDir, "args.txt"))
+	if err != nil {
+		t.Fatalf("Failed to read mock QEMU args: %v", err)
+	}
+	if !strings.HasPrefix(string(args), "-m 4096 ") {
+		t.Errorf("build args not rendered with override: %s", args)
+	}
+}
+
+func TestCreateOverlayAbsoluteBacking(t *testing.T) {
+	tempDir := t.TempDir()
+	stateDir := filepath.Join(tempDir, "img.test")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		t.Fatalf("Failed to create state dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, "stage2.img"), []byte("base"), 0644); err != nil {
+		t.Fatalf("Failed to create base image: %v", err)
+	}
+
+	// Mock qemu-img records the backing path in the overlay and reports it back on 'info'
+	mockQemuImg := filepath.Join(tempDir, "mock-qemu-img")
+	script := `#!/bin/sh
+case "$1" in
+create) printf '%s' "$7" > "$8" ;;
+info) printf '{"backing-filename": "%s"}' "$(cat "$3")" ;;
+esac
+`
+	if err := os.WriteFile(mockQemuImg, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to create mock qemu-img: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(stateDir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	builder := NewCloudInitImageBuilder(&ImageConfig{Builder: "cloud-init"}, stateDir, "qemu-system-x86_64", mockQemuImg, nil, NewTemplateProcessor(tempDir), trace.NewNoOpTracer())
+	if err := builder.createOverlay("stage2.img", "stage3.img"); err != nil {
+		t.Fatalf("createOverlay() error = %v", err)
+	}
+
+	backing, err := os.ReadFile(filepath.Join(stateDir, "stage3.img"))
+	if err != nil {
+		t.Fatalf("Failed to read overlay: %v", err)
+	}
+	if !filepath.IsAbs(string(backing)) {
+		t.Errorf("overlay backing path %q is not absolute", backing)
+	}
+	if want, _ := filepath.Abs(filepath.Join(stateDir, "stage2.img")); string(backing) != want {
+		t.Errorf("overlay backing path = %q, want %q", backing, want)
+	}
+
+	// A backing chain which doesn't resolve is reported
+	if err := os.Remove(filepath.Join(stateDir, "stage2.img")); err != nil {
+		t.Fatalf("Failed to remove base image: %v", err)
+	}
+	err = builder.verifyOverlay(filepath.Join(stateDir, "stage3.img"), string(backing))
+	if err == nil || !strings.Contains(err.Error(), "does not resolve") {
+		t.Errorf("verifyOverlay() error = %v, want unresolved backing error", err)
+	}
+}
+
+func TestQemuImgFailureSurfacesOutput(t *testing.T) {
+	tempDir := t.TempDir()
+
+	mockQemuImg := filepath.Join(tempDir, "mock-qemu-img")
+	script := `#!/bin/sh
+echo "qemu-img: $1: Could not open 'missing.img': No such file or directory" >&2
+exit 1
+`
+	if err := os.WriteFile(mockQemuImg, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to create mock qemu-img: %v", err)
+	}
+
+	builder := NewCloudInitImageBuilder(&ImageConfig{Builder: "cloud-init"}, tempDir, "qemu-system-x86_64", mockQemuImg, nil, NewTemplateProcessor(tempDir), trace.NewNoOpTracer())
+
+	tests := map[string]func() error{
+		"resize": func() error { return builder.resizeImage("missing.img", "10G") },
+		"create": func() error { return builder.createOverlay("missing.img", filepath.Join(tempDir, "overlay.img")) },
+		"cp": func() error {
+			return builder.copyFile(filepath.Join(tempDir, "missing.img"), filepath.Join(tempDir, "copy.img"))
+		},
+	}
+	for name, run := range tests {
+		err := run()
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+			continue
+		}
+		want := "Could not open"
+		if name == "cp" {
+			want = "No such file or directory"
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %q does not include the command's output", name, err)
+		}
+	}
+}
+
+func TestBuildRecordsStageTimings(t *testing.T) {
+	stateDir := t.TempDir()
+
+	// Lay out the state of an up to date build, so every stage runs but skips its work
+	files := map[string]string{
+		"stage1.img.checksum":          "abc123",
+		"stage2.manifest.json":         `{"base_img_hash": "abc123", "img_size": "10G"}`,
+		"cloud-init.iso.manifest.json": `{}`,
+		"stage1.img":                   "base",
+		"stage3.img":                   "overlay",
+		"cloud-init.iso":               "iso",
+		"stage2.img":                   "resized base",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(stateDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	config := &ImageConfig{
+		Builder: "cloud-init",
+		ImgSize: "10G",
+		BaseImg: &BaseImageConfig{URL: "https://example.com/base.qcow2", SHA256Sum: "abc123"},
+	}
+	builder := NewCloudInitImageBuilder(config, stateDir, "qemu-system-x86_64", "qemu-img", nil, NewTemplateProcessor(stateDir), trace.NewNoOpTracer())
+	if err := builder.Build(context.Background()); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	timings := builder.Timings()
+	if len(timings) != len(CloudInitStages) {
+		t.Errorf("Expected a timing per stage, got %v", timings)
+	}
+	for _, stage := range CloudInitStages {
+		if elapsed, ok := timings[stage]; !ok || elapsed < 0 {
+			t.Errorf("Missing timing for stage %s: %v", stage, timings)
+		}
+	}
+
+	// A failing build only records the stages it reached
+	config.BaseImg = nil
+	if err := builder.Build(context.Background()); err == nil {
+		t.Fatal("Build() without a base image should fail")
+	}
+	if _, ok := builder.Timings()["download"]; !ok || len(builder.Timings()) != 1 {
+		t.Errorf("Expected only the download stage to be timed, got %v", builder.Timings())
+	}
+}
+
+func TestCreateISODatasources(t *testing.T) {
+	// Use the native writer, whose output can be read back
+	t.Setenv("PATH", t.TempDir())
+
+	tests := []struct {
+		name      string
+		cloudInit *CloudInitConfig
+		wantLabel string
+		wantFiles []string
+	}{
+		{
+			name:      "default",
+			wantLabel: "cidata",
+			wantFiles: []string{"meta-data;1", "setup.sh;1", "user-data;1"},
+		},
+		{
+			name:      "configdrive",
+			cloudInit: &CloudInitConfig{Datasource: CloudInitConfigDrive},
+			wantLabel: "config-2",
+			wantFiles: []string{"openstack/latest/meta_data.json;1", "openstack/latest/user_data;1", "setup.sh;1"},
+		},
+		{
+			name:      "custom label",
+			cloudInit: &CloudInitConfig{Datasource: CloudInitNoCloud, VolumeLabel: "SEED"},
+			wantLabel: "SEED",
+			wantFiles: []string{"meta-data;1", "setup.sh;1", "user-data;1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			manifest := make(map[string]string)
+			for _, name := range []string{"user-data", "meta-data", "setup.sh"} {
+				if err := os.WriteFile(filepath.Join(stateDir, name), []byte(name), 0644); err != nil {
+					t.Fatalf("Failed to write %s: %v", name, err)
+				}
+				manifest[name] = "hash"
+			}
+			if tt.cloudInit != nil {
+				manifest[isoDatasourceKey] = tt.cloudInit.ISODatasource()
+				manifest[isoVolumeLabelKey] = tt.cloudInit.ISOVolumeLabel()
+			}
+
+			config := &ImageConfig{Builder: "cloud-init", CloudInit: tt.cloudInit}
+			builder := NewCloudInitImageBuilder(config, stateDir, "qemu-system-x86_64", "qemu-img", nil, NewTemplateProcessor(stateDir), trace.NewNoOpTracer())
+			isoPath := filepath.Join(stateDir, "cloud-init.iso")
+			if err := builder.createISO(isoPath, manifest); err != nil {
+				t.Fatalf("createISO() error = %v", err)
+			}
+
+			image, err := os.ReadFile(isoPath)
+			if err != nil {
+				t.Fatalf("Failed to read ISO: %v", err)
+			}
+			if label := strings.TrimRight(string(image[pvdLBA*isoSectorSize+40:pvdLBA*isoSectorSize+72]), " "); label != tt.wantLabel {
+				t.Errorf("Volume label = %q, want %q", label, tt.wantLabel)
+			}
+			var names []string
+			for name := range readISOFiles(t, image, true) {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !slices.Equal(names, tt.wantFiles) {
+				t.Errorf("ISO files = %v, want %v", names, tt.wantFiles)
+			}
+		})
+	}
+}
+
+// TestPrepareAdditionalSourcesParallel tests that sources are downloaded by a
+// bounded pool of workers and that every failing source is reported
+func TestPrepareAdditionalSourcesParallel(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/missing") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	var sources []SourceConfig
+	for i := range 6 {
+		path := fmt.Sprintf("/file%d", i)
+		sources = append(sources, SourceConfig{
+			Filename:  fmt.Sprintf("file%d", i),
+			URL:       server.URL + path,
+			SHA256Sum: fmt.Sprintf("%x", sha256.Sum256([]byte(path))),
+		})
+	}
+
+	cacheDir := t.TempDir()
+	config := &ImageConfig{Builder: "cloud-init", Sources: sources, ParallelDownloads: 2}
+	builder := NewCloudInitImageBuilder(config, t.TempDir(), "qemu-system-x86_64", "qemu-img",
+		downloader.NewDownloader(cacheDir), NewTemplateProcessor(t.TempDir()), trace.NewNoOpTracer())
+	if err := builder.prepareAdditionalSources(); err != nil {
+		t.Fatalf("prepareAdditionalSources() error: %v", err)
+	}
+	for _, source := range sources {
+		if _, err := os.Stat(filepath.Join(cacheDir, source.SHA256Sum)); err != nil {
+			t.Errorf("Expected %s in the download cache: %v", source.Filename, err)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("Expected at most 2 concurrent downloads and some overlap, got %d", peak)
+	}
+
+	// One failing source does not mask another
+	config.Sources = []SourceConfig{
+		{Filename: "a", URL: server.URL + "/missing-a", SHA256Sum: "aa"},
+		sources[0],
+		{Filename: "b", URL: server.URL + "/missing-b", SHA256Sum: "bb"},
+	}
+	err := builder.prepareAdditionalSources()
+	if err == nil || !strings.Contains(err.Error(), "source a:") || !strings.Contains(err.Error(), "source b:") {
+		t.Errorf("Expected both failing sources to be reported, got %v", err)
+	}
+}
@@ -10,3 +10,9 @@ type BaseImageConfig = config.BaseImageConfig
 type EnvHookConfig = config.EnvHookConfig
 type TemplateConfig = config.TemplateConfig
 type SourceConfig = config.SourceConfig
+type CloudInitConfig = config.CloudInitConfig
+
+const (
+	CloudInitNoCloud     = config.CloudInitNoCloud
+	CloudInitConfigDrive = config.CloudInitConfigDrive
+)
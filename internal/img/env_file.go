@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package img
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envFileKeyPattern matches the variable names allowed in an env file
+var envFileKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// loadEnvFile reads the dotenv-style file at path, returning its variables and
+// the hash of its contents
+func loadEnvFile(path string) (map[string]interface{}, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read env_file: %w", err)
+	}
+	env, err := parseEnvFile(string(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("env_file %s: %w", path, err)
+	}
+	return env, fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// parseEnvFile parses KEY=VALUE lines. Blank lines and lines starting with #
+// are skipped and an "export " prefix is allowed. Values may be single-quoted
+// (taken literally) or double-quoted (supporting \n, \t, \" and \\), unquoted
+// values end at " #".
+func parseEnvFile(data string) (map[string]interface{}, error) {
+	env := make(map[string]interface{})
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envFileKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE, got %q", i+1, line)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && strings.HasSuffix(value, "'"):
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"' && strings.HasSuffix(value, `"`):
+			value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		case strings.HasPrefix(value, "'") || strings.HasPrefix(value, `"`):
+			return nil, fmt.Errorf("line %d: unterminated quote in value of %s", i+1, key)
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = strings.TrimSpace(value[:comment])
+			}
+		}
+		env[key] = value
+	}
+	return env, nil
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package img
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	data := `# Deployment settings
+HOSTNAME=builder
+export USER = admin
+
+PASSWORD='p@ss # not a comment'
+MOTD="Hello\nWorld \"quoted\""
+DOMAIN=example.com # trailing comment
+EMPTY=
+`
+	env, err := parseEnvFile(data)
+	if err != nil {
+		t.Fatalf("parseEnvFile() error = %v", err)
+	}
+	want := map[string]interface{}{
+		"HOSTNAME": "builder",
+		"USER":     "admin",
+		"PASSWORD": "p@ss # not a comment",
+		"MOTD":     "Hello\nWorld \"quoted\"",
+		"DOMAIN":   "example.com",
+		"EMPTY":    "",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("parseEnvFile() = %v, want %v", env, want)
+	}
+
+	for _, bad := range []string{"NOVALUE", "1KEY=x", "KEY='unterminated"} {
+		if _, err := parseEnvFile("OK=1\n" + bad); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("parseEnvFile(%q) error = %v, want an error on line 2", bad, err)
+		}
+	}
+}
@@ -25,16 +25,7 @@ func (e *EnvHookExecutor) Execute(
 	configDir string,
 	env map[string]interface{},
 ) (map[string]interface{}, error) {
-	// Prepare the script path
-	scriptPath := filepath.Join(configDir, hook.Script)
-
-	// Create command
-	var cmd *exec.Cmd
-	if hook.Interpreter != "" {
-		cmd = exec.Command(hook.Interpreter, scriptPath)
-	} else {
-		cmd = exec.Command(scriptPath)
-	}
+	cmd := hookCommand(hook, configDir)
 
 	// Set up stdin with JSON input
 	inputData, err := json.Marshal(env)
@@ -74,3 +65,40 @@ func (e *EnvHookExecutor) Execute(
 
 	return result, nil
 }
+
+// hookCommand returns the command running hook's script, which is relative to
+// configDir, through its interpreter if one is set
+func hookCommand(hook *EnvHookConfig, configDir string) *exec.Cmd {
+	scriptPath := filepath.Join(configDir, hook.Script)
+	if hook.Interpreter != "" {
+		return exec.Command(hook.Interpreter, scriptPath)
+	}
+	return exec.Command(scriptPath)
+}
+
+// PostBuildInput is the JSON a post_build hook receives on stdin
+type PostBuildInput struct {
+	Image    string            `json:"image"`    // Absolute path of the built image
+	Manifest map[string]string `json:"manifest"` // Input hashes of the build
+}
+
+// ExecutePostBuild runs a post_build hook with input as JSON on stdin, failing
+// if the hook exits non-zero. Its output is returned for tracing.
+func (e *EnvHookExecutor) ExecutePostBuild(hook *EnvHookConfig, configDir string, input PostBuildInput) (string, error) {
+	cmd := hookCommand(hook, configDir)
+
+	inputData, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal post_build input: %w", err)
+	}
+	cmd.Stdin = bytes.NewReader(inputData)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("post_build hook failed: %s, %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return stdout.String(), nil
+}
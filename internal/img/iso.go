@@ -0,0 +1,487 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package img
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// isoTools are the external ISO writers tried in order, they share genisoimage's options
+var isoTools = []string{"genisoimage", "mkisofs", "xorrisofs"}
+
+// findISOTool returns the first external ISO writer found in PATH, or "" if there is none
+func findISOTool() string {
+	for _, tool := range isoTools {
+		if path, err := exec.LookPath(tool); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// isoFile is a file grafted into an ISO image
+type isoFile struct {
+	Name string // Path in the image, "/"-separated components validated to be Joliet-compatible
+	Path string // Path of the file on disk
+}
+
+const isoSectorSize = 2048
+
+// isoLayout holds the sector positions of everything in an image written by writeISO
+type isoLayout struct {
+	primaryDirs, jolietDirs     []byte    // Directory extents, the root first
+	primaryLBA, jolietLBA       uint32    // Root directories
+	primaryRoot, jolietRoot     uint32    // Sizes of the root directory extents
+	primaryTables, jolietTables [2][]byte // Path tables, little and big endian
+	totalSectors                uint32
+}
+
+// isoDirEntry is a file's or subdirectory's record in a directory
+type isoDirEntry struct {
+	id    string
+	lba   uint32
+	size  uint32
+	isDir bool
+}
+
+// isoDir is a directory of an image being planned
+type isoDir struct {
+	name    string // Name in the image, "" for the root
+	parent  *isoDir
+	dirs    []*isoDir
+	files   []int     // Indices into the files of the image
+	lba     [2]uint32 // Extent of the primary and Joliet directory
+	sectors [2]uint32
+	ids     [2]string // Identifier in the primary and Joliet parent directory
+	fileIDs [2][]string
+}
+
+// Indices into the per-volume fields of isoDir
+const (
+	primaryVolume = 0
+	jolietVolume  = 1
+)
+
+// Fixed sectors of the volume descriptors and path tables
+const (
+	pvdLBA           = 16
+	svdLBA           = 17
+	terminatorLBA    = 18
+	pathTableLLBA    = 19
+	pathTableMLBA    = 20
+	jolietTableLLBA  = 21
+	jolietTableMLBA  = 22
+	firstDirLBA      = 23
+	dirRecordBaseLen = 33
+)
+
+// writeISO writes an ISO9660 image with Joliet extensions holding files, for
+// systems without an external ISO writer. Directories in the file names are
+// created as needed. The output only depends on the files' names and contents,
+// so rebuilding it keeps its hash.
+func writeISO(isoPath, volumeID string, files []isoFile) error {
+	sizes := make([]uint32, len(files))
+	for i, f := range files {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", f.Path, err)
+		}
+		if info.Size() > 0xFFFFFFFF {
+			return fmt.Errorf("%s is too large for an ISO9660 image", f.Path)
+		}
+		sizes[i] = uint32(info.Size())
+	}
+
+	layout, err := planISO(files, sizes)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(isoPath)
+	if err != nil {
+		return fmt.Errorf("failed to create ISO: %w", err)
+	}
+	defer out.Close()
+
+	// System area, volume descriptors and path tables
+	head := make([]byte, firstDirLBA*isoSectorSize)
+	writeVolumeDescriptor(head[pvdLBA*isoSectorSize:], 1, volumeID, layout, false)
+	writeVolumeDescriptor(head[svdLBA*isoSectorSize:], 2, volumeID, layout, true)
+	term := head[terminatorLBA*isoSectorSize:]
+	term[0] = 255
+	copy(term[1:6], "CD001")
+	term[6] = 1
+	copy(head[pathTableLLBA*isoSectorSize:], layout.primaryTables[0])
+	copy(head[pathTableMLBA*isoSectorSize:], layout.primaryTables[1])
+	copy(head[jolietTableLLBA*isoSectorSize:], layout.jolietTables[0])
+	copy(head[jolietTableMLBA*isoSectorSize:], layout.jolietTables[1])
+
+	if _, err := out.Write(head); err != nil {
+		return fmt.Errorf("failed to write ISO: %w", err)
+	}
+	if _, err := out.Write(layout.primaryDirs); err != nil {
+		return fmt.Errorf("failed to write ISO: %w", err)
+	}
+	if _, err := out.Write(layout.jolietDirs); err != nil {
+		return fmt.Errorf("failed to write ISO: %w", err)
+	}
+
+	for i, f := range files {
+		if err := copyISOFile(out, f.Path, sizes[i]); err != nil {
+			return err
+		}
+	}
+
+	return out.Close()
+}
+
+// copyISOFile appends size bytes of the file at path to out, padded to a full sector
+func copyISOFile(out io.Writer, path string, size uint32) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer in.Close()
+
+	if _, err := io.CopyN(out, in, int64(size)); err != nil {
+		return fmt.Errorf("failed to copy %s into ISO: %w", path, err)
+	}
+	if pad := sectorsFor(size)*isoSectorSize - size; pad > 0 {
+		if _, err := out.Write(make([]byte, pad)); err != nil {
+			return fmt.Errorf("failed to write ISO: %w", err)
+		}
+	}
+	return nil
+}
+
+// planISO assigns names and sectors to the directories and files of the image.
+// The primary directories come first, then the Joliet ones, each in path table
+// order, and the file data follows in the order of files.
+func planISO(files []isoFile, sizes []uint32) (*isoLayout, error) {
+	root := buildISOTree(files)
+	assignISONames(root, files)
+
+	// The directory sizes only depend on the names, so build them once to measure
+	layout := &isoLayout{}
+	next := uint32(firstDirLBA)
+	for volume := range 2 {
+		for _, dir := range pathTableOrder(root, volume) {
+			dir.sectors[volume] = uint32(len(buildDirectory(dir, volume, sizes))) / isoSectorSize
+			dir.lba[volume] = next
+			next += dir.sectors[volume]
+		}
+	}
+
+	fileLBAs := make([]uint32, len(files))
+	for i, size := range sizes {
+		fileLBAs[i] = next
+		next += sectorsFor(size)
+	}
+	layout.totalSectors = next
+
+	for volume := range 2 {
+		var extents []byte
+		for _, dir := range pathTableOrder(root, volume) {
+			extents = append(extents, buildDirectory(dir, volume, sizes, fileLBAs...)...)
+		}
+		table := [2][]byte{
+			buildPathTable(root, volume, binary.LittleEndian),
+			buildPathTable(root, volume, binary.BigEndian),
+		}
+		if len(table[0]) > isoSectorSize {
+			return nil, fmt.Errorf("too many directories for an ISO image")
+		}
+		if volume == primaryVolume {
+			layout.primaryDirs, layout.primaryTables = extents, table
+		} else {
+			layout.jolietDirs, layout.jolietTables = extents, table
+		}
+	}
+	layout.primaryLBA, layout.primaryRoot = root.lba[primaryVolume], root.sectors[primaryVolume]*isoSectorSize
+	layout.jolietLBA, layout.jolietRoot = root.lba[jolietVolume], root.sectors[jolietVolume]*isoSectorSize
+	return layout, nil
+}
+
+// buildISOTree returns the root of the directory tree holding files
+func buildISOTree(files []isoFile) *isoDir {
+	root := &isoDir{}
+	for i, f := range files {
+		dir := root
+		parts := strings.Split(f.Name, "/")
+		for _, name := range parts[:len(parts)-1] {
+			var sub *isoDir
+			for _, d := range dir.dirs {
+				if d.name == name {
+					sub = d
+				}
+			}
+			if sub == nil {
+				sub = &isoDir{name: name, parent: dir}
+				dir.dirs = append(dir.dirs, sub)
+			}
+			dir = sub
+		}
+		dir.files = append(dir.files, i)
+	}
+	return root
+}
+
+// assignISONames sets the primary and Joliet identifiers of the directories and
+// files below dir, unique within each directory
+func assignISONames(dir *isoDir, files []isoFile) {
+	var names []string
+	for _, sub := range dir.dirs {
+		names = append(names, sub.name)
+	}
+	for _, i := range dir.files {
+		names = append(names, path.Base(files[i].Name))
+	}
+
+	primary := primaryISONames(names, len(dir.dirs))
+	for i, sub := range dir.dirs {
+		sub.ids = [2]string{primary[i], string(encodeUCS2(sub.name))}
+		assignISONames(sub, files)
+	}
+	for i := range dir.files {
+		name := names[len(dir.dirs)+i]
+		dir.fileIDs[primaryVolume] = append(dir.fileIDs[primaryVolume], primary[len(dir.dirs)+i])
+		dir.fileIDs[jolietVolume] = append(dir.fileIDs[jolietVolume], string(encodeUCS2(name+";1")))
+	}
+}
+
+// pathTableOrder returns the directories below and including root breadth
+// first, each directory's subdirectories sorted by their identifier in volume
+func pathTableOrder(root *isoDir, volume int) []*isoDir {
+	order := []*isoDir{root}
+	for i := 0; i < len(order); i++ {
+		subs := append([]*isoDir{}, order[i].dirs...)
+		sort.Slice(subs, func(a, b int) bool { return subs[a].ids[volume] < subs[b].ids[volume] })
+		order = append(order, subs...)
+	}
+	return order
+}
+
+// primaryISONames returns unique ISO9660 names for the entries of a directory,
+// such as OPENSTAC for the first dirs subdirectories and USER_DAT.;1 for files
+func primaryISONames(names []string, dirs int) []string {
+	ids := make([]string, len(names))
+	seen := make(map[string]bool)
+	for i, name := range names {
+		upper := strings.ToUpper(name)
+		base, ext := dCharacters(upper, 8), ""
+		if i >= dirs {
+			b, e, _ := strings.Cut(upper, ".")
+			base, ext = dCharacters(b, 8), dCharacters(strings.ReplaceAll(e, ".", "_"), 3)
+		}
+		id := func(base string) string {
+			if i < dirs {
+				return base
+			}
+			return base + "." + ext + ";1"
+		}
+
+		candidate := id(base)
+		for n := 1; seen[candidate]; n++ {
+			suffix := fmt.Sprintf("%d", n)
+			candidate = id(base[:min(len(base), 8-len(suffix))] + suffix)
+		}
+		seen[candidate] = true
+		ids[i] = candidate
+	}
+	return ids
+}
+
+// dCharacters maps s to at most n ISO9660 d-characters (A-Z, 0-9 and _)
+func dCharacters(s string, n int) string {
+	var b strings.Builder
+	for _, r := range s {
+		if b.Len() == n {
+			break
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// encodeUCS2 encodes s as big-endian UCS-2, the character set of Joliet
+func encodeUCS2(s string) []byte {
+	var buf bytes.Buffer
+	for _, c := range utf16.Encode([]rune(s)) {
+		binary.Write(&buf, binary.BigEndian, c)
+	}
+	return buf.Bytes()
+}
+
+// buildDirectory returns the extent of dir in volume with ".", ".." and a record
+// per entry, sorted by identifier. Records never span sectors. Without fileLBAs
+// the extent size is only measured.
+func buildDirectory(dir *isoDir, volume int, sizes []uint32, fileLBAs ...uint32) []byte {
+	var entries []isoDirEntry
+	for _, sub := range dir.dirs {
+		entries = append(entries, isoDirEntry{id: sub.ids[volume], lba: sub.lba[volume], size: sub.sectors[volume] * isoSectorSize, isDir: true})
+	}
+	for i, file := range dir.files {
+		entry := isoDirEntry{id: dir.fileIDs[volume][i], size: sizes[file]}
+		if fileLBAs != nil {
+			entry.lba = fileLBAs[file]
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+
+	var extent []byte
+	add := func(record []byte) {
+		if room := isoSectorSize - len(extent)%isoSectorSize; len(record) > room {
+			extent = append(extent, make([]byte, room)...)
+		}
+		extent = append(extent, record...)
+	}
+
+	parent := dir.parent
+	if parent == nil {
+		parent = dir // The root is its own parent
+	}
+	add(dirRecord("\x00", dir.lba[volume], dir.sectors[volume]*isoSectorSize, true))
+	add(dirRecord("\x01", parent.lba[volume], parent.sectors[volume]*isoSectorSize, true))
+	for _, entry := range entries {
+		add(dirRecord(entry.id, entry.lba, entry.size, entry.isDir))
+	}
+
+	if pad := len(extent) % isoSectorSize; pad != 0 {
+		extent = append(extent, make([]byte, isoSectorSize-pad)...)
+	}
+	return extent
+}
+
+// dirRecord returns a directory record for an extent with the given identifier
+func dirRecord(id string, lba, size uint32, isDir bool) []byte {
+	length := dirRecordBaseLen + len(id)
+	if length%2 != 0 {
+		length++
+	}
+	r := make([]byte, length)
+	r[0] = byte(length)
+	putBothEndian32(r[2:], lba)
+	putBothEndian32(r[10:], size)
+	copy(r[18:25], isoRecordingDate())
+	if isDir {
+		r[25] = 0x02
+	}
+	putBothEndian16(r[28:], 1) // Volume sequence number
+	r[32] = byte(len(id))
+	copy(r[33:], id)
+	return r
+}
+
+// isoRecordingDate is the fixed date of all directory records, 1970-01-01 00:00:00 UTC
+func isoRecordingDate() []byte {
+	return []byte{70, 1, 1, 0, 0, 0, 0}
+}
+
+// writeVolumeDescriptor writes the primary (type 1) or Joliet supplementary (type 2)
+// volume descriptor into sector
+func writeVolumeDescriptor(sector []byte, kind byte, volumeID string, layout *isoLayout, joliet bool) {
+	sector[0] = kind
+	copy(sector[1:6], "CD001")
+	sector[6] = 1
+
+	text := func(field []byte, s string) {
+		if joliet {
+			for i := 0; i+1 < len(field); i += 2 {
+				field[i], field[i+1] = 0x00, ' '
+			}
+			copy(field, encodeUCS2(s))
+			return
+		}
+		for i := range field {
+			field[i] = ' '
+		}
+		copy(field, s)
+	}
+	text(sector[8:40], "")
+	text(sector[40:72], volumeID)
+
+	putBothEndian32(sector[80:], layout.totalSectors)
+	rootLBA, rootSize := layout.primaryLBA, layout.primaryRoot
+	tableL, tableM := uint32(pathTableLLBA), uint32(pathTableMLBA)
+	tableSize := len(layout.primaryTables[0])
+	if joliet {
+		copy(sector[88:91], "%/E") // UCS-2 level 3
+		rootLBA, rootSize = layout.jolietLBA, layout.jolietRoot
+		tableL, tableM = jolietTableLLBA, jolietTableMLBA
+		tableSize = len(layout.jolietTables[0])
+	}
+	putBothEndian16(sector[120:], 1)             // Volume set size
+	putBothEndian16(sector[124:], 1)             // Volume sequence number
+	putBothEndian16(sector[128:], isoSectorSize) // Logical block size
+	putBothEndian32(sector[132:], uint32(tableSize))
+	binary.LittleEndian.PutUint32(sector[140:], tableL)
+	binary.BigEndian.PutUint32(sector[148:], tableM)
+	copy(sector[156:190], dirRecord("\x00", rootLBA, rootSize, true))
+
+	for _, field := range [][]byte{sector[190:318], sector[318:446], sector[446:574], sector[574:702]} {
+		text(field, "")
+	}
+	text(sector[702:739], "")
+	text(sector[739:776], "")
+	text(sector[776:813], "")
+
+	// Creation, modification, expiration and effective dates are left unspecified
+	for _, offset := range []int{813, 830, 847, 864} {
+		copy(sector[offset:offset+16], "0000000000000000")
+	}
+	sector[881] = 1 // File structure version
+}
+
+// buildPathTable returns the path table of volume, listing the directories in
+// path table order with the number of their parent directory
+func buildPathTable(root *isoDir, volume int, order binary.ByteOrder) []byte {
+	dirs := pathTableOrder(root, volume)
+	numbers := make(map[*isoDir]uint16, len(dirs))
+	var table []byte
+	for i, dir := range dirs {
+		numbers[dir] = uint16(i + 1)
+		id, parent := dir.ids[volume], uint16(1)
+		if dir.parent == nil {
+			id = "\x00"
+		} else {
+			parent = numbers[dir.parent]
+		}
+
+		entry := make([]byte, 8+len(id)+len(id)%2)
+		entry[0] = byte(len(id))
+		order.PutUint32(entry[2:], dir.lba[volume])
+		order.PutUint16(entry[6:], parent)
+		copy(entry[8:], id)
+		table = append(table, entry...)
+	}
+	return table
+}
+
+func putBothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+func putBothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+// sectorsFor returns the number of sectors needed for size bytes
+func sectorsFor(size uint32) uint32 {
+	return (size + isoSectorSize - 1) / isoSectorSize
+}
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package img
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// readISOFiles returns the files of the primary or Joliet volume of an ISO
+// image by their path
+func readISOFiles(t *testing.T, image []byte, joliet bool) map[string][]byte {
+	t.Helper()
+	sector := image[pvdLBA*isoSectorSize:]
+	if joliet {
+		sector = image[svdLBA*isoSectorSize:]
+		if string(sector[88:91]) != "%/E" {
+			t.Fatalf("Expected a Joliet supplementary volume descriptor")
+		}
+	}
+	if string(sector[1:6]) != "CD001" {
+		t.Fatalf("Expected a volume descriptor, got %q", sector[1:6])
+	}
+
+	files := make(map[string][]byte)
+	var walk func(record []byte, prefix string)
+	walk = func(record []byte, prefix string) {
+		lba := binary.LittleEndian.Uint32(record[2:])
+		size := binary.LittleEndian.Uint32(record[10:])
+		dir := image[lba*isoSectorSize : lba*isoSectorSize+size]
+		for pos := 0; pos < len(dir); {
+			length := int(dir[pos])
+			if length == 0 {
+				// Padding up to the next sector
+				pos = (pos/isoSectorSize + 1) * isoSectorSize
+				continue
+			}
+			record := dir[pos : pos+length]
+			pos += length
+
+			id := record[33 : 33+int(record[32])]
+			if len(id) == 1 && id[0] <= 1 {
+				continue // "." and ".."
+			}
+			name := string(id)
+			if joliet {
+				units := make([]uint16, len(id)/2)
+				for i := range units {
+					units[i] = binary.BigEndian.Uint16(id[2*i:])
+				}
+				name = string(utf16.Decode(units))
+			}
+			if record[25]&0x02 != 0 {
+				walk(record, prefix+name+"/")
+				continue
+			}
+			lba := binary.LittleEndian.Uint32(record[2:])
+			size := binary.LittleEndian.Uint32(record[10:])
+			files[prefix+name] = image[lba*isoSectorSize : lba*isoSectorSize+size]
+		}
+	}
+	walk(sector[156:190], "")
+	return files
+}
+
+func TestWriteISO(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string][]byte{
+		"user-data":      []byte("#cloud-config\nhostname: test\n"),
+		"meta-data":      []byte("instance-id: test\n"),
+		"network-config": bytes.Repeat([]byte("x"), 5000), // Spans several sectors
+		"empty.txt":      nil,
+	}
+	var files []isoFile
+	for name, data := range contents {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		files = append(files, isoFile{Name: name, Path: path})
+	}
+
+	isoPath := filepath.Join(dir, "cloud-init.iso")
+	if err := writeISO(isoPath, "cidata", files); err != nil {
+		t.Fatalf("writeISO() error = %v", err)
+	}
+	image, err := os.ReadFile(isoPath)
+	if err != nil {
+		t.Fatalf("Failed to read ISO: %v", err)
+	}
+	if len(image)%isoSectorSize != 0 {
+		t.Errorf("Expected whole sectors, got %d bytes", len(image))
+	}
+	pvd := image[pvdLBA*isoSectorSize:]
+	if label := strings.TrimRight(string(pvd[40:72]), " "); label != "cidata" {
+		t.Errorf("Expected volume label cidata, got %q", label)
+	}
+	if sectors := binary.LittleEndian.Uint32(pvd[80:]); int(sectors)*isoSectorSize != len(image) {
+		t.Errorf("Volume size of %d sectors does not match the %d byte image", sectors, len(image))
+	}
+
+	// cloud-init reads the Joliet names, which keep case and dashes
+	jolietFiles := readISOFiles(t, image, true)
+	for name, data := range contents {
+		got, ok := jolietFiles[name+";1"]
+		if !ok {
+			t.Errorf("Expected %s in the Joliet directory, got %v", name, jolietFiles)
+			continue
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Content of %s differs: got %d bytes, want %d", name, len(got), len(data))
+		}
+	}
+
+	primaryFiles := readISOFiles(t, image, false)
+	if got := primaryFiles["USER_DAT.;1"]; !bytes.Equal(got, contents["user-data"]) {
+		t.Errorf("Expected user-data as USER_DAT.;1 in the primary directory, got %v", primaryFiles)
+	}
+	if len(primaryFiles) != len(contents) {
+		t.Errorf("Expected %d unique primary names, got %d", len(contents), len(primaryFiles))
+	}
+
+	// The image only depends on the files, so an unchanged rebuild keeps its hash
+	again := filepath.Join(dir, "again.iso")
+	if err := writeISO(again, "cidata", files); err != nil {
+		t.Fatalf("writeISO() error = %v", err)
+	}
+	if rebuilt, _ := os.ReadFile(again); !bytes.Equal(rebuilt, image) {
+		t.Error("Expected rebuilding the ISO to produce the same image")
+	}
+}
+
+func TestWriteISOSubdirectories(t *testing.T) {
+	dir := t.TempDir()
+	contents := map[string][]byte{
+		"openstack/latest/user_data":      []byte("#cloud-config\nhostname: test\n"),
+		"openstack/latest/meta_data.json": []byte(`{"uuid":"test"}`),
+		"openstack/content/0000":          []byte("extra"),
+		"readme.txt":                      []byte("top level"),
+	}
+	var files []isoFile
+	for name, data := range contents {
+		path := filepath.Join(dir, strings.ReplaceAll(name, "/", "_"))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		files = append(files, isoFile{Name: name, Path: path})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	isoPath := filepath.Join(dir, "config-drive.iso")
+	if err := writeISO(isoPath, "config-2", files); err != nil {
+		t.Fatalf("writeISO() error = %v", err)
+	}
+	image, err := os.ReadFile(isoPath)
+	if err != nil {
+		t.Fatalf("Failed to read ISO: %v", err)
+	}
+
+	jolietFiles := readISOFiles(t, image, true)
+	for name, data := range contents {
+		if got, ok := jolietFiles[name+";1"]; !ok || !bytes.Equal(got, data) {
+			t.Errorf("Expected %s in the Joliet volume, got %v", name, jolietFiles)
+		}
+	}
+	primaryFiles := readISOFiles(t, image, false)
+	if got := primaryFiles["OPENSTAC/LATEST/USER_DAT.;1"]; !bytes.Equal(got, contents["openstack/latest/user_data"]) {
+		t.Errorf("Expected user_data as OPENSTAC/LATEST/USER_DAT.;1 in the primary volume, got %v", primaryFiles)
+	}
+	if len(primaryFiles) != len(contents) {
+		t.Errorf("Expected %d primary files, got %d", len(contents), len(primaryFiles))
+	}
+
+	// The path table lists the root, openstack, then content and latest
+	table := image[jolietTableLLBA*isoSectorSize:]
+	var names []string
+	for pos := 0; table[pos] != 0; {
+		length := int(table[pos])
+		id := table[pos+8 : pos+8+length]
+		names = append(names, string(bytes.ReplaceAll(id, []byte{0}, nil)))
+		pos += 8 + length + length%2
+	}
+	if want := []string{"", "openstack", "content", "latest"}; !slices.Equal(names, want) {
+		t.Errorf("Joliet path table = %q, want %q", names, want)
+	}
+}
+
+func TestFindISOToolFallsBackWithoutTools(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if tool := findISOTool(); tool != "" {
+		t.Errorf("Expected no ISO writer in an empty PATH, got %s", tool)
+	}
+
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "xorrisofs"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write mock tool: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+	if tool := findISOTool(); filepath.Base(tool) != "xorrisofs" {
+		t.Errorf("Expected xorrisofs to be found, got %q", tool)
+	}
+}
@@ -5,7 +5,10 @@ package img
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"qqmgr/internal/downloader"
 	"qqmgr/internal/trace"
@@ -13,27 +16,64 @@ import (
 
 // Manager handles image building operations
 type Manager struct {
-	configDir  string
-	runtimeDir string
-	qemuBin    string
-	qemuImg    string
-	downloader *downloader.Downloader
-	tracer     trace.Tracer
+	configDir      string
+	runtimeDir     string
+	qemuBin        string
+	qemuImg        string
+	downloader     *downloader.Downloader
+	tracer         trace.Tracer
+	powerdown      PowerdownFunc
+	qmpWaitTimeout time.Duration
+	noCache        bool
+	// parallelDownloads overrides the parallel_downloads of cloud-init images if set
+	parallelDownloads int
 }
 
 // NewManager creates a new image manager
 func NewManager(configDir, runtimeDir, qemuBin, qemuImg string, tracer trace.Tracer) *Manager {
 	downloadCacheDir := filepath.Join(runtimeDir, "download_cache")
 	return &Manager{
-		configDir:  configDir,
-		runtimeDir: runtimeDir,
-		qemuBin:    qemuBin,
-		qemuImg:    qemuImg,
-		downloader: downloader.NewDownloader(downloadCacheDir),
-		tracer:     tracer,
+		configDir:      configDir,
+		runtimeDir:     runtimeDir,
+		qemuBin:        qemuBin,
+		qemuImg:        qemuImg,
+		downloader:     downloader.NewDownloader(downloadCacheDir),
+		tracer:         tracer,
+		qmpWaitTimeout: defaultQMPWaitTimeout,
 	}
 }
 
+// SetPowerdownFunc sets the function builders use to gracefully stop a build VM
+func (m *Manager) SetPowerdownFunc(powerdown PowerdownFunc) {
+	m.powerdown = powerdown
+}
+
+// SetDownloadLimit caps base image and source downloads to bytesPerSecond, 0 means unlimited
+func (m *Manager) SetDownloadLimit(bytesPerSecond int64) {
+	m.downloader.SetBandwidthLimit(bytesPerSecond)
+}
+
+// SetDownloadHeadCheck makes downloads check the URL with a HEAD request first
+func (m *Manager) SetDownloadHeadCheck(enabled bool) {
+	m.downloader.SetHeadCheck(enabled)
+}
+
+// SetQMPWaitTimeout sets how long builders wait for a build VM's QMP socket after start
+func (m *Manager) SetQMPWaitTimeout(timeout time.Duration) {
+	m.qmpWaitTimeout = timeout
+}
+
+// SetNoCache makes builds re-download base images and sources instead of using the download cache
+func (m *Manager) SetNoCache(noCache bool) {
+	m.noCache = noCache
+}
+
+// SetParallelDownloads sets how many sources cloud-init builds download at once,
+// overriding the images' parallel_downloads. Zero keeps the configured values.
+func (m *Manager) SetParallelDownloads(n int) {
+	m.parallelDownloads = n
+}
+
 // CreateBuilder creates an appropriate image builder based on the configuration
 func (m *Manager) CreateBuilder(config *ImageConfig, imgName string) (ImageBuilder, error) {
 	// Determine state directory
@@ -44,24 +84,184 @@ func (m *Manager) CreateBuilder(config *ImageConfig, imgName string) (ImageBuild
 		return NewRawImageBuilder(config, stateDir, m.qemuBin, m.qemuImg, m.tracer), nil
 	case "cloud-init":
 		templateProcessor := NewTemplateProcessor(m.configDir)
-		return NewCloudInitImageBuilder(config, stateDir, m.qemuBin, m.qemuImg, m.downloader, templateProcessor, m.tracer), nil
+		builder := NewCloudInitImageBuilder(config, stateDir, m.qemuBin, m.qemuImg, m.downloader, templateProcessor, m.tracer)
+		builder.SetPowerdownFunc(m.powerdown)
+		builder.SetQMPWaitTimeout(m.qmpWaitTimeout)
+		builder.SetNoCache(m.noCache)
+		builder.SetParallelDownloads(m.parallelDownloads)
+		return builder, nil
 	default:
 		return nil, fmt.Errorf("unknown builder type: %s", config.Builder)
 	}
 }
 
 // BuildImage builds a specific image
-func (m *Manager) BuildImage(ctx context.Context, imgName string, config *ImageConfig) error {
+func (m *Manager) BuildImage(ctx context.Context, imgName string, config *ImageConfig) (*BuildResult, error) {
+	builder, err := m.CreateBuilder(config, imgName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create builder: %w", err)
+	}
+
+	result := &BuildResult{}
+	err = builder.Build(ctx)
+	result.ImagePath = builder.GetImagePath()
+	if cloudInit, ok := builder.(*CloudInitImageBuilder); ok {
+		result.Timings = cloudInit.Timings()
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// Publish the final image at its configured stable location
+	if config.Output != "" {
+		result.ImagePath = m.outputPath(config)
+		if err := m.exportIfNeeded(builder, result.ImagePath); err != nil {
+			return result, err
+		}
+	}
+
+	// An up to date image was already handed to the hook by the build that made it
+	if builder.Rebuilt() {
+		return result, m.runPostBuildHook(config, builder, result.ImagePath)
+	}
+	return result, nil
+}
+
+// runPostBuildHook runs the image's post_build hook, if one is configured, with
+// the final image path and the recorded manifest. A failing hook fails the build.
+func (m *Manager) runPostBuildHook(config *ImageConfig, builder ImageBuilder, imagePath string) error {
+	hook := config.PostBuild
+	if hook == nil {
+		return nil
+	}
+	manifest, err := builder.RecordedManifest()
+	if err != nil {
+		return fmt.Errorf("failed to read build manifest: %w", err)
+	}
+
+	m.tracer.Trace("post-build", "Executing post_build hook", "script", hook.Script, "image", imagePath)
+	output, err := NewEnvHookExecutor().ExecutePostBuild(hook, m.configDir, PostBuildInput{
+		Image:    imagePath,
+		Manifest: manifest,
+	})
+	if output != "" {
+		m.tracer.Trace("post-build", "post_build hook output", "output", strings.TrimSpace(output))
+	}
+	return err
+}
+
+// ImageStatus describes the build state of an image
+type ImageStatus struct {
+	Name     string            `json:"name"`
+	Builder  string            `json:"builder"`
+	ImgSize  string            `json:"img_size"`
+	Built    bool              `json:"built"`
+	Path     string            `json:"path,omitempty"`
+	StateDir string            `json:"state_dir,omitempty"`
+	Manifest map[string]string `json:"manifest,omitempty"` // Input hashes recorded by the last build
+	Error    string            `json:"error,omitempty"`
+}
+
+// GetImageStatus reports whether an image is built, where it lives and its
+// recorded manifest. Problems are reported in Error rather than failing.
+func (m *Manager) GetImageStatus(imgName string, config *ImageConfig) ImageStatus {
+	status := ImageStatus{
+		Name:    imgName,
+		Builder: config.Builder,
+		ImgSize: config.ImgSize,
+	}
+
+	builder, err := m.CreateBuilder(config, imgName)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.StateDir = builder.GetStateDir()
+
+	if status.Path, err = m.GetImagePath(imgName, config); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if _, err := os.Stat(status.Path); err == nil {
+		status.Built = true
+	}
+
+	if status.Manifest, err = builder.RecordedManifest(); err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// RenderTemplates renders an image's cloud-init templates into outputDir without building it
+func (m *Manager) RenderTemplates(imgName string, config *ImageConfig, outputDir string) error {
+	builder, err := m.CreateBuilder(config, imgName)
+	if err != nil {
+		return fmt.Errorf("failed to create builder: %w", err)
+	}
+
+	cloudInit, ok := builder.(*CloudInitImageBuilder)
+	if !ok {
+		return fmt.Errorf("image '%s' uses the '%s' builder, which has no templates", imgName, config.Builder)
+	}
+	return cloudInit.RenderTemplates(outputDir)
+}
+
+// PruneStages removes the intermediate build files of a built image and returns their paths
+func (m *Manager) PruneStages(imgName string, config *ImageConfig) ([]string, error) {
+	builder, err := m.CreateBuilder(config, imgName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create builder: %w", err)
+	}
+
+	cloudInit, ok := builder.(*CloudInitImageBuilder)
+	if !ok {
+		return nil, fmt.Errorf("image '%s' uses the '%s' builder, which has no intermediate stages", imgName, config.Builder)
+	}
+	return cloudInit.PruneStages()
+}
+
+// ExportImage writes a standalone copy of a built image to dst
+func (m *Manager) ExportImage(imgName string, config *ImageConfig, dst string) error {
 	builder, err := m.CreateBuilder(config, imgName)
 	if err != nil {
 		return fmt.Errorf("failed to create builder: %w", err)
 	}
 
-	return builder.Build(ctx)
+	return m.exportIfNeeded(builder, dst)
+}
+
+// exportIfNeeded exports the built image unless dst is already up to date
+func (m *Manager) exportIfNeeded(builder ImageBuilder, dst string) error {
+	if !exportNeeded(builder.GetImagePath(), dst) {
+		m.tracer.Trace("export", "Exported image is up to date", "path", dst)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := builder.Export(dst); err != nil {
+		return fmt.Errorf("failed to export image to %s: %w", dst, err)
+	}
+	m.tracer.Trace("export", "Exported image", "path", dst)
+	return nil
 }
 
-// GetImagePath returns the path to a built image
+// outputPath resolves an image's `output` field against the config directory
+func (m *Manager) outputPath(config *ImageConfig) string {
+	if filepath.IsAbs(config.Output) {
+		return config.Output
+	}
+	return filepath.Join(m.configDir, config.Output)
+}
+
+// GetImagePath returns the path to a built image, which is the stable
+// `output` path when one is configured
 func (m *Manager) GetImagePath(imgName string, config *ImageConfig) (string, error) {
+	if config.Output != "" {
+		return m.outputPath(config), nil
+	}
+
 	builder, err := m.CreateBuilder(config, imgName)
 	if err != nil {
 		return "", fmt.Errorf("failed to create builder: %w", err)
@@ -0,0 +1,278 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package img
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"qqmgr/internal/trace"
+)
+
+func TestManagerImageOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "project")
+	runtimeDir := filepath.Join(configDir, ".qqmgr", "qqmgr.toml")
+
+	// Mock qemu-img writes recognizable content for 'create'
+	mockQemuImg := filepath.Join(tempDir, "mock-qemu-img")
+	script := `#!/bin/sh
+if [ "$1" = "create" ]; then
+    printf 'raw-image-data' > "$4"
+fi
+`
+	if err := os.WriteFile(mockQemuImg, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to create mock qemu-img: %v", err)
+	}
+
+	manager := NewManager(configDir, runtimeDir, "qemu-system-x86_64", mockQemuImg, trace.NewNoOpTracer())
+
+	config := &ImageConfig{Builder: "raw", ImgSize: "1G"}
+	statePath, err := manager.GetImagePath("disk", config)
+	if err != nil {
+		t.Fatalf("GetImagePath() error: %v", err)
+	}
+	if statePath != filepath.Join(runtimeDir, "img.disk", "image.img") {
+		t.Errorf("GetImagePath() without output = %s, want path in state dir", statePath)
+	}
+
+	config.Output = "images/disk.img"
+	wantOutput := filepath.Join(configDir, "images", "disk.img")
+	outputPath, err := manager.GetImagePath("disk", config)
+	if err != nil {
+		t.Fatalf("GetImagePath() error: %v", err)
+	}
+	if outputPath != wantOutput {
+		t.Errorf("GetImagePath() with output = %s, want %s", outputPath, wantOutput)
+	}
+
+	if _, err := manager.BuildImage(context.Background(), "disk", config); err != nil {
+		t.Fatalf("BuildImage() error: %v", err)
+	}
+
+	data, err := os.ReadFile(wantOutput)
+	if err != nil {
+		t.Fatalf("Expected image to be copied to output path: %v", err)
+	}
+	if string(data) != "raw-image-data" {
+		t.Errorf("Output image content = %q, want %q", data, "raw-image-data")
+	}
+
+	// Explicit export, as used by --output-dir
+	exportPath := filepath.Join(tempDir, "export", "disk.img")
+	if err := manager.ExportImage("disk", config, exportPath); err != nil {
+		t.Fatalf("ExportImage() error: %v", err)
+	}
+	if _, err := os.Stat(exportPath); err != nil {
+		t.Errorf("Expected exported image at %s: %v", exportPath, err)
+	}
+}
+
+func TestManagerGetImageStatus(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "project")
+	runtimeDir := filepath.Join(configDir, ".qqmgr", "qqmgr.toml")
+
+	mockQemuImg := filepath.Join(tempDir, "mock-qemu-img")
+	script := `#!/bin/sh
+if [ "$1" = "create" ]; then
+    printf 'raw-image-data' > "$4"
+fi
+`
+	if err := os.WriteFile(mockQemuImg, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to create mock qemu-img: %v", err)
+	}
+
+	manager := NewManager(configDir, runtimeDir, "qemu-system-x86_64", mockQemuImg, trace.NewNoOpTracer())
+	config := &ImageConfig{Builder: "raw", ImgSize: "1G"}
+
+	status := manager.GetImageStatus("disk", config)
+	if status.Built || status.Manifest != nil || status.Error != "" {
+		t.Errorf("GetImageStatus() before build = %+v, want unbuilt without manifest or error", status)
+	}
+
+	if _, err := manager.BuildImage(context.Background(), "disk", config); err != nil {
+		t.Fatalf("BuildImage() error: %v", err)
+	}
+
+	status = manager.GetImageStatus("disk", config)
+	if !status.Built {
+		t.Errorf("GetImageStatus() after build reports unbuilt: %+v", status)
+	}
+	if status.Path != filepath.Join(runtimeDir, "img.disk", "image.img") {
+		t.Errorf("GetImageStatus() path = %s", status.Path)
+	}
+	if status.Manifest["builder"] != "raw" || status.Manifest["img_size"] != "1G" {
+		t.Errorf("GetImageStatus() manifest = %v, want recorded raw manifest", status.Manifest)
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Failed to marshal status: %v", err)
+	}
+	var shape map[string]interface{}
+	if err := json.Unmarshal(data, &shape); err != nil {
+		t.Fatalf("Failed to unmarshal status: %v", err)
+	}
+	for _, key := range []string{"name", "builder", "img_size", "built", "path", "state_dir", "manifest"} {
+		if _, ok := shape[key]; !ok {
+			t.Errorf("JSON status is missing %q: %s", key, data)
+		}
+	}
+	if _, ok := shape["error"]; ok {
+		t.Errorf("JSON status should omit empty error: %s", data)
+	}
+
+	// An unknown builder still yields a status entry
+	status = manager.GetImageStatus("broken", &ImageConfig{Builder: "unknown"})
+	if status.Error == "" || status.Name != "broken" {
+		t.Errorf("GetImageStatus() for broken image = %+v, want error entry", status)
+	}
+}
+
+func TestManagerPruneStages(t *testing.T) {
+	runtimeDir := t.TempDir()
+	manager := NewManager(t.TempDir(), runtimeDir, "qemu-system-x86_64", "qemu-img", trace.NewNoOpTracer())
+
+	config := &ImageConfig{
+		Builder: "cloud-init",
+		ImgSize: "10G",
+		BaseImg: &BaseImageConfig{URL: "https://example.com/base.qcow2", SHA256Sum: "abc123"},
+	}
+
+	// Pruning an image which was never built fails and removes nothing
+	if _, err := manager.PruneStages("test", config); err == nil {
+		t.Fatal("PruneStages() on an unbuilt image should fail")
+	}
+
+	// Lay out the state of a completed build
+	stateDir := filepath.Join(runtimeDir, "img.test")
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		t.Fatalf("Failed to create state dir: %v", err)
+	}
+	files := map[string]string{
+		"stage1.img":           "base",
+		"stage1.img.checksum":  "abc123",
+		"stage2.img":           "resized base",
+		"stage3.img":           "overlay",
+		"cloud-init.iso":       "iso",
+		"stage2.manifest.json": `{"base_img_hash": "abc123", "img_size": "10G"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(stateDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	removed, err := manager.PruneStages("test", config)
+	if err != nil {
+		t.Fatalf("PruneStages() error: %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("Expected 2 removed files, got %v", removed)
+	}
+
+	for _, name := range []string{"stage1.img", "cloud-init.iso"} {
+		if _, err := os.Stat(filepath.Join(stateDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	// The final image, its backing file and the manifests remain
+	for _, name := range []string{"stage2.img", "stage3.img", "stage1.img.checksum", "stage2.manifest.json"} {
+		if _, err := os.Stat(filepath.Join(stateDir, name)); err != nil {
+			t.Errorf("Expected %s to remain: %v", name, err)
+		}
+	}
+	if status := manager.GetImageStatus("test", config); !status.Built {
+		t.Errorf("Expected image to still be reported as built, got %+v", status)
+	}
+
+	// The ISO hash is kept so the customization stage stays up to date
+	data, err := os.ReadFile(filepath.Join(stateDir, prunedISOHashFile))
+	if err != nil {
+		t.Fatalf("Expected the pruned ISO hash to be recorded: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("iso"))); string(data) != want {
+		t.Errorf("Recorded ISO hash = %s, want %s", data, want)
+	}
+
+	// Raw images have no stages to prune
+	if _, err := manager.PruneStages("disk", &ImageConfig{Builder: "raw", ImgSize: "1G"}); err == nil {
+		t.Error("PruneStages() on a raw image should fail")
+	}
+}
+
+// TestManagerPostBuildHook tests that post_build runs with the output path and
+// manifest once the image was rebuilt, and that a failing hook fails the build
+func TestManagerPostBuildHook(t *testing.T) {
+	tempDir := t.TempDir()
+	configDir := filepath.Join(tempDir, "project")
+	runtimeDir := filepath.Join(configDir, ".qqmgr", "qqmgr.toml")
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+
+	mockQemuImg := filepath.Join(tempDir, "mock-qemu-img")
+	if err := os.WriteFile(mockQemuImg, []byte("#!/bin/sh\ntouch \"$4\"\n"), 0755); err != nil {
+		t.Fatalf("Failed to create mock qemu-img: %v", err)
+	}
+
+	// The hook records its input next to the config file
+	inputPath := filepath.Join(configDir, "hook-input.json")
+	hook := fmt.Sprintf("cat > %s\n", inputPath)
+	if err := os.WriteFile(filepath.Join(configDir, "post-build.sh"), []byte(hook), 0644); err != nil {
+		t.Fatalf("Failed to write hook: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "fail.sh"), []byte("echo 'registry unreachable' >&2\nexit 3\n"), 0644); err != nil {
+		t.Fatalf("Failed to write hook: %v", err)
+	}
+
+	manager := NewManager(configDir, runtimeDir, "qemu-system-x86_64", mockQemuImg, trace.NewNoOpTracer())
+	config := &ImageConfig{
+		Builder:   "raw",
+		ImgSize:   "1G",
+		Output:    "images/disk.img",
+		PostBuild: &EnvHookConfig{Interpreter: "sh", Script: "post-build.sh"},
+	}
+	result, err := manager.BuildImage(context.Background(), "disk", config)
+	if err != nil {
+		t.Fatalf("BuildImage() error: %v", err)
+	}
+
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		t.Fatalf("Expected the hook to run: %v", err)
+	}
+	var input PostBuildInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		t.Fatalf("Hook input is not valid JSON: %v", err)
+	}
+	if want := filepath.Join(configDir, "images", "disk.img"); input.Image != want || result.ImagePath != want {
+		t.Errorf("Hook got image %s, want the exported %s", input.Image, want)
+	}
+	if input.Manifest["builder"] != "raw" || input.Manifest["img_size"] != "1G" {
+		t.Errorf("Hook got unexpected manifest %v", input.Manifest)
+	}
+
+	// An up to date image is not handed to the hook again
+	os.Remove(inputPath)
+	if _, err := manager.BuildImage(context.Background(), "disk", config); err != nil {
+		t.Fatalf("BuildImage() error: %v", err)
+	}
+	if _, err := os.Stat(inputPath); err == nil {
+		t.Error("Expected the hook not to run for an up to date image")
+	}
+
+	config.ImgSize = "2G"
+	config.PostBuild = &EnvHookConfig{Interpreter: "sh", Script: "fail.sh"}
+	_, err = manager.BuildImage(context.Background(), "disk", config)
+	if err == nil || !strings.Contains(err.Error(), "registry unreachable") {
+		t.Errorf("Expected the failing hook to fail the build, got %v", err)
+	}
+}
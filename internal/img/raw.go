@@ -41,19 +41,17 @@ func (r *RawImageBuilder) Build(ctx context.Context) error {
 		return fmt.Errorf("failed to check manifest: %w", err)
 	}
 
-	if !changed {
-		// Image is up to date
-		return nil
-	}
-
-	// Create the raw image
-	if err := r.createRawImage(); err != nil {
-		return fmt.Errorf("failed to create raw image: %w", err)
-	}
+	// Only (re)create the image if it is not up to date
+	r.rebuilt = false
+	if changed {
+		if err := r.createRawImage(); err != nil {
+			return fmt.Errorf("failed to create raw image: %w", err)
+		}
+		r.rebuilt = true
 
-	// Save the manifest
-	if err := r.saveManifest(manifest); err != nil {
-		return fmt.Errorf("failed to save manifest: %w", err)
+		if err := r.saveManifest(manifest); err != nil {
+			return fmt.Errorf("failed to save manifest: %w", err)
+		}
 	}
 
 	return nil
@@ -64,6 +62,16 @@ func (r *RawImageBuilder) GetImagePath() string {
 	return filepath.Join(r.stateDir, "image.img")
 }
 
+// Export copies the raw image to dst
+func (r *RawImageBuilder) Export(dst string) error {
+	return copyImageFile(r.GetImagePath(), dst)
+}
+
+// RecordedManifest returns the manifest saved by the last build, nil if never built
+func (r *RawImageBuilder) RecordedManifest() (map[string]string, error) {
+	return r.loadManifest()
+}
+
 // GetManifest returns the current manifest for this image
 func (r *RawImageBuilder) GetManifest() (map[string]string, error) {
 	return r.calculateManifest()
@@ -0,0 +1,340 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QGAClient talks to the QEMU guest agent over the host side of its
+// virtio-serial channel. The protocol is QMP's JSON without the greeting,
+// capabilities negotiation and events.
+type QGAClient struct {
+	socketPath string
+	conn       net.Conn
+	reader     *bufio.Reader
+	mu         sync.Mutex
+	logger     Logger
+}
+
+// NewQGAClient creates a new guest agent client
+func NewQGAClient(socketPath string) *QGAClient {
+	return &QGAClient{
+		socketPath: socketPath,
+		logger:     &DefaultLogger{},
+	}
+}
+
+// Connect connects to the agent socket and synchronizes with the agent. The
+// socket is served by QEMU, so it accepts connections whether or not the agent
+// runs in the guest; a guest without agent fails the sync once ctx is done.
+func (q *QGAClient) Connect(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.conn != nil {
+		return nil
+	}
+
+	if _, err := os.Stat(q.socketPath); os.IsNotExist(err) {
+		return fmt.Errorf("guest agent socket at %s not found, is QEMU running?", q.socketPath)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", q.socketPath)
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("you lack permissions to talk over socket %s", q.socketPath)
+		}
+		return fmt.Errorf("failed to connect to guest agent socket: %w", err)
+	}
+	q.conn = conn
+	q.reader = bufio.NewReader(conn)
+
+	if err := q.sync(ctx); err != nil {
+		q.closeConnection()
+		return fmt.Errorf("guest agent not responding: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection to the agent
+func (q *QGAClient) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closeConnection()
+}
+
+func (q *QGAClient) closeConnection() error {
+	if q.conn == nil {
+		return nil
+	}
+	err := q.conn.Close()
+	q.conn = nil
+	q.reader = nil
+	return err
+}
+
+// sync sends guest-sync with a fresh id and discards everything read before
+// the matching answer, e.g. responses to commands of an earlier client which
+// the agent only answered after it went away
+func (q *QGAClient) sync(ctx context.Context) error {
+	id := time.Now().UnixNano() & 0x7fffffff
+	cmd := map[string]interface{}{
+		"execute":   "guest-sync",
+		"arguments": map[string]interface{}{"id": id},
+	}
+	for {
+		response, err := q.roundTrip(ctx, cmd)
+		if err != nil {
+			return err
+		}
+		cmd = nil
+
+		var got int64
+		if json.Unmarshal(response.Return, &got) == nil && got == id {
+			return nil
+		}
+		q.logger.Debug("discarding stale guest agent response: %s", formatJSON(response))
+	}
+}
+
+// roundTrip writes cmd, unless it is nil, and reads the next response
+func (q *QGAClient) roundTrip(ctx context.Context, cmd map[string]interface{}) (*QMPResponse, error) {
+	if q.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		q.conn.SetDeadline(deadline)
+		defer q.conn.SetDeadline(time.Time{})
+	}
+
+	if cmd != nil {
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal command: %w", err)
+		}
+		q.logger.Debug("QGA SEND:\n%s", formatJSON(cmd))
+		if _, err := q.conn.Write(append(data, '\n')); err != nil {
+			return nil, fmt.Errorf("failed to write to guest agent socket: %w", err)
+		}
+	}
+
+	line, err := q.reader.ReadString('\n')
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to read from guest agent socket: %w", err)
+	}
+
+	var response QMPResponse
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &response); err != nil {
+		return nil, fmt.Errorf("failed to parse guest agent response: %w", err)
+	}
+	return &response, nil
+}
+
+// SendCommand sends a command to the guest agent and returns its response
+func (q *QGAClient) SendCommand(ctx context.Context, cmd map[string]interface{}) (*QMPResponse, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.roundTrip(ctx, cmd)
+}
+
+// SetTime sets the guest's clock to t and lets the guest write it to its RTC
+func (q *QGAClient) SetTime(ctx context.Context, t time.Time) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "guest-set-time",
+		"arguments": map[string]interface{}{"time": t.UnixNano()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed guest-set-time: %w", err)
+	}
+
+	if err := commandError("guest-set-time", response); err != nil {
+		q.logger.Error("error while sending guest agent command 'guest-set-time':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// Ping checks that the guest agent is alive
+func (q *QGAClient) Ping(ctx context.Context) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "guest-ping",
+	})
+	if err != nil {
+		return fmt.Errorf("failed guest-ping: %w", err)
+	}
+
+	if err := commandError("guest-ping", response); err != nil {
+		q.logger.Error("error while sending guest agent command 'guest-ping':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// GuestAgentInfo is the result of guest-info
+type GuestAgentInfo struct {
+	Version           string `json:"version"`
+	SupportedCommands []struct {
+		Name            string `json:"name"`
+		Enabled         bool   `json:"enabled"`
+		SuccessResponse bool   `json:"success-response"`
+	} `json:"supported_commands"`
+}
+
+// Info returns the agent's version and the commands it supports
+func (q *QGAClient) Info(ctx context.Context) (*GuestAgentInfo, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "guest-info",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed guest-info: %w", err)
+	}
+
+	if err := commandError("guest-info", response); err != nil {
+		q.logger.Error("error while sending guest agent command 'guest-info':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var info GuestAgentInfo
+	if err := json.Unmarshal(response.Return, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse guest-info response: %w", err)
+	}
+	return &info, nil
+}
+
+// GuestExecStatus is the result of guest-exec-status, output is only present
+// once the process exited and if it was started with captured output
+type GuestExecStatus struct {
+	Exited       bool   `json:"exited"`
+	ExitCode     *int   `json:"exitcode,omitempty"`
+	Signal       *int   `json:"signal,omitempty"`
+	OutData      []byte `json:"out-data,omitempty"` // base64 on the wire, decoded by encoding/json
+	ErrData      []byte `json:"err-data,omitempty"`
+	OutTruncated bool   `json:"out-truncated,omitempty"`
+	ErrTruncated bool   `json:"err-truncated,omitempty"`
+}
+
+// Exec starts path with args in the guest and returns its PID. With
+// captureOutput, stdout and stderr are returned by ExecStatus once it exited.
+func (q *QGAClient) Exec(ctx context.Context, path string, args []string, captureOutput bool) (int64, error) {
+	arguments := map[string]interface{}{
+		"path":           path,
+		"capture-output": captureOutput,
+	}
+	if len(args) > 0 {
+		arguments["arg"] = args
+	}
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "guest-exec",
+		"arguments": arguments,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed guest-exec: %w", err)
+	}
+
+	if err := commandError("guest-exec", response); err != nil {
+		q.logger.Error("error while sending guest agent command 'guest-exec':\n%s", formatJSON(response))
+		return 0, err
+	}
+
+	var result struct {
+		PID int64 `json:"pid"`
+	}
+	if err := json.Unmarshal(response.Return, &result); err != nil {
+		return 0, fmt.Errorf("failed to parse guest-exec response: %w", err)
+	}
+	return result.PID, nil
+}
+
+// ExecStatus returns the status of a process started by Exec
+func (q *QGAClient) ExecStatus(ctx context.Context, pid int64) (*GuestExecStatus, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "guest-exec-status",
+		"arguments": map[string]interface{}{"pid": pid},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed guest-exec-status: %w", err)
+	}
+
+	if err := commandError("guest-exec-status", response); err != nil {
+		q.logger.Error("error while sending guest agent command 'guest-exec-status':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var status GuestExecStatus
+	if err := json.Unmarshal(response.Return, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse guest-exec-status response: %w", err)
+	}
+	return &status, nil
+}
+
+// Run starts path with args in the guest, capturing its output, and polls
+// every checkInterval until it exited
+func (q *QGAClient) Run(ctx context.Context, path string, args []string, checkInterval time.Duration) (*GuestExecStatus, error) {
+	pid, err := q.Exec(ctx, path, args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		status, err := q.ExecStatus(ctx, pid)
+		if err != nil {
+			return nil, err
+		}
+		if status.Exited {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// FsFreeze freezes the guest's filesystems and returns how many were frozen.
+// They stay frozen until FsThaw, so callers should thaw in a defer.
+func (q *QGAClient) FsFreeze(ctx context.Context) (int, error) {
+	return q.fsfreeze(ctx, "guest-fsfreeze-freeze")
+}
+
+// FsThaw thaws the guest's filesystems and returns how many were thawed
+func (q *QGAClient) FsThaw(ctx context.Context) (int, error) {
+	return q.fsfreeze(ctx, "guest-fsfreeze-thaw")
+}
+
+// fsfreeze sends command, which returns the number of affected filesystems
+func (q *QGAClient) fsfreeze(ctx context.Context, command string) (int, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": command,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed %s: %w", command, err)
+	}
+
+	if err := commandError(command, response); err != nil {
+		q.logger.Error("error while sending guest agent command '%s':\n%s", command, formatJSON(response))
+		return 0, err
+	}
+
+	var count int
+	if err := json.Unmarshal(response.Return, &count); err != nil {
+		return 0, fmt.Errorf("failed to parse %s response: %w", command, err)
+	}
+	return count, nil
+}
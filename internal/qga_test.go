@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockQGAServer answers guest agent commands on a unix socket, recording them
+type mockQGAServer struct {
+	mu       sync.Mutex
+	commands []map[string]interface{}
+	silent   bool // Accept connections but never answer, like QEMU without an agent in the guest
+
+	execPolls int
+}
+
+func newMockQGAServer(t *testing.T) (*mockQGAServer, string) {
+	socketPath := filepath.Join(t.TempDir(), "qga.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create guest agent socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &mockQGAServer{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, socketPath
+}
+
+func (s *mockQGAServer) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		// Keep nanosecond timestamps exact
+		var cmd map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&cmd); err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		silent := s.silent
+		s.mu.Unlock()
+		if silent {
+			continue
+		}
+
+		args, _ := cmd["arguments"].(map[string]interface{})
+		switch cmd["execute"] {
+		case "guest-sync":
+			// A response left over from an earlier client comes first
+			fmt.Fprintln(conn, `{"return":{}}`)
+			fmt.Fprintf(conn, `{"return":%s}`+"\n", args["id"])
+		case "guest-set-time", "guest-ping":
+			fmt.Fprintln(conn, `{"return":{}}`)
+		case "guest-info":
+			fmt.Fprintln(conn, `{"return":{"version":"8.2.2","supported_commands":[{"enabled":true,"name":"guest-exec","success-response":true},{"enabled":false,"name":"guest-file-open","success-response":true}]}}`)
+		case "guest-exec":
+			fmt.Fprintln(conn, `{"return":{"pid":4711}}`)
+		case "guest-exec-status":
+			// The process runs on the first poll and has exited on the next
+			s.mu.Lock()
+			s.execPolls++
+			polls := s.execPolls
+			s.mu.Unlock()
+			if polls < 2 {
+				fmt.Fprintln(conn, `{"return":{"exited":false}}`)
+			} else {
+				fmt.Fprintln(conn, `{"return":{"exited":true,"exitcode":3,"out-data":"aGVsbG8K","err-data":"b29wcwo="}}`)
+			}
+		default:
+			fmt.Fprintf(conn, `{"error":{"class":"CommandNotFound","desc":"Command %s has not been found"}}`+"\n", cmd["execute"])
+		}
+	}
+}
+
+func (s *mockQGAServer) lastCommand() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.commands) == 0 {
+		return nil
+	}
+	return s.commands[len(s.commands)-1]
+}
+
+func TestQGAClientSetTime(t *testing.T) {
+	server, socketPath := newMockQGAServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewQGAClient(socketPath)
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	defer client.Close()
+
+	now := time.Unix(1700000000, 123456789)
+	if err := client.SetTime(ctx, now); err != nil {
+		t.Fatalf("SetTime() failed: %v", err)
+	}
+
+	sent := server.lastCommand()
+	args, _ := sent["arguments"].(map[string]interface{})
+	if sent["execute"] != "guest-set-time" || args["time"] != json.Number(fmt.Sprint(now.UnixNano())) {
+		t.Errorf("Expected guest-set-time with time %d, sent %v", now.UnixNano(), sent)
+	}
+}
+
+func TestQGAClientNoAgent(t *testing.T) {
+	server, socketPath := newMockQGAServer(t)
+	server.silent = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	client := NewQGAClient(socketPath)
+	if err := client.Connect(ctx); err == nil {
+		client.Close()
+		t.Fatal("Expected Connect to fail without an agent answering")
+	}
+
+	if err := NewQGAClient(filepath.Join(t.TempDir(), "missing.sock")).Connect(context.Background()); err == nil {
+		t.Error("Expected Connect to fail without a socket")
+	}
+}
+
+func TestQGAClientCommands(t *testing.T) {
+	server, socketPath := newMockQGAServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewQGAClient(socketPath)
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Ping(ctx); err != nil {
+		t.Errorf("Ping() failed: %v", err)
+	}
+
+	info, err := client.Info(ctx)
+	if err != nil {
+		t.Fatalf("Info() failed: %v", err)
+	}
+	if info.Version != "8.2.2" || len(info.SupportedCommands) != 2 || info.SupportedCommands[1].Enabled {
+		t.Errorf("Unexpected guest-info result: %+v", info)
+	}
+
+	status, err := client.Run(ctx, "/bin/sh", []string{"-c", "echo hello; echo oops >&2; exit 3"}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if status.ExitCode == nil || *status.ExitCode != 3 || string(status.OutData) != "hello\n" || string(status.ErrData) != "oops\n" {
+		t.Errorf("Unexpected exec status: %+v", status)
+	}
+
+	// guest-exec is sent with the arguments and output capture, then polled by pid
+	var execCmd, statusCmd map[string]interface{}
+	server.mu.Lock()
+	for _, cmd := range server.commands {
+		switch cmd["execute"] {
+		case "guest-exec":
+			execCmd = cmd
+		case "guest-exec-status":
+			statusCmd = cmd
+		}
+	}
+	polls := server.execPolls
+	server.mu.Unlock()
+
+	execArgs, _ := execCmd["arguments"].(map[string]interface{})
+	if execArgs["path"] != "/bin/sh" || execArgs["capture-output"] != true || fmt.Sprint(execArgs["arg"]) != "[-c echo hello; echo oops >&2; exit 3]" {
+		t.Errorf("Unexpected guest-exec payload: %v", execCmd)
+	}
+	statusArgs, _ := statusCmd["arguments"].(map[string]interface{})
+	if statusArgs["pid"] != json.Number("4711") || polls != 2 {
+		t.Errorf("Expected two guest-exec-status polls of pid 4711, got %d with %v", polls, statusCmd)
+	}
+}
@@ -4,14 +4,18 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -20,6 +24,7 @@ type QMPResponse struct {
 	Return json.RawMessage `json:"return,omitempty"`
 	Error  *QMPError       `json:"error,omitempty"`
 	Event  *QMPEvent       `json:"event,omitempty"`
+	ID     json.RawMessage `json:"id,omitempty"` // Echo of the command's id, if it had one
 }
 
 // QMPError represents an error response from QMP
@@ -28,6 +33,42 @@ type QMPError struct {
 	Desc  string `json:"desc"`
 }
 
+// QMPCommandError is returned when QEMU answers a command with an error,
+// use errors.As to inspect the class or errors.Is against ErrCommandNotFound etc.
+type QMPCommandError struct {
+	QMPError
+	Command string
+}
+
+func (e *QMPCommandError) Error() string {
+	return fmt.Sprintf("error while sending QMP command '%s': %s", e.Command, e.Desc)
+}
+
+// Is matches a target QMPCommandError by class and, if set on the target, command
+func (e *QMPCommandError) Is(target error) bool {
+	t, ok := target.(*QMPCommandError)
+	if !ok {
+		return false
+	}
+	return (t.Class == "" || t.Class == e.Class) && (t.Command == "" || t.Command == e.Command)
+}
+
+// Errors matching any failed command of the given QMP error class
+var (
+	ErrCommandNotFound = &QMPCommandError{QMPError: QMPError{Class: "CommandNotFound"}}
+	ErrDeviceNotFound  = &QMPCommandError{QMPError: QMPError{Class: "DeviceNotFound"}}
+	ErrDeviceNotActive = &QMPCommandError{QMPError: QMPError{Class: "DeviceNotActive"}}
+	ErrKVMMissingCap   = &QMPCommandError{QMPError: QMPError{Class: "KVMMissingCap"}}
+)
+
+// commandError returns the response's error as a QMPCommandError, or nil
+func commandError(command string, response *QMPResponse) error {
+	if response.Error == nil {
+		return nil
+	}
+	return &QMPCommandError{QMPError: *response.Error, Command: command}
+}
+
 // QMPEvent represents an event from QMP
 type QMPEvent struct {
 	Event string                 `json:"event"`
@@ -51,6 +92,21 @@ type QMPClient struct {
 	events     []QMPEvent
 	eventsMu   sync.RWMutex
 	logger     Logger
+	// capabilities negotiated with the server during Connect
+	capabilities []string
+	// commands supported by the server, fetched once per connection by SupportsCommand
+	commands map[string]bool
+	// batches counts SendBatch calls, to give each batch's commands unique ids
+	batches int
+	// wireLogPath, if set, receives every raw line sent to and read from the socket
+	wireLogPath string
+	wireLog     *os.File
+	// statusTTL, if non-zero, lets CheckStatus reuse a query-status result
+	// younger than this instead of asking QEMU again
+	statusTTL    time.Duration
+	statusMu     sync.Mutex
+	cachedStatus map[string]interface{}
+	cachedAt     time.Time
 }
 
 // Logger interface for dependency injection and testing
@@ -83,6 +139,57 @@ func NewQMPClientWithLogger(socketPath string, logger Logger) *QMPClient {
 	}
 }
 
+// NewQMPClientWithWireLog creates a new QMP client which appends all raw
+// protocol traffic to the file at logPath
+func NewQMPClientWithWireLog(socketPath, logPath string) *QMPClient {
+	return &QMPClient{
+		socketPath:  socketPath,
+		logger:      &DefaultLogger{},
+		wireLogPath: logPath,
+	}
+}
+
+// SetStatusTTL makes CheckStatus, and thereby QueryStatus and IsRunning, reuse
+// the last query-status result for up to ttl. Sending any command other than
+// a query drops the cached result. 0 (the default) disables caching.
+func (q *QMPClient) SetStatusTTL(ttl time.Duration) {
+	q.statusMu.Lock()
+	defer q.statusMu.Unlock()
+	q.statusTTL = ttl
+	q.cachedStatus = nil
+}
+
+// cachedRunState returns the cached query-status result if it is younger than the TTL
+func (q *QMPClient) cachedRunState() (map[string]interface{}, bool) {
+	q.statusMu.Lock()
+	defer q.statusMu.Unlock()
+	if q.statusTTL <= 0 || q.cachedStatus == nil || time.Since(q.cachedAt) >= q.statusTTL {
+		return nil, false
+	}
+	return maps.Clone(q.cachedStatus), true
+}
+
+// cacheRunState stores a query-status result if caching is enabled
+func (q *QMPClient) cacheRunState(status map[string]interface{}) {
+	q.statusMu.Lock()
+	defer q.statusMu.Unlock()
+	if q.statusTTL > 0 {
+		q.cachedStatus = maps.Clone(status)
+		q.cachedAt = time.Now()
+	}
+}
+
+// invalidateRunState drops the cached query-status result unless execute is a
+// query, since any other command may change the run state
+func (q *QMPClient) invalidateRunState(execute string) {
+	if strings.HasPrefix(execute, "query-") {
+		return
+	}
+	q.statusMu.Lock()
+	defer q.statusMu.Unlock()
+	q.cachedStatus = nil
+}
+
 // Connected returns true if the client is connected
 func (q *QMPClient) Connected() bool {
 	q.mu.Lock()
@@ -105,7 +212,8 @@ func (q *QMPClient) Connect(ctx context.Context) error {
 	}
 
 	// Connect to Unix socket
-	conn, err := net.Dial("unix", q.socketPath)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", q.socketPath)
 	if err != nil {
 		if os.IsPermission(err) {
 			return fmt.Errorf("you lack permissions to talk over socket %s", q.socketPath)
@@ -113,28 +221,79 @@ func (q *QMPClient) Connect(ctx context.Context) error {
 		return fmt.Errorf("failed to connect to QMP socket: %w", err)
 	}
 
+	// Bound the handshake by the context, a wedged QEMU may accept but never greet
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
 	q.conn = conn
 	q.reader = bufio.NewReader(conn)
 	q.writer = bufio.NewWriter(conn)
 
+	if q.wireLogPath != "" {
+		wireLog, err := os.OpenFile(q.wireLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			q.closeConnection()
+			return fmt.Errorf("failed to open QMP wire log: %w", err)
+		}
+		q.wireLog = wireLog
+	}
+
 	// Read QMP greeting
-	if err := q.readGreeting(); err != nil {
+	offered, err := q.readGreeting()
+	if err != nil {
 		q.closeConnection()
 		return fmt.Errorf("failed to read QMP greeting: %w", err)
 	}
 
-	// Send qmp_capabilities command
-	_, err = q.sendCommandInternal(ctx, map[string]interface{}{
+	// Send qmp_capabilities command, enabling out-of-band execution if offered
+	capsCmd := map[string]interface{}{
 		"execute": "qmp_capabilities",
-	})
+	}
+	var enabled []string
+	for _, capability := range offered {
+		if capability == "oob" {
+			enabled = append(enabled, capability)
+		}
+	}
+	if len(enabled) > 0 {
+		capsCmd["arguments"] = map[string]interface{}{
+			"enable": enabled,
+		}
+	}
+
+	response, err := q.sendCommandInternal(ctx, capsCmd)
 	if err != nil {
 		q.closeConnection()
 		return fmt.Errorf("failed to send qmp_capabilities: %w", err)
 	}
+	if response.Error != nil {
+		q.closeConnection()
+		return fmt.Errorf("qmp_capabilities rejected: %s", response.Error.Desc)
+	}
+	q.capabilities = enabled
+	conn.SetDeadline(time.Time{})
 
 	return nil
 }
 
+// Capabilities returns the capabilities negotiated with the server
+func (q *QMPClient) Capabilities() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]string{}, q.capabilities...)
+}
+
+// HasCapability returns true if the given capability was negotiated with the server
+func (q *QMPClient) HasCapability(name string) bool {
+	for _, capability := range q.Capabilities() {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Close closes the QMP connection
 func (q *QMPClient) Close() error {
 	q.mu.Lock()
@@ -147,30 +306,166 @@ func (q *QMPClient) closeConnection() error {
 		return nil
 	}
 
+	if q.wireLog != nil {
+		q.wireLog.Close()
+		q.wireLog = nil
+	}
+
 	err := q.conn.Close()
 	q.conn = nil
 	q.reader = nil
 	q.writer = nil
+	q.capabilities = nil
+	q.commands = nil
 	return err
 }
 
-// readGreeting reads the initial QMP greeting
-func (q *QMPClient) readGreeting() error {
+// readGreeting reads the initial QMP greeting and returns the capabilities offered by the server
+func (q *QMPClient) readGreeting() ([]string, error) {
 	line, err := q.reader.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("failed to read greeting: %w", err)
+		return nil, fmt.Errorf("failed to read greeting: %w", err)
 	}
+	q.logWire("<-", line)
 
-	// Parse greeting (we don't need to validate it, just consume it)
-	var greeting map[string]interface{}
+	var greeting struct {
+		QMP struct {
+			Capabilities []string `json:"capabilities"`
+		} `json:"QMP"`
+	}
 	if err := json.Unmarshal([]byte(line), &greeting); err != nil {
-		return fmt.Errorf("failed to parse greeting: %w", err)
+		return nil, fmt.Errorf("failed to parse greeting: %w", err)
 	}
 
 	q.logger.Debug("QMP greeting received: %s", strings.TrimSpace(line))
+	return greeting.QMP.Capabilities, nil
+}
+
+// logWire appends a raw protocol line to the wire log, if one is configured
+func (q *QMPClient) logWire(direction, line string) {
+	if q.wireLog == nil {
+		return
+	}
+	fmt.Fprintf(q.wireLog, "%s %s %s\n", time.Now().Format(time.RFC3339Nano), direction, strings.TrimRight(line, "\r\n"))
+}
+
+// handleEvent buffers the event carried by line and returns it, or returns nil
+// if line is not an event
+func (q *QMPClient) handleEvent(line string) *QMPEvent {
+	var event QMPEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil || event.Event == "" {
+		return nil
+	}
+
+	q.logger.Debug("QMP EVENT:\n%s", formatJSON(event))
+	q.eventsMu.Lock()
+	q.events = append(q.events, event)
+	q.eventsMu.Unlock()
+	return &event
+}
+
+// takeEvent removes and returns the first buffered event with the given name
+func (q *QMPClient) takeEvent(name string) *QMPEvent {
+	q.eventsMu.Lock()
+	defer q.eventsMu.Unlock()
+
+	for i, event := range q.events {
+		if event.Event == name {
+			q.events = append(q.events[:i], q.events[i+1:]...)
+			return &event
+		}
+	}
 	return nil
 }
 
+// readLine reads a line from the server, giving up once ctx is done
+func (q *QMPClient) readLine(ctx context.Context) (string, error) {
+	var deadlineMu sync.Mutex
+	finished := false
+	stop := context.AfterFunc(ctx, func() {
+		deadlineMu.Lock()
+		defer deadlineMu.Unlock()
+		if !finished {
+			q.conn.SetReadDeadline(time.Now())
+		}
+	})
+	defer func() {
+		stop()
+		deadlineMu.Lock()
+		finished = true
+		deadlineMu.Unlock()
+		q.conn.SetReadDeadline(time.Time{})
+	}()
+
+	line, err := q.reader.ReadString('\n')
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if err == io.EOF {
+			return "", fmt.Errorf("connection closed by server")
+		}
+		return "", fmt.Errorf("failed to read from QMP socket: %w", err)
+	}
+	q.logWire("<-", line)
+	return line, nil
+}
+
+// WaitForEvent waits until an event with the given name is received and
+// returns it, removing it from the event buffer
+func (q *QMPClient) WaitForEvent(ctx context.Context, name string) (*QMPEvent, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if event := q.takeEvent(name); event != nil {
+			return event, nil
+		}
+
+		if q.conn == nil || q.reader == nil {
+			return nil, fmt.Errorf("not connected")
+		}
+
+		line, err := q.readLine(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if q.handleEvent(line) == nil {
+			q.logger.Debug("ignoring non-event message while waiting for %s: %s", name, strings.TrimSpace(line))
+		}
+	}
+}
+
+// NextEvent waits for the next event of any kind and returns it, taking
+// buffered events first
+func (q *QMPClient) NextEvent(ctx context.Context) (*QMPEvent, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		q.eventsMu.Lock()
+		if len(q.events) > 0 {
+			event := q.events[0]
+			q.events = q.events[1:]
+			q.eventsMu.Unlock()
+			return &event, nil
+		}
+		q.eventsMu.Unlock()
+
+		if q.conn == nil || q.reader == nil {
+			return nil, fmt.Errorf("not connected")
+		}
+
+		line, err := q.readLine(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if q.handleEvent(line) == nil {
+			q.logger.Debug("ignoring non-event message while waiting for events: %s", strings.TrimSpace(line))
+		}
+	}
+}
+
 // getResponse reads a response from the QMP server
 func (q *QMPClient) getResponse(ctx context.Context) (*QMPResponse, error) {
 	for {
@@ -180,12 +475,14 @@ func (q *QMPClient) getResponse(ctx context.Context) (*QMPResponse, error) {
 		default:
 		}
 
-		line, err := q.reader.ReadString('\n')
+		line, err := q.readLine(ctx)
 		if err != nil {
-			if err == io.EOF {
-				return nil, fmt.Errorf("connection closed by server")
-			}
-			return nil, fmt.Errorf("failed to read response: %w", err)
+			return nil, err
+		}
+
+		// Handle events
+		if event := q.handleEvent(line); event != nil {
+			continue
 		}
 
 		var response QMPResponse
@@ -194,15 +491,6 @@ func (q *QMPClient) getResponse(ctx context.Context) (*QMPResponse, error) {
 			return nil, fmt.Errorf("failed to parse response: %w", err)
 		}
 
-		// Handle events
-		if response.Event != nil {
-			q.logger.Debug("QMP EVENT:\n%s", formatJSON(response))
-			q.eventsMu.Lock()
-			q.events = append(q.events, *response.Event)
-			q.eventsMu.Unlock()
-			continue
-		}
-
 		// Handle return or error
 		if response.Return != nil || response.Error != nil {
 			return &response, nil
@@ -227,7 +515,14 @@ func (q *QMPClient) sendCommandInternal(ctx context.Context, cmd map[string]inte
 		return nil, fmt.Errorf("failed to encode command: %w", err)
 	}
 
+	q.logger.Debug("QMP CMD ->\n%s", formatJSON(cmd))
+	return q.writeAndRead(ctx, cmdBytes)
+}
+
+// writeAndRead writes an encoded command line and reads its response
+func (q *QMPClient) writeAndRead(ctx context.Context, cmdBytes []byte) (*QMPResponse, error) {
 	cmdBytes = append(cmdBytes, '\n')
+	q.logWire("->", string(cmdBytes))
 	if _, err := q.writer.Write(cmdBytes); err != nil {
 		return nil, fmt.Errorf("failed to write command: %w", err)
 	}
@@ -236,8 +531,6 @@ func (q *QMPClient) sendCommandInternal(ctx context.Context, cmd map[string]inte
 		return nil, fmt.Errorf("failed to flush command: %w", err)
 	}
 
-	q.logger.Debug("QMP CMD ->\n%s", formatJSON(cmd))
-
 	// Read response
 	response, err := q.getResponse(ctx)
 	if err != nil {
@@ -250,127 +543,1513 @@ func (q *QMPClient) sendCommandInternal(ctx context.Context, cmd map[string]inte
 
 // SendCommand sends a command to QMP and returns the response
 func (q *QMPClient) SendCommand(ctx context.Context, cmd map[string]interface{}) (*QMPResponse, error) {
+	execute, _ := cmd["execute"].(string)
+	q.invalidateRunState(execute)
+
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	return q.sendCommandInternal(ctx, cmd)
 }
 
-// QueryCommands queries available QMP commands
-func (q *QMPClient) QueryCommands(ctx context.Context) ([]map[string]interface{}, error) {
-	response, err := q.SendCommand(ctx, map[string]interface{}{
-		"execute": "query-commands",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed query-commands: %w", err)
+// SendBatch writes several commands at once and returns their responses in the
+// same order, matched by the id each command is tagged with, saving a round
+// trip per command on slow sockets. QEMU runs all of them even if one fails, so
+// every response is collected before the error of the first failed command is
+// returned along with them.
+func (q *QMPClient) SendBatch(ctx context.Context, cmds []map[string]interface{}) ([]*QMPResponse, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	for _, cmd := range cmds {
+		execute, _ := cmd["execute"].(string)
+		q.invalidateRunState(execute)
 	}
 
-	if response.Error != nil {
-		q.logger.Error("error while sending QMP command 'query-commands':\n%s", formatJSON(response))
-		return nil, fmt.Errorf("error while sending QMP command 'query-commands': %s", response.Error.Desc)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.conn == nil || q.reader == nil || q.writer == nil {
+		return nil, fmt.Errorf("not connected")
 	}
 
-	var commands []map[string]interface{}
-	if err := json.Unmarshal(response.Return, &commands); err != nil {
-		return nil, fmt.Errorf("failed to parse commands response: %w", err)
+	q.batches++
+	ids := make(map[string]int, len(cmds))
+	var lines bytes.Buffer
+	for i, cmd := range cmds {
+		id := fmt.Sprintf("batch-%d-%d", q.batches, i)
+		ids[id] = i
+
+		tagged := make(map[string]interface{}, len(cmd)+1)
+		for k, v := range cmd {
+			tagged[k] = v
+		}
+		tagged["id"] = id
+
+		cmdBytes, err := json.Marshal(tagged)
+		if err != nil {
+			q.logger.Exception(err, "error encoding QMP message")
+			return nil, fmt.Errorf("failed to encode command: %w", err)
+		}
+		q.logger.Debug("QMP CMD ->\n%s", formatJSON(tagged))
+		lines.Write(cmdBytes)
+		lines.WriteByte('\n')
 	}
 
-	return commands, nil
+	q.logWire("->", lines.String())
+	if _, err := q.writer.Write(lines.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to write commands: %w", err)
+	}
+	if err := q.writer.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush commands: %w", err)
+	}
+
+	responses := make([]*QMPResponse, len(cmds))
+	for range cmds {
+		response, err := q.getResponse(ctx)
+		if err != nil {
+			return nil, err
+		}
+		q.logger.Debug("<- QMP RSP:\n%s", formatJSON(response))
+
+		var id string
+		json.Unmarshal(response.ID, &id)
+		i, ok := ids[id]
+		if !ok || responses[i] != nil {
+			return nil, fmt.Errorf("unexpected response id %s in batch", response.ID)
+		}
+		responses[i] = response
+	}
+
+	for i, response := range responses {
+		execute, _ := cmds[i]["execute"].(string)
+		if err := commandError(execute, response); err != nil {
+			q.logger.Error("error while sending QMP command '%s':\n%s", execute, formatJSON(response))
+			return responses, err
+		}
+	}
+	return responses, nil
 }
 
-// CheckStatus checks if the VM is responsive by querying its status
-func (q *QMPClient) CheckStatus(ctx context.Context) (map[string]interface{}, error) {
-	response, err := q.SendCommand(ctx, map[string]interface{}{
-		"execute": "query-status",
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to query VM status: %w", err)
+// SendCommandRaw sends a command given as a JSON object as-is, preserving its
+// field order and number formatting
+func (q *QMPClient) SendCommandRaw(ctx context.Context, jsonLine string) (*QMPResponse, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonLine), &fields); err != nil {
+		return nil, fmt.Errorf("invalid QMP command JSON: %w", err)
+	}
+	var execute string
+	if err := json.Unmarshal(fields["execute"], &execute); err != nil || execute == "" {
+		return nil, fmt.Errorf("command is missing 'execute'")
 	}
+	q.invalidateRunState(execute)
 
-	if response.Error != nil {
-		return nil, fmt.Errorf("error querying VM status: %s", response.Error.Desc)
+	// QMP is line-based, so the command must be sent on a single line
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(jsonLine)); err != nil {
+		return nil, fmt.Errorf("invalid QMP command JSON: %w", err)
 	}
 
-	var status map[string]interface{}
-	if err := json.Unmarshal(response.Return, &status); err != nil {
-		return nil, fmt.Errorf("failed to parse status response: %w", err)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.conn == nil || q.reader == nil || q.writer == nil {
+		return nil, fmt.Errorf("not connected")
 	}
 
-	return status, nil
+	q.logger.Debug("QMP CMD ->\n%s", compact.String())
+	return q.writeAndRead(ctx, compact.Bytes())
 }
 
-// IsRunning checks if the VM is running and responsive
-func (q *QMPClient) IsRunning(ctx context.Context) bool {
-	status, err := q.CheckStatus(ctx)
+// SendCommandOOB sends a command for out-of-band execution, allowing it to be
+// processed even while the main loop is busy (e.g. a wedged VM).
+// Only commands QEMU marks as OOB-capable can be run this way.
+func (q *QMPClient) SendCommandOOB(ctx context.Context, cmd map[string]interface{}) (*QMPResponse, error) {
+	if !q.HasCapability("oob") {
+		return nil, fmt.Errorf("out-of-band execution not negotiated with QMP server")
+	}
+
+	execute, ok := cmd["execute"].(string)
+	if !ok {
+		return nil, fmt.Errorf("command is missing 'execute'")
+	}
+
+	// QEMU >= 3.0 selects OOB execution via 'exec-oob' rather than 'execute'
+	oobCmd := make(map[string]interface{}, len(cmd))
+	for k, v := range cmd {
+		if k != "execute" {
+			oobCmd[k] = v
+		}
+	}
+	oobCmd["exec-oob"] = execute
+
+	return q.SendCommand(ctx, oobCmd)
+}
+
+// Ping checks that the QMP server answers commands
+func (q *QMPClient) Ping(ctx context.Context) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-version",
+	})
 	if err != nil {
-		return false
+		return fmt.Errorf("QMP ping failed: %w", err)
 	}
 
-	if running, ok := status["running"].(bool); ok {
-		return running
+	if err := commandError("query-version", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-version':\n%s", formatJSON(response))
+		return err
 	}
-	return false
+
+	return nil
 }
 
-// shutdown attempts to shut down the VM
-func (q *QMPClient) shutdown(ctx context.Context, checkInterval time.Duration, timeout time.Duration, force bool) (bool, error) {
-	deadline := time.Now().Add(timeout)
-	forceCmd := "quit"
-	if !force {
-		forceCmd = "system_powerdown"
+// QueryCommands queries available QMP commands
+func (q *QMPClient) QueryCommands(ctx context.Context) ([]map[string]interface{}, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-commands",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-commands: %w", err)
 	}
 
-	for time.Now().Before(deadline) {
-		select {
-		case <-ctx.Done():
-			return false, ctx.Err()
-		default:
-		}
+	if err := commandError("query-commands", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-commands':\n%s", formatJSON(response))
+		return nil, err
+	}
 
-		_, err := q.SendCommand(ctx, map[string]interface{}{
-			"execute": forceCmd,
-		})
+	var commands []map[string]interface{}
+	if err := json.Unmarshal(response.Return, &commands); err != nil {
+		return nil, fmt.Errorf("failed to parse commands response: %w", err)
+	}
+
+	return commands, nil
+}
+
+// SupportsCommand reports whether the server implements the named command, so
+// callers can pick the right command across QEMU versions. The command list is
+// queried once and cached for the lifetime of the connection.
+func (q *QMPClient) SupportsCommand(ctx context.Context, name string) (bool, error) {
+	q.mu.Lock()
+	commands := q.commands
+	q.mu.Unlock()
 
+	if commands == nil {
+		list, err := q.QueryCommands(ctx)
 		if err != nil {
-			// Check if connection is broken (VM has shut down)
-			if strings.Contains(err.Error(), "connection closed") ||
-				strings.Contains(err.Error(), "broken pipe") ||
-				strings.Contains(err.Error(), "connection reset") {
-				return true, nil
+			return false, err
+		}
+		commands = make(map[string]bool, len(list))
+		for _, command := range list {
+			if name, ok := command["name"].(string); ok {
+				commands[name] = true
 			}
 		}
 
-		// Wait before next attempt
-		select {
-		case <-time.After(checkInterval):
-		case <-ctx.Done():
-			return false, ctx.Err()
+		q.mu.Lock()
+		if q.conn != nil {
+			q.commands = commands
 		}
+		q.mu.Unlock()
 	}
 
-	return false, nil
+	return commands[name], nil
 }
 
-// Shutdown attempts to shut down the VM gracefully, with fallback to force quit
-func (q *QMPClient) Shutdown(ctx context.Context, checkInterval time.Duration, timeout time.Duration, forceAfterTimeout bool) (bool, error) {
-	// Try graceful shutdown first
-	success, err := q.shutdown(ctx, checkInterval, timeout, false)
+// QueryChardev queries the character devices QEMU has created
+func (q *QMPClient) QueryChardev(ctx context.Context) ([]map[string]interface{}, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-chardev",
+	})
 	if err != nil {
-		return false, err
+		return nil, fmt.Errorf("failed query-chardev: %w", err)
 	}
 
-	if success {
-		return true, nil
+	if err := commandError("query-chardev", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-chardev':\n%s", formatJSON(response))
+		return nil, err
 	}
 
-	// If graceful shutdown failed and force is enabled, try force shutdown
-	if forceAfterTimeout {
-		return q.shutdown(ctx, checkInterval, 5*time.Second, true)
+	var chardevs []map[string]interface{}
+	if err := json.Unmarshal(response.Return, &chardevs); err != nil {
+		return nil, fmt.Errorf("failed to parse chardev response: %w", err)
 	}
 
-	return false, nil
+	return chardevs, nil
 }
 
-// GetEvents returns all collected events and clears the buffer
+// Fdset is a set of file descriptors passed to QEMU with add-fd, which
+// -drive and -blockdev options reference as /dev/fdset/<id>
+type Fdset struct {
+	ID  int       `json:"fdset-id"`
+	FDs []FdsetFD `json:"fds"`
+}
+
+// FdsetFD is a file descriptor in an fdset, as numbered in the QEMU process
+type FdsetFD struct {
+	FD     int    `json:"fd"`
+	Opaque string `json:"opaque,omitempty"`
+}
+
+// QueryFdsets queries the fdsets QEMU holds
+func (q *QMPClient) QueryFdsets(ctx context.Context) ([]Fdset, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-fdsets",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-fdsets: %w", err)
+	}
+
+	if err := commandError("query-fdsets", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-fdsets':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var fdsets []Fdset
+	if err := json.Unmarshal(response.Return, &fdsets); err != nil {
+		return nil, fmt.Errorf("failed to parse fdsets response: %w", err)
+	}
+
+	return fdsets, nil
+}
+
+// AddFdInfo is the fdset and QEMU-side descriptor number add-fd assigned
+type AddFdInfo struct {
+	FdsetID int `json:"fdset-id"`
+	FD      int `json:"fd"`
+}
+
+// AddFd passes file to QEMU over the QMP socket (SCM_RIGHTS) and adds it to the
+// fdset fdsetID, or to a new fdset if fdsetID is negative. opaque is a free-form
+// note shown by query-fdsets. The caller may close file afterwards, QEMU holds
+// its own copy.
+func (q *QMPClient) AddFd(ctx context.Context, file *os.File, fdsetID int, opaque string) (*AddFdInfo, error) {
+	arguments := map[string]interface{}{}
+	if fdsetID >= 0 {
+		arguments["fdset-id"] = fdsetID
+	}
+	if opaque != "" {
+		arguments["opaque"] = opaque
+	}
+	cmd := map[string]interface{}{
+		"execute":   "add-fd",
+		"arguments": arguments,
+	}
+	cmdBytes, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode command: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	unixConn, ok := q.conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not connected")
+	}
+	// QEMU takes the descriptor received along with the command
+	q.logger.Debug("QMP CMD ->\n%s", formatJSON(cmd))
+	cmdBytes = append(cmdBytes, '\n')
+	q.logWire("->", string(cmdBytes))
+	if _, _, err := unixConn.WriteMsgUnix(cmdBytes, syscall.UnixRights(int(file.Fd())), nil); err != nil {
+		return nil, fmt.Errorf("failed add-fd: %w", err)
+	}
+	response, err := q.getResponse(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed add-fd: %w", err)
+	}
+
+	if err := commandError("add-fd", response); err != nil {
+		q.logger.Error("error while sending QMP command 'add-fd':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var info AddFdInfo
+	if err := json.Unmarshal(response.Return, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse add-fd response: %w", err)
+	}
+
+	return &info, nil
+}
+
+// BlockJob describes a running block job such as a mirror, commit or stream
+type BlockJob struct {
+	Device string `json:"device"`
+	Type   string `json:"type"`
+	Offset int64  `json:"offset"`
+	Len    int64  `json:"len"`
+	Speed  int64  `json:"speed"`
+	Busy   bool   `json:"busy"`
+	Paused bool   `json:"paused"`
+	Ready  bool   `json:"ready"`
+}
+
+// Percent returns how far the job has progressed, from 0 to 100
+func (j BlockJob) Percent() float64 {
+	if j.Len <= 0 {
+		return 0
+	}
+	return float64(j.Offset) * 100 / float64(j.Len)
+}
+
+// QueryBlockJobs queries the progress of all running block jobs
+func (q *QMPClient) QueryBlockJobs(ctx context.Context) ([]BlockJob, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-block-jobs",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-block-jobs: %w", err)
+	}
+
+	if err := commandError("query-block-jobs", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-block-jobs':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var jobs []BlockJob
+	if err := json.Unmarshal(response.Return, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse block jobs response: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// PCIBus is a root PCI bus as reported by query-pci
+type PCIBus struct {
+	Bus     int         `json:"bus"`
+	Devices []PCIDevice `json:"devices"`
+}
+
+// PCIDevice is a PCI function, bridges carry the devices behind them
+type PCIDevice struct {
+	Bus       int          `json:"bus"`
+	Slot      int          `json:"slot"`
+	Function  int          `json:"function"`
+	ClassInfo PCIClassInfo `json:"class_info"`
+	ID        PCIDeviceID  `json:"id"`
+	QdevID    string       `json:"qdev_id"`
+	IRQ       *int         `json:"irq,omitempty"`
+	Bridge    *PCIBridge   `json:"pci_bridge,omitempty"`
+}
+
+// PCIClassInfo is the class code of a PCI device, with a description if QEMU knows one
+type PCIClassInfo struct {
+	Desc  string `json:"desc,omitempty"`
+	Class int    `json:"class"`
+}
+
+// PCIDeviceID holds the vendor and device IDs of a PCI device
+type PCIDeviceID struct {
+	Vendor int `json:"vendor"`
+	Device int `json:"device"`
+}
+
+// PCIBridge describes the secondary bus of a PCI bridge and the devices on it
+type PCIBridge struct {
+	Bus struct {
+		Number      int `json:"number"`
+		Secondary   int `json:"secondary"`
+		Subordinate int `json:"subordinate"`
+	} `json:"bus"`
+	Devices []PCIDevice `json:"devices,omitempty"`
+}
+
+// Address returns the device address in bus:slot.function notation, e.g. 00:1f.2
+func (d PCIDevice) Address() string {
+	return fmt.Sprintf("%02x:%02x.%x", d.Bus, d.Slot, d.Function)
+}
+
+// QueryPCI queries the PCI buses of the VM and the devices on them
+func (q *QMPClient) QueryPCI(ctx context.Context) ([]PCIBus, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-pci",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-pci: %w", err)
+	}
+
+	if err := commandError("query-pci", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-pci':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var buses []PCIBus
+	if err := json.Unmarshal(response.Return, &buses); err != nil {
+		return nil, fmt.Errorf("failed to parse PCI response: %w", err)
+	}
+
+	return buses, nil
+}
+
+// CPUInfo describes a virtual CPU and the host thread running it
+type CPUInfo struct {
+	Index    int64  `json:"cpu-index"`
+	QOMPath  string `json:"qom-path"`
+	ThreadID int64  `json:"thread-id"`
+	Target   string `json:"target,omitempty"`
+}
+
+// legacyCPUInfo is a CPU as reported by query-cpus, which QEMU 6.0 removed
+type legacyCPUInfo struct {
+	CPU      int64  `json:"CPU"`
+	QOMPath  string `json:"qom_path"`
+	ThreadID int64  `json:"thread_id"`
+	Arch     string `json:"arch"`
+}
+
+// QueryCPUs queries the virtual CPUs of the VM. It uses query-cpus-fast, which
+// does not interrupt the guest, and falls back to query-cpus on QEMU < 2.12.
+func (q *QMPClient) QueryCPUs(ctx context.Context) ([]CPUInfo, error) {
+	fast, err := q.SupportsCommand(ctx, "query-cpus-fast")
+	if err != nil {
+		return nil, err
+	}
+
+	command := "query-cpus-fast"
+	if !fast {
+		command = "query-cpus"
+	}
+
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": command,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed %s: %w", command, err)
+	}
+
+	if err := commandError(command, response); err != nil {
+		q.logger.Error("error while sending QMP command '%s':\n%s", command, formatJSON(response))
+		return nil, err
+	}
+
+	if fast {
+		var cpus []CPUInfo
+		if err := json.Unmarshal(response.Return, &cpus); err != nil {
+			return nil, fmt.Errorf("failed to parse CPU response: %w", err)
+		}
+		return cpus, nil
+	}
+
+	var legacy []legacyCPUInfo
+	if err := json.Unmarshal(response.Return, &legacy); err != nil {
+		return nil, fmt.Errorf("failed to parse CPU response: %w", err)
+	}
+	cpus := make([]CPUInfo, len(legacy))
+	for i, cpu := range legacy {
+		cpus[i] = CPUInfo{Index: cpu.CPU, QOMPath: cpu.QOMPath, ThreadID: cpu.ThreadID, Target: cpu.Arch}
+	}
+	return cpus, nil
+}
+
+// HotpluggableCPU is a vCPU slot as reported by query-hotpluggable-cpus. Props
+// holds its topology (socket-id, core-id, thread-id, node-id, ...), which
+// device_add takes as-is to plug a CPU into the slot.
+type HotpluggableCPU struct {
+	Type       string           `json:"type"`
+	VCPUsCount int              `json:"vcpus-count"`
+	Props      map[string]int64 `json:"props"`
+	QOMPath    string           `json:"qom-path,omitempty"` // only set for plugged slots
+}
+
+// Plugged reports whether a CPU occupies the slot
+func (c HotpluggableCPU) Plugged() bool {
+	return c.QOMPath != ""
+}
+
+// DeviceID returns the ID of the CPU plugged into the slot by device_add, or ""
+// for boot CPUs, which cannot be unplugged
+func (c HotpluggableCPU) DeviceID() string {
+	id, ok := strings.CutPrefix(c.QOMPath, "/machine/peripheral/")
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// cpuTopology lists the CPU props from the outermost to the innermost level
+var cpuTopology = []string{"drawer-id", "book-id", "socket-id", "die-id", "cluster-id", "core-id", "thread-id"}
+
+// cpuSlotLess orders CPU slots by their position in the topology
+func cpuSlotLess(a, b HotpluggableCPU) bool {
+	for _, prop := range cpuTopology {
+		if a.Props[prop] != b.Props[prop] {
+			return a.Props[prop] < b.Props[prop]
+		}
+	}
+	return false
+}
+
+// cpuDeviceID names the CPU device plugged into a slot after its topology
+func cpuDeviceID(slot HotpluggableCPU) string {
+	id := "cpu"
+	for _, prop := range cpuTopology {
+		if value, ok := slot.Props[prop]; ok {
+			id += fmt.Sprintf("-%d", value)
+		}
+	}
+	return id
+}
+
+// ErrNoCPUSlot is returned by AddCPU and DelCPU when there is no slot to plug
+// a CPU into or no hotplugged CPU to remove
+var ErrNoCPUSlot = errors.New("no CPU slot available")
+
+// QueryHotpluggableCPUs queries the vCPU slots of the VM, plugged or not. Only
+// VMs started with -smp ...,maxcpus=N have free slots.
+func (q *QMPClient) QueryHotpluggableCPUs(ctx context.Context) ([]HotpluggableCPU, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-hotpluggable-cpus",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-hotpluggable-cpus: %w", err)
+	}
+
+	if err := commandError("query-hotpluggable-cpus", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-hotpluggable-cpus':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var cpus []HotpluggableCPU
+	if err := json.Unmarshal(response.Return, &cpus); err != nil {
+		return nil, fmt.Errorf("failed to parse hotpluggable CPUs response: %w", err)
+	}
+
+	return cpus, nil
+}
+
+// AddCPU plugs a vCPU into the first free slot, returning the slot with the
+// ID of the new CPU device
+func (q *QMPClient) AddCPU(ctx context.Context) (*HotpluggableCPU, string, error) {
+	cpus, err := q.QueryHotpluggableCPUs(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var slot *HotpluggableCPU
+	for i := range cpus {
+		if !cpus[i].Plugged() && (slot == nil || cpuSlotLess(cpus[i], *slot)) {
+			slot = &cpus[i]
+		}
+	}
+	if slot == nil {
+		return nil, "", fmt.Errorf("%w: all %d CPU slots are in use", ErrNoCPUSlot, len(cpus))
+	}
+
+	id := cpuDeviceID(*slot)
+	arguments := map[string]interface{}{
+		"driver": slot.Type,
+		"id":     id,
+	}
+	for prop, value := range slot.Props {
+		arguments[prop] = value
+	}
+
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "device_add",
+		"arguments": arguments,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed device_add: %w", err)
+	}
+
+	if err := commandError("device_add", response); err != nil {
+		q.logger.Error("error while sending QMP command 'device_add':\n%s", formatJSON(response))
+		return nil, "", err
+	}
+
+	return slot, id, nil
+}
+
+// DelCPU asks the guest to release the hotplugged vCPU with the given device
+// ID, or the last hotplugged one if id is empty, and returns the ID. The guest
+// completes the unplug asynchronously, QEMU emits DEVICE_DELETED once done.
+func (q *QMPClient) DelCPU(ctx context.Context, id string) (string, error) {
+	if id == "" {
+		cpus, err := q.QueryHotpluggableCPUs(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		var slot *HotpluggableCPU
+		for i := range cpus {
+			if cpus[i].DeviceID() != "" && (slot == nil || cpuSlotLess(*slot, cpus[i])) {
+				slot = &cpus[i]
+			}
+		}
+		if slot == nil {
+			return "", fmt.Errorf("%w: no hotplugged CPU to remove, boot CPUs cannot be unplugged", ErrNoCPUSlot)
+		}
+		id = slot.DeviceID()
+	}
+
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "device_del",
+		"arguments": map[string]interface{}{"id": id},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed device_del: %w", err)
+	}
+
+	if err := commandError("device_del", response); err != nil {
+		q.logger.Error("error while sending QMP command 'device_del':\n%s", formatJSON(response))
+		return "", err
+	}
+
+	return id, nil
+}
+
+// RxFilter is the receive filter state of a NIC as reported by query-rx-filter
+type RxFilter struct {
+	Name              string   `json:"name"`
+	Promiscuous       bool     `json:"promiscuous"`
+	Multicast         string   `json:"multicast"` // normal, none or all
+	Unicast           string   `json:"unicast"`
+	Vlan              string   `json:"vlan"`
+	BroadcastAllowed  bool     `json:"broadcast-allowed"`
+	MulticastOverflow bool     `json:"multicast-overflow"`
+	UnicastOverflow   bool     `json:"unicast-overflow"`
+	MainMAC           string   `json:"main-mac"`
+	VlanTable         []int    `json:"vlan-table"`
+	UnicastTable      []string `json:"unicast-table"`
+	MulticastTable    []string `json:"multicast-table"`
+}
+
+// QueryRxFilter queries the receive filters of the VM's NICs, or of the NIC
+// with the given device ID only if device is not empty
+func (q *QMPClient) QueryRxFilter(ctx context.Context, device string) ([]RxFilter, error) {
+	cmd := map[string]interface{}{
+		"execute": "query-rx-filter",
+	}
+	if device != "" {
+		cmd["arguments"] = map[string]interface{}{"name": device}
+	}
+	response, err := q.SendCommand(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed query-rx-filter: %w", err)
+	}
+
+	if err := commandError("query-rx-filter", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-rx-filter':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var filters []RxFilter
+	if err := json.Unmarshal(response.Return, &filters); err != nil {
+		return nil, fmt.Errorf("failed to parse rx filter response: %w", err)
+	}
+
+	return filters, nil
+}
+
+// BlockStats holds the I/O counters of a block device
+type BlockStats struct {
+	Device  string
+	RdBytes int64
+	WrBytes int64
+	RdOps   int64
+	WrOps   int64
+}
+
+// QueryBlockStats queries the I/O counters of all block devices
+func (q *QMPClient) QueryBlockStats(ctx context.Context) ([]BlockStats, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-blockstats",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-blockstats: %w", err)
+	}
+
+	if err := commandError("query-blockstats", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-blockstats':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var entries []struct {
+		Device   string `json:"device"`
+		NodeName string `json:"node-name"`
+		QDev     string `json:"qdev"`
+		Stats    struct {
+			RdBytes int64 `json:"rd_bytes"`
+			WrBytes int64 `json:"wr_bytes"`
+			RdOps   int64 `json:"rd_operations"`
+			WrOps   int64 `json:"wr_operations"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal(response.Return, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse blockstats response: %w", err)
+	}
+
+	stats := make([]BlockStats, 0, len(entries))
+	for _, e := range entries {
+		// Devices set up with -blockdev have no legacy device name
+		device := e.Device
+		if device == "" {
+			device = e.QDev
+		}
+		if device == "" {
+			device = e.NodeName
+		}
+		stats = append(stats, BlockStats{
+			Device:  device,
+			RdBytes: e.Stats.RdBytes,
+			WrBytes: e.Stats.WrBytes,
+			RdOps:   e.Stats.RdOps,
+			WrOps:   e.Stats.WrOps,
+		})
+	}
+
+	return stats, nil
+}
+
+// BlockRate is the I/O throughput of a block device between two samples
+type BlockRate struct {
+	Device       string
+	ReadBytesPS  float64
+	WriteBytesPS float64
+	ReadIOPS     float64
+	WriteIOPS    float64
+}
+
+// BlockRates computes per-device rates from two samples taken elapsed apart,
+// devices missing from prev are skipped
+func BlockRates(prev, cur []BlockStats, elapsed time.Duration) []BlockRate {
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return nil
+	}
+
+	previous := make(map[string]BlockStats, len(prev))
+	for _, p := range prev {
+		previous[p.Device] = p
+	}
+
+	var rates []BlockRate
+	for _, c := range cur {
+		p, ok := previous[c.Device]
+		if !ok {
+			continue
+		}
+		rates = append(rates, BlockRate{
+			Device:       c.Device,
+			ReadBytesPS:  float64(c.RdBytes-p.RdBytes) / seconds,
+			WriteBytesPS: float64(c.WrBytes-p.WrBytes) / seconds,
+			ReadIOPS:     float64(c.RdOps-p.RdOps) / seconds,
+			WriteIOPS:    float64(c.WrOps-p.WrOps) / seconds,
+		})
+	}
+	return rates
+}
+
+// HumanMonitorCommand runs an HMP command through QMP and returns its output
+func (q *QMPClient) HumanMonitorCommand(ctx context.Context, commandLine string) (string, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "human-monitor-command",
+		"arguments": map[string]interface{}{"command-line": commandLine},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed human-monitor-command: %w", err)
+	}
+
+	if err := commandError("human-monitor-command", response); err != nil {
+		return "", err
+	}
+
+	var output string
+	if err := json.Unmarshal(response.Return, &output); err != nil {
+		return "", fmt.Errorf("failed to parse human-monitor-command response: %w", err)
+	}
+
+	return output, nil
+}
+
+// SaveVM takes an internal snapshot of the VM's state and disks through HMP's
+// savevm, which needs all writable disks to be qcow2. HMP reports failures as
+// output rather than as a QMP error.
+func (q *QMPClient) SaveVM(ctx context.Context, name string) error {
+	output, err := q.HumanMonitorCommand(ctx, "savevm "+name)
+	if err != nil {
+		return err
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		return fmt.Errorf("savevm failed: %s", output)
+	}
+	return nil
+}
+
+// ChangeMedium replaces the medium in a removable device such as a CD-ROM
+// drive, format may be empty to let QEMU probe it. If QEMU refuses the change,
+// e.g. because the guest locked the tray, the device is force-ejected through
+// HMP and the change retried once.
+func (q *QMPClient) ChangeMedium(ctx context.Context, device, filename, format string) error {
+	err := q.changeMedium(ctx, device, filename, format)
+	if err == nil || errors.Is(err, ErrDeviceNotFound) {
+		return err
+	}
+	var cmdErr *QMPCommandError
+	if !errors.As(err, &cmdErr) {
+		return err
+	}
+
+	q.logger.Debug("blockdev-change-medium failed, force-ejecting '%s': %s", device, cmdErr.Desc)
+	if output, ejectErr := q.HumanMonitorCommand(ctx, "eject -f "+device); ejectErr != nil {
+		return fmt.Errorf("%w (eject fallback failed: %v)", err, ejectErr)
+	} else if output = strings.TrimSpace(output); output != "" {
+		return fmt.Errorf("%w (eject fallback failed: %s)", err, output)
+	}
+
+	return q.changeMedium(ctx, device, filename, format)
+}
+
+// changeMedium sends a single blockdev-change-medium command
+func (q *QMPClient) changeMedium(ctx context.Context, device, filename, format string) error {
+	arguments := map[string]interface{}{
+		"device":   device,
+		"filename": filename,
+	}
+	if format != "" {
+		arguments["format"] = format
+	}
+
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "blockdev-change-medium",
+		"arguments": arguments,
+	})
+	if err != nil {
+		return fmt.Errorf("failed blockdev-change-medium: %w", err)
+	}
+
+	return commandError("blockdev-change-medium", response)
+}
+
+// VMStatus is the typed result of query-status
+type VMStatus struct {
+	Status     string `json:"status"` // e.g. running, paused, shutdown, guest-panicked
+	Running    bool   `json:"running"`
+	Singlestep bool   `json:"singlestep"`
+	Reason     string `json:"reason,omitempty"` // Shutdown or reset cause, if QEMU reports one
+}
+
+// IsPanicked reports whether the guest is stopped after a panic
+func (s *VMStatus) IsPanicked() bool {
+	return s.Status == "guest-panicked"
+}
+
+// IsShutdown reports whether the guest has shut down while QEMU keeps running
+func (s *VMStatus) IsShutdown() bool {
+	return s.Status == "shutdown"
+}
+
+// ParseVMStatus converts a query-status result as returned by CheckStatus into a VMStatus
+func ParseVMStatus(status map[string]interface{}) (*VMStatus, error) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	var parsed VMStatus
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse status response: %w", err)
+	}
+	return &parsed, nil
+}
+
+// ErrNotResumed is returned by Resume when the VM is still stopped after cont
+var ErrNotResumed = errors.New("VM did not resume")
+
+// Cont resumes a paused VM, which does not wait for it to run
+func (q *QMPClient) Cont(ctx context.Context) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "cont",
+	})
+	if err != nil {
+		return fmt.Errorf("failed cont: %w", err)
+	}
+
+	if err := commandError("cont", response); err != nil {
+		q.logger.Error("error while sending QMP command 'cont':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// Resume resumes a paused VM and polls query-status every checkInterval until it
+// runs. If it is still stopped after timeout, e.g. because it pauses again on an
+// I/O error from a full disk, an error wrapping ErrNotResumed names its state.
+func (q *QMPClient) Resume(ctx context.Context, checkInterval, timeout time.Duration) (*VMStatus, error) {
+	if err := q.Cont(ctx); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := q.QueryStatus(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if status.Running {
+			return status, nil
+		}
+		if time.Now().After(deadline) {
+			return status, fmt.Errorf("%w within %s, status: %s", ErrNotResumed, timeout, status.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// InjectNMI injects a non-maskable interrupt into the guest, e.g. to trigger a crash dump
+func (q *QMPClient) InjectNMI(ctx context.Context) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "inject-nmi",
+	})
+	if err != nil {
+		return fmt.Errorf("failed inject-nmi: %w", err)
+	}
+
+	if err := commandError("inject-nmi", response); err != nil {
+		q.logger.Error("error while sending QMP command 'inject-nmi':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// SystemWakeup wakes up a guest suspended to RAM, e.g. by guest-suspend-ram or
+// ACPI sleep, which cont does not. QEMU fails it if the guest is not suspended.
+func (q *QMPClient) SystemWakeup(ctx context.Context) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "system_wakeup",
+	})
+	if err != nil {
+		return fmt.Errorf("failed system_wakeup: %w", err)
+	}
+
+	if err := commandError("system_wakeup", response); err != nil {
+		q.logger.Error("error while sending QMP command 'system_wakeup':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// SystemReset resets the guest like pressing the reset button, QEMU emits a
+// RESET event once it is done
+func (q *QMPClient) SystemReset(ctx context.Context) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "system_reset",
+	})
+	if err != nil {
+		return fmt.Errorf("failed system_reset: %w", err)
+	}
+
+	if err := commandError("system_reset", response); err != nil {
+		q.logger.Error("error while sending QMP command 'system_reset':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// ResetRTCReinjection drops the RTC interrupts QEMU still has to reinject into
+// the guest, e.g. the backlog which piled up while the VM was paused. Only
+// x86 targets with an in-kernel RTC support it, others answer CommandNotFound.
+func (q *QMPClient) ResetRTCReinjection(ctx context.Context) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "rtc-reset-reinjection",
+	})
+	if err != nil {
+		return fmt.Errorf("failed rtc-reset-reinjection: %w", err)
+	}
+
+	if err := commandError("rtc-reset-reinjection", response); err != nil {
+		q.logger.Error("error while sending QMP command 'rtc-reset-reinjection':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// SetPassword sets the password of the VM's display for protocol ("vnc" or
+// "spice"). The display must have password authentication enabled, e.g.
+// '-vnc :0,password=on', otherwise QEMU rejects the password.
+func (q *QMPClient) SetPassword(ctx context.Context, protocol, password string) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "set_password",
+		"arguments": map[string]interface{}{
+			"protocol": protocol,
+			"password": password,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed set_password: %w", err)
+	}
+
+	if err := commandError("set_password", response); err != nil {
+		q.logger.Error("error while sending QMP command 'set_password':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// SetVNCPassword sets the password of the VM's VNC display. It uses
+// set_password and falls back to change-vnc-password on QEMU builds without it.
+func (q *QMPClient) SetVNCPassword(ctx context.Context, password string) error {
+	err := q.SetPassword(ctx, "vnc", password)
+	if !errors.Is(err, ErrCommandNotFound) {
+		return err
+	}
+
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute":   "change-vnc-password",
+		"arguments": map[string]interface{}{"password": password},
+	})
+	if err != nil {
+		return fmt.Errorf("failed change-vnc-password: %w", err)
+	}
+
+	if err := commandError("change-vnc-password", response); err != nil {
+		q.logger.Error("error while sending QMP command 'change-vnc-password':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// VNCInfo is the VNC server of the VM as reported by query-vnc. For a unix
+// socket, Family is "unix" and Host holds its path.
+type VNCInfo struct {
+	Enabled bool   `json:"enabled"`
+	Host    string `json:"host,omitempty"`
+	Family  string `json:"family,omitempty"` // ipv4, ipv6, unix or unknown
+	Service string `json:"service,omitempty"`
+	Auth    string `json:"auth,omitempty"`
+	Clients []struct {
+		Host    string `json:"host"`
+		Service string `json:"service"`
+	} `json:"clients,omitempty"`
+}
+
+// QueryVNC returns where the VM's VNC server listens
+func (q *QMPClient) QueryVNC(ctx context.Context) (*VNCInfo, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-vnc",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-vnc: %w", err)
+	}
+
+	if err := commandError("query-vnc", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-vnc':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var info VNCInfo
+	if err := json.Unmarshal(response.Return, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse query-vnc response: %w", err)
+	}
+	return &info, nil
+}
+
+// SpiceInfo is the SPICE server of the VM as reported by query-spice. For a
+// unix socket, Host holds its path and no port is set.
+type SpiceInfo struct {
+	Enabled  bool   `json:"enabled"`
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	TLSPort  int    `json:"tls-port,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+	Migrated bool   `json:"migrated"`
+}
+
+// QuerySpice returns where the VM's SPICE server listens. QEMU built without
+// SPICE support fails it with ErrCommandNotFound.
+func (q *QMPClient) QuerySpice(ctx context.Context) (*SpiceInfo, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-spice",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-spice: %w", err)
+	}
+
+	if err := commandError("query-spice", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-spice':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var info SpiceInfo
+	if err := json.Unmarshal(response.Return, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse query-spice response: %w", err)
+	}
+	return &info, nil
+}
+
+// TPMInfo is a TPM device of the VM as reported by query-tpm
+type TPMInfo struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"` // tpm-tis, tpm-crb, tpm-spapr, ...
+	Options struct {
+		Type string `json:"type"` // emulator or passthrough
+		Data struct {
+			Chardev    string `json:"chardev,omitempty"`     // emulator
+			Path       string `json:"path,omitempty"`        // passthrough
+			CancelPath string `json:"cancel-path,omitempty"` // passthrough
+		} `json:"data"`
+	} `json:"options"`
+}
+
+// QueryTPM returns the TPM devices of the VM, empty if it has none
+func (q *QMPClient) QueryTPM(ctx context.Context) ([]TPMInfo, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-tpm",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-tpm: %w", err)
+	}
+
+	if err := commandError("query-tpm", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-tpm':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var tpms []TPMInfo
+	if err := json.Unmarshal(response.Return, &tpms); err != nil {
+		return nil, fmt.Errorf("failed to parse query-tpm response: %w", err)
+	}
+	return tpms, nil
+}
+
+// DumpStatus is the progress of a guest memory dump as reported by query-dump
+type DumpStatus struct {
+	Status    string `json:"status"` // none, active, completed or failed
+	Completed int64  `json:"completed"`
+	Total     int64  `json:"total"`
+}
+
+// Percent returns how much of the guest memory has been written, from 0 to 100
+func (d DumpStatus) Percent() float64 {
+	if d.Total <= 0 {
+		return 0
+	}
+	return float64(d.Completed) * 100 / float64(d.Total)
+}
+
+// ErrDumpFailed is returned by WaitForDump when QEMU reports the dump as failed
+var ErrDumpFailed = errors.New("guest memory dump failed")
+
+// DumpGuestMemory starts writing the guest memory to file, an absolute path on
+// the QEMU host, as an ELF core for crash or gdb. The dump runs in the
+// background, follow it with QueryDump or WaitForDump. paging resolves guest
+// virtual addresses, which takes longer and trusts guest page tables.
+func (q *QMPClient) DumpGuestMemory(ctx context.Context, file string, paging bool) error {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "dump-guest-memory",
+		"arguments": map[string]interface{}{
+			"paging":   paging,
+			"protocol": "file:" + file,
+			"detach":   true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed dump-guest-memory: %w", err)
+	}
+
+	if err := commandError("dump-guest-memory", response); err != nil {
+		q.logger.Error("error while sending QMP command 'dump-guest-memory':\n%s", formatJSON(response))
+		return err
+	}
+
+	return nil
+}
+
+// QueryDump queries the progress of the current or last guest memory dump
+func (q *QMPClient) QueryDump(ctx context.Context) (*DumpStatus, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-dump",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed query-dump: %w", err)
+	}
+
+	if err := commandError("query-dump", response); err != nil {
+		q.logger.Error("error while sending QMP command 'query-dump':\n%s", formatJSON(response))
+		return nil, err
+	}
+
+	var status DumpStatus
+	if err := json.Unmarshal(response.Return, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse dump status response: %w", err)
+	}
+
+	return &status, nil
+}
+
+// WaitForDump polls query-dump every checkInterval, passing each status to
+// progress if set, until the dump completed. A failed dump returns ErrDumpFailed.
+func (q *QMPClient) WaitForDump(ctx context.Context, checkInterval time.Duration, progress func(DumpStatus)) (*DumpStatus, error) {
+	for {
+		status, err := q.QueryDump(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(*status)
+		}
+
+		switch status.Status {
+		case "completed":
+			return status, nil
+		case "failed":
+			return status, ErrDumpFailed
+		case "none":
+			return status, fmt.Errorf("no guest memory dump is running")
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// QueryStatus queries the run state of the VM
+func (q *QMPClient) QueryStatus(ctx context.Context) (*VMStatus, error) {
+	status, err := q.CheckStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVMStatus(status)
+}
+
+// CheckStatus checks if the VM is responsive by querying its status
+func (q *QMPClient) CheckStatus(ctx context.Context) (map[string]interface{}, error) {
+	if status, ok := q.cachedRunState(); ok {
+		return status, nil
+	}
+
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-status",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query VM status: %w", err)
+	}
+
+	if err := commandError("query-status", response); err != nil {
+		return nil, err
+	}
+
+	var status map[string]interface{}
+	if err := json.Unmarshal(response.Return, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status response: %w", err)
+	}
+
+	q.cacheRunState(status)
+	return status, nil
+}
+
+// QueryKVM queries whether KVM is available and enabled for the VM
+func (q *QMPClient) QueryKVM(ctx context.Context) (map[string]interface{}, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "query-kvm",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query KVM status: %w", err)
+	}
+
+	if err := commandError("query-kvm", response); err != nil {
+		return nil, err
+	}
+
+	var kvm map[string]interface{}
+	if err := json.Unmarshal(response.Return, &kvm); err != nil {
+		return nil, fmt.Errorf("failed to parse KVM response: %w", err)
+	}
+
+	return kvm, nil
+}
+
+// QueryRaw runs each of the given argument-less commands and returns their
+// complete responses keyed by command name. A failing command is recorded as
+// an error entry rather than aborting the remaining queries.
+func (q *QMPClient) QueryRaw(ctx context.Context, commands []string) map[string]interface{} {
+	results := make(map[string]interface{}, len(commands))
+	for _, command := range commands {
+		response, err := q.SendCommand(ctx, map[string]interface{}{
+			"execute": command,
+		})
+		if err != nil {
+			results[command] = map[string]interface{}{"error": err.Error()}
+			continue
+		}
+		results[command] = response
+	}
+	return results
+}
+
+// IsRunning checks if the VM is running and responsive
+func (q *QMPClient) IsRunning(ctx context.Context) bool {
+	status, err := q.CheckStatus(ctx)
+	if err != nil {
+		return false
+	}
+
+	if running, ok := status["running"].(bool); ok {
+		return running
+	}
+	return false
+}
+
+// shutdown attempts to shut down the VM
+func (q *QMPClient) shutdown(ctx context.Context, checkInterval time.Duration, timeout time.Duration, force bool) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	forceCmd := "quit"
+	if !force {
+		forceCmd = "system_powerdown"
+	}
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		default:
+		}
+
+		_, err := q.SendCommand(ctx, map[string]interface{}{
+			"execute": forceCmd,
+		})
+
+		if err != nil && connectionClosed(err) {
+			// QEMU has shut down
+			return true, nil
+		}
+
+		// Wait before next attempt
+		select {
+		case <-time.After(checkInterval):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	return false, nil
+}
+
+// PowerdownWithEventConfirmation sends a single system_powerdown and waits up
+// to timeout for the guest's SHUTDOWN event. Only if no event arrives does it
+// fall back to the polling used by Shutdown.
+func (q *QMPClient) PowerdownWithEventConfirmation(ctx context.Context, checkInterval time.Duration, timeout time.Duration, forceAfterTimeout bool) (bool, error) {
+	response, err := q.SendCommand(ctx, map[string]interface{}{
+		"execute": "system_powerdown",
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to send system_powerdown: %w", err)
+	}
+	if err := commandError("system_powerdown", response); err != nil {
+		return false, err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	event, err := q.WaitForEvent(waitCtx, "SHUTDOWN")
+	if event != nil {
+		// Keep the confirmation buffered so GetEvents reports the whole shutdown
+		q.eventsMu.Lock()
+		q.events = append(q.events, *event)
+		q.eventsMu.Unlock()
+	}
+	if err == nil || connectionClosed(err) {
+		// Give QEMU the remaining time to exit
+		if q.waitForExit(waitCtx, checkInterval) {
+			return true, nil
+		}
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return false, ctx.Err()
+		}
+		if !forceAfterTimeout {
+			return false, nil
+		}
+
+		fallbackCtx, cancelFallback := context.WithTimeout(context.WithoutCancel(ctx), powerdownFallbackTimeout)
+		defer cancelFallback()
+
+		q.logger.Debug("QEMU did not exit within %s of its SHUTDOWN event, sending quit", timeout)
+		return q.shutdown(fallbackCtx, checkInterval, 5*time.Second, true)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return false, ctx.Err()
+	}
+
+	// The wait may have used up the caller's deadline, the fallback gets its own
+	fallbackCtx, cancelFallback := context.WithTimeout(context.WithoutCancel(ctx), powerdownFallbackTimeout)
+	defer cancelFallback()
+
+	q.logger.Debug("no SHUTDOWN event within %s, falling back to polling", timeout)
+	return q.Shutdown(fallbackCtx, checkInterval, 5*time.Second, forceAfterTimeout)
+}
+
+// connectionClosed reports whether err means QEMU closed the QMP connection
+func connectionClosed(err error) bool {
+	return strings.Contains(err.Error(), "connection closed") ||
+		strings.Contains(err.Error(), "broken pipe") ||
+		strings.Contains(err.Error(), "connection reset")
+}
+
+// powerdownFallbackTimeout bounds the polling and forced quit after no SHUTDOWN
+// event arrived, which take up to 5s each
+const powerdownFallbackTimeout = 15 * time.Second
+
+// waitForExit polls QEMU after its SHUTDOWN event until it closes the connection
+// or ctx is done, and reports whether it exited. QEMU run with -no-shutdown stays
+// in the shutdown run state instead, so it is told to quit.
+func (q *QMPClient) waitForExit(ctx context.Context, checkInterval time.Duration) bool {
+	quitSent := false
+	for {
+		status, err := q.QueryStatus(ctx)
+		if err != nil {
+			if connectionClosed(err) {
+				return true
+			}
+		} else if status.Status == "shutdown" && !quitSent {
+			q.logger.Debug("QEMU stayed in the shutdown run state, sending quit")
+			if _, err := q.SendCommand(ctx, map[string]interface{}{"execute": "quit"}); err != nil && connectionClosed(err) {
+				return true
+			}
+			quitSent = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(checkInterval):
+		}
+	}
+}
+
+// Shutdown attempts to shut down the VM gracefully, with fallback to force quit
+func (q *QMPClient) Shutdown(ctx context.Context, checkInterval time.Duration, timeout time.Duration, forceAfterTimeout bool) (bool, error) {
+	// Try graceful shutdown first
+	success, err := q.shutdown(ctx, checkInterval, timeout, false)
+	if err != nil {
+		return false, err
+	}
+
+	if success {
+		return true, nil
+	}
+
+	// If graceful shutdown failed and force is enabled, try force shutdown
+	if forceAfterTimeout {
+		return q.shutdown(ctx, checkInterval, 5*time.Second, true)
+	}
+
+	return false, nil
+}
+
+// GetEvents returns all collected events and clears the buffer.
+// Draining is destructive: concurrent callers each receive a disjoint subset
+// of the events. Use PeekEvents to inspect the buffer without consuming it.
 func (q *QMPClient) GetEvents() []QMPEvent {
 	q.eventsMu.Lock()
 	defer q.eventsMu.Unlock()
@@ -381,6 +2060,16 @@ func (q *QMPClient) GetEvents() []QMPEvent {
 	return events
 }
 
+// PeekEvents returns a copy of all collected events without clearing the buffer
+func (q *QMPClient) PeekEvents() []QMPEvent {
+	q.eventsMu.RLock()
+	defer q.eventsMu.RUnlock()
+
+	events := make([]QMPEvent, len(q.events))
+	copy(events, q.events)
+	return events
+}
+
 // formatJSON formats a JSON object for logging
 func formatJSON(v interface{}) string {
 	data, err := json.MarshalIndent(v, "", "  ")
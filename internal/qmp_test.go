@@ -3,14 +3,19 @@
 package internal
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -47,6 +52,36 @@ type MockQEMUServer struct {
 	responses []string
 	commands  []string
 	closed    bool
+	// shutdownOnPowerdown makes system_powerdown emit a SHUTDOWN event while
+	// keeping the connection open, like a guest taking its time to stop
+	shutdownOnPowerdown bool
+	// exitOnPowerdown additionally closes the connection shortly after the
+	// SHUTDOWN event, like QEMU exiting once the guest stopped
+	exitOnPowerdown bool
+	// commandErrors makes the given commands fail with the given error
+	commandErrors map[string]QMPError
+	// lockedTrays lists devices whose medium can only be changed after a forced eject
+	lockedTrays map[string]bool
+	// blockstatsCalls counts query-blockstats calls so successive snapshots differ
+	blockstatsCalls int
+	// vmStatus makes query-status report a stopped VM in this state instead of running
+	vmStatus string
+	// pausedAfterCont is how many query-status calls after cont still report vmStatus,
+	// a negative value keeps the VM stopped
+	pausedAfterCont int
+	contSent        bool
+	// supportedCommands, if set, replaces the commands listed by query-commands
+	supportedCommands []string
+	// dumpPolls counts query-dump calls after dump-guest-memory, each advancing
+	// the dump by a quarter; dumpFails makes it fail halfway instead
+	dumpStarted bool
+	dumpPolls   int
+	dumpFails   bool
+	// hotpluggedCPUs maps the socket of each CPU added by device_add to its ID,
+	// sockets 0 and 1 hold boot CPUs and sockets 2 and 3 are free
+	hotpluggedCPUs map[int64]string
+	// receivedFDs holds the file descriptors passed along with commands, for add-fd
+	receivedFDs []int
 }
 
 // NewMockQEMUServer creates a new mock QEMU server
@@ -107,15 +142,14 @@ func (s *MockQEMUServer) acceptConnections(t *testing.T) {
 func (s *MockQEMUServer) handleQMPProtocol(t *testing.T, conn net.Conn) {
 	defer conn.Close()
 
-	// Read commands and send responses
+	// Read commands and send responses, a line at a time as commands may be pipelined
+	reader := bufio.NewReader(rightsReader{conn: conn.(*net.UnixConn), server: s})
 	for {
-		buffer := make([]byte, 1024)
-		n, err := conn.Read(buffer)
+		command, err := reader.ReadString('\n')
 		if err != nil {
 			break
 		}
 
-		command := string(buffer[:n])
 		s.mu.Lock()
 		s.commands = append(s.commands, strings.TrimSpace(command))
 		s.mu.Unlock()
@@ -127,26 +161,246 @@ func (s *MockQEMUServer) handleQMPProtocol(t *testing.T, conn net.Conn) {
 			continue
 		}
 
-		// Generate response based on command
+		// Generate response based on command, echoing its id like QEMU
 		response := s.generateResponse(cmd)
+		if id, ok := cmd["id"]; ok {
+			var tagged map[string]interface{}
+			if err := json.Unmarshal([]byte(response), &tagged); err == nil {
+				tagged["id"] = id
+				data, _ := json.Marshal(tagged)
+				response = string(data)
+			}
+		}
 		conn.Write([]byte(response + "\n"))
 	}
 }
 
+// rightsReader reads from a unix connection, recording the file descriptors
+// passed along with the data like QEMU does for add-fd
+type rightsReader struct {
+	conn   *net.UnixConn
+	server *MockQEMUServer
+}
+
+func (r rightsReader) Read(p []byte) (int, error) {
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := r.conn.ReadMsgUnix(p, oob)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 && oobn == 0 {
+		return 0, io.EOF
+	}
+	if oobn > 0 {
+		msgs, _ := syscall.ParseSocketControlMessage(oob[:oobn])
+		for _, msg := range msgs {
+			fds, _ := syscall.ParseUnixRights(&msg)
+			r.server.mu.Lock()
+			r.server.receivedFDs = append(r.server.receivedFDs, fds...)
+			r.server.mu.Unlock()
+		}
+	}
+	return n, err
+}
+
 func (s *MockQEMUServer) generateResponse(cmd map[string]interface{}) string {
 	execute, ok := cmd["execute"].(string)
 	if !ok {
-		return `{"error":{"class":"GenericError","desc":"Invalid command format"}}`
+		// Out-of-band commands use 'exec-oob' instead of 'execute'
+		if execute, ok = cmd["exec-oob"].(string); !ok {
+			return `{"error":{"class":"GenericError","desc":"Invalid command format"}}`
+		}
+	}
+
+	s.mu.Lock()
+	qmpErr, failing := s.commandErrors[execute]
+	s.mu.Unlock()
+	if failing {
+		data, _ := json.Marshal(map[string]interface{}{"error": qmpErr})
+		return string(data)
 	}
 
 	switch execute {
 	case "qmp_capabilities":
 		return `{"return":{}}`
 	case "query-commands":
+		s.mu.Lock()
+		supported := s.supportedCommands
+		s.mu.Unlock()
+		if supported != nil {
+			var list []string
+			for _, name := range supported {
+				list = append(list, fmt.Sprintf(`{"name":%q}`, name))
+			}
+			return `{"return":[` + strings.Join(list, ",") + `]}`
+		}
 		return `{"return":[{"name":"query-commands","ret-type":"CommandInfoList"},{"name":"query-status","ret-type":"StatusInfo"}]}`
+	case "dump-guest-memory":
+		s.mu.Lock()
+		s.dumpStarted = true
+		s.dumpPolls = 0
+		s.mu.Unlock()
+		return `{"return":{}}`
+	case "query-dump":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.dumpStarted {
+			return `{"return":{"status":"none","completed":0,"total":0}}`
+		}
+		s.dumpPolls++
+		const total = 4 << 20
+		completed := min(s.dumpPolls, 4) * total / 4
+		switch {
+		case s.dumpFails && s.dumpPolls >= 2:
+			return fmt.Sprintf(`{"return":{"status":"failed","completed":%d,"total":%d}}`, total/2, total)
+		case completed == total:
+			return fmt.Sprintf(`{"return":{"status":"completed","completed":%d,"total":%d}}`, total, total)
+		}
+		return fmt.Sprintf(`{"return":{"status":"active","completed":%d,"total":%d}}`, completed, total)
+	case "query-cpus-fast":
+		return `{"return":[{"cpu-index":0,"qom-path":"/machine/unattached/device[0]","thread-id":4242,"target":"x86_64","props":{"core-id":0,"thread-id":0,"socket-id":0}},{"cpu-index":1,"qom-path":"/machine/unattached/device[1]","thread-id":4243,"target":"x86_64","props":{"core-id":0,"thread-id":0,"socket-id":1}}]}`
+	case "query-hotpluggable-cpus":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// Like QEMU, list the slots from the last to the first
+		var slots []string
+		for socket := int64(3); socket >= 0; socket-- {
+			slot := fmt.Sprintf(`{"type":"qemu64-x86_64-cpu","vcpus-count":1,"props":{"core-id":0,"thread-id":0,"socket-id":%d}`, socket)
+			if socket < 2 {
+				slot += fmt.Sprintf(`,"qom-path":"/machine/unattached/device[%d]"`, socket)
+			} else if id, ok := s.hotpluggedCPUs[socket]; ok {
+				slot += fmt.Sprintf(`,"qom-path":"/machine/peripheral/%s"`, id)
+			}
+			slots = append(slots, slot+"}")
+		}
+		return `{"return":[` + strings.Join(slots, ",") + `]}`
+	case "device_add":
+		args, _ := cmd["arguments"].(map[string]interface{})
+		socket, _ := args["socket-id"].(float64)
+		id, _ := args["id"].(string)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.hotpluggedCPUs == nil {
+			s.hotpluggedCPUs = make(map[int64]string)
+		}
+		s.hotpluggedCPUs[int64(socket)] = id
+		return `{"return":{}}`
+	case "device_del":
+		args, _ := cmd["arguments"].(map[string]interface{})
+		id, _ := args["id"].(string)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for socket, plugged := range s.hotpluggedCPUs {
+			if plugged == id {
+				delete(s.hotpluggedCPUs, socket)
+				return `{"return":{}}`
+			}
+		}
+		return fmt.Sprintf(`{"error":{"class":"DeviceNotFound","desc":"Device '%s' not found"}}`, id)
+	case "set_password", "change-vnc-password", "rtc-reset-reinjection":
+		return `{"return":{}}`
+	case "query-tpm":
+		return `{"return":[{"id":"tpm0","model":"tpm-tis","options":{"type":"emulator","data":{"chardev":"chrtpm"}}}]}`
+	case "query-vnc":
+		return `{"return":{"enabled":true,"host":"127.0.0.1","family":"ipv4","service":"5901","auth":"vnc","clients":[{"host":"127.0.0.1","service":"51234","family":"ipv4","websocket":false}]}}`
+	case "query-cpus":
+		return `{"return":[{"CPU":0,"current":true,"halted":false,"qom_path":"/machine/unattached/device[0]","thread_id":4242,"arch":"x86","pc":-2130449078}]}`
 	case "query-status":
+		s.mu.Lock()
+		if s.contSent && s.pausedAfterCont == 0 {
+			s.vmStatus = ""
+		} else if s.contSent && s.pausedAfterCont > 0 {
+			s.pausedAfterCont--
+		}
+		vmStatus := s.vmStatus
+		s.mu.Unlock()
+		if vmStatus != "" {
+			return fmt.Sprintf(`{"return":{"running":false,"singlestep":false,"status":%q}}`, vmStatus)
+		}
 		return `{"return":{"running":true,"singlestep":false,"status":"running"}}`
+	case "query-chardev":
+		return `{"return":[{"frontend-open":true,"filename":"unix:/tmp/qmp.sock,server=on","label":"compat_monitor1"},{"frontend-open":true,"filename":"file","label":"serial0"}]}`
+	case "query-fdsets":
+		return `{"return":[{"fdset-id":1,"fds":[{"fd":42,"opaque":"luks-key"},{"fd":43}]}]}`
+	case "add-fd":
+		args, _ := cmd["arguments"].(map[string]interface{})
+		fdsetID, ok := args["fdset-id"].(float64)
+		if !ok {
+			fdsetID = 2
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.receivedFDs) == 0 {
+			return `{"error":{"class":"GenericError","desc":"No file descriptor supplied via SCM_RIGHTS"}}`
+		}
+		return fmt.Sprintf(`{"return":{"fdset-id":%d,"fd":%d}}`, int(fdsetID), s.receivedFDs[len(s.receivedFDs)-1])
+	case "blockdev-change-medium":
+		args, _ := cmd["arguments"].(map[string]interface{})
+		device, _ := args["device"].(string)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if device == "missing" {
+			return `{"error":{"class":"DeviceNotFound","desc":"Device 'missing' not found"}}`
+		}
+		if s.lockedTrays[device] {
+			return fmt.Sprintf(`{"error":{"class":"GenericError","desc":"Device '%s' is locked and force was not specified, wait for tray to open and try again"}}`, device)
+		}
+		return `{"return":{}}`
+	case "human-monitor-command":
+		args, _ := cmd["arguments"].(map[string]interface{})
+		line, _ := args["command-line"].(string)
+		if device, ok := strings.CutPrefix(line, "eject -f "); ok {
+			s.mu.Lock()
+			delete(s.lockedTrays, device)
+			s.mu.Unlock()
+		}
+		return `{"return":""}`
+	case "query-blockstats":
+		s.mu.Lock()
+		n := int64(s.blockstatsCalls)
+		s.blockstatsCalls++
+		s.mu.Unlock()
+		// Each snapshot adds 1 MiB/64 ops read and 512 KiB/32 ops written
+		return fmt.Sprintf(`{"return":[{"device":"drive0","stats":{"rd_bytes":%d,"wr_bytes":%d,"rd_operations":%d,"wr_operations":%d}},{"device":"","node-name":"#block123","stats":{"rd_bytes":0,"wr_bytes":0,"rd_operations":0,"wr_operations":0}}]}`,
+			n*1048576, n*524288, n*64, n*32)
+	case "query-block-jobs":
+		return `{"return":[{"device":"drive0","type":"mirror","offset":268435456,"len":1073741824,"speed":0,"busy":true,"paused":false,"ready":false,"io-status":"ok"}]}`
+	case "query-pci":
+		// Host bridge plus a PCIe root port with a virtio-net device behind it
+		return `{"return":[{"bus":0,"devices":[` +
+			`{"bus":0,"slot":0,"function":0,"class_info":{"desc":"Host bridge","class":1536},"id":{"device":10688,"vendor":32902},"qdev_id":"","regions":[]},` +
+			`{"bus":0,"slot":2,"function":0,"class_info":{"desc":"PCI bridge","class":1540},"id":{"device":12,"vendor":6966},"qdev_id":"rp0","regions":[],` +
+			`"pci_bridge":{"bus":{"number":0,"secondary":1,"subordinate":1},"devices":[` +
+			`{"bus":1,"slot":0,"function":0,"irq":10,"class_info":{"desc":"Ethernet controller","class":512},"id":{"device":4161,"vendor":6900},"qdev_id":"net0","regions":[]}]}}]}]}`
+	case "query-rx-filter":
+		args, _ := cmd["arguments"].(map[string]interface{})
+		if name, ok := args["name"].(string); ok && name != "net0" {
+			return fmt.Sprintf(`{"error":{"class":"GenericError","desc":"net client(%s) isn't a NIC"}}`, name)
+		}
+		return `{"return":[{"name":"net0","promiscuous":false,"multicast":"normal","unicast":"normal","vlan":"normal",` +
+			`"broadcast-allowed":true,"multicast-overflow":false,"unicast-overflow":false,"main-mac":"52:54:00:12:34:56",` +
+			`"vlan-table":[],"unicast-table":[],"multicast-table":["01:00:5e:00:00:01","33:33:00:00:00:01"]}]}`
+	case "cont":
+		s.mu.Lock()
+		s.contSent = true
+		s.mu.Unlock()
+		return `{"return":{}}`
+	case "inject-nmi", "system_wakeup":
+		return `{"return":{}}`
+	case "query-kvm":
+		return `{"return":{"enabled":true,"present":true}}`
+	case "query-version":
+		return `{"return":{"qemu":{"micro":0,"minor":8,"major":6},"package":""}}`
 	case "system_powerdown":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.shutdownOnPowerdown {
+			if s.exitOnPowerdown && s.conn != nil {
+				conn := s.conn
+				time.AfterFunc(50*time.Millisecond, func() { conn.Close() })
+			}
+			return `{"return":{}}` + "\n" + `{"event":"SHUTDOWN","data":{"guest":true,"reason":"guest-shutdown"},"timestamp":{"seconds":1700000000,"microseconds":0}}`
+		}
 		return `{"return":{}}`
 	case "quit":
 		// Simulate VM shutdown by closing connection
@@ -394,6 +648,37 @@ func TestQMPClientEvents(t *testing.T) {
 	}
 }
 
+// TestQMPClientPeekEvents tests that peeking leaves the buffer intact while draining empties it
+func TestQMPClientPeekEvents(t *testing.T) {
+	client := NewQMPClient("/tmp/test")
+	client.events = append(client.events,
+		QMPEvent{Event: "RESET"},
+		QMPEvent{Event: "SHUTDOWN"},
+	)
+
+	for i := 0; i < 2; i++ {
+		events := client.PeekEvents()
+		if len(events) != 2 {
+			t.Fatalf("PeekEvents() call %d returned %d events, expected 2", i+1, len(events))
+		}
+		if events[0].Event != "RESET" || events[1].Event != "SHUTDOWN" {
+			t.Errorf("PeekEvents() returned unexpected events: %+v", events)
+		}
+	}
+
+	events := client.GetEvents()
+	if len(events) != 2 {
+		t.Fatalf("GetEvents() returned %d events, expected 2", len(events))
+	}
+
+	if events := client.GetEvents(); len(events) != 0 {
+		t.Errorf("Expected buffer to be empty after GetEvents(), got %d events", len(events))
+	}
+	if events := client.PeekEvents(); len(events) != 0 {
+		t.Errorf("Expected PeekEvents() to return nothing after drain, got %d events", len(events))
+	}
+}
+
 // TestQMPClientJSONFormatting tests JSON formatting utility
 func TestQMPClientJSONFormatting(t *testing.T) {
 	testData := map[string]interface{}{
@@ -449,3 +734,1411 @@ func TestQMPClientConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestQMPClientOOBNegotiation tests that OOB is enabled when advertised by the server
+func TestQMPClientOOBNegotiation(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if !client.HasCapability("oob") {
+		t.Errorf("Expected 'oob' capability to be negotiated, got %v", client.Capabilities())
+	}
+
+	commands := server.GetCommands()
+	if len(commands) == 0 || !strings.Contains(commands[0], `"enable":["oob"]`) {
+		t.Errorf("Expected qmp_capabilities to enable oob, got %v", commands)
+	}
+
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	// An error reply is not a successful ping
+	server.commandErrors = map[string]QMPError{
+		"query-version": {Class: "GenericError", Desc: "QEMU is shutting down"},
+	}
+	var cmdErr *QMPCommandError
+	if err := client.Ping(ctx); !errors.As(err, &cmdErr) {
+		t.Errorf("Expected QMPCommandError from Ping, got %T: %v", err, err)
+	}
+	server.commandErrors = nil
+
+	response, err := client.SendCommandOOB(ctx, map[string]interface{}{
+		"execute": "query-status",
+	})
+	if err != nil {
+		t.Fatalf("Failed to send OOB command: %v", err)
+	}
+	if response.Error != nil {
+		t.Errorf("Unexpected error response: %s", response.Error.Desc)
+	}
+
+	commands = server.GetCommands()
+	last := commands[len(commands)-1]
+	if !strings.Contains(last, `"exec-oob":"query-status"`) {
+		t.Errorf("Expected OOB command to use exec-oob, got %s", last)
+	}
+}
+
+// TestQMPClientWireLog tests that raw protocol traffic is written to the wire log
+func TestQMPClientWireLog(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	wireLogPath := filepath.Join(t.TempDir(), "qmp-wire.log")
+	client := NewQMPClientWithWireLog(socketPath, wireLogPath)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if _, err := client.CheckStatus(ctx); err != nil {
+		t.Fatalf("Failed to check status: %v", err)
+	}
+	client.Close()
+
+	data, err := os.ReadFile(wireLogPath)
+	if err != nil {
+		t.Fatalf("Failed to read wire log: %v", err)
+	}
+	wireLog := string(data)
+
+	expected := []string{
+		`<- {"QMP":`,
+		`-> {"execute":"query-status"}`,
+		`<- {"return":{"running":true`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(wireLog, want) {
+			t.Errorf("Expected wire log to contain %q, got:\n%s", want, wireLog)
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(wireLog), "\n") {
+		timestamp, _, _ := strings.Cut(line, " ")
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			t.Errorf("Wire log line has no valid timestamp: %q", line)
+		}
+	}
+}
+
+// TestQMPClientQueryRaw tests KVM queries and aggregated raw responses
+func TestQMPClientQueryRaw(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	kvm, err := client.QueryKVM(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query KVM: %v", err)
+	}
+	if enabled, _ := kvm["enabled"].(bool); !enabled {
+		t.Errorf("Expected KVM to be enabled, got %v", kvm)
+	}
+
+	// query-current-machine is unknown to the mock, it must not abort the others
+	raw := client.QueryRaw(ctx, []string{"query-status", "query-current-machine", "query-kvm"})
+	if len(raw) != 3 {
+		t.Fatalf("Expected 3 raw results, got %d: %v", len(raw), raw)
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatalf("Failed to marshal raw results: %v", err)
+	}
+	output := string(data)
+
+	expected := []string{
+		`"query-status":{"return":{"running":true`,
+		`"query-kvm":{"return":{"enabled":true,"present":true}}`,
+		`"query-current-machine":{"error":{"class":"CommandNotFound"`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected raw output to contain %s, got %s", want, output)
+		}
+	}
+}
+
+// TestQMPClientQueryChardev tests parsing of the chardev list
+func TestQMPClientQueryChardev(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	chardevs, err := client.QueryChardev(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query chardevs: %v", err)
+	}
+	if len(chardevs) != 2 {
+		t.Fatalf("Expected 2 chardevs, got %d", len(chardevs))
+	}
+	if label, _ := chardevs[0]["label"].(string); label != "compat_monitor1" {
+		t.Errorf("Expected first chardev label 'compat_monitor1', got %v", chardevs[0]["label"])
+	}
+}
+
+func TestQMPClientSendCommandRaw(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	response, err := client.SendCommandRaw(ctx, "{\n  \"execute\": \"query-status\"\n}")
+	if err != nil {
+		t.Fatalf("SendCommandRaw() error = %v", err)
+	}
+	var status map[string]interface{}
+	if err := json.Unmarshal(response.Return, &status); err != nil {
+		t.Fatalf("Failed to parse status: %v", err)
+	}
+	if status["status"] != "running" {
+		t.Errorf("Expected status 'running', got %v", status["status"])
+	}
+
+	commands := server.GetCommands()
+	if len(commands) == 0 || commands[len(commands)-1] != `{"execute":"query-status"}` {
+		t.Errorf("Expected compacted query-status to reach the server, got %v", commands)
+	}
+
+	for _, invalid := range []string{`{"execute":`, `{"arguments":{}}`, `{"execute":42}`} {
+		if _, err := client.SendCommandRaw(ctx, invalid); err == nil {
+			t.Errorf("SendCommandRaw(%q) expected error", invalid)
+		}
+	}
+}
+
+func TestQMPClientCommandError(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+	server.commandErrors = map[string]QMPError{
+		"query-status":   {Class: "DeviceNotFound", Desc: "Device 'virtio0' not found"},
+		"query-commands": {Class: "CommandNotFound", Desc: "The command query-commands has not been found"},
+	}
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.CheckStatus(ctx)
+	var cmdErr *QMPCommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected QMPCommandError, got %T: %v", err, err)
+	}
+	if cmdErr.Class != "DeviceNotFound" || cmdErr.Command != "query-status" {
+		t.Errorf("Expected DeviceNotFound from query-status, got %s from %s", cmdErr.Class, cmdErr.Command)
+	}
+	if !errors.Is(err, ErrDeviceNotFound) || errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("errors.Is mismatch for %v", err)
+	}
+
+	_, err = client.QueryCommands(ctx)
+	if !errors.As(err, &cmdErr) || cmdErr.Class != "CommandNotFound" {
+		t.Errorf("Expected CommandNotFound from query-commands, got %v", err)
+	}
+	if !errors.Is(fmt.Errorf("wrapped: %w", err), &QMPCommandError{Command: "query-commands"}) {
+		t.Errorf("Expected wrapped error to match by command")
+	}
+}
+
+func TestQMPClientQueryBlockStatsRates(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	first, err := client.QueryBlockStats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query blockstats: %v", err)
+	}
+	second, err := client.QueryBlockStats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query blockstats: %v", err)
+	}
+	if len(second) != 2 || second[0].Device != "drive0" || second[1].Device != "#block123" {
+		t.Fatalf("Unexpected devices: %+v", second)
+	}
+
+	rates := BlockRates(first, second, 2*time.Second)
+	if len(rates) != 2 {
+		t.Fatalf("Expected 2 rates, got %d", len(rates))
+	}
+	want := BlockRate{Device: "drive0", ReadBytesPS: 524288, WriteBytesPS: 262144, ReadIOPS: 32, WriteIOPS: 16}
+	if rates[0] != want {
+		t.Errorf("BlockRates() = %+v, want %+v", rates[0], want)
+	}
+	if rates[1] != (BlockRate{Device: "#block123"}) {
+		t.Errorf("Expected idle device to have zero rates, got %+v", rates[1])
+	}
+
+	if rates := BlockRates(first, second, 0); rates != nil {
+		t.Errorf("Expected no rates for zero interval, got %+v", rates)
+	}
+}
+
+func TestQMPClientChangeMedium(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+	server.lockedTrays = map[string]bool{"ide1-cd1": true}
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	lastCommand := func() map[string]interface{} {
+		commands := server.GetCommands()
+		var cmd map[string]interface{}
+		if err := json.Unmarshal([]byte(commands[len(commands)-1]), &cmd); err != nil {
+			t.Fatalf("Failed to parse sent command: %v", err)
+		}
+		return cmd
+	}
+
+	if err := client.ChangeMedium(ctx, "ide1-cd0", "/isos/disk2.iso", "raw"); err != nil {
+		t.Fatalf("ChangeMedium() error = %v", err)
+	}
+	cmd := lastCommand()
+	want := map[string]interface{}{"device": "ide1-cd0", "filename": "/isos/disk2.iso", "format": "raw"}
+	if cmd["execute"] != "blockdev-change-medium" || !reflect.DeepEqual(cmd["arguments"], want) {
+		t.Errorf("Unexpected command payload: %v", cmd)
+	}
+
+	// Without a format QEMU probes it
+	if err := client.ChangeMedium(ctx, "ide1-cd0", "/isos/disk3.iso", ""); err != nil {
+		t.Fatalf("ChangeMedium() error = %v", err)
+	}
+	if args, _ := lastCommand()["arguments"].(map[string]interface{}); args["format"] != nil {
+		t.Errorf("Expected no format argument, got %v", args)
+	}
+
+	// A locked tray is force-ejected and the change retried
+	if err := client.ChangeMedium(ctx, "ide1-cd1", "/isos/disk2.iso", "raw"); err != nil {
+		t.Fatalf("ChangeMedium() with locked tray error = %v", err)
+	}
+	commands := server.GetCommands()
+	if n := len(commands); n < 3 || !strings.Contains(commands[n-2], "eject -f ide1-cd1") {
+		t.Errorf("Expected forced eject before retry, got %v", commands)
+	}
+
+	err = client.ChangeMedium(ctx, "missing", "/isos/disk2.iso", "raw")
+	if !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Expected DeviceNotFound, got %v", err)
+	}
+}
+
+func TestQMPClientQueryBlockJobs(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	jobs, err := client.QueryBlockJobs(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query block jobs: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("Expected 1 block job, got %d", len(jobs))
+	}
+	if jobs[0].Device != "drive0" || jobs[0].Type != "mirror" {
+		t.Errorf("Expected mirror job on drive0, got %+v", jobs[0])
+	}
+	if pct := jobs[0].Percent(); pct != 25 {
+		t.Errorf("Expected 25%% progress, got %.1f%%", pct)
+	}
+	if pct := (BlockJob{}).Percent(); pct != 0 {
+		t.Errorf("Expected 0%% progress for a job without length, got %.1f%%", pct)
+	}
+}
+
+func TestQMPClientQueryPCI(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	buses, err := client.QueryPCI(ctx)
+	if err != nil {
+		t.Fatalf("Failed to query PCI: %v", err)
+	}
+	if len(buses) != 1 || len(buses[0].Devices) != 2 {
+		t.Fatalf("Expected 1 bus with 2 devices, got %+v", buses)
+	}
+
+	bridge := buses[0].Devices[1]
+	if bridge.Bridge == nil || bridge.Bridge.Bus.Secondary != 1 {
+		t.Fatalf("Expected a bridge to bus 1, got %+v", bridge)
+	}
+	if len(bridge.Bridge.Devices) != 1 {
+		t.Fatalf("Expected 1 device behind the bridge, got %d", len(bridge.Bridge.Devices))
+	}
+	nic := bridge.Bridge.Devices[0]
+	if nic.QdevID != "net0" || nic.ID.Vendor != 0x1af4 || nic.ID.Device != 0x1041 {
+		t.Errorf("Unexpected nested device: %+v", nic)
+	}
+	if nic.Address() != "01:00.0" {
+		t.Errorf("Expected address 01:00.0, got %s", nic.Address())
+	}
+	if nic.IRQ == nil || *nic.IRQ != 10 {
+		t.Errorf("Expected IRQ 10, got %v", nic.IRQ)
+	}
+}
+
+func TestQMPClientInjectNMI(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.InjectNMI(ctx); err != nil {
+		t.Fatalf("InjectNMI() error = %v", err)
+	}
+	commands := server.GetCommands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, `"inject-nmi"`) {
+		t.Errorf("Expected inject-nmi to be sent, got %s", last)
+	}
+
+	// QEMU refuses NMIs on machines without support for them
+	server.commandErrors = map[string]QMPError{
+		"inject-nmi": {Class: "GenericError", Desc: "Injecting an NMI is not supported on this machine"},
+	}
+	var cmdErr *QMPCommandError
+	if err := client.InjectNMI(ctx); !errors.As(err, &cmdErr) {
+		t.Errorf("Expected QMPCommandError, got %T: %v", err, err)
+	}
+}
+
+func TestQMPClientSystemWakeup(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.SystemWakeup(ctx); err != nil {
+		t.Fatalf("SystemWakeup() error = %v", err)
+	}
+	commands := server.GetCommands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, `"system_wakeup"`) {
+		t.Errorf("Expected system_wakeup to be sent, got %s", last)
+	}
+
+	// QEMU refuses to wake up a guest which is not suspended
+	server.commandErrors = map[string]QMPError{
+		"system_wakeup": {Class: "GenericError", Desc: "Unable to wake up: guest is not in suspended state"},
+	}
+	var cmdErr *QMPCommandError
+	if err := client.SystemWakeup(ctx); !errors.As(err, &cmdErr) {
+		t.Errorf("Expected QMPCommandError, got %T: %v", err, err)
+	}
+}
+
+func TestQMPClientResume(t *testing.T) {
+	tests := []struct {
+		name            string
+		pausedAfterCont int
+		wantErr         bool
+	}{
+		{"runs after a few polls", 2, false},
+		{"stays paused", -1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, socketPath, err := NewMockQEMUServer(t)
+			if err != nil {
+				t.Fatalf("Failed to create mock server: %v", err)
+			}
+			defer server.Close()
+			defer os.RemoveAll(filepath.Dir(socketPath))
+			server.vmStatus = "paused"
+			server.pausedAfterCont = tt.pausedAfterCont
+
+			logger := &TestLogger{t: t}
+			client := NewQMPClientWithLogger(socketPath, logger)
+
+			ctx := context.Background()
+			if err := client.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer client.Close()
+
+			status, err := client.Resume(ctx, 10*time.Millisecond, 200*time.Millisecond)
+			if tt.wantErr {
+				if !errors.Is(err, ErrNotResumed) {
+					t.Fatalf("Expected ErrNotResumed, got %v", err)
+				}
+				if !strings.Contains(err.Error(), "paused") {
+					t.Errorf("Expected the error to name the state, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resume() error = %v", err)
+			}
+			if !status.Running || status.Status != "running" {
+				t.Errorf("Expected the VM to run, got %+v", status)
+			}
+
+			// cont once, then one query-status per poll until the VM runs
+			var conts, polls int
+			for _, command := range server.GetCommands() {
+				if strings.Contains(command, `"cont"`) {
+					conts++
+				}
+				if strings.Contains(command, "query-status") {
+					polls++
+				}
+			}
+			if conts != 1 || polls != 3 {
+				t.Errorf("Expected 1 cont and 3 status polls, got %d and %d", conts, polls)
+			}
+		})
+	}
+}
+
+func TestQMPClientQueryRxFilter(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	filters, err := client.QueryRxFilter(ctx, "")
+	if err != nil {
+		t.Fatalf("Failed to query rx filters: %v", err)
+	}
+	if len(filters) != 1 {
+		t.Fatalf("Expected 1 NIC, got %d", len(filters))
+	}
+	nic := filters[0]
+	if nic.Name != "net0" || nic.MainMAC != "52:54:00:12:34:56" || nic.Multicast != "normal" {
+		t.Errorf("Unexpected filter state: %+v", nic)
+	}
+	if !nic.BroadcastAllowed || len(nic.MulticastTable) != 2 {
+		t.Errorf("Expected broadcast allowed and 2 multicast entries, got %+v", nic)
+	}
+
+	// A device name is passed on, no arguments are sent without one
+	if _, err := client.QueryRxFilter(ctx, "net1"); err == nil {
+		t.Error("Expected an error for a device that is not a NIC")
+	}
+	commands := server.GetCommands()
+	if !strings.Contains(commands[len(commands)-1], `"name":"net1"`) {
+		t.Errorf("Expected the device name to be sent, got %s", commands[len(commands)-1])
+	}
+	if strings.Contains(commands[len(commands)-2], "arguments") {
+		t.Errorf("Expected no arguments without a device, got %s", commands[len(commands)-2])
+	}
+}
+
+// TestQMPClientPowerdownWithEventConfirmation tests that a single powerdown
+// confirmed by a SHUTDOWN event suffices
+func TestQMPClientPowerdownWithEventConfirmation(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+	server.shutdownOnPowerdown = true
+	server.exitOnPowerdown = true
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	success, err := client.PowerdownWithEventConfirmation(ctx, 100*time.Millisecond, 500*time.Millisecond, true)
+	if err != nil {
+		t.Fatalf("PowerdownWithEventConfirmation failed: %v", err)
+	}
+	if !success {
+		t.Error("Expected powerdown to be confirmed")
+	}
+
+	powerdowns := 0
+	for _, command := range server.GetCommands() {
+		if strings.Contains(command, "system_powerdown") {
+			powerdowns++
+		}
+		if strings.Contains(command, `"quit"`) {
+			t.Errorf("Expected no forced quit, got %s", command)
+		}
+	}
+	if powerdowns != 1 {
+		t.Errorf("Expected exactly 1 system_powerdown, got %d", powerdowns)
+	}
+}
+
+// TestQMPClientPowerdownNoShutdown tests that QEMU staying in the shutdown run
+// state after the SHUTDOWN event, as with -no-shutdown, is told to quit
+func TestQMPClientPowerdownNoShutdown(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+	server.shutdownOnPowerdown = true
+	server.vmStatus = "shutdown"
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	start := time.Now()
+	success, err := client.PowerdownWithEventConfirmation(ctx, 50*time.Millisecond, 5*time.Second, false)
+	if err != nil || !success {
+		t.Fatalf("PowerdownWithEventConfirmation() = %t, %v, want success", success, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected to return on the shutdown run state, waited %s", elapsed)
+	}
+
+	commands := server.GetCommands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, `"quit"`) {
+		t.Errorf("Expected QEMU to be told to quit, got %s", last)
+	}
+}
+
+// TestQMPClientPowerdownNoExit tests that QEMU still running after the timeout
+// is reported as not stopped when it may not be forced
+func TestQMPClientPowerdownNoExit(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+	server.shutdownOnPowerdown = true
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	success, err := client.PowerdownWithEventConfirmation(ctx, 50*time.Millisecond, 300*time.Millisecond, false)
+	if err != nil || success {
+		t.Fatalf("PowerdownWithEventConfirmation() = %t, %v, want no success", success, err)
+	}
+	for _, command := range server.GetCommands() {
+		if strings.Contains(command, `"quit"`) {
+			t.Errorf("Expected no quit without force, got %s", command)
+		}
+	}
+}
+
+// TestQMPClientPowerdownFallbackContext tests that the fallback after a missing
+// SHUTDOWN event still runs when the caller's deadline expired during the wait
+func TestQMPClientPowerdownFallbackContext(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	success, err := client.PowerdownWithEventConfirmation(ctx, 100*time.Millisecond, 200*time.Millisecond, true)
+	if err != nil || !success {
+		t.Fatalf("PowerdownWithEventConfirmation() = %t, %v, want success through the forced quit", success, err)
+	}
+
+	commands := server.GetCommands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, `"quit"`) {
+		t.Errorf("Expected the fallback to quit QEMU, got %s", last)
+	}
+}
+
+// TestQMPClientWaitForEventTimeout tests that WaitForEvent honors its context
+func TestQMPClientWaitForEventTimeout(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.WaitForEvent(waitCtx, "SHUTDOWN"); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	// The connection must remain usable after the timed out wait
+	if err := client.Ping(ctx); err != nil {
+		t.Errorf("Ping after WaitForEvent timeout failed: %v", err)
+	}
+}
+
+// TestQMPClientQueryStatus tests the typed query-status result, including a panicked guest
+func TestQMPClientQueryStatus(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	logger := &TestLogger{t: t}
+	client := NewQMPClientWithLogger(socketPath, logger)
+
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	status, err := client.QueryStatus(ctx)
+	if err != nil {
+		t.Fatalf("QueryStatus() failed: %v", err)
+	}
+	if status.Status != "running" || !status.Running || status.IsPanicked() {
+		t.Errorf("Expected a running VM, got %+v", status)
+	}
+
+	server.vmStatus = "guest-panicked"
+	status, err = client.QueryStatus(ctx)
+	if err != nil {
+		t.Fatalf("QueryStatus() failed: %v", err)
+	}
+	if !status.IsPanicked() || status.Running {
+		t.Errorf("Expected a panicked, stopped VM, got %+v", status)
+	}
+	if status.IsShutdown() {
+		t.Error("A panicked guest must not be reported as a clean shutdown")
+	}
+}
+
+func TestQMPClientStatusTTL(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	statusQueries := func() int {
+		n := 0
+		for _, command := range server.GetCommands() {
+			if strings.Contains(command, `"query-status"`) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Disabled by default, every call asks QEMU
+	client.IsRunning(ctx)
+	client.IsRunning(ctx)
+	if n := statusQueries(); n != 2 {
+		t.Fatalf("Expected 2 query-status commands without a TTL, got %d", n)
+	}
+
+	client.SetStatusTTL(time.Minute)
+	if !client.IsRunning(ctx) {
+		t.Fatal("Expected the VM to be running")
+	}
+	if !client.IsRunning(ctx) {
+		t.Fatal("Expected the cached status to report running")
+	}
+	if status, err := client.QueryStatus(ctx); err != nil || status.Status != "running" {
+		t.Fatalf("QueryStatus() = %+v, %v", status, err)
+	}
+	if n := statusQueries(); n != 3 {
+		t.Errorf("Expected calls within the TTL to reuse the result, got %d query-status commands", n)
+	}
+
+	// A command which may change the run state drops the cached result
+	if _, err := client.SendCommand(ctx, map[string]interface{}{"execute": "cont"}); err != nil {
+		t.Fatalf("Failed to send cont: %v", err)
+	}
+	client.IsRunning(ctx)
+	if n := statusQueries(); n != 4 {
+		t.Errorf("Expected a fresh query-status after cont, got %d query-status commands", n)
+	}
+
+	// An expired result is refreshed
+	client.SetStatusTTL(time.Millisecond)
+	client.IsRunning(ctx)
+	time.Sleep(5 * time.Millisecond)
+	client.IsRunning(ctx)
+	if n := statusQueries(); n != 6 {
+		t.Errorf("Expected an expired result to be refreshed, got %d query-status commands", n)
+	}
+}
+
+func TestQMPClientQueryCPUs(t *testing.T) {
+	tests := []struct {
+		name        string
+		supported   []string
+		wantCommand string
+		want        []CPUInfo
+	}{
+		{
+			name:        "query-cpus-fast",
+			supported:   []string{"query-commands", "query-status", "query-cpus-fast", "query-cpus"},
+			wantCommand: "query-cpus-fast",
+			want: []CPUInfo{
+				{Index: 0, QOMPath: "/machine/unattached/device[0]", ThreadID: 4242, Target: "x86_64"},
+				{Index: 1, QOMPath: "/machine/unattached/device[1]", ThreadID: 4243, Target: "x86_64"},
+			},
+		},
+		{
+			name:        "falls back to query-cpus",
+			supported:   []string{"query-commands", "query-status", "query-cpus"},
+			wantCommand: "query-cpus",
+			want: []CPUInfo{
+				{Index: 0, QOMPath: "/machine/unattached/device[0]", ThreadID: 4242, Target: "x86"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, socketPath, err := NewMockQEMUServer(t)
+			if err != nil {
+				t.Fatalf("Failed to create mock server: %v", err)
+			}
+			defer server.Close()
+			defer os.RemoveAll(filepath.Dir(socketPath))
+			server.supportedCommands = tt.supported
+
+			client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+			ctx := context.Background()
+			if err := client.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer client.Close()
+
+			cpus, err := client.QueryCPUs(ctx)
+			if err != nil {
+				t.Fatalf("QueryCPUs() error = %v", err)
+			}
+			if !reflect.DeepEqual(cpus, tt.want) {
+				t.Errorf("QueryCPUs() = %+v, want %+v", cpus, tt.want)
+			}
+
+			sent := false
+			for _, command := range server.GetCommands() {
+				if strings.Contains(command, fmt.Sprintf("%q", tt.wantCommand)) {
+					sent = true
+				}
+			}
+			if !sent {
+				t.Errorf("Expected %s to be sent, got %v", tt.wantCommand, server.GetCommands())
+			}
+		})
+	}
+}
+
+func TestQMPClientSupportsCommand(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+	server.supportedCommands = []string{"query-commands", "query-status", "query-pci"}
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	for name, want := range map[string]bool{"query-pci": true, "query-status": true, "query-cpus-fast": false, "inject-nmi": false} {
+		got, err := client.SupportsCommand(ctx, name)
+		if err != nil {
+			t.Fatalf("SupportsCommand(%s) error = %v", name, err)
+		}
+		if got != want {
+			t.Errorf("SupportsCommand(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	// The command list is only queried once per connection
+	queries := 0
+	for _, command := range server.GetCommands() {
+		if strings.Contains(command, `"query-commands"`) {
+			queries++
+		}
+	}
+	if queries != 1 {
+		t.Errorf("Expected a single query-commands, got %d", queries)
+	}
+}
+
+func TestQMPClientSendBatch(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	responses, err := client.SendBatch(ctx, []map[string]interface{}{
+		{"execute": "query-status"},
+		{"execute": "query-chardev"},
+	})
+	if err != nil {
+		t.Fatalf("SendBatch() error = %v", err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+	var status VMStatus
+	if err := json.Unmarshal(responses[0].Return, &status); err != nil || status.Status != "running" {
+		t.Errorf("Expected the query-status response first, got %s", responses[0].Return)
+	}
+	var chardevs []map[string]interface{}
+	if err := json.Unmarshal(responses[1].Return, &chardevs); err != nil || len(chardevs) != 2 {
+		t.Errorf("Expected the query-chardev response second, got %s", responses[1].Return)
+	}
+
+	// All responses are collected even if a command fails
+	server.commandErrors = map[string]QMPError{"device_add": {Class: "GenericError", Desc: "Duplicate ID 'disk1'"}}
+	responses, err = client.SendBatch(ctx, []map[string]interface{}{
+		{"execute": "device_add", "arguments": map[string]interface{}{"driver": "virtio-blk-pci", "id": "disk1"}},
+		{"execute": "query-status"},
+	})
+	var cmdErr *QMPCommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Command != "device_add" {
+		t.Fatalf("Expected a device_add error, got %v", err)
+	}
+	if len(responses) != 2 || responses[1].Return == nil {
+		t.Errorf("Expected both responses despite the error, got %v", responses)
+	}
+
+	// The connection stays in sync for single commands
+	if _, err := client.QueryStatus(ctx); err != nil {
+		t.Errorf("QueryStatus() after a batch error = %v", err)
+	}
+}
+
+func TestQMPClientDumpGuestMemory(t *testing.T) {
+	for _, fails := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fails=%v", fails), func(t *testing.T) {
+			server, socketPath, err := NewMockQEMUServer(t)
+			if err != nil {
+				t.Fatalf("Failed to create mock server: %v", err)
+			}
+			defer server.Close()
+			defer os.RemoveAll(filepath.Dir(socketPath))
+			server.dumpFails = fails
+
+			client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+			ctx := context.Background()
+			if err := client.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer client.Close()
+
+			// Waiting without a dump having been started fails right away
+			if _, err := client.WaitForDump(ctx, time.Millisecond, nil); err == nil {
+				t.Fatal("Expected WaitForDump() without a dump to fail")
+			}
+
+			if err := client.DumpGuestMemory(ctx, "/tmp/guest.elf", true); err != nil {
+				t.Fatalf("DumpGuestMemory() error = %v", err)
+			}
+			commands := server.GetCommands()
+			var sent map[string]interface{}
+			if err := json.Unmarshal([]byte(commands[len(commands)-1]), &sent); err != nil {
+				t.Fatalf("Failed to parse sent command: %v", err)
+			}
+			args, _ := sent["arguments"].(map[string]interface{})
+			if args["protocol"] != "file:/tmp/guest.elf" || args["paging"] != true || args["detach"] != true {
+				t.Errorf("Unexpected dump-guest-memory arguments: %v", args)
+			}
+
+			var percents []float64
+			status, err := client.WaitForDump(ctx, time.Millisecond, func(status DumpStatus) {
+				percents = append(percents, status.Percent())
+			})
+			if fails {
+				if !errors.Is(err, ErrDumpFailed) {
+					t.Fatalf("Expected ErrDumpFailed, got %v", err)
+				}
+				if status == nil || status.Status != "failed" {
+					t.Errorf("Expected the failed status to be returned, got %+v", status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WaitForDump() error = %v", err)
+			}
+			if status.Status != "completed" || status.Completed != status.Total {
+				t.Errorf("Expected a completed dump, got %+v", status)
+			}
+			if want := []float64{25, 50, 75, 100}; !reflect.DeepEqual(percents, want) {
+				t.Errorf("Progress = %v, want %v", percents, want)
+			}
+		})
+	}
+}
+
+func TestQMPClientHotplugCPU(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	cpus, err := client.QueryHotpluggableCPUs(ctx)
+	if err != nil {
+		t.Fatalf("QueryHotpluggableCPUs() error = %v", err)
+	}
+	if len(cpus) != 4 || cpus[0].Plugged() || !cpus[3].Plugged() || cpus[3].DeviceID() != "" {
+		t.Fatalf("Unexpected hotpluggable CPUs: %+v", cpus)
+	}
+
+	// Boot CPUs cannot be unplugged
+	if _, err := client.DelCPU(ctx, ""); !errors.Is(err, ErrNoCPUSlot) {
+		t.Errorf("Expected ErrNoCPUSlot without hotplugged CPUs, got %v", err)
+	}
+
+	// The first free slot is used, with its props passed to device_add
+	slot, id, err := client.AddCPU(ctx)
+	if err != nil {
+		t.Fatalf("AddCPU() error = %v", err)
+	}
+	if id != "cpu-2-0-0" || slot.Props["socket-id"] != 2 {
+		t.Errorf("AddCPU() = %+v, %q, want socket 2 as cpu-2-0-0", slot, id)
+	}
+	commands := server.GetCommands()
+	var sent map[string]interface{}
+	if err := json.Unmarshal([]byte(commands[len(commands)-1]), &sent); err != nil {
+		t.Fatalf("Failed to parse sent command: %v", err)
+	}
+	want := map[string]interface{}{
+		"driver":    "qemu64-x86_64-cpu",
+		"id":        "cpu-2-0-0",
+		"socket-id": float64(2),
+		"core-id":   float64(0),
+		"thread-id": float64(0),
+	}
+	if sent["execute"] != "device_add" || !reflect.DeepEqual(sent["arguments"], want) {
+		t.Errorf("Unexpected device_add command: %v", sent)
+	}
+
+	if _, id, err = client.AddCPU(ctx); err != nil || id != "cpu-3-0-0" {
+		t.Fatalf("AddCPU() = %q, %v, want cpu-3-0-0", id, err)
+	}
+	if _, _, err := client.AddCPU(ctx); !errors.Is(err, ErrNoCPUSlot) {
+		t.Errorf("Expected ErrNoCPUSlot with all slots in use, got %v", err)
+	}
+
+	// The last hotplugged CPU is removed first
+	if id, err := client.DelCPU(ctx, ""); err != nil || id != "cpu-3-0-0" {
+		t.Errorf("DelCPU() = %q, %v, want cpu-3-0-0", id, err)
+	}
+	if id, err := client.DelCPU(ctx, "cpu-2-0-0"); err != nil || id != "cpu-2-0-0" {
+		t.Errorf("DelCPU(cpu-2-0-0) = %q, %v", id, err)
+	}
+	if _, err := client.DelCPU(ctx, "cpu-2-0-0"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Expected ErrDeviceNotFound for a removed CPU, got %v", err)
+	}
+}
+
+func TestQMPClientSetVNCPassword(t *testing.T) {
+	tests := []struct {
+		name          string
+		commandErrors map[string]QMPError
+		wantCommand   string
+		wantArgs      map[string]interface{}
+		wantErr       bool
+	}{
+		{
+			name:        "set_password",
+			wantCommand: "set_password",
+			wantArgs:    map[string]interface{}{"protocol": "vnc", "password": "s3cret"},
+		},
+		{
+			name:          "falls back to change-vnc-password",
+			commandErrors: map[string]QMPError{"set_password": {Class: "CommandNotFound", Desc: "The command set_password has not been found"}},
+			wantCommand:   "change-vnc-password",
+			wantArgs:      map[string]interface{}{"password": "s3cret"},
+		},
+		{
+			name:          "no VNC display",
+			commandErrors: map[string]QMPError{"set_password": {Class: "GenericError", Desc: "Could not set password"}},
+			wantCommand:   "set_password",
+			wantArgs:      map[string]interface{}{"protocol": "vnc", "password": "s3cret"},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, socketPath, err := NewMockQEMUServer(t)
+			if err != nil {
+				t.Fatalf("Failed to create mock server: %v", err)
+			}
+			defer server.Close()
+			defer os.RemoveAll(filepath.Dir(socketPath))
+			server.commandErrors = tt.commandErrors
+
+			client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+			ctx := context.Background()
+			if err := client.Connect(ctx); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer client.Close()
+
+			err = client.SetVNCPassword(ctx, "s3cret")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetVNCPassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			commands := server.GetCommands()
+			var sent map[string]interface{}
+			if err := json.Unmarshal([]byte(commands[len(commands)-1]), &sent); err != nil {
+				t.Fatalf("Failed to parse sent command: %v", err)
+			}
+			if sent["execute"] != tt.wantCommand || !reflect.DeepEqual(sent["arguments"], tt.wantArgs) {
+				t.Errorf("Sent %v, want %s with %v", sent, tt.wantCommand, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestQMPClientQueryVNC(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	vnc, err := client.QueryVNC(ctx)
+	if err != nil {
+		t.Fatalf("QueryVNC() failed: %v", err)
+	}
+	if !vnc.Enabled || vnc.Host != "127.0.0.1" || vnc.Service != "5901" || vnc.Family != "ipv4" || len(vnc.Clients) != 1 {
+		t.Errorf("Unexpected VNC info: %+v", vnc)
+	}
+
+	// The mock is built without SPICE, like many QEMU packages
+	if _, err := client.QuerySpice(ctx); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("Expected QuerySpice() to fail with ErrCommandNotFound, got %v", err)
+	}
+}
+
+func TestQMPClientQueryTPM(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	tpms, err := client.QueryTPM(ctx)
+	if err != nil {
+		t.Fatalf("QueryTPM() failed: %v", err)
+	}
+	if len(tpms) != 1 {
+		t.Fatalf("Expected one TPM, got %+v", tpms)
+	}
+	tpm := tpms[0]
+	if tpm.ID != "tpm0" || tpm.Model != "tpm-tis" || tpm.Options.Type != "emulator" || tpm.Options.Data.Chardev != "chrtpm" {
+		t.Errorf("Unexpected TPM info: %+v", tpm)
+	}
+}
+
+func TestQMPClientResetRTCReinjection(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.ResetRTCReinjection(ctx); err != nil {
+		t.Fatalf("ResetRTCReinjection() failed: %v", err)
+	}
+	commands := server.GetCommands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, `"execute":"rtc-reset-reinjection"`) || strings.Contains(last, "arguments") {
+		t.Errorf("Expected a bare rtc-reset-reinjection, sent %s", last)
+	}
+
+	// Targets without an in-kernel RTC do not know the command
+	server.mu.Lock()
+	server.commandErrors = map[string]QMPError{"rtc-reset-reinjection": {Class: "CommandNotFound", Desc: "The command rtc-reset-reinjection has not been found"}}
+	server.mu.Unlock()
+	if err := client.ResetRTCReinjection(ctx); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("Expected ErrCommandNotFound, got %v", err)
+	}
+}
+
+func TestQMPClientFdsets(t *testing.T) {
+	server, socketPath, err := NewMockQEMUServer(t)
+	if err != nil {
+		t.Fatalf("Failed to create mock server: %v", err)
+	}
+	defer server.Close()
+	defer os.RemoveAll(filepath.Dir(socketPath))
+
+	client := NewQMPClientWithLogger(socketPath, &TestLogger{t: t})
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	fdsets, err := client.QueryFdsets(ctx)
+	if err != nil {
+		t.Fatalf("QueryFdsets() failed: %v", err)
+	}
+	want := []Fdset{{ID: 1, FDs: []FdsetFD{{FD: 42, Opaque: "luks-key"}, {FD: 43}}}}
+	if !reflect.DeepEqual(fdsets, want) {
+		t.Errorf("QueryFdsets() = %+v, want %+v", fdsets, want)
+	}
+
+	// The descriptor must arrive with the command, the mock writes through it
+	keyPath := filepath.Join(t.TempDir(), "key")
+	file, err := os.Create(keyPath)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := client.AddFd(ctx, file, 1, "luks-key")
+	if err != nil {
+		t.Fatalf("AddFd() failed: %v", err)
+	}
+	if info.FdsetID != 1 {
+		t.Errorf("Expected fdset 1, got %d", info.FdsetID)
+	}
+	received := os.NewFile(uintptr(info.FD), "received")
+	defer received.Close()
+	if _, err := received.WriteString("secret"); err != nil {
+		t.Fatalf("Failed to write through the received descriptor: %v", err)
+	}
+	if data, _ := os.ReadFile(keyPath); string(data) != "secret" {
+		t.Errorf("Expected the passed descriptor to refer to the file, file holds %q", data)
+	}
+
+	commands := server.GetCommands()
+	if last := commands[len(commands)-1]; !strings.Contains(last, `"fdset-id":1`) || !strings.Contains(last, `"opaque":"luks-key"`) {
+		t.Errorf("Expected add-fd with fdset-id and opaque, sent %s", last)
+	}
+
+	// Without a fdset-id QEMU creates a new fdset
+	if _, err := client.AddFd(ctx, file, -1, ""); err != nil {
+		t.Fatalf("AddFd() into a new fdset failed: %v", err)
+	}
+	commands = server.GetCommands()
+	if last := commands[len(commands)-1]; strings.Contains(last, "fdset-id") || strings.Contains(last, "opaque") {
+		t.Errorf("Expected add-fd without fdset-id and opaque, sent %s", last)
+	}
+}
@@ -4,9 +4,11 @@ package internal
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"qqmgr/internal/config"
+	"strconv"
 )
 
 // GenerateSSHConfig generates an SSH config file for a specific VM
@@ -37,33 +39,34 @@ func GenerateSSHConfig(appCtx *AppContext, vmName string) (string, error) {
 	defer file.Close()
 
 	// Create control directory for SSH control sockets
-	controlDir := filepath.Join(filepath.Dir(sshConfigPath), "ssh")
+	controlDir := vmEntry.SshControlDir()
 	if err := os.MkdirAll(controlDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create SSH control directory: %w", err)
 	}
 
+	// ssh uses the first value given for an option, so the VM's user and jump
+	// hosts go first and replace the same options given directly
+	overridden := map[string]bool{
+		"User":      vm.SSH.User != "",
+		"ProxyJump": vm.SSH.ProxyJump != "",
+	}
+	if vm.SSH.User != "" {
+		fmt.Fprintf(file, "User %s\n", vm.SSH.User)
+	}
+	if vm.SSH.ProxyJump != "" {
+		fmt.Fprintf(file, "ProxyJump %s\n", vm.SSH.ProxyJump)
+	}
+
 	// Write global SSH options with ControlPath fix
 	for key, value := range appCtx.Config.SSH {
-		if key == "ControlPath" {
-			// Convert relative ControlPath to absolute path
-			if strValue, ok := value.(string); ok {
-				if !filepath.IsAbs(strValue) {
-					// Replace relative path with absolute path in control directory
-					controlPath := filepath.Join(controlDir, filepath.Base(strValue))
-					fmt.Fprintf(file, "%s %s\n", key, controlPath)
-				} else {
-					fmt.Fprintf(file, "%s %s\n", key, strValue)
-				}
-			} else {
-				fmt.Fprintf(file, "%s %v\n", key, value)
-			}
-		} else {
-			if strValue, ok := value.(string); ok {
-				fmt.Fprintf(file, "%s %s\n", key, strValue)
-			} else {
-				fmt.Fprintf(file, "%s %v\n", key, value)
-			}
+		if overridden[key] {
+			continue
 		}
+		if strValue, ok := value.(string); ok && key == "ControlPath" && !filepath.IsAbs(strValue) {
+			// Replace relative path with absolute path in control directory
+			value = filepath.Join(controlDir, filepath.Base(strValue))
+		}
+		fmt.Fprintf(file, "%s %s\n", key, formatSSHOption(value))
 	}
 
 	// Write VM-specific SSH options (excluding port and vm_port)
@@ -72,11 +75,10 @@ func GenerateSSHConfig(appCtx *AppContext, vmName string) (string, error) {
 		if len(key) > 0 && key[0] >= 'a' && key[0] <= 'z' {
 			continue
 		}
-		if strValue, ok := value.(string); ok {
-			fmt.Fprintf(file, "%s %s\n", key, strValue)
-		} else {
-			fmt.Fprintf(file, "%s %v\n", key, value)
+		if overridden[key] {
+			continue
 		}
+		fmt.Fprintf(file, "%s %s\n", key, formatSSHOption(value))
 	}
 
 	return sshConfigPath, nil
@@ -92,7 +94,7 @@ func GetSSHOptions(cfg *config.Config, vmName string) (map[string]interface{}, e
 	// Start with global options
 	options := make(map[string]interface{})
 	for k, v := range cfg.SSH {
-		options[k] = v
+		options[k] = normalizeSSHOption(v)
 	}
 
 	// Add VM-specific options (excluding port and vm_port)
@@ -101,8 +103,46 @@ func GetSSHOptions(cfg *config.Config, vmName string) (map[string]interface{}, e
 		if len(k) > 0 && k[0] >= 'a' && k[0] <= 'z' {
 			continue
 		}
-		options[k] = v
+		options[k] = normalizeSSHOption(v)
+	}
+
+	// [vm.x.ssh].user and proxy_jump take precedence over User and ProxyJump options
+	if vm.SSH.User != "" {
+		options["User"] = vm.SSH.User
+	}
+	if vm.SSH.ProxyJump != "" {
+		options["ProxyJump"] = vm.SSH.ProxyJump
 	}
 
 	return options, nil
 }
+
+// normalizeSSHOption converts a TOML option value to what ssh expects: booleans
+// become "yes" or "no" and whole floats integers, other values are unchanged
+func normalizeSSHOption(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return value
+}
+
+// formatSSHOption formats a TOML option value for an ssh config file
+func formatSSHOption(value interface{}) string {
+	switch v := normalizeSSHOption(value).(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -38,17 +38,7 @@ Compression = "yes"`
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	config, err := config.LoadFromFile(testFile)
-	if err != nil {
-		t.Fatalf("Failed to load config: %v", err)
-	}
-
-	// Test SSH config generation
-	sshConfigPath, err := GenerateSSHConfig(config, "test-vm", testFile)
-	if err != nil {
-		t.Fatalf("Failed to generate SSH config: %v", err)
-	}
-	defer os.Remove(sshConfigPath)
+	sshConfigPath := generateTestSSHConfig(t, testFile, "test-vm")
 
 	// Read the generated config
 	configData, err := os.ReadFile(sshConfigPath)
@@ -89,6 +79,112 @@ Compression = "yes"`
 	}
 }
 
+// generateTestSSHConfig loads the config at configPath and generates the SSH config of vmName
+func generateTestSSHConfig(t *testing.T, configPath, vmName string) string {
+	t.Helper()
+	cfg, err := config.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	appCtx, err := NewAppContext(cfg, configPath)
+	if err != nil {
+		t.Fatalf("Failed to create app context: %v", err)
+	}
+	defer appCtx.Close()
+
+	sshConfigPath, err := GenerateSSHConfig(appCtx, vmName)
+	if err != nil {
+		t.Fatalf("Failed to generate SSH config: %v", err)
+	}
+	return sshConfigPath
+}
+
+func TestSSHConfigUser(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testConfigContent := `[ssh]
+User = "global"
+StrictHostKeyChecking = "no"
+
+[defaults.vm.ssh]
+user = "debian"
+
+[vm.ubuntu]
+cmd = ["-nodefaults"]
+
+[vm.ubuntu.ssh]
+port = 2089
+user = "ubuntu"
+
+[vm.inherited]
+cmd = ["-nodefaults"]
+
+[vm.inherited.ssh]
+port = 2090`
+
+	testFile := filepath.Join(tempDir, "test.toml")
+	if err := os.WriteFile(testFile, []byte(testConfigContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for vmName, wantUser := range map[string]string{"ubuntu": "ubuntu", "inherited": "debian"} {
+		configData, err := os.ReadFile(generateTestSSHConfig(t, testFile, vmName))
+		if err != nil {
+			t.Fatalf("Failed to read generated SSH config: %v", err)
+		}
+		configContent := string(configData)
+
+		// ssh takes the first User directive, so it must be the only one
+		if !strings.HasPrefix(configContent, "User "+wantUser+"\n") {
+			t.Errorf("%s: expected config to start with 'User %s', got:\n%s", vmName, wantUser, configContent)
+		}
+		if strings.Count(configContent, "User ") != 1 {
+			t.Errorf("%s: expected a single User directive, got:\n%s", vmName, configContent)
+		}
+		if !strings.Contains(configContent, "StrictHostKeyChecking no") {
+			t.Errorf("%s: expected other global options to remain", vmName)
+		}
+	}
+
+	cfg, err := config.LoadFromFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	options, err := GetSSHOptions(cfg, "ubuntu")
+	if err != nil {
+		t.Fatalf("Failed to get SSH options: %v", err)
+	}
+	if options["User"] != "ubuntu" {
+		t.Errorf("Expected GetSSHOptions to report User ubuntu, got %v", options["User"])
+	}
+	if _, exists := options["user"]; exists {
+		t.Error("Expected the lowercase user key to be excluded from SSH options")
+	}
+}
+
+func TestSSHConfigWithoutUser(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testConfigContent := `[vm.test-vm]
+cmd = ["-nodefaults"]
+
+[vm.test-vm.ssh]
+port = 2089`
+
+	testFile := filepath.Join(tempDir, "test.toml")
+	if err := os.WriteFile(testFile, []byte(testConfigContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	configData, err := os.ReadFile(generateTestSSHConfig(t, testFile, "test-vm"))
+	if err != nil {
+		t.Fatalf("Failed to read generated SSH config: %v", err)
+	}
+	if strings.Contains(string(configData), "User") {
+		t.Errorf("Expected no User directive without a configured user, got:\n%s", configData)
+	}
+}
+
 func TestGetSSHOptions(t *testing.T) {
 	// Create a temporary directory for testing
 	tempDir := t.TempDir()
@@ -146,3 +242,140 @@ Compression = "yes"`
 		t.Error("Expected vm_port to be excluded from SSH options")
 	}
 }
+
+func TestSSHConfigProxyJump(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testConfigContent := `[ssh]
+ProxyJump = "global-bastion"
+StrictHostKeyChecking = "no"
+
+[vm.remote]
+cmd = ["-nodefaults"]
+
+[vm.remote.ssh]
+port = 2089
+vm_port = 2022
+proxy_jump = "admin@bastion.example.com"
+
+[vm.local]
+cmd = ["-nodefaults"]
+
+[vm.local.ssh]
+port = 2090`
+
+	testFile := filepath.Join(tempDir, "test.toml")
+	if err := os.WriteFile(testFile, []byte(testConfigContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for vmName, wantJump := range map[string]string{"remote": "admin@bastion.example.com", "local": "global-bastion"} {
+		configData, err := os.ReadFile(generateTestSSHConfig(t, testFile, vmName))
+		if err != nil {
+			t.Fatalf("Failed to read generated SSH config: %v", err)
+		}
+		configContent := string(configData)
+
+		if !strings.Contains(configContent, "ProxyJump "+wantJump+"\n") {
+			t.Errorf("%s: expected 'ProxyJump %s', got:\n%s", vmName, wantJump, configContent)
+		}
+		if strings.Count(configContent, "ProxyJump ") != 1 {
+			t.Errorf("%s: expected a single ProxyJump directive, got:\n%s", vmName, configContent)
+		}
+		for _, line := range strings.Split(configContent, "\n") {
+			if strings.HasPrefix(line, "port") || strings.HasPrefix(line, "vm_port") || strings.HasPrefix(line, "proxy_jump") {
+				t.Errorf("%s: expected lowercase qqmgr keys to be excluded, got %q", vmName, line)
+			}
+		}
+	}
+
+	cfg, err := config.LoadFromFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	options, err := GetSSHOptions(cfg, "remote")
+	if err != nil {
+		t.Fatalf("Failed to get SSH options: %v", err)
+	}
+	if options["ProxyJump"] != "admin@bastion.example.com" {
+		t.Errorf("Expected GetSSHOptions to report the VM's ProxyJump, got %v", options["ProxyJump"])
+	}
+}
+
+func TestSSHConfigOptionTypes(t *testing.T) {
+	testConfigContent := `[qemu]
+bin = "qemu-system-x86_64"
+
+[ssh]
+Compression = true
+ForwardAgent = false
+ServerAliveInterval = 60
+ConnectionAttempts = 3.0
+
+[vm.test-vm]
+cmd = ["-nodefaults"]
+
+[vm.test-vm.ssh]
+port = 2089
+StrictHostKeyChecking = false
+IPQoS = "lowdelay"`
+
+	testFile := filepath.Join(t.TempDir(), "test.toml")
+	if err := os.WriteFile(testFile, []byte(testConfigContent), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	configData, err := os.ReadFile(generateTestSSHConfig(t, testFile, "test-vm"))
+	if err != nil {
+		t.Fatalf("Failed to read generated SSH config: %v", err)
+	}
+	configContent := string(configData)
+
+	// ssh wants yes/no rather than true/false, and integers without a fraction
+	for _, want := range []string{
+		"Compression yes\n",
+		"ForwardAgent no\n",
+		"StrictHostKeyChecking no\n",
+		"ServerAliveInterval 60\n",
+		"ConnectionAttempts 3\n",
+		"IPQoS lowdelay\n",
+	} {
+		if !strings.Contains(configContent, want) {
+			t.Errorf("Expected %q in generated SSH config:\n%s", want, configContent)
+		}
+	}
+	if strings.Contains(configContent, "true") || strings.Contains(configContent, "false") {
+		t.Errorf("Expected no TOML booleans in generated SSH config:\n%s", configContent)
+	}
+
+	cfg, err := config.LoadFromFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	options, err := GetSSHOptions(cfg, "test-vm")
+	if err != nil {
+		t.Fatalf("Failed to get SSH options: %v", err)
+	}
+	if options["Compression"] != "yes" || options["StrictHostKeyChecking"] != "no" || options["ConnectionAttempts"] != int64(3) {
+		t.Errorf("Expected GetSSHOptions to format booleans and whole floats, got %v", options)
+	}
+}
+
+func TestFormatSSHOption(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{true, "yes"},
+		{false, "no"},
+		{int64(22), "22"},
+		{1.0, "1"},
+		{0.5, "0.5"},
+		{"/dev/null", "/dev/null"},
+	}
+	for _, tt := range tests {
+		if got := formatSSHOption(tt.value); got != tt.want {
+			t.Errorf("formatSSHOption(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -4,66 +4,406 @@ package tail
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Lines selects where the output of a file starts, like tail's -n: the last
+// Count lines, or with FromStart everything from line Count on. It implements
+// pflag.Value, parsing "N" and "+N".
+type Lines struct {
+	Count     int
+	FromStart bool
+}
+
+// String returns the lines in the form parsed by Set
+func (l *Lines) String() string {
+	if l.FromStart {
+		return "+" + strconv.Itoa(l.Count)
+	}
+	return strconv.Itoa(l.Count)
+}
+
+// Set parses "N" as the last N lines and "+N" as starting at line N
+func (l *Lines) Set(value string) error {
+	number, fromStart := strings.CutPrefix(value, "+")
+	count, err := strconv.Atoi(number)
+	if err != nil || count < 0 || strings.HasPrefix(number, "-") {
+		return fmt.Errorf("invalid number of lines '%s', expected N or +N", value)
+	}
+	l.Count, l.FromStart = count, fromStart
+	return nil
+}
+
+// Type names the flag value in help output
+func (l *Lines) Type() string {
+	return "lines"
+}
+
+// Filter selects lines like grep: those matching Pattern, or with Invert those
+// not matching it. The nil Filter selects every line.
+type Filter struct {
+	Pattern *regexp.Regexp
+	Invert  bool
+}
+
+// NewFilter compiles pattern into a Filter, which is nil for an empty pattern
+func NewFilter(pattern string, invert bool) (*Filter, error) {
+	if pattern == "" {
+		if invert {
+			return nil, fmt.Errorf("inverting the filter requires a pattern")
+		}
+		return nil, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+	return &Filter{Pattern: re, Invert: invert}, nil
+}
+
+// Match reports whether the filter selects line, which may end in a newline
+func (f *Filter) Match(line string) bool {
+	if f == nil {
+		return true
+	}
+	return f.Pattern.MatchString(strings.TrimSuffix(line, "\n")) != f.Invert
+}
+
 // ShowLastLines displays the last N lines from a file
 func ShowLastLines(filePath string, lines int) error {
+	return WriteLastLines(os.Stdout, filePath, lines)
+}
+
+// chunkSize is how much of a file is read at a time when searching it backwards
+const chunkSize = 64 * 1024
+
+// WriteLastLines writes the last N lines from a file to out. The file is searched
+// backwards in chunks, so memory use does not depend on the size of the file.
+func WriteLastLines(out io.Writer, filePath string, lines int) error {
+	return WriteLines(out, filePath, Lines{Count: lines})
+}
+
+// WriteLines writes the selected lines of a file to out
+func WriteLines(out io.Writer, filePath string, lines Lines) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	// Read all lines
-	var allLines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		allLines = append(allLines, scanner.Text())
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := info.Size()
+	offset, err := linesOffset(file, size, lines)
+	if err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	if offset == size {
+		return nil
 	}
 
-	if err := scanner.Err(); err != nil {
+	// Copy up to the size seen above, the file may still be growing
+	if _, err := io.Copy(out, io.NewSectionReader(file, offset, size-offset)); err != nil {
 		return fmt.Errorf("error reading file: %w", err)
 	}
 
-	// Show last N lines
-	start := 0
-	if len(allLines) > lines {
-		start = len(allLines) - lines
+	// Terminate a partial last line like the complete ones
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return fmt.Errorf("error reading file: %w", err)
 	}
+	if last[0] != '\n' {
+		fmt.Fprintln(out)
+	}
+
+	return nil
+}
 
-	for i := start; i < len(allLines); i++ {
-		fmt.Println(allLines[i])
+// WriteFilteredLines is WriteLines counting only the lines selected by filter:
+// the last N matching lines, or the matching lines from line N on
+func WriteFilteredLines(out io.Writer, filePath string, lines Lines, filter *Filter) error {
+	if filter == nil {
+		return WriteLines(out, filePath, lines)
 	}
 
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	return writeFilteredLines(out, io.NewSectionReader(file, 0, info.Size()), lines, filter)
+}
+
+// writeFilteredLines writes the selected lines of r matched by filter to out.
+// The file is read forwards, keeping no more than the last Count matches.
+func writeFilteredLines(out io.Writer, r io.Reader, lines Lines, filter *Filter) error {
+	if !lines.FromStart && lines.Count == 0 {
+		return nil
+	}
+
+	reader := bufio.NewReader(r)
+	var last []string
+	for number := 1; ; number++ {
+		line, err := reader.ReadString('\n')
+		if line != "" && filter.Match(line) && (!lines.FromStart || number >= lines.Count) {
+			// Terminate a partial last line like the complete ones
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			if lines.FromStart {
+				if _, err := io.WriteString(out, line); err != nil {
+					return err
+				}
+			} else {
+				if len(last) == lines.Count {
+					last = last[1:]
+				}
+				last = append(last, line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading file: %w", err)
+		}
+	}
+
+	for _, line := range last {
+		if _, err := io.WriteString(out, line); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// completeLinesEnd returns the offset following the last newline within the
+// first size bytes of file, where a partial last line starts
+func completeLinesEnd(file io.ReaderAt, size int64) (int64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return size, nil
+	}
+	return lastLinesOffset(file, size, 1)
+}
+
+// linesOffset returns the offset at which the selected lines of the first size
+// bytes of file start, size if none are selected
+func linesOffset(file io.ReaderAt, size int64, lines Lines) (int64, error) {
+	if lines.FromStart {
+		return lineOffset(file, size, lines.Count)
+	}
+	if lines.Count == 0 {
+		return size, nil
+	}
+	return lastLinesOffset(file, size, lines.Count)
+}
+
+// lineOffset returns the offset at which line n, counting from 1, of the first
+// size bytes of file starts, size if there are fewer lines
+func lineOffset(file io.ReaderAt, size int64, n int) (int64, error) {
+	buf := make([]byte, chunkSize)
+	newlines := 0
+	for start := int64(0); start < size && newlines < n-1; {
+		chunk := buf[:min(chunkSize, size-start)]
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i, b := range chunk {
+			if b != '\n' {
+				continue
+			}
+			newlines++
+			if newlines == n-1 {
+				return start + int64(i) + 1, nil
+			}
+		}
+		start += int64(len(chunk))
+	}
+	if newlines < n-1 {
+		return size, nil
+	}
+	return 0, nil
+}
+
+// lastLinesOffset returns the offset at which the last n lines of the first size
+// bytes of file start, reading backwards one chunk at a time
+func lastLinesOffset(file io.ReaderAt, size int64, n int) (int64, error) {
+	buf := make([]byte, chunkSize)
+	newlines := 0
+	for end := size; end > 0; {
+		start := max(end-chunkSize, 0)
+		chunk := buf[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := len(chunk) - 1; i >= 0; i-- {
+			// The newline ending the file does not start another line
+			if chunk[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			newlines++
+			if newlines == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
 // FollowFileOutput continuously monitors a file for new output
 func FollowFileOutput(filePath string) error {
+	return followFileOutput(context.Background(), filePath, Lines{}, nil, os.Stdout)
+}
+
+// followFileOutput continuously writes new output of a file selected by filter
+// to out, starting with the selected lines of its existing contents
+func followFileOutput(ctx context.Context, filePath string, lines Lines, filter *Filter, out io.Writer) error {
+	fmt.Printf("Following output from %s (Ctrl+C to stop)...\n", filepath.Base(filePath))
+	if filter == nil {
+		return FollowFileLines(ctx, filePath, lines, func(chunk string) {
+			fmt.Fprint(out, chunk)
+		})
+	}
+
+	// Matching needs whole lines, so a partial last line is left to following
+	filtered := &lineFilter{filter: filter, out: out}
+	return followFile(ctx, filePath, nil, func(file *os.File, size int64) (int64, error) {
+		end, err := completeLinesEnd(file, size)
+		if err != nil {
+			return 0, err
+		}
+		return end, writeFilteredLines(out, io.NewSectionReader(file, 0, end), lines, filter)
+	}, filtered.emit)
+}
+
+// lineFilter writes the lines emitted while following a file which its filter
+// selects to out, joining the pieces of lines emitted in several chunks
+type lineFilter struct {
+	filter  *Filter
+	out     io.Writer
+	pending string
+}
+
+// emit buffers chunk until its line is complete and writes the line if selected
+func (l *lineFilter) emit(chunk string) {
+	l.pending += chunk
+	if !strings.HasSuffix(l.pending, "\n") {
+		return
+	}
+	if l.filter.Match(l.pending) {
+		fmt.Fprint(l.out, l.pending)
+	}
+	l.pending = ""
+}
+
+// FollowFile writes lines appended to filePath to out until ctx is cancelled.
+// If fromStart is set, the existing file contents are written first.
+func FollowFile(ctx context.Context, filePath string, fromStart bool, out io.Writer) error {
+	return FollowFileFunc(ctx, filePath, fromStart, func(chunk string) {
+		fmt.Fprint(out, chunk)
+	})
+}
+
+// FollowFileFunc calls emit with each line appended to filePath until ctx is
+// cancelled. Lines include their trailing newline, except for a partial line
+// at the end of the file whose remainder is emitted once it is written, and
+// lines longer than the read buffer, which are emitted in pieces.
+func FollowFileFunc(ctx context.Context, filePath string, fromStart bool, emit func(chunk string)) error {
+	var lines Lines
+	if fromStart {
+		lines = Lines{Count: 1, FromStart: true}
+	}
+	return FollowFileLines(ctx, filePath, lines, emit)
+}
+
+// FollowFileLines is FollowFileFunc starting with the selected lines of the
+// file's existing contents, the zero Lines starts at its end
+func FollowFileLines(ctx context.Context, filePath string, lines Lines, emit func(chunk string)) error {
+	return followFile(ctx, filePath, nil, func(file *os.File, size int64) (int64, error) {
+		return linesOffset(file, size, lines)
+	}, emit)
+}
+
+// FollowFileUntil is FollowFile which, once stop is closed, writes what was
+// appended up to the end of the file and returns, e.g. after its writer exited
+func FollowFileUntil(ctx context.Context, filePath string, fromStart bool, stop <-chan struct{}, out io.Writer) error {
+	var lines Lines
+	if fromStart {
+		lines = Lines{Count: 1, FromStart: true}
+	}
+	return followFile(ctx, filePath, stop, func(file *os.File, size int64) (int64, error) {
+		return linesOffset(file, size, lines)
+	}, func(chunk string) {
+		fmt.Fprint(out, chunk)
+	})
+}
+
+// followFile follows filePath from the offset start returns for the size the
+// file has when opened, start may write the existing contents itself. Once
+// stop, which may be nil, is closed it returns at the end of the file.
+func followFile(ctx context.Context, filePath string, stop <-chan struct{}, start func(file *os.File, size int64) (int64, error), emit func(chunk string)) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
-	defer file.Close()
+	defer func() { file.Close() }()
 
-	// Seek to end of file to start from current position
-	if _, err := file.Seek(0, 2); err != nil {
-		return fmt.Errorf("failed to seek to end of file: %w", err)
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	offset, err := start(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("error reading file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in file: %w", err)
 	}
 
 	// Create a buffered reader
 	reader := bufio.NewReader(file)
-
-	fmt.Printf("Following output from %s (Ctrl+C to stop)...\n", filepath.Base(filePath))
+	stopping := false
 
 	// Monitor for new output
 	for {
-		line, err := reader.ReadString('\n')
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		// ReadSlice caps buffering at the reader's size, longer lines are emitted in pieces
+		slice, err := reader.ReadSlice('\n')
+		line := string(slice)
+		if err == bufio.ErrBufferFull {
+			emit(line)
+			continue
+		}
 		if err != nil {
 			// Check if file was truncated (VM restarted)
 			if strings.Contains(err.Error(), "bad file descriptor") ||
@@ -81,30 +421,83 @@ func FollowFileOutput(filePath string) error {
 				continue
 			}
 
-			// For EOF, just wait a bit and continue
-			if strings.Contains(err.Error(), "EOF") {
-				time.Sleep(100 * time.Millisecond)
+			// For EOF, emit any partial line and wait a bit before continuing
+			if err == io.EOF {
+				if line != "" {
+					emit(line)
+				}
+				if stopping {
+					return nil
+				}
+				// Read once more up to the end, the writer may have appended before stopping
+				select {
+				case <-stop:
+					stopping = true
+				case <-time.After(100 * time.Millisecond):
+				}
 				continue
 			}
 
 			return fmt.Errorf("error reading file: %w", err)
 		}
 
-		// Print the line without the trailing newline (ReadString includes it)
-		fmt.Print(line)
+		emit(line)
+	}
+}
+
+// PrefixWriter prepends a label to every line written through it
+type PrefixWriter struct {
+	out         io.Writer
+	prefix      string
+	atLineStart bool
+}
+
+// NewPrefixWriter returns a writer prefixing each line written to out with "[label] "
+func NewPrefixWriter(out io.Writer, label string) *PrefixWriter {
+	return &PrefixWriter{out: out, prefix: "[" + label + "] ", atLineStart: true}
+}
+
+// Write writes p to the underlying writer, inserting the prefix at each line start
+func (w *PrefixWriter) Write(p []byte) (int, error) {
+	var buf []byte
+	for _, b := range p {
+		if w.atLineStart {
+			buf = append(buf, w.prefix...)
+			w.atLineStart = false
+		}
+		buf = append(buf, b)
+		if b == '\n' {
+			w.atLineStart = true
+		}
 	}
+	if _, err := w.out.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-// DisplayFileOutput shows file output either as last N lines or following mode
-func DisplayFileOutput(filePath string, follow bool, lines int) error {
+// DisplayFileOutput shows the selected lines of a file and, in following mode,
+// what is appended to it afterwards, prefixing each line with "[label] " if
+// label is set. fromStart follows the file from its beginning, whatever lines
+// selects, and implies follow. Only lines selected by filter are shown, lines
+// counts those.
+func DisplayFileOutput(filePath string, follow, fromStart bool, lines Lines, filter *Filter, label string) error {
 	// Check if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return fmt.Errorf("file not found: %s", filePath)
 	}
 
-	if follow {
-		return FollowFileOutput(filePath)
+	var out io.Writer = os.Stdout
+	if label != "" {
+		out = NewPrefixWriter(os.Stdout, label)
+	}
+
+	if fromStart {
+		lines = Lines{Count: 1, FromStart: true}
+	}
+	if follow || fromStart {
+		return followFileOutput(context.Background(), filePath, lines, filter, out)
 	} else {
-		return ShowLastLines(filePath, lines)
+		return WriteFilteredLines(out, filePath, lines, filter)
 	}
 }
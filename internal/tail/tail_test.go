@@ -0,0 +1,441 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewPrefixWriter(&buf, "web")
+
+	// Partial lines must only be prefixed once
+	for _, chunk := range []string{"Booting", " kernel\nLogin: ", "\n", "a\nb\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	want := "[web] Booting kernel\n[web] Login: \n[web] a\n[web] b\n"
+	if buf.String() != want {
+		t.Errorf("prefixed output = %q, want %q", buf.String(), want)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for use by a follower and the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFollowFilePrefixed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serial")
+	if err := os.WriteFile(path, []byte("old line\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- FollowFile(ctx, path, false, NewPrefixWriter(&out, "db"))
+	}()
+	time.Sleep(150 * time.Millisecond)
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file for appending: %v", err)
+	}
+	file.WriteString("new line 1\nnew ")
+	time.Sleep(250 * time.Millisecond)
+	file.WriteString("line 2\n")
+	file.Close()
+
+	want := "[db] new line 1\n[db] new line 2\n"
+	deadline := time.Now().Add(2 * time.Second)
+	for out.String() != want && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("FollowFile() error = %v", err)
+	}
+
+	if out.String() != want {
+		t.Errorf("followed output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestWriteLastLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		lines   int
+		want    string
+	}{
+		{"fewer lines than asked", "a\nb\n", 5, "a\nb\n"},
+		{"last lines", "a\nb\nc\n", 2, "b\nc\n"},
+		{"partial last line", "a\nb\nc", 2, "b\nc\n"},
+		{"empty lines count", "a\n\n\nb\n", 3, "\n\nb\n"},
+		{"zero lines", "a\n", 0, ""},
+		{"empty file", "", 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := WriteLastLines(&buf, path, tt.lines); err != nil {
+				t.Fatalf("WriteLastLines() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteLastLines() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLastLinesLargeFileBoundedMemory(t *testing.T) {
+	// 32 MiB of serial log, spanning many chunks
+	path := filepath.Join(t.TempDir(), "serial.log")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	line := strings.Repeat("x", 1023) + "\n"
+	w := bufio.NewWriter(file)
+	for i := 0; i < 32*1024; i++ {
+		w.WriteString(line)
+	}
+	w.WriteString("second to last\nlast\n")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	file.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var buf bytes.Buffer
+	if err := WriteLastLines(&buf, path, 3); err != nil {
+		t.Fatalf("WriteLastLines() error = %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+	if buf.String() != line+"second to last\nlast\n" {
+		t.Errorf("Unexpected last lines: %q", buf.String())
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("Expected memory use bounded by the chunk size, allocated %d bytes", allocated)
+	}
+}
+
+func TestFollowFileLongLine(t *testing.T) {
+	// A line longer than the reader's buffer is emitted in pieces instead of accumulated
+	path := filepath.Join(t.TempDir(), "serial")
+	long := strings.Repeat("y", 10000) + "\n"
+	if err := os.WriteFile(path, []byte(long), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var chunks []string
+	done := make(chan error, 1)
+	go func() {
+		done <- FollowFileFunc(ctx, path, true, func(chunk string) {
+			mu.Lock()
+			chunks = append(chunks, chunk)
+			mu.Unlock()
+		})
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		got := strings.Join(chunks, "")
+		mu.Unlock()
+		if got == long {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("FollowFileFunc() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(chunks, "") != long {
+		t.Fatalf("Expected the whole line to be emitted, got %d chunks", len(chunks))
+	}
+	if len(chunks) < 2 {
+		t.Errorf("Expected the long line to be emitted in pieces, got %d chunk", len(chunks))
+	}
+}
+
+func TestFollowFileOutputFromStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serial")
+	if err := os.WriteFile(path, []byte("boot line 1\nboot line 2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- followFileOutput(ctx, path, Lines{Count: 1, FromStart: true}, nil, &out)
+	}()
+	time.Sleep(150 * time.Millisecond)
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file for appending: %v", err)
+	}
+	file.WriteString("live line\n")
+	file.Close()
+
+	// Output printed before attaching comes first, followed by what is appended
+	want := "boot line 1\nboot line 2\nlive line\n"
+	deadline := time.Now().Add(2 * time.Second)
+	for out.String() != want && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("followFileOutput() error = %v", err)
+	}
+
+	if out.String() != want {
+		t.Errorf("followed output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFollowFileUntil(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serial")
+	if err := os.WriteFile(path, []byte("boot line\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	var out syncBuffer
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- FollowFileUntil(context.Background(), path, true, stop, &out)
+	}()
+	time.Sleep(150 * time.Millisecond)
+
+	// What the writer appends right before stopping is still written
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file for appending: %v", err)
+	}
+	file.WriteString("last line\n")
+	file.Close()
+	close(stop)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("FollowFileUntil() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("FollowFileUntil() did not return once stopped")
+	}
+	if want := "boot line\nlast line\n"; out.String() != want {
+		t.Errorf("followed output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestLinesSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Lines
+		wantErr bool
+	}{
+		{value: "10", want: Lines{Count: 10}},
+		{value: "0", want: Lines{Count: 0}},
+		{value: "+3", want: Lines{Count: 3, FromStart: true}},
+		{value: "-3", wantErr: true},
+		{value: "+-3", wantErr: true},
+		{value: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		var got Lines
+		err := got.Set(tt.value)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("Set(%q) = %+v, %v, want %+v (error %t)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+		if !tt.wantErr && got.String() != tt.value {
+			t.Errorf("String() = %q, want %q", got.String(), tt.value)
+		}
+	}
+}
+
+func TestWriteLinesFromStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		lines   Lines
+		want    string
+	}{
+		{"from line 2", "a\nb\nc\n", Lines{Count: 2, FromStart: true}, "b\nc\n"},
+		{"from line 1", "a\nb\n", Lines{Count: 1, FromStart: true}, "a\nb\n"},
+		{"from line 0 is the whole file", "a\nb\n", Lines{Count: 0, FromStart: true}, "a\nb\n"},
+		{"from the partial last line", "a\nb\nc", Lines{Count: 3, FromStart: true}, "c\n"},
+		{"past the end", "a\nb\n", Lines{Count: 5, FromStart: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := WriteLines(&buf, path, tt.lines); err != nil {
+				t.Fatalf("WriteLines() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteLines() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowFileLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines Lines
+		want  string
+	}{
+		// -n 0 -f only shows what is appended after attaching
+		{"only new lines", Lines{}, "live line\n"},
+		{"last lines first", Lines{Count: 1}, "boot line 3\nlive line\n"},
+		{"from line 2", Lines{Count: 2, FromStart: true}, "boot line 2\nboot line 3\nlive line\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "serial")
+			if err := os.WriteFile(path, []byte("boot line 1\nboot line 2\nboot line 3\n"), 0644); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var out syncBuffer
+			done := make(chan error, 1)
+			go func() {
+				done <- FollowFileLines(ctx, path, tt.lines, func(chunk string) { out.Write([]byte(chunk)) })
+			}()
+			time.Sleep(150 * time.Millisecond)
+
+			file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatalf("Failed to open file for appending: %v", err)
+			}
+			file.WriteString("live line\n")
+			file.Close()
+
+			deadline := time.Now().Add(2 * time.Second)
+			for out.String() != tt.want && time.Now().Before(deadline) {
+				time.Sleep(20 * time.Millisecond)
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("FollowFileLines() error = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("FollowFileLines() output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFilteredLines(t *testing.T) {
+	content := "[  OK  ] Started cloud-init.service\nlogin prompt\n[  OK  ] Reached cloud-init target\nerror: disk\ncloud-init finished"
+	tests := []struct {
+		name    string
+		pattern string
+		invert  bool
+		lines   Lines
+		want    string
+	}{
+		{"matching", "cloud-init", false, Lines{Count: 10}, "[  OK  ] Started cloud-init.service\n[  OK  ] Reached cloud-init target\ncloud-init finished\n"},
+		{"last matching lines", "cloud-init", false, Lines{Count: 2}, "[  OK  ] Reached cloud-init target\ncloud-init finished\n"},
+		{"from line", "cloud-init", false, Lines{Count: 2, FromStart: true}, "[  OK  ] Reached cloud-init target\ncloud-init finished\n"},
+		{"inverted", "cloud-init", true, Lines{Count: 10}, "login prompt\nerror: disk\n"},
+		{"inverted last line", `^\[`, true, Lines{Count: 1}, "cloud-init finished\n"},
+		{"no match", "kernel panic", false, Lines{Count: 10}, ""},
+		{"no lines", "cloud-init", false, Lines{Count: 0}, ""},
+	}
+
+	path := filepath.Join(t.TempDir(), "serial")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter(tt.pattern, tt.invert)
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+			var buf bytes.Buffer
+			if err := WriteFilteredLines(&buf, path, tt.lines, filter); err != nil {
+				t.Fatalf("WriteFilteredLines() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteFilteredLines() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	if filter, err := NewFilter("", false); filter != nil || err != nil {
+		t.Errorf("NewFilter(\"\") = %v, %v, want no filter", filter, err)
+	}
+	if _, err := NewFilter("cloud-(init", false); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+	if _, err := NewFilter("", true); err == nil {
+		t.Error("Expected an error for inverting without a pattern")
+	}
+
+	var filter *Filter
+	if !filter.Match("anything\n") {
+		t.Error("Expected the nil filter to match every line")
+	}
+}
@@ -1,6 +1,7 @@
 package trace
 
 import (
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -39,6 +40,12 @@ func NewTraceLogger(patterns []string) Tracer {
 // NewTraceLoggerWithFile creates a new trace logger that writes to a file
 // The file is truncated if it exists, created if it doesn't
 func NewTraceLoggerWithFile(patterns []string, filePath string) (Tracer, error) {
+	return NewTraceLoggerWithFileMirror(patterns, filePath, nil)
+}
+
+// NewTraceLoggerWithFileMirror creates a trace logger like NewTraceLoggerWithFile
+// which also writes every trace to mirror, unless mirror is nil
+func NewTraceLoggerWithFileMirror(patterns []string, filePath string, mirror io.Writer) (Tracer, error) {
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -51,7 +58,11 @@ func NewTraceLoggerWithFile(patterns []string, filePath string) (Tracer, error)
 		return nil, err
 	}
 
-	logger := slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{
+	var out io.Writer = file
+	if mirror != nil {
+		out = io.MultiWriter(file, mirror)
+	}
+	logger := slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{
 		Level: slog.LevelDebug,
 	}))
 
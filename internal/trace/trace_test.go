@@ -0,0 +1,37 @@
+package trace
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceLoggerWithFileMirror(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.log")
+	var mirror bytes.Buffer
+
+	tracer, err := NewTraceLoggerWithFileMirror([]string{"qemu"}, path, &mirror)
+	if err != nil {
+		t.Fatalf("Failed to create tracer: %v", err)
+	}
+	tracer.Trace("qemu", "starting", "bin", "qemu-system-x86_64")
+	tracer.Trace("iso", "not traced")
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	for name, got := range map[string]string{"file": string(data), "mirror": mirror.String()} {
+		if !strings.Contains(got, `"msg":"starting"`) || !strings.Contains(got, `"trace":"qemu"`) {
+			t.Errorf("Expected the %s to receive the traced line, got %q", name, got)
+		}
+		if strings.Contains(got, "not traced") {
+			t.Errorf("Expected the %s to skip other categories, got %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+)
+
+// guestAgentTimeout bounds connecting to the guest agent, a guest without a
+// running agent never answers
+const guestAgentTimeout = 3 * time.Second
+
+// ErrNoGuestAgent is returned when the VM has no guest agent channel
+var ErrNoGuestAgent = errors.New("no guest agent channel, set guest_agent = true on the VM")
+
+// GuestAgentSocket returns the socket of the VM's guest agent channel: the
+// injected one if guest_agent is set, otherwise that of a unix socket chardev
+// with id "qga0" from the VM's own cmd. It is "" if there is none.
+func (m *Manager) GuestAgentSocket(ctx context.Context) (string, error) {
+	if m.vmEntry.GuestAgent {
+		return m.vmEntry.GuestAgentSocketPath(), nil
+	}
+
+	qmpClient, err := m.QMPClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer qmpClient.Close()
+
+	chardevs, err := qmpClient.QueryChardev(ctx)
+	if err != nil {
+		return "", err
+	}
+	return guestAgentSocket(chardevs), nil
+}
+
+// GuestAgentClient returns a client connected to the VM's guest agent, the
+// caller must close it
+func (m *Manager) GuestAgentClient(ctx context.Context) (*internal.QGAClient, error) {
+	socket, err := m.GuestAgentSocket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if socket == "" {
+		return nil, ErrNoGuestAgent
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, guestAgentTimeout)
+	defer cancel()
+	agent := internal.NewQGAClient(socket)
+	if err := agent.Connect(connectCtx); err != nil {
+		return nil, fmt.Errorf("failed to connect to guest agent: %w", err)
+	}
+	return agent, nil
+}
+
+// guestAgentSocket returns the path of the unix socket behind the guest agent
+// chardev, e.g. "unix:/run/qga.sock,server=on", or "" if there is none
+func guestAgentSocket(chardevs []map[string]interface{}) string {
+	for _, chardev := range chardevs {
+		if label, _ := chardev["label"].(string); label != config.GuestAgentChardevID {
+			continue
+		}
+		filename, _ := chardev["filename"].(string)
+		path, ok := strings.CutPrefix(filename, "unix:")
+		if !ok {
+			return ""
+		}
+		path, _, _ = strings.Cut(path, ",")
+		return path
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"testing"
+)
+
+func TestGuestAgentSocket(t *testing.T) {
+	chardevs := []map[string]interface{}{
+		{"label": "compat_monitor0", "filename": "unix:/run/vm/monitor.socket,server=on"},
+		{"label": "qga0", "filename": "unix:/run/vm/qga.socket,server=on"},
+	}
+	if got := guestAgentSocket(chardevs); got != "/run/vm/qga.socket" {
+		t.Errorf("Expected /run/vm/qga.socket, got %q", got)
+	}
+
+	// Only a unix socket can be connected to
+	if got := guestAgentSocket([]map[string]interface{}{{"label": "qga0", "filename": "pty:/dev/pts/3"}}); got != "" {
+		t.Errorf("Expected no socket for a pty chardev, got %q", got)
+	}
+	if got := guestAgentSocket(chardevs[:1]); got != "" {
+		t.Errorf("Expected no socket without a qga0 chardev, got %q", got)
+	}
+}
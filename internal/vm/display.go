@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"qqmgr/internal"
+)
+
+// vncBasePort is the TCP port of VNC display :0
+const vncBasePort = 5900
+
+// DisplayEndpoint is where a VNC or SPICE display of the VM listens
+type DisplayEndpoint struct {
+	Protocol   string // vnc or spice
+	Host       string
+	Port       int
+	Socket     string // Path of the unix socket, instead of Host and Port
+	FromConfig bool   // Taken from the VM's -vnc argument as QEMU could not be queried
+}
+
+// Address returns host:port, or the socket path for a unix socket
+func (e DisplayEndpoint) Address() string {
+	if e.Socket != "" {
+		return e.Socket
+	}
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// DisplayEndpoints returns the enabled VNC and SPICE displays of the VM as
+// reported by QEMU. If QEMU cannot be queried, the VNC display is taken from
+// the VM's -vnc argument instead, and the query error returned if it has none.
+func (m *Manager) DisplayEndpoints(ctx context.Context) ([]DisplayEndpoint, error) {
+	qmpClient, err := m.QMPClient(ctx)
+	if err == nil {
+		var endpoints []DisplayEndpoint
+		endpoints, err = queryDisplayEndpoints(ctx, qmpClient)
+		qmpClient.Close()
+		if err == nil {
+			return endpoints, nil
+		}
+	}
+
+	if endpoint, ok := vncFromArgs(m.vmEntry.GetFullCommand()); ok {
+		return []DisplayEndpoint{endpoint}, nil
+	}
+	return nil, err
+}
+
+// queryDisplayEndpoints asks QEMU for its VNC and SPICE displays. QEMU lacking
+// one of them, e.g. when built without SPICE, only fails if both queries fail.
+func queryDisplayEndpoints(ctx context.Context, qmpClient *internal.QMPClient) ([]DisplayEndpoint, error) {
+	vnc, vncErr := qmpClient.QueryVNC(ctx)
+	spice, spiceErr := qmpClient.QuerySpice(ctx)
+	if vncErr != nil && spiceErr != nil {
+		return nil, errors.Join(vncErr, spiceErr)
+	}
+	return displayEndpoints(vnc, spice), nil
+}
+
+// displayEndpoints extracts the endpoints of the enabled displays, either may be nil
+func displayEndpoints(vnc *internal.VNCInfo, spice *internal.SpiceInfo) []DisplayEndpoint {
+	var endpoints []DisplayEndpoint
+	if vnc != nil && vnc.Enabled {
+		endpoint := DisplayEndpoint{Protocol: "vnc"}
+		if vnc.Family == "unix" {
+			endpoint.Socket = vnc.Host
+		} else {
+			endpoint.Host = vnc.Host
+			endpoint.Port, _ = strconv.Atoi(vnc.Service)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	if spice != nil && spice.Enabled {
+		endpoint := DisplayEndpoint{Protocol: "spice", Host: spice.Host, Port: spice.Port}
+		if endpoint.Port == 0 {
+			endpoint.Port = spice.TLSPort
+		}
+		if endpoint.Port == 0 && strings.HasPrefix(spice.Host, "/") {
+			endpoint = DisplayEndpoint{Protocol: "spice", Socket: spice.Host}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+// vncFromArgs returns the VNC display of a -vnc argument in args, which is
+// [host]:display or unix:path followed by options. A display without host
+// listens on all interfaces and is reached through localhost.
+func vncFromArgs(args []string) (DisplayEndpoint, bool) {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] != "-vnc" {
+			continue
+		}
+		display, _, _ := strings.Cut(args[i+1], ",")
+		if path, ok := strings.CutPrefix(display, "unix:"); ok {
+			return DisplayEndpoint{Protocol: "vnc", Socket: path, FromConfig: true}, true
+		}
+
+		colon := strings.LastIndex(display, ":")
+		if colon < 0 {
+			return DisplayEndpoint{}, false // none
+		}
+		number, err := strconv.Atoi(display[colon+1:])
+		if err != nil || number < 0 {
+			return DisplayEndpoint{}, false
+		}
+		host := strings.Trim(display[:colon], "[]")
+		if host == "" {
+			host = "localhost"
+		}
+		return DisplayEndpoint{Protocol: "vnc", Host: host, Port: vncBasePort + number, FromConfig: true}, true
+	}
+	return DisplayEndpoint{}, false
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+)
+
+func TestDisplayEndpoints(t *testing.T) {
+	tests := []struct {
+		name  string
+		vnc   *internal.VNCInfo
+		spice *internal.SpiceInfo
+		want  []DisplayEndpoint
+	}{
+		{
+			name: "vnc on tcp",
+			vnc:  &internal.VNCInfo{Enabled: true, Host: "127.0.0.1", Family: "ipv4", Service: "5901"},
+			want: []DisplayEndpoint{{Protocol: "vnc", Host: "127.0.0.1", Port: 5901}},
+		},
+		{
+			name: "vnc on a unix socket",
+			vnc:  &internal.VNCInfo{Enabled: true, Host: "/run/vm/vnc.sock", Family: "unix"},
+			want: []DisplayEndpoint{{Protocol: "vnc", Socket: "/run/vm/vnc.sock"}},
+		},
+		{
+			name:  "vnc disabled, spice with tls only",
+			vnc:   &internal.VNCInfo{Enabled: false},
+			spice: &internal.SpiceInfo{Enabled: true, Host: "0.0.0.0", TLSPort: 5931},
+			want:  []DisplayEndpoint{{Protocol: "spice", Host: "0.0.0.0", Port: 5931}},
+		},
+		{
+			name:  "spice on a unix socket",
+			spice: &internal.SpiceInfo{Enabled: true, Host: "/run/vm/spice.sock"},
+			want:  []DisplayEndpoint{{Protocol: "spice", Socket: "/run/vm/spice.sock"}},
+		},
+		{
+			name: "no display",
+			vnc:  &internal.VNCInfo{Enabled: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := displayEndpoints(tt.vnc, tt.spice); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("displayEndpoints() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVNCFromArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		want   DisplayEndpoint
+		wantOK bool
+	}{
+		{args: []string{"-m", "1G", "-vnc", ":1"}, want: DisplayEndpoint{Protocol: "vnc", Host: "localhost", Port: 5901, FromConfig: true}, wantOK: true},
+		{args: []string{"-vnc", "127.0.0.1:0,password=on"}, want: DisplayEndpoint{Protocol: "vnc", Host: "127.0.0.1", Port: 5900, FromConfig: true}, wantOK: true},
+		{args: []string{"-vnc", "[::1]:2"}, want: DisplayEndpoint{Protocol: "vnc", Host: "::1", Port: 5902, FromConfig: true}, wantOK: true},
+		{args: []string{"-vnc", "unix:/run/vm/vnc.sock,password=on"}, want: DisplayEndpoint{Protocol: "vnc", Socket: "/run/vm/vnc.sock", FromConfig: true}, wantOK: true},
+		{args: []string{"-vnc", "none"}},
+		{args: []string{"-display", "none"}},
+	}
+
+	for _, tt := range tests {
+		got, ok := vncFromArgs(tt.args)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("vncFromArgs(%v) = %+v, %t, want %+v, %t", tt.args, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestManagerDisplayEndpointsFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without a running QEMU the endpoint comes from the VM's -vnc argument
+	vmEntry := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir(), Cmd: []string{"-m 1G", "-vnc 127.0.0.1:3"}}
+	endpoints, err := NewManager(vmEntry).DisplayEndpoints(ctx)
+	if err != nil {
+		t.Fatalf("DisplayEndpoints() failed: %v", err)
+	}
+	want := []DisplayEndpoint{{Protocol: "vnc", Host: "127.0.0.1", Port: 5903, FromConfig: true}}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Errorf("DisplayEndpoints() = %+v, want %+v", endpoints, want)
+	}
+
+	// Without either, the query error is returned
+	vmEntry.Cmd = []string{"-m 1G"}
+	if _, err := NewManager(vmEntry).DisplayEndpoints(ctx); err == nil {
+		t.Errorf("Expected an error without QEMU and without -vnc")
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+)
+
+// ErrVMGone is returned by FollowEvents once the QMP socket was removed and did not come back
+var ErrVMGone = errors.New("VM is gone, its QMP socket was removed")
+
+var (
+	// reconnectMinDelay and reconnectMaxDelay bound the backoff between reconnect attempts
+	reconnectMinDelay = 100 * time.Millisecond
+	reconnectMaxDelay = 5 * time.Second
+	// socketGoneGrace is how long a removed QMP socket may take to reappear,
+	// e.g. while the VM is restarted, before the VM counts as gone
+	socketGoneGrace = 30 * time.Second
+	// reconnectTimeout bounds a single reconnect, a wedged QEMU may accept but never greet
+	reconnectTimeout = 5 * time.Second
+)
+
+// FollowEvents calls onEvent with every QMP event of the VM until ctx is done,
+// which is not an error. Without reconnect a dropped connection ends the
+// stream with an error. With reconnect it is re-established with backoff and
+// onReconnect is called once it is back; a QMP socket which exists but does
+// not accept connections is retried indefinitely, a removed one only for a
+// grace period before ErrVMGone is returned.
+func (m *Manager) FollowEvents(ctx context.Context, reconnect bool, onEvent func(*internal.QMPEvent), onReconnect func()) error {
+	qmpClient, err := m.QMPClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		err := streamEvents(ctx, qmpClient, onEvent)
+		qmpClient.Close()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !reconnect {
+			return fmt.Errorf("event stream ended: %w", err)
+		}
+
+		qmpClient, err = m.reconnectQMP(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		onReconnect()
+	}
+}
+
+// streamEvents passes the events read from qmpClient to onEvent until reading fails
+func streamEvents(ctx context.Context, qmpClient *internal.QMPClient, onEvent func(*internal.QMPEvent)) error {
+	for {
+		event, err := qmpClient.NextEvent(ctx)
+		if err != nil {
+			return err
+		}
+		onEvent(event)
+	}
+}
+
+// reconnectQMP connects to the VM's QMP socket again, backing off exponentially
+// between attempts
+func (m *Manager) reconnectQMP(ctx context.Context) (*internal.QMPClient, error) {
+	delay := reconnectMinDelay
+	var goneSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+
+		if _, err := os.Stat(m.vmEntry.QmpSocketPath()); os.IsNotExist(err) {
+			if goneSince.IsZero() {
+				goneSince = time.Now()
+			}
+			if time.Since(goneSince) >= socketGoneGrace {
+				return nil, ErrVMGone
+			}
+			continue
+		}
+		goneSince = time.Time{}
+
+		connectCtx, cancel := context.WithTimeout(ctx, reconnectTimeout)
+		qmpClient, err := m.QMPClient(connectCtx)
+		cancel()
+		if err == nil {
+			return qmpClient, nil
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"qqmgr/internal"
+	"qqmgr/internal/config"
+)
+
+// serveDroppingQMP serves QMP on socketPath, sending one event per connection.
+// The first drops connections right after their event, like a QEMU going away,
+// and closes the listener as well if removeSocket is set.
+func serveDroppingQMP(t *testing.T, socketPath string, events []string, removeSocket bool) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create QMP socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintln(conn, `{"QMP":{"version":{},"capabilities":[]}}`)
+			scanner := bufio.NewScanner(conn)
+			if !scanner.Scan() || !strings.Contains(scanner.Text(), "qmp_capabilities") {
+				conn.Close()
+				continue
+			}
+			fmt.Fprintln(conn, `{"return":{}}`)
+			if i < len(events) {
+				fmt.Fprintf(conn, `{"event":%q,"timestamp":{"seconds":1700000000,"microseconds":0}}`+"\n", events[i])
+			}
+			if i == 0 {
+				conn.Close()
+				if removeSocket {
+					listener.Close()
+					return
+				}
+				continue
+			}
+			// Later connections stay up until the client goes away
+			go func() {
+				defer conn.Close()
+				for scanner.Scan() {
+				}
+			}()
+		}
+	}()
+}
+
+func TestManagerFollowEventsReconnect(t *testing.T) {
+	defer func(delay time.Duration) { reconnectMinDelay = delay }(reconnectMinDelay)
+	reconnectMinDelay = 10 * time.Millisecond
+
+	vmEntry := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+	serveDroppingQMP(t, vmEntry.QmpSocketPath(), []string{"STOP", "RESUME"}, false)
+	manager := NewManager(vmEntry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var seen []string
+	err := manager.FollowEvents(ctx, true,
+		func(event *internal.QMPEvent) {
+			seen = append(seen, event.Event)
+			if event.Event == "RESUME" {
+				cancel()
+			}
+		},
+		func() {
+			seen = append(seen, "[reconnected]")
+		})
+	if err != nil {
+		t.Fatalf("FollowEvents() failed: %v", err)
+	}
+	if strings.Join(seen, " ") != "STOP [reconnected] RESUME" {
+		t.Errorf("Expected STOP, a reconnect and RESUME, got %v", seen)
+	}
+}
+
+func TestManagerFollowEventsDropped(t *testing.T) {
+	defer func(delay, grace time.Duration) {
+		reconnectMinDelay, socketGoneGrace = delay, grace
+	}(reconnectMinDelay, socketGoneGrace)
+	reconnectMinDelay = 10 * time.Millisecond
+	socketGoneGrace = 100 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without --reconnect the stream ends with the connection
+	vmEntry := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+	serveDroppingQMP(t, vmEntry.QmpSocketPath(), []string{"STOP"}, false)
+	err := NewManager(vmEntry).FollowEvents(ctx, false, func(*internal.QMPEvent) {}, func() {})
+	if err == nil || !strings.Contains(err.Error(), "event stream ended") {
+		t.Errorf("Expected the stream to end, got %v", err)
+	}
+
+	// A QMP socket which is removed for good means the VM is gone
+	gone := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+	serveDroppingQMP(t, gone.QmpSocketPath(), []string{"SHUTDOWN"}, true)
+	reconnected := false
+	err = NewManager(gone).FollowEvents(ctx, true, func(*internal.QMPEvent) {}, func() { reconnected = true })
+	if !errors.Is(err, ErrVMGone) || reconnected {
+		t.Errorf("Expected ErrVMGone without reconnecting, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"os"
+
+	"qqmgr/internal"
+)
+
+// Export is the complete runtime state of a VM in one document, for dashboards.
+// Parts that cannot be determined, e.g. the live QMP details of a stopped VM,
+// are left empty and the reason recorded in Errors under the part's name.
+type Export struct {
+	Name          string                 `json:"name"`
+	Command       []string               `json:"command"`
+	Env           map[string]string      `json:"env"`
+	DataDir       string                 `json:"data_dir"`
+	Status        *Status                `json:"status"`
+	Sockets       []SocketReadiness      `json:"sockets"`
+	SSH           ExportSSH              `json:"ssh"`
+	QMP           map[string]interface{} `json:"qmp"` // Raw responses of the live QMP queries
+	CPUs          []internal.CPUInfo     `json:"cpus"`
+	RecordedPanic *internal.QMPEvent     `json:"recorded_panic"`
+	Logs          map[string]string      `json:"logs"`
+	Errors        map[string]string      `json:"errors"`
+}
+
+// ExportSSH is the SSH access part of an Export
+type ExportSSH struct {
+	Port         interface{} `json:"port"`
+	User         interface{} `json:"user"`
+	Config       string      `json:"config"`
+	ConfigExists bool        `json:"config_exists"`
+}
+
+// exportQueries are the QMP queries whose raw responses an Export includes
+var exportQueries = []string{"query-status", "query-kvm", "query-current-machine", "query-name"}
+
+// Export collects the runtime state of the VM. It never fails, errors of the
+// individual parts are recorded in the result instead.
+func (m *Manager) Export(ctx context.Context) *Export {
+	export := &Export{
+		Name:    m.vmEntry.Name,
+		Command: m.vmEntry.GetFullCommand(),
+		Env:     m.vmEntry.Env,
+		DataDir: m.vmEntry.DataDir,
+		SSH: ExportSSH{
+			Port:   m.getSSHPort(),
+			Config: m.vmEntry.SshConfigPath(),
+		},
+		Logs: map[string]string{
+			"serial": m.vmEntry.SerialFilePath(),
+			"stdout": m.vmEntry.QemuStdoutPath(),
+			"stderr": m.vmEntry.QemuStderrPath(),
+		},
+		Errors: make(map[string]string),
+	}
+	if sshData, ok := m.vmEntry.Vars["ssh"].(map[string]interface{}); ok {
+		export.SSH.User = sshData["user"]
+	}
+	if _, err := os.Stat(export.SSH.Config); err == nil {
+		export.SSH.ConfigExists = true
+	}
+
+	status, err := m.GetStatus(ctx)
+	if err != nil {
+		export.Errors["status"] = err.Error()
+	}
+	export.Status = status
+
+	export.RecordedPanic, err = m.RecordedPanic()
+	if err != nil {
+		export.Errors["recorded_panic"] = err.Error()
+	}
+
+	export.Sockets = m.SocketsReady(ctx)
+
+	// The live details need QMP, bounded like the status probe so a dead VM
+	// does not hold up the export
+	qmpCtx := ctx
+	if m.probeTimeout > 0 {
+		var cancel context.CancelFunc
+		qmpCtx, cancel = context.WithTimeout(ctx, m.probeTimeout)
+		defer cancel()
+	}
+	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
+	if err := qmpClient.Connect(qmpCtx); err != nil {
+		export.Errors["qmp"] = err.Error()
+		return export
+	}
+	defer qmpClient.Close()
+
+	export.QMP = qmpClient.QueryRaw(qmpCtx, exportQueries)
+	export.CPUs, err = qmpClient.QueryCPUs(qmpCtx)
+	if err != nil {
+		export.Errors["cpus"] = err.Error()
+	}
+
+	return export
+}
@@ -4,8 +4,11 @@ package vm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,18 +18,32 @@ import (
 	"syscall"
 )
 
+// defaultProbeTimeout bounds the QMP checks behind GetStatus and IsAlive
+const defaultProbeTimeout = 2 * time.Second
+
+// ErrAlreadyRunning is returned by EnsureStopped when the VM is running
+var ErrAlreadyRunning = errors.New("already running")
+
 // Manager provides VM management functionality
 type Manager struct {
-	vmEntry *config.VmEntry
+	vmEntry      *config.VmEntry
+	probeTimeout time.Duration
 }
 
 // NewManager creates a new VM manager for the given VM entry
 func NewManager(vmEntry *config.VmEntry) *Manager {
 	return &Manager{
-		vmEntry: vmEntry,
+		vmEntry:      vmEntry,
+		probeTimeout: defaultProbeTimeout,
 	}
 }
 
+// SetProbeTimeout sets how long GetStatus and IsAlive wait for QMP, so a
+// dead VM with its socket left behind is reported quickly (0 uses the caller's context only)
+func (m *Manager) SetProbeTimeout(timeout time.Duration) {
+	m.probeTimeout = timeout
+}
+
 // Status represents the current status of a VM
 type Status struct {
 	Name          string                 `json:"name"`
@@ -41,6 +58,9 @@ type Status struct {
 	MonitorSocket string                 `json:"monitor_socket"`
 	QMPConnected  bool                   `json:"qmp_connected"`
 	StatusDetails map[string]interface{} `json:"status_details,omitempty"`
+	VMStatus      *internal.VMStatus     `json:"vm_status,omitempty"` // Typed view of StatusDetails
+	Panicked      bool                   `json:"guest_panicked"`
+	PanicInfo     map[string]interface{} `json:"panic_info,omitempty"` // Data of the recorded GUEST_PANICKED event
 }
 
 // GetStatus returns the current status of the VM
@@ -74,72 +94,159 @@ func (m *Manager) GetStatus(ctx context.Context) (*Status, error) {
 		status.QMPConnected = connected
 		status.IsRunning = alive // QMP is the authoritative source
 		status.StatusDetails = statusDetails
+		if len(statusDetails) > 0 {
+			status.VMStatus, _ = internal.ParseVMStatus(statusDetails)
+		}
+	}
+
+	// A panic shows in the run state while QEMU is paused on it, and in the event
+	// recorded by WatchPanic even once QEMU has exited
+	if status.VMStatus != nil && status.VMStatus.IsPanicked() {
+		status.Panicked = true
+	}
+	if event, err := m.RecordedPanic(); err == nil && event != nil {
+		status.Panicked = true
+		status.PanicInfo = event.Data
 	}
 
 	return status, nil
 }
 
+// WatchStatus calls onStatus with the VM's status right away and then every
+// interval until ctx is done. QEMU serves one QMP client at a time, so each
+// check uses a short-lived connection and other commands like stop can
+// connect in between.
+func (m *Manager) WatchStatus(ctx context.Context, interval time.Duration, onStatus func(*Status, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := m.GetStatus(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		onStatus(status, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // IsAlive checks if the VM is alive using QMP
 func (m *Manager) IsAlive(ctx context.Context) (bool, error) {
 	alive, _, _, err := m.checkQMPStatus(ctx)
 	return alive, err
 }
 
+// EnsureStopped returns an error wrapping ErrAlreadyRunning if the VM is running
+func (m *Manager) EnsureStopped(ctx context.Context) error {
+	status, err := m.GetStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get VM status: %w", err)
+	}
+	if !status.IsRunning {
+		return nil
+	}
+	if status.PID != nil {
+		return fmt.Errorf("VM '%s' is %w (PID: %d)", m.vmEntry.Name, ErrAlreadyRunning, *status.PID)
+	}
+	return fmt.Errorf("VM '%s' is %w", m.vmEntry.Name, ErrAlreadyRunning)
+}
+
 // Stop gracefully shuts down the VM
 func (m *Manager) Stop(ctx context.Context, timeout time.Duration, forceAfterTimeout bool) (bool, error) {
+	success, _, err := m.StopWithEvents(ctx, timeout, forceAfterTimeout)
+	return success, err
+}
+
+// StopWithEvents shuts down the VM like Stop and also returns the QMP events
+// observed during the shutdown attempt, also when stopping fails
+func (m *Manager) StopWithEvents(ctx context.Context, timeout time.Duration, forceAfterTimeout bool) (bool, []internal.QMPEvent, error) {
 	// First check if VM is running
 	status, err := m.GetStatus(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to get VM status: %w", err)
+		return false, nil, fmt.Errorf("failed to get VM status: %w", err)
 	}
 
 	if !status.IsRunning {
 		// VM is not running, clean up any stale files
 		if err := m.cleanupRuntimeFiles(); err != nil {
-			return false, fmt.Errorf("failed to cleanup runtime files: %w", err)
+			return false, nil, fmt.Errorf("failed to cleanup runtime files: %w", err)
 		}
-		return true, nil
+		return true, nil, nil
 	}
 
 	// Create QMP client
 	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
 
+	var events []internal.QMPEvent
 	// Try to connect to QMP
 	if err := qmpClient.Connect(ctx); err != nil {
 		// QMP connection failed, fall back to force kill
 		if status.PID != nil {
-			if err := m.forceKillPID(*status.PID); err != nil {
-				return false, fmt.Errorf("failed to force kill PID %d: %w", *status.PID, err)
+			if err := m.killAndWait(*status.PID); err != nil {
+				return false, nil, err
 			}
 		}
 	} else {
 		defer qmpClient.Close()
 
 		// Attempt graceful shutdown via QMP
-		success, err := qmpClient.Shutdown(ctx, 1*time.Second, timeout, forceAfterTimeout)
-		if err != nil {
-			// QMP shutdown failed, fall back to force kill
+		success, err := qmpClient.PowerdownWithEventConfirmation(ctx, 1*time.Second, timeout, forceAfterTimeout)
+		events = qmpClient.GetEvents()
+		if err != nil || (!success && forceAfterTimeout) {
+			// QMP shutdown failed or timed out, fall back to force kill
 			if status.PID != nil {
-				if err := m.forceKillPID(*status.PID); err != nil {
-					return false, fmt.Errorf("failed to force kill PID %d: %w", *status.PID, err)
-				}
-			}
-		} else if !success && forceAfterTimeout {
-			// Graceful shutdown timed out, force kill
-			if status.PID != nil {
-				if err := m.forceKillPID(*status.PID); err != nil {
-					return false, fmt.Errorf("failed to force kill PID %d: %w", *status.PID, err)
+				if err := m.killAndWait(*status.PID); err != nil {
+					return false, events, err
 				}
 			}
+		} else if !success {
+			// QEMU is still running, its files stay in place
+			return false, events, nil
 		}
 	}
 
 	// Clean up runtime files
 	if err := m.cleanupRuntimeFiles(); err != nil {
-		return false, fmt.Errorf("failed to cleanup runtime files: %w", err)
+		return false, events, fmt.Errorf("failed to cleanup runtime files: %w", err)
 	}
 
-	return true, nil
+	return true, events, nil
+}
+
+// PlanStop returns the steps StopWithEvents would take to stop the running VM
+// with the given status, without taking them. Callers check that the VM is
+// running first, like the stop command does before stopping it.
+func (m *Manager) PlanStop(status *Status, timeout time.Duration, forceAfterTimeout bool) ([]string, error) {
+	var steps []string
+	switch {
+	case status.QMPConnected:
+		steps = append(steps, fmt.Sprintf("request graceful shutdown via QMP system_powerdown, waiting up to %s", timeout))
+		if status.PID != nil {
+			if forceAfterTimeout {
+				steps = append(steps, fmt.Sprintf("force-kill PID %d if it is still running after %s", *status.PID, timeout))
+			} else {
+				steps = append(steps, fmt.Sprintf("force-kill PID %d only if the QMP shutdown request fails", *status.PID))
+			}
+		}
+	case status.PID != nil:
+		steps = append(steps, fmt.Sprintf("force-kill PID %d, QMP is not reachable", *status.PID))
+	}
+
+	files, err := m.runtimeFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if _, err := os.Lstat(file); err == nil {
+			steps = append(steps, "remove "+file)
+		}
+	}
+
+	return steps, nil
 }
 
 // readPIDFile reads and validates the PID from the PID file
@@ -185,6 +292,12 @@ func (m *Manager) isProcessRunning(pid *int) bool {
 
 // checkQMPStatus checks VM status via QMP
 func (m *Manager) checkQMPStatus(ctx context.Context) (alive bool, connected bool, statusDetails map[string]interface{}, err error) {
+	if m.probeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.probeTimeout)
+		defer cancel()
+	}
+
 	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
 
 	// Try to connect to QMP
@@ -195,18 +308,132 @@ func (m *Manager) checkQMPStatus(ctx context.Context) (alive bool, connected boo
 
 	connected = true
 
-	// Check if VM is running via QMP
-	alive = qmpClient.IsRunning(ctx)
+	status, err := qmpClient.CheckStatus(ctx)
 
 	// Get detailed status if possible
 	statusDetails = make(map[string]interface{})
-	if status, err := qmpClient.CheckStatus(ctx); err == nil {
+	if err == nil {
 		statusDetails = status
+		alive, _ = status["running"].(bool)
 	}
 
 	return alive, connected, statusDetails, nil
 }
 
+// SocketReadiness reports whether an auto-injected socket exists on disk and
+// is backed by a chardev QEMU reports as created
+type SocketReadiness struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Exists  bool   `json:"exists"`
+	Chardev string `json:"chardev,omitempty"`
+	Ready   bool   `json:"ready"`
+}
+
+// SocketsReady cross-references the auto-injected sockets with QEMU's chardevs
+func (m *Manager) SocketsReady(ctx context.Context) []SocketReadiness {
+	var chardevs []map[string]interface{}
+
+	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
+	if err := qmpClient.Connect(ctx); err == nil {
+		defer qmpClient.Close()
+		if result, err := qmpClient.QueryChardev(ctx); err == nil {
+			chardevs = result
+		}
+	}
+
+	return crossReferenceSockets(map[string]string{
+		"qmp":     m.vmEntry.QmpSocketPath(),
+		"monitor": m.vmEntry.MonitorSocketPath(),
+	}, chardevs)
+}
+
+// crossReferenceSockets matches socket paths against the filenames of the
+// given chardevs (e.g. "unix:/path/qmp,server=on"), sorted by socket name
+func crossReferenceSockets(sockets map[string]string, chardevs []map[string]interface{}) []SocketReadiness {
+	var names []string
+	for name := range sockets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]SocketReadiness, 0, len(names))
+	for _, name := range names {
+		entry := SocketReadiness{Name: name, Path: sockets[name]}
+
+		if info, err := os.Stat(entry.Path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			entry.Exists = true
+		}
+
+		for _, chardev := range chardevs {
+			filename, _ := chardev["filename"].(string)
+			if strings.HasPrefix(filename, "unix:"+entry.Path+",") || filename == "unix:"+entry.Path {
+				entry.Chardev, _ = chardev["label"].(string)
+				break
+			}
+		}
+
+		entry.Ready = entry.Exists && entry.Chardev != ""
+		result = append(result, entry)
+	}
+	return result
+}
+
+// RawQMPStatus returns the complete QMP responses used to diagnose a VM
+func (m *Manager) RawQMPStatus(ctx context.Context) (map[string]interface{}, error) {
+	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
+	if err := qmpClient.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to QMP: %w", err)
+	}
+	defer qmpClient.Close()
+
+	return qmpClient.QueryRaw(ctx, []string{"query-status", "query-kvm", "query-current-machine"}), nil
+}
+
+// BlockJobs returns the block jobs currently running in the VM
+func (m *Manager) BlockJobs(ctx context.Context) ([]internal.BlockJob, error) {
+	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
+
+	if err := qmpClient.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to QMP: %w", err)
+	}
+	defer qmpClient.Close()
+
+	return qmpClient.QueryBlockJobs(ctx)
+}
+
+// PCIDevices queries the PCI topology of the VM
+func (m *Manager) PCIDevices(ctx context.Context) ([]internal.PCIBus, error) {
+	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
+
+	if err := qmpClient.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to QMP: %w", err)
+	}
+	defer qmpClient.Close()
+
+	return qmpClient.QueryPCI(ctx)
+}
+
+// Fdsets returns the fdsets of the VM, the file descriptors passed to QEMU with add-fd
+func (m *Manager) Fdsets(ctx context.Context) ([]internal.Fdset, error) {
+	qmpClient, err := m.QMPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer qmpClient.Close()
+
+	return qmpClient.QueryFdsets(ctx)
+}
+
+// QMPClient returns a QMP client connected to the VM, the caller must close it
+func (m *Manager) QMPClient(ctx context.Context) (*internal.QMPClient, error) {
+	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
+	if err := qmpClient.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to QMP: %w", err)
+	}
+	return qmpClient, nil
+}
+
 // forceKillPID sends SIGKILL to the process
 func (m *Manager) forceKillPID(pid int) error {
 	process, err := os.FindProcess(pid)
@@ -221,14 +448,31 @@ func (m *Manager) forceKillPID(pid int) error {
 	return nil
 }
 
+// killExitTimeout bounds the wait for a force-killed QEMU to go away
+var killExitTimeout = 5 * time.Second
+
+// killAndWait force kills the process and waits for it to exit, so its runtime
+// files are not removed from under a QEMU which is still running
+func (m *Manager) killAndWait(pid int) error {
+	if err := m.forceKillPID(pid); err != nil {
+		return fmt.Errorf("failed to force kill PID %d: %w", pid, err)
+	}
+
+	deadline := time.Now().Add(killExitTimeout)
+	for m.isProcessRunning(&pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("PID %d still running %s after force kill", pid, killExitTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil
+}
+
 // cleanupRuntimeFiles removes runtime files for the VM
 func (m *Manager) cleanupRuntimeFiles() error {
-	files := []string{
-		m.vmEntry.PidFilePath(),
-		m.vmEntry.SerialFilePath(),
-		m.vmEntry.QmpSocketPath(),
-		m.vmEntry.MonitorSocketPath(),
-		m.vmEntry.SshConfigPath(),
+	files, err := m.runtimeFiles()
+	if err != nil {
+		return err
 	}
 
 	for _, file := range files {
@@ -240,19 +484,45 @@ func (m *Manager) cleanupRuntimeFiles() error {
 	return nil
 }
 
-// getSSHPort retrieves the SSH port from the VM configuration
-func (m *Manager) getSSHPort() interface{} {
-	// Try the new nested structure first (vm.ssh.port)
-	if sshData, ok := m.vmEntry.Vars["ssh"].(map[string]interface{}); ok {
-		if port, exists := sshData["port"]; exists {
-			return port
+// runtimeFiles returns the paths of the runtime files removed once the VM
+// stopped, whether they exist or not. A PID file or QMP socket given with
+// --pid-from or --socket belongs to the tool which started QEMU and is left out.
+func (m *Manager) runtimeFiles() ([]string, error) {
+	var files []string
+	dataDir, _ := filepath.Abs(m.vmEntry.DataDir)
+	for _, file := range []string{
+		m.vmEntry.PidFilePath(),
+		m.vmEntry.SerialFilePath(),
+		m.vmEntry.QmpSocketPath(),
+		m.vmEntry.MonitorSocketPath(),
+		m.vmEntry.GuestAgentSocketPath(),
+		m.vmEntry.TPMSocketPath(),
+		m.vmEntry.SshConfigPath(),
+	} {
+		if strings.HasPrefix(file, dataDir+string(filepath.Separator)) {
+			files = append(files, file)
 		}
 	}
 
-	// Fall back to the old structure (ssh_host)
-	if port, exists := m.vmEntry.Vars["ssh_host"]; exists {
-		return port
+	// Stale ControlMaster sockets would wedge SSH after a restart
+	controlDir := m.vmEntry.SshControlDir()
+	entries, err := os.ReadDir(controlDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read SSH control directory: %w", err)
 	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, filepath.Join(controlDir, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
 
+// getSSHPort retrieves the SSH port from the VM configuration, nil if there is none
+func (m *Manager) getSSHPort() interface{} {
+	if port, ok := m.vmEntry.SSHPort(); ok {
+		return port
+	}
 	return nil
 }
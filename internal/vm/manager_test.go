@@ -3,13 +3,23 @@
 package vm
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"qqmgr/internal"
 	"qqmgr/internal/config"
 )
 
@@ -323,6 +333,84 @@ func TestManagerCleanupRuntimeFiles(t *testing.T) {
 	}
 }
 
+func TestManagerGetStatusProbeTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: tmpDir,
+	}
+
+	// A socket which is listening but never accepts, like a wedged QEMU
+	listener, err := net.Listen("unix", vmEntry.QmpSocketPath())
+	if err != nil {
+		t.Fatalf("Failed to create QMP socket: %v", err)
+	}
+	defer listener.Close()
+
+	manager := NewManager(vmEntry)
+	manager.SetProbeTimeout(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	status, err := manager.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetStatus() took %v, expected it to give up after the probe timeout", elapsed)
+	}
+	if status.IsAlive || status.QMPConnected {
+		t.Errorf("Expected unresponsive VM to be reported as not alive, got %+v", status)
+	}
+
+	start = time.Now()
+	if alive, _ := manager.IsAlive(ctx); alive {
+		t.Error("Expected IsAlive() to be false")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("IsAlive() took %v, expected it to give up after the probe timeout", elapsed)
+	}
+}
+
+func TestManagerCleanupSSHControlSockets(t *testing.T) {
+	tmpDir := t.TempDir()
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: tmpDir,
+	}
+	manager := NewManager(vmEntry)
+
+	controlDir := vmEntry.SshControlDir()
+	if err := os.MkdirAll(controlDir, 0755); err != nil {
+		t.Fatalf("Failed to create control directory: %v", err)
+	}
+
+	// A leftover ControlMaster socket and a stale plain file
+	socketPath := filepath.Join(controlDir, "master-localhost-2222")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create control socket: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	stalePath := filepath.Join(controlDir, "master-localhost-2223")
+	if err := os.WriteFile(stalePath, nil, 0600); err != nil {
+		t.Fatalf("Failed to create stale control file: %v", err)
+	}
+
+	if err := manager.cleanupRuntimeFiles(); err != nil {
+		t.Fatalf("Failed to cleanup runtime files: %v", err)
+	}
+
+	for _, path := range []string{socketPath, stalePath} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("Control socket %s should have been removed", path)
+		}
+	}
+}
+
 // TestManagerForceKillPID tests force kill functionality
 func TestManagerForceKillPID(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "vm-manager-test-*")
@@ -374,3 +462,611 @@ func BenchmarkManagerReadPIDFile(b *testing.B) {
 		}
 	}
 }
+
+func TestCrossReferenceSockets(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Sockets which exist on disk
+	qmpPath := filepath.Join(tmpDir, "qmp")
+	monitorPath := filepath.Join(tmpDir, "monitor")
+	for _, path := range []string{qmpPath, monitorPath} {
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to create socket: %v", err)
+		}
+		defer listener.Close()
+	}
+
+	sockets := map[string]string{
+		"qmp":     qmpPath,
+		"monitor": monitorPath,
+		"missing": filepath.Join(tmpDir, "missing"),
+	}
+	chardevs := []map[string]interface{}{
+		{"label": "compat_monitor1", "filename": "unix:" + qmpPath + ",server=on"},
+		{"label": "missing0", "filename": "unix:" + filepath.Join(tmpDir, "missing") + ",server=on"},
+		{"label": "serial0", "filename": "file"},
+	}
+
+	got := crossReferenceSockets(sockets, chardevs)
+	want := []SocketReadiness{
+		{Name: "missing", Path: sockets["missing"], Exists: false, Chardev: "missing0", Ready: false},
+		{Name: "monitor", Path: monitorPath, Exists: true, Chardev: "", Ready: false},
+		{Name: "qmp", Path: qmpPath, Exists: true, Chardev: "compat_monitor1", Ready: true},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("crossReferenceSockets() = %+v, want %+v", got, want)
+	}
+}
+
+func TestManagerEnsureStopped(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: t.TempDir(),
+	}
+	manager := NewManager(vmEntry)
+	manager.SetProbeTimeout(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := manager.EnsureStopped(ctx); err != nil {
+		t.Fatalf("EnsureStopped() on a stopped VM failed: %v", err)
+	}
+
+	// The current process stands in for a running QEMU
+	if err := os.WriteFile(vmEntry.PidFilePath(), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+
+	err := manager.EnsureStopped(ctx)
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("EnsureStopped() error = %v, want ErrAlreadyRunning", err)
+	}
+	if want := "VM 'test-vm' is already running (PID: " + strconv.Itoa(os.Getpid()) + ")"; err.Error() != want {
+		t.Errorf("EnsureStopped() error = %q, want %q", err.Error(), want)
+	}
+}
+
+// serveMockQMP runs a minimal QMP server on socketPath which reports runState for
+// query-status and, if set, emits event right after negotiation
+func serveMockQMP(t *testing.T, socketPath, runState, event string) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create QMP socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprintln(conn, `{"QMP":{"version":{},"capabilities":[]}}`)
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					switch {
+					case strings.Contains(scanner.Text(), "qmp_capabilities"):
+						fmt.Fprintln(conn, `{"return":{}}`)
+						if event != "" {
+							fmt.Fprintln(conn, event)
+						}
+					case strings.Contains(scanner.Text(), "system_powerdown"):
+						// The guest acknowledges the powerdown and QEMU exits
+						fmt.Fprintln(conn, `{"return":{}}`)
+						fmt.Fprintln(conn, `{"event":"POWERDOWN","timestamp":{"seconds":1700000000,"microseconds":0}}`)
+						fmt.Fprintln(conn, `{"event":"SHUTDOWN","data":{"guest":true,"reason":"guest-shutdown"},"timestamp":{"seconds":1700000001,"microseconds":0}}`)
+						return
+					case strings.Contains(scanner.Text(), "system_reset"):
+						fmt.Fprintln(conn, `{"return":{}}`)
+						fmt.Fprintln(conn, `{"event":"RESET","data":{"guest":false,"reason":"host-qmp-system-reset"},"timestamp":{"seconds":1700000000,"microseconds":0}}`)
+					case strings.Contains(scanner.Text(), "query-status"):
+						fmt.Fprintf(conn, `{"return":{"running":%t,"singlestep":false,"status":%q}}`+"\n", runState == "running", runState)
+					default:
+						fmt.Fprintln(conn, `{"return":{}}`)
+					}
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestManagerWatchPanic(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: t.TempDir(),
+	}
+	serveMockQMP(t, vmEntry.QmpSocketPath(), "guest-panicked",
+		`{"event":"GUEST_PANICKED","data":{"action":"pause","info":{"type":"hyper-v","arg1":1}},"timestamp":{"seconds":1700000000,"microseconds":0}}`)
+	manager := NewManager(vmEntry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	panicked := make(chan *internal.QMPEvent, 1)
+	done := manager.WatchPanic(ctx, func(event *internal.QMPEvent) {
+		panicked <- event
+	})
+
+	select {
+	case event := <-panicked:
+		if event.Event != EventGuestPanicked {
+			t.Errorf("Expected %s, got %s", EventGuestPanicked, event.Event)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the panic to be reported")
+	}
+	<-done
+
+	recorded, err := manager.RecordedPanic()
+	if err != nil || recorded == nil {
+		t.Fatalf("Expected the panic to be recorded, got %v, %v", recorded, err)
+	}
+
+	status, err := manager.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus() failed: %v", err)
+	}
+	if !status.Panicked {
+		t.Errorf("Expected status to flag the panic, got %+v", status)
+	}
+	if status.VMStatus == nil || !status.VMStatus.IsPanicked() {
+		t.Errorf("Expected guest-panicked run state, got %+v", status.VMStatus)
+	}
+	if status.PanicInfo["action"] != "pause" {
+		t.Errorf("Expected the panic info to be reported, got %v", status.PanicInfo)
+	}
+}
+
+// TestManagerWatchPanicReset tests that a panic whose action resets the guest is
+// found in the serial log, as the guest runs again by the next check and QEMU
+// does not replay the GUEST_PANICKED event
+func TestManagerWatchPanicReset(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: t.TempDir(),
+	}
+	serveMockQMP(t, vmEntry.QmpSocketPath(), "running", "")
+	if err := os.WriteFile(vmEntry.SerialFilePath(), []byte("Booting Linux\n"), 0644); err != nil {
+		t.Fatalf("Failed to write serial log: %v", err)
+	}
+	manager := NewManager(vmEntry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	panicked := make(chan *internal.QMPEvent, 1)
+	done := manager.WatchPanic(ctx, func(event *internal.QMPEvent) {
+		panicked <- event
+	})
+
+	// The guest panics and is reset between two checks
+	time.Sleep(100 * time.Millisecond)
+	serial, err := os.OpenFile(vmEntry.SerialFilePath(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open serial log: %v", err)
+	}
+	fmt.Fprint(serial, "[    4.2] Kernel panic - not syncing: Attempted to kill init!\n[    4.3] Rebooting in 1 seconds..\nBooting Linux\n")
+	serial.Close()
+
+	select {
+	case event := <-panicked:
+		if event.Event != EventGuestPanicked {
+			t.Errorf("Expected %s, got %s", EventGuestPanicked, event.Event)
+		}
+		if event.Data["serial"] != "Kernel panic - not syncing: Attempted to kill init!" {
+			t.Errorf("Expected the panic line in the event, got %v", event.Data)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the panic to be reported")
+	}
+	<-done
+
+	status, err := manager.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus() failed: %v", err)
+	}
+	if !status.Panicked || status.PanicInfo["serial"] == nil {
+		t.Errorf("Expected status to flag the recorded panic, got %+v", status)
+	}
+}
+
+func TestManagerWatchPanicReleasesQMP(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: t.TempDir(),
+	}
+	listener, err := net.Listen("unix", vmEntry.QmpSocketPath())
+	if err != nil {
+		t.Fatalf("Failed to create QMP socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	// Like QEMU, serve one client at a time. The guest panics once the third
+	// client asked, without an event anyone sees.
+	var statusQueries atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprintln(conn, `{"QMP":{"version":{},"capabilities":[]}}`)
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				if !strings.Contains(scanner.Text(), "query-status") {
+					fmt.Fprintln(conn, `{"return":{}}`)
+					continue
+				}
+				state := "running"
+				if statusQueries.Add(1) > 2 {
+					state = "guest-panicked"
+				}
+				fmt.Fprintf(conn, `{"return":{"running":%t,"singlestep":false,"status":%q}}`+"\n", state == "running", state)
+			}
+			conn.Close()
+		}
+	}()
+
+	manager := NewManager(vmEntry)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	panicked := make(chan *internal.QMPEvent, 1)
+	done := manager.WatchPanic(ctx, func(event *internal.QMPEvent) {
+		panicked <- event
+	})
+
+	// Another client gets through while the watch is running
+	time.Sleep(100 * time.Millisecond)
+	status, err := manager.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus() failed: %v", err)
+	}
+	if !status.QMPConnected {
+		t.Error("Expected status to reach QMP while the panic watch runs")
+	}
+
+	select {
+	case event := <-panicked:
+		if event.Event != EventGuestPanicked {
+			t.Errorf("Expected %s, got %s", EventGuestPanicked, event.Event)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for the panicked run state to be reported")
+	}
+	<-done
+
+	if recorded, err := manager.RecordedPanic(); err != nil || recorded == nil {
+		t.Errorf("Expected the panic to be recorded, got %v, %v", recorded, err)
+	}
+}
+
+func TestManagerGetStatusExternalPaths(t *testing.T) {
+	externalDir := t.TempDir()
+	vmEntry := &config.VmEntry{
+		Name:      "external",
+		DataDir:   t.TempDir(),
+		QmpSocket: filepath.Join(externalDir, "qemu.qmp"),
+		PidFile:   filepath.Join(externalDir, "qemu.pid"),
+	}
+
+	// The current process stands in for a QEMU started by another tool
+	if err := os.WriteFile(vmEntry.PidFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	serveMockQMP(t, vmEntry.QmpSocket, "running", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	status, err := NewManager(vmEntry).GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus() failed: %v", err)
+	}
+	if status.QMPSocket != vmEntry.QmpSocket || status.PIDFile != vmEntry.PidFile {
+		t.Errorf("Expected the injected paths to be used, got socket %s and PID file %s", status.QMPSocket, status.PIDFile)
+	}
+	if status.PID == nil || *status.PID != os.Getpid() {
+		t.Errorf("Expected PID %d from the injected PID file, got %v", os.Getpid(), status.PID)
+	}
+	if !status.QMPConnected || !status.IsRunning {
+		t.Errorf("Expected the VM to be reached through the injected socket, got %+v", status)
+	}
+}
+
+func TestManagerCleanupKeepsExternalPaths(t *testing.T) {
+	externalDir := t.TempDir()
+	vmEntry := &config.VmEntry{
+		Name:      "external",
+		DataDir:   t.TempDir(),
+		QmpSocket: filepath.Join(externalDir, "qemu.qmp"),
+		PidFile:   filepath.Join(externalDir, "qemu.pid"),
+	}
+	for _, file := range []string{vmEntry.QmpSocket, vmEntry.PidFile, vmEntry.SerialFilePath()} {
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", file, err)
+		}
+	}
+
+	if err := NewManager(vmEntry).cleanupRuntimeFiles(); err != nil {
+		t.Fatalf("cleanupRuntimeFiles() failed: %v", err)
+	}
+
+	// The files of the tool which started QEMU stay, those of qqmgr go
+	for _, file := range []string{vmEntry.QmpSocket, vmEntry.PidFile} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected %s to be kept: %v", file, err)
+		}
+	}
+	if _, err := os.Stat(vmEntry.SerialFilePath()); !os.IsNotExist(err) {
+		t.Errorf("Expected the serial file to be removed, got %v", err)
+	}
+}
+
+func TestManagerStopWithEvents(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: t.TempDir(),
+	}
+
+	// A sleep process stands in for QEMU so a forced kill cannot hit the test
+	qemu := exec.Command("sleep", "30")
+	if err := qemu.Start(); err != nil {
+		t.Fatalf("Failed to start mock QEMU: %v", err)
+	}
+	t.Cleanup(func() {
+		qemu.Process.Kill()
+		qemu.Wait()
+	})
+	if err := os.WriteFile(vmEntry.PidFilePath(), []byte(strconv.Itoa(qemu.Process.Pid)), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	serveMockQMP(t, vmEntry.QmpSocketPath(), "running", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	success, events, err := NewManager(vmEntry).StopWithEvents(ctx, 2*time.Second, false)
+	if err != nil {
+		t.Fatalf("StopWithEvents() failed: %v", err)
+	}
+	if !success {
+		t.Error("Expected the acknowledged powerdown to succeed")
+	}
+
+	var names []string
+	for _, event := range events {
+		names = append(names, event.Event)
+	}
+	if strings.Join(names, ",") != "POWERDOWN,SHUTDOWN" {
+		t.Errorf("Expected POWERDOWN and SHUTDOWN to be reported, got %v", names)
+	}
+}
+
+func TestManagerStopKeepsRunningFiles(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: t.TempDir(),
+	}
+
+	qemu := exec.Command("sleep", "30")
+	if err := qemu.Start(); err != nil {
+		t.Fatalf("Failed to start mock QEMU: %v", err)
+	}
+	t.Cleanup(func() {
+		qemu.Process.Kill()
+		qemu.Wait()
+	})
+	if err := os.WriteFile(vmEntry.PidFilePath(), []byte(strconv.Itoa(qemu.Process.Pid)), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+
+	// QEMU acknowledges the powerdown but the guest ignores it
+	listener, err := net.Listen("unix", vmEntry.QmpSocketPath())
+	if err != nil {
+		t.Fatalf("Failed to create QMP socket: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprintln(conn, `{"QMP":{"version":{},"capabilities":[]}}`)
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					if strings.Contains(scanner.Text(), "query-status") {
+						fmt.Fprintln(conn, `{"return":{"running":true,"singlestep":false,"status":"running"}}`)
+						continue
+					}
+					fmt.Fprintln(conn, `{"return":{}}`)
+				}
+			}(conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	success, _, err := NewManager(vmEntry).StopWithEvents(ctx, 300*time.Millisecond, false)
+	if err != nil {
+		t.Fatalf("StopWithEvents() failed: %v", err)
+	}
+	if success {
+		t.Error("Expected the ignored powerdown not to succeed")
+	}
+
+	// The files of the still running QEMU are kept
+	for _, file := range []string{vmEntry.PidFilePath(), vmEntry.QmpSocketPath()} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected %s to be kept: %v", file, err)
+		}
+	}
+}
+
+func TestManagerPlanStop(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		DataDir: t.TempDir(),
+	}
+
+	qemu := exec.Command("sleep", "30")
+	if err := qemu.Start(); err != nil {
+		t.Fatalf("Failed to start mock QEMU: %v", err)
+	}
+	t.Cleanup(func() {
+		qemu.Process.Kill()
+		qemu.Wait()
+	})
+	pid := qemu.Process.Pid
+	if err := os.WriteFile(vmEntry.PidFilePath(), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	serveMockQMP(t, vmEntry.QmpSocketPath(), "running", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	manager := NewManager(vmEntry)
+	status, err := manager.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus() failed: %v", err)
+	}
+	steps, err := manager.PlanStop(status, 20*time.Second, true)
+	if err != nil {
+		t.Fatalf("PlanStop() failed: %v", err)
+	}
+	want := []string{
+		"request graceful shutdown via QMP system_powerdown, waiting up to 20s",
+		fmt.Sprintf("force-kill PID %d if it is still running after 20s", pid),
+		"remove " + vmEntry.PidFilePath(),
+		"remove " + vmEntry.QmpSocketPath(),
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("PlanStop() = %q, want %q", steps, want)
+	}
+
+	// Planning must not have powered down, killed or cleaned up anything
+	status, err = manager.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("GetStatus() failed: %v", err)
+	}
+	if !status.IsRunning || !status.QMPConnected {
+		t.Errorf("Expected the VM to still be running, got %+v", status)
+	}
+	if _, err := os.Stat(vmEntry.PidFilePath()); err != nil {
+		t.Errorf("Expected the PID file to remain: %v", err)
+	}
+}
+
+func TestManagerExport(t *testing.T) {
+	vmEntry := &config.VmEntry{
+		Name:    "test-vm",
+		Cmd:     []string{"-m 1G", "-nodefaults"},
+		Vars:    map[string]interface{}{"ssh": map[string]interface{}{"port": int64(2222), "user": "root"}},
+		DataDir: t.TempDir(),
+		Env:     map[string]string{"TMPDIR": "/tmp"},
+	}
+	if err := os.WriteFile(vmEntry.PidFilePath(), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+	serveMockQMP(t, vmEntry.QmpSocketPath(), "running", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	export := NewManager(vmEntry).Export(ctx)
+	if export.Status == nil || !export.Status.IsRunning {
+		t.Errorf("Expected a running status, got %+v", export.Status)
+	}
+	if export.SSH.Port != int64(2222) || export.SSH.User != "root" {
+		t.Errorf("Unexpected SSH info: %+v", export.SSH)
+	}
+	if _, ok := export.QMP["query-status"]; !ok {
+		t.Errorf("Expected the live query-status response, got %v", export.QMP)
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("Failed to marshal export: %v", err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatalf("Failed to parse export: %v", err)
+	}
+	for _, key := range []string{"name", "command", "env", "data_dir", "status", "sockets", "ssh", "qmp", "cpus", "recorded_panic", "logs", "errors"} {
+		if _, ok := document[key]; !ok {
+			t.Errorf("Expected key %q in export, got %s", key, data)
+		}
+	}
+}
+
+// TestManagerWatchStatus tests that the watch loop releases the QMP connection
+// after each tick, as QEMU serves one client at a time and stop must get through
+func TestManagerWatchStatus(t *testing.T) {
+	vmEntry := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+	listener, err := net.Listen("unix", vmEntry.QmpSocketPath())
+	if err != nil {
+		t.Fatalf("Failed to create QMP socket: %v", err)
+	}
+	defer listener.Close()
+
+	// Like QEMU, greet the next client only once the previous one disconnected
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprintln(conn, `{"QMP":{"version":{},"capabilities":[]}}`)
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					if strings.Contains(scanner.Text(), "query-status") {
+						fmt.Fprintln(conn, `{"return":{"running":true,"singlestep":false,"status":"running"}}`)
+						continue
+					}
+					fmt.Fprintln(conn, `{"return":{}}`)
+				}
+			}(conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	manager := NewManager(vmEntry)
+	ticks := 0
+	manager.WatchStatus(ctx, 10*time.Millisecond, func(status *Status, err error) {
+		if err != nil || !status.IsRunning {
+			t.Errorf("Tick %d: expected a running VM, got %+v, %v", ticks, status, err)
+		}
+
+		// Another command gets through between ticks
+		raw, err := manager.RawQMPStatus(ctx)
+		if err != nil || raw["query-kvm"] == nil {
+			t.Errorf("Tick %d: expected raw responses, got %v, %v", ticks, raw, err)
+		}
+		if ticks++; ticks == 3 {
+			cancel()
+		}
+	})
+
+	if ticks != 3 {
+		t.Errorf("Expected 3 ticks, got %d", ticks)
+	}
+	if n := connections.Load(); n != 6 {
+		t.Errorf("Expected a connection per status check, got %d for 6 checks", n)
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"qqmgr/internal/config"
+)
+
+// Orphan describes a runtime directory with no matching VM or image in the configuration
+type Orphan struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"` // "vm" or "img"
+	Name string `json:"name"`
+	PID  *int   `json:"pid,omitempty"`
+	Live bool   `json:"live"` // PID file points at a running process, never remove
+}
+
+// FindOrphans lists the vm.* and img.* directories under runtimeDir which do not
+// correspond to any of the given VM or image names
+func FindOrphans(runtimeDir string, vmNames, imgNames []string) ([]Orphan, error) {
+	entries, err := os.ReadDir(runtimeDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read runtime directory: %w", err)
+	}
+
+	known := map[string]map[string]bool{
+		"vm":  make(map[string]bool),
+		"img": make(map[string]bool),
+	}
+	for _, name := range vmNames {
+		known["vm"][name] = true
+	}
+	for _, name := range imgNames {
+		known["img"][name] = true
+	}
+
+	var orphans []Orphan
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		kind, name, ok := strings.Cut(entry.Name(), ".")
+		if !ok || known[kind] == nil || known[kind][name] {
+			continue
+		}
+
+		orphan := Orphan{
+			Path: filepath.Join(runtimeDir, entry.Name()),
+			Kind: kind,
+			Name: name,
+		}
+
+		if kind == "vm" {
+			manager := NewManager(&config.VmEntry{Name: name, DataDir: orphan.Path})
+			// An unreadable PID file is treated as stale
+			if pid, err := manager.readPIDFile(); err == nil && pid != nil {
+				orphan.PID = pid
+				orphan.Live = manager.isProcessRunning(pid)
+			}
+		}
+
+		orphans = append(orphans, orphan)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Path < orphans[j].Path
+	})
+
+	return orphans, nil
+}
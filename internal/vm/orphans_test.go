@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestFindOrphans tests orphan detection against a runtime dir with live-config and stale entries
+func TestFindOrphans(t *testing.T) {
+	runtimeDir := t.TempDir()
+
+	dirs := []string{
+		"vm.configured",
+		"vm.removed",
+		"vm.removed-but-running",
+		"img.configured",
+		"img.removed",
+		"download_cache",
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(runtimeDir, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+
+	// The current process stands in for a still-running QEMU
+	pidFile := filepath.Join(runtimeDir, "vm.removed-but-running", "pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		t.Fatalf("Failed to write PID file: %v", err)
+	}
+
+	orphans, err := FindOrphans(runtimeDir, []string{"configured"}, []string{"configured"})
+	if err != nil {
+		t.Fatalf("FindOrphans() failed: %v", err)
+	}
+
+	expected := map[string]bool{
+		"vm.removed":             false,
+		"vm.removed-but-running": true,
+		"img.removed":            false,
+	}
+	if len(orphans) != len(expected) {
+		t.Fatalf("Expected %d orphans, got %d: %+v", len(expected), len(orphans), orphans)
+	}
+
+	for _, orphan := range orphans {
+		live, ok := expected[filepath.Base(orphan.Path)]
+		if !ok {
+			t.Errorf("Unexpected orphan: %s", orphan.Path)
+			continue
+		}
+		if orphan.Live != live {
+			t.Errorf("Orphan %s: expected live=%v, got %v", orphan.Path, live, orphan.Live)
+		}
+	}
+
+	// A missing runtime directory has no orphans
+	orphans, err = FindOrphans(filepath.Join(runtimeDir, "missing"), nil, nil)
+	if err != nil {
+		t.Fatalf("FindOrphans() on missing dir failed: %v", err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("Expected no orphans for missing runtime dir, got %d", len(orphans))
+	}
+}
@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"qqmgr/internal"
+)
+
+// EventGuestPanicked is the QMP event QEMU emits when the guest kernel panics
+const EventGuestPanicked = "GUEST_PANICKED"
+
+// ErrGuestPanicked is reported when the guest kernel panicked
+var ErrGuestPanicked = errors.New("guest panicked")
+
+// panicPollInterval is how often WatchPanic checks the VM for a panic
+const panicPollInterval = time.Second
+
+// serialPanicMarker starts the line a Linux guest logs to its console on a panic
+var serialPanicMarker = []byte("Kernel panic - not syncing")
+
+// WatchPanic checks the VM every panicPollInterval and calls onPanic once if
+// the guest panicked, after recording the event to the VM's panic file. QEMU
+// serves one QMP client at a time, so each check uses a short-lived connection
+// and other commands like stop or status can connect in between. The returned
+// channel is closed once watching ended after ctx is done.
+//
+// QEMU does not replay the GUEST_PANICKED event to later connections, and only
+// stays in the guest-panicked run state if the panic action pauses the guest.
+// Panics which reset the guest or shut QEMU down are found in the serial log.
+func (m *Manager) WatchPanic(ctx context.Context, onPanic func(*internal.QMPEvent)) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var serialOffset int64
+		ticker := time.NewTicker(panicPollInterval)
+		defer ticker.Stop()
+		for {
+			if event := m.checkPanic(ctx, &serialOffset); event != nil {
+				onPanic(event)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// checkPanic returns the guest's panic if it is recorded already, QEMU reports
+// it through a GUEST_PANICKED event or the guest-panicked run state, or the
+// serial log read on from serialOffset shows it, nil if the guest did not panic
+func (m *Manager) checkPanic(ctx context.Context, serialOffset *int64) *internal.QMPEvent {
+	if event, err := m.RecordedPanic(); err == nil && event != nil {
+		return event
+	}
+
+	if event := m.checkQMPPanic(ctx); event != nil {
+		return event
+	}
+	if event := m.checkSerialPanic(serialOffset); event != nil {
+		m.recordPanic(event)
+		return event
+	}
+	return nil
+}
+
+// checkQMPPanic returns the panic QEMU reports through a GUEST_PANICKED event or
+// the guest-panicked run state, nil if there is none or QMP is not reachable
+func (m *Manager) checkQMPPanic(ctx context.Context) *internal.QMPEvent {
+	checkCtx, cancel := context.WithTimeout(ctx, defaultProbeTimeout)
+	defer cancel()
+
+	qmpClient := internal.NewQMPClient(m.vmEntry.QmpSocketPath())
+	if err := qmpClient.Connect(checkCtx); err != nil {
+		return nil
+	}
+	defer qmpClient.Close()
+
+	status, err := qmpClient.QueryStatus(checkCtx)
+	if err != nil {
+		return nil
+	}
+
+	// The event carries the panic details, the run state only that QEMU paused on it
+	for _, event := range qmpClient.GetEvents() {
+		if event.Event == EventGuestPanicked {
+			m.recordPanic(&event)
+			return &event
+		}
+	}
+	if status.IsPanicked() {
+		event := &internal.QMPEvent{Event: EventGuestPanicked, Data: map[string]interface{}{"action": "pause"}}
+		m.recordPanic(event)
+		return event
+	}
+	return nil
+}
+
+// checkSerialPanic scans the complete lines of the serial log from offset for a
+// kernel panic, advancing offset past them. The event carries the panic line,
+// as the action QEMU took on it is not known from the log.
+func (m *Manager) checkSerialPanic(offset *int64) *internal.QMPEvent {
+	file, err := os.Open(m.vmEntry.SerialFilePath())
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(*offset, io.SeekStart); err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil
+	}
+
+	// A line still being written is scanned again once it is complete
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil
+	}
+	*offset += int64(end + 1)
+
+	for _, line := range bytes.Split(data[:end], []byte("\n")) {
+		if i := bytes.Index(line, serialPanicMarker); i >= 0 {
+			message := string(bytes.TrimSpace(line[i:]))
+			return &internal.QMPEvent{Event: EventGuestPanicked, Data: map[string]interface{}{"serial": message}}
+		}
+	}
+	return nil
+}
+
+// RecordedPanic returns the GUEST_PANICKED event recorded since the VM was
+// started, or nil if there is none
+func (m *Manager) RecordedPanic() (*internal.QMPEvent, error) {
+	data, err := os.ReadFile(m.vmEntry.PanicFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read panic file: %w", err)
+	}
+
+	var event internal.QMPEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("failed to parse panic file: %w", err)
+	}
+	return &event, nil
+}
+
+// recordPanic writes event to the VM's panic file, errors are not fatal as the
+// panic is still reported to the caller
+func (m *Manager) recordPanic(event *internal.QMPEvent) {
+	data, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(m.vmEntry.PanicFilePath(), data, 0644)
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EventReset is the QMP event QEMU emits once the guest has been reset
+const EventReset = "RESET"
+
+// resetEventTimeout bounds the wait for QEMU's RESET event
+var resetEventTimeout = 10 * time.Second
+
+// Reset resets the running guest and waits for QEMU to confirm it with a RESET
+// event, releasing the QMP connection again for others. A guest which is not
+// running is not reset.
+func (m *Manager) Reset(ctx context.Context) error {
+	qmpClient, err := m.QMPClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer qmpClient.Close()
+
+	status, err := qmpClient.QueryStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("querying VM status: %w", err)
+	}
+	if !status.Running {
+		return fmt.Errorf("VM is not running (status: %s)", status.Status)
+	}
+
+	if err := qmpClient.SystemReset(ctx); err != nil {
+		return err
+	}
+	eventCtx, cancel := context.WithTimeout(ctx, resetEventTimeout)
+	defer cancel()
+	if _, err := qmpClient.WaitForEvent(eventCtx, EventReset); err != nil {
+		return fmt.Errorf("waiting for %s event: %w", EventReset, err)
+	}
+	return nil
+}
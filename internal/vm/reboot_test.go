@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"qqmgr/internal/config"
+)
+
+func TestManagerReset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The mock confirms system_reset with a RESET event
+	running := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+	serveMockQMP(t, running.QmpSocketPath(), "running", "")
+	if err := NewManager(running).Reset(ctx); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	// A paused guest is not reset
+	paused := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+	serveMockQMP(t, paused.QmpSocketPath(), "paused", "")
+	err := NewManager(paused).Reset(ctx)
+	if err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("Expected a not running error, got %v", err)
+	}
+}
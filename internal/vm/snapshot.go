@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// thawTimeout bounds thawing the guest's filesystems, which must happen even
+// when the snapshot ran out of time
+const thawTimeout = 10 * time.Second
+
+// CreateSnapshot takes an internal snapshot named name of the running VM. With
+// freeze, the guest's filesystems are frozen through the guest agent for the
+// duration of the snapshot and thawed afterwards, also if it failed. A VM
+// without a responding guest agent is snapshotted unfrozen and warn is called.
+func (m *Manager) CreateSnapshot(ctx context.Context, name string, freeze bool, warn func(string)) (err error) {
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("invalid snapshot name '%s'", name)
+	}
+
+	if freeze {
+		agent, agentErr := m.GuestAgentClient(ctx)
+		if agentErr != nil {
+			warn(fmt.Sprintf("not freezing filesystems, guest agent unavailable: %v", agentErr))
+		} else {
+			defer agent.Close()
+			// Thaw also if the freeze failed half-way, leaving some filesystems frozen
+			defer func() {
+				thawCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), thawTimeout)
+				defer cancel()
+				if _, thawErr := agent.FsThaw(thawCtx); thawErr != nil {
+					err = errors.Join(err, fmt.Errorf("failed to thaw guest filesystems: %w", thawErr))
+				}
+			}()
+			if _, err := agent.FsFreeze(ctx); err != nil {
+				return fmt.Errorf("failed to freeze guest filesystems: %w", err)
+			}
+		}
+	}
+
+	qmpClient, err := m.QMPClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer qmpClient.Close()
+
+	return qmpClient.SaveVM(ctx, name)
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"qqmgr/internal/config"
+)
+
+// commandLog records the commands the mock QMP and guest agent servers received, in order
+type commandLog struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (l *commandLog) add(command string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.commands = append(l.commands, command)
+}
+
+func (l *commandLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.commands, " ")
+}
+
+// serveSnapshotMocks serves QMP and, unless agentSocket is empty, the guest
+// agent, logging savevm, freeze and thaw. savevmOutput is what HMP answers to savevm.
+func serveSnapshotMocks(t *testing.T, qmpSocket, agentSocket, savevmOutput string, log *commandLog) {
+	serve := func(socketPath string, handle func(conn net.Conn, cmd map[string]interface{})) {
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			t.Fatalf("Failed to create socket: %v", err)
+		}
+		t.Cleanup(func() { listener.Close() })
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func(conn net.Conn) {
+					defer conn.Close()
+					handle(conn, nil)
+					scanner := bufio.NewScanner(conn)
+					for scanner.Scan() {
+						var cmd map[string]interface{}
+						if err := json.Unmarshal(scanner.Bytes(), &cmd); err != nil {
+							continue
+						}
+						handle(conn, cmd)
+					}
+				}(conn)
+			}
+		}()
+	}
+
+	serve(qmpSocket, func(conn net.Conn, cmd map[string]interface{}) {
+		if cmd == nil {
+			fmt.Fprintln(conn, `{"QMP":{"version":{},"capabilities":[]}}`)
+			return
+		}
+		args, _ := cmd["arguments"].(map[string]interface{})
+		switch cmd["execute"] {
+		case "human-monitor-command":
+			log.add(fmt.Sprint(args["command-line"]))
+			fmt.Fprintf(conn, `{"return":%q}`+"\n", savevmOutput)
+		case "query-chardev":
+			fmt.Fprintln(conn, `{"return":[]}`)
+		default:
+			fmt.Fprintln(conn, `{"return":{}}`)
+		}
+	})
+
+	if agentSocket == "" {
+		return
+	}
+	serve(agentSocket, func(conn net.Conn, cmd map[string]interface{}) {
+		if cmd == nil {
+			return
+		}
+		args, _ := cmd["arguments"].(map[string]interface{})
+		switch cmd["execute"] {
+		case "guest-sync":
+			id, _ := json.Marshal(args["id"])
+			fmt.Fprintf(conn, `{"return":%s}`+"\n", id)
+		case "guest-fsfreeze-freeze", "guest-fsfreeze-thaw":
+			log.add(fmt.Sprint(cmd["execute"]))
+			fmt.Fprintln(conn, `{"return":2}`)
+		default:
+			fmt.Fprintln(conn, `{"return":{}}`)
+		}
+	})
+}
+
+func TestManagerCreateSnapshotFreeze(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name         string
+		savevmOutput string
+		wantErr      bool
+	}{
+		{name: "success"},
+		// The filesystems are thawed also if the snapshot fails
+		{name: "savevm fails", savevmOutput: "Error: Device 'drive0' is writable but does not support snapshots\r\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmEntry := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir(), GuestAgent: true}
+			log := &commandLog{}
+			serveSnapshotMocks(t, vmEntry.QmpSocketPath(), vmEntry.GuestAgentSocketPath(), tt.savevmOutput, log)
+
+			var warnings []string
+			err := NewManager(vmEntry).CreateSnapshot(ctx, "before-upgrade", true, func(warning string) {
+				warnings = append(warnings, warning)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateSnapshot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := log.String(); got != "guest-fsfreeze-freeze savevm before-upgrade guest-fsfreeze-thaw" {
+				t.Errorf("Expected freeze, savevm and thaw in order, got %q", got)
+			}
+			if len(warnings) != 0 {
+				t.Errorf("Expected no warnings, got %v", warnings)
+			}
+		})
+	}
+}
+
+func TestManagerCreateSnapshotNoAgent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Without a guest agent channel the snapshot is taken unfrozen, with a warning
+	vmEntry := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+	log := &commandLog{}
+	serveSnapshotMocks(t, vmEntry.QmpSocketPath(), "", "", log)
+
+	var warnings []string
+	err := NewManager(vmEntry).CreateSnapshot(ctx, "snap1", true, func(warning string) {
+		warnings = append(warnings, warning)
+	})
+	if err != nil {
+		t.Fatalf("CreateSnapshot() failed: %v", err)
+	}
+	if got := log.String(); got != "savevm snap1" {
+		t.Errorf("Expected only savevm, got %q", got)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "guest agent") {
+		t.Errorf("Expected a warning about the guest agent, got %v", warnings)
+	}
+
+	if err := NewManager(vmEntry).CreateSnapshot(ctx, "has space", false, func(string) {}); err == nil {
+		t.Errorf("Expected an error for a snapshot name with whitespace")
+	}
+}
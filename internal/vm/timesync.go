@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"qqmgr/internal"
+)
+
+// TimeSync reports what SyncTime could do to bring the guest clock back in line
+type TimeSync struct {
+	RTCReset    bool   // QEMU dropped the pending RTC reinjection backlog, false if the target lacks it
+	AgentSocket string // Socket of the guest agent channel, empty if none is configured
+	ClockSet    bool   // The guest agent set the guest clock to the host time
+	AgentError  string // Why the guest agent could not set the clock
+}
+
+// SyncTime resets QEMU's RTC reinjection and, if the VM has a guest agent
+// channel, sets the guest clock to the host's time. A missing or unresponsive
+// agent is not an error, it is reported in the result instead.
+func (m *Manager) SyncTime(ctx context.Context) (*TimeSync, error) {
+	result := &TimeSync{}
+
+	qmpClient, err := m.QMPClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = qmpClient.ResetRTCReinjection(ctx)
+	// The agent socket is served by QEMU too, release QMP for others first
+	qmpClient.Close()
+	if err != nil && !errors.Is(err, internal.ErrCommandNotFound) {
+		return nil, err
+	}
+	result.RTCReset = err == nil
+
+	result.AgentSocket, err = m.GuestAgentSocket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if result.AgentSocket == "" {
+		return result, nil
+	}
+
+	agent, err := m.GuestAgentClient(ctx)
+	if err != nil {
+		result.AgentError = err.Error()
+		return result, nil
+	}
+	defer agent.Close()
+
+	if err := agent.SetTime(ctx, time.Now()); err != nil {
+		result.AgentError = err.Error()
+		return result, nil
+	}
+	result.ClockSet = true
+	return result, nil
+}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"qqmgr/internal/config"
+)
+
+// tpmSocketTimeout bounds waiting for swtpm to create its socket
+const tpmSocketTimeout = 5 * time.Second
+
+// CheckSwtpm fails if swtpm, which emulates the TPM of VMs with tpm = true, is not in PATH
+func CheckSwtpm() error {
+	if _, err := exec.LookPath("swtpm"); err != nil {
+		return fmt.Errorf("tuning option 'tpm' requires swtpm in PATH: %w", err)
+	}
+	return nil
+}
+
+// StartTPM starts the swtpm emulating the VM's TPM, QEMU must be started
+// afterwards. swtpm runs in the background and exits once QEMU disconnects.
+func (m *Manager) StartTPM() error {
+	if err := CheckSwtpm(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.vmEntry.TPMStateDir(), 0700); err != nil {
+		return fmt.Errorf("failed to create TPM state directory: %w", err)
+	}
+
+	socketPath := m.vmEntry.TPMSocketPath()
+	os.Remove(socketPath)
+
+	output, err := exec.Command("swtpm", swtpmArgs(m.vmEntry)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start swtpm: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	deadline := time.Now().Add(tpmSocketTimeout)
+	for {
+		if _, err := os.Stat(socketPath); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			m.StopTPM()
+			return fmt.Errorf("swtpm did not create its socket %s within %s", socketPath, tpmSocketTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// StopTPM stops the swtpm started by StartTPM. swtpm's --terminate only ends it
+// once a connected QEMU disconnects, so a QEMU that failed to start would leave
+// it running. An swtpm which already exited is left alone.
+func (m *Manager) StopTPM() {
+	pidPath := tpmPidFilePath(m.vmEntry)
+	if data, err := os.ReadFile(pidPath); err == nil {
+		// The PID may have been reused since swtpm exited
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && processName(pid) == "swtpm" {
+			syscall.Kill(pid, syscall.SIGTERM)
+		}
+	}
+	os.Remove(pidPath)
+	os.Remove(m.vmEntry.TPMSocketPath())
+}
+
+// processName returns the command name of the process pid, empty if there is none
+func processName(pid int) string {
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+// tpmPidFilePath returns the path swtpm writes its PID to, next to its socket
+func tpmPidFilePath(vmEntry *config.VmEntry) string {
+	return filepath.Join(filepath.Dir(vmEntry.TPMSocketPath()), "swtpm.pid")
+}
+
+// swtpmArgs returns the arguments starting a daemonized TPM 2.0 swtpm for
+// vmEntry, which keeps its state in the VM's TPM state directory
+func swtpmArgs(vmEntry *config.VmEntry) []string {
+	return []string{
+		"socket", "--tpm2",
+		"--tpmstate", "dir=" + vmEntry.TPMStateDir(),
+		"--ctrl", "type=unixio,path=" + vmEntry.TPMSocketPath(),
+		"--log", "file=" + filepath.Join(filepath.Dir(vmEntry.TPMSocketPath()), "swtpm.log"),
+		"--pid", "file=" + tpmPidFilePath(vmEntry),
+		"--terminate", "--daemon",
+	}
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vmutil
+
+import (
+	"fmt"
+	"os"
+	"qqmgr/internal/config"
+	"syscall"
+)
+
+// VMLock is an advisory lock on a VM's runtime directory, held while starting or
+// stopping the VM so concurrent qqmgr invocations do not race on its PID file
+// and sockets. The kernel drops the lock when the process exits.
+type VMLock struct {
+	file *os.File
+}
+
+// LockVM blocks until it holds the lock of the VM, creating the runtime
+// directory if needed
+func LockVM(vmEntry *config.VmEntry) (*VMLock, error) {
+	if err := os.MkdirAll(vmEntry.DataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+
+	file, err := os.OpenFile(vmEntry.LockFilePath(), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", vmEntry.LockFilePath(), err)
+	}
+	return &VMLock{file: file}, nil
+}
+
+// Unlock releases the lock, it is safe to call more than once
+func (l *VMLock) Unlock() {
+	if l == nil || l.file == nil {
+		return
+	}
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vmutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"qqmgr/internal/config"
+	"strconv"
+	"syscall"
+)
+
+// PortInUseError reports a hostfwd host port which is already taken on the host
+type PortInUseError struct {
+	Port  int64
+	Owner string // VM configured to use the port, empty if none is
+}
+
+func (e *PortInUseError) Error() string {
+	if e.Owner != "" {
+		return fmt.Sprintf("host port %d already in use (possibly VM '%s')", e.Port, e.Owner)
+	}
+	return fmt.Sprintf("host port %d already in use", e.Port)
+}
+
+// ProbeHostPort checks that the host side of fwd can be bound, the way QEMU
+// will bind it. An empty host address binds all addresses, like QEMU does.
+func ProbeHostPort(fwd config.HostFwd) error {
+	addr := net.JoinHostPort(fwd.HostAddr, strconv.FormatInt(fwd.HostPort, 10))
+	if fwd.Proto == "udp" {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return listener.Close()
+}
+
+// CheckHostPorts probes the host ports of the hostfwd rules in the VM's
+// command, so a taken port fails before QEMU does with a bind error buried in
+// its log. owners names the VM configured for a port, see Config.HostPortOwners.
+// Arguments with rules which fail to parse are skipped, start warns about them.
+func CheckHostPorts(vmEntry *config.VmEntry, owners map[int64]string) error {
+	var rules []config.HostFwd
+	for _, arg := range vmEntry.Cmd {
+		argRules, err := config.FindHostFwds([]string{arg})
+		if err != nil {
+			continue
+		}
+		rules = append(rules, argRules...)
+	}
+
+	var errs []error
+	for _, fwd := range rules {
+		// Port 0 lets the host pick a free port
+		if fwd.HostPort == 0 {
+			continue
+		}
+		err := ProbeHostPort(fwd)
+		if errors.Is(err, syscall.EADDRINUSE) {
+			errs = append(errs, &PortInUseError{Port: fwd.HostPort, Owner: owners[fwd.HostPort]})
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("host port %d: %w", fwd.HostPort, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vmutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"qqmgr/internal/config"
+)
+
+// freePort returns a TCP port on localhost that is free right now
+func freePort(t *testing.T) int64 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return int64(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestCheckHostPorts(t *testing.T) {
+	port := freePort(t)
+	vmEntry := &config.VmEntry{
+		Name: "foo",
+		Cmd: []string{
+			fmt.Sprintf("-nic user,hostfwd=tcp:127.0.0.1:%d-:22,hostfwd=tcp::0-:80", port),
+			"-nic user,hostfwd=tcp::bad-:22", // Skipped, start only warns about it
+		},
+	}
+	owners := map[int64]string{port: "bar"}
+
+	if err := CheckHostPorts(vmEntry, owners); err != nil {
+		t.Fatalf("Expected free ports to pass, got %v", err)
+	}
+
+	// Another process holding the port fails the check, naming the VM configured for it
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("Failed to occupy port %d: %v", port, err)
+	}
+	defer listener.Close()
+
+	err = CheckHostPorts(vmEntry, owners)
+	var inUse *PortInUseError
+	if !errors.As(err, &inUse) || inUse.Port != port {
+		t.Fatalf("Expected PortInUseError for port %d, got %v", port, err)
+	}
+	want := fmt.Sprintf("host port %d already in use (possibly VM 'bar')", port)
+	if err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+
+	// Without a known owner the message only names the port
+	if err := CheckHostPorts(vmEntry, nil); err == nil || err.Error() != fmt.Sprintf("host port %d already in use", port) {
+		t.Errorf("Expected an ownerless conflict, got %v", err)
+	}
+}
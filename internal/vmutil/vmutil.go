@@ -3,6 +3,7 @@
 package vmutil
 
 import (
+	"fmt"
 	"os"
 	"qqmgr/internal/config"
 )
@@ -12,3 +13,14 @@ func DeleteLogFiles(vmEntry *config.VmEntry) {
 	_ = os.Remove(vmEntry.QemuStdoutPath())
 	_ = os.Remove(vmEntry.QemuStderrPath())
 }
+
+// RotateLogFiles moves existing stdout/stderr log files for a VM to <log>.1,
+// replacing any previously rotated logs
+func RotateLogFiles(vmEntry *config.VmEntry) error {
+	for _, path := range []string{vmEntry.QemuStdoutPath(), vmEntry.QemuStderrPath()} {
+		if err := os.Rename(path, path+".1"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate %s: %w", path, err)
+		}
+	}
+	return nil
+}
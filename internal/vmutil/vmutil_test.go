@@ -0,0 +1,40 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-FileCopyrightText: 2025 Jesper Devantier <jwd@defmacro.it>
+package vmutil
+
+import (
+	"os"
+	"testing"
+
+	"qqmgr/internal/config"
+)
+
+func TestRotateLogFiles(t *testing.T) {
+	vmEntry := &config.VmEntry{Name: "test-vm", DataDir: t.TempDir()}
+
+	if err := os.WriteFile(vmEntry.QemuStdoutPath(), []byte("crash trace"), 0644); err != nil {
+		t.Fatalf("Failed to write stdout log: %v", err)
+	}
+	if err := os.WriteFile(vmEntry.QemuStderrPath()+".1", []byte("older run"), 0644); err != nil {
+		t.Fatalf("Failed to write rotated stderr log: %v", err)
+	}
+
+	// A missing stderr log is not an error and leaves its older rotation alone
+	if err := RotateLogFiles(vmEntry); err != nil {
+		t.Fatalf("RotateLogFiles() error = %v", err)
+	}
+
+	data, err := os.ReadFile(vmEntry.QemuStdoutPath() + ".1")
+	if err != nil {
+		t.Fatalf("Expected prior stdout log to be preserved as .1: %v", err)
+	}
+	if string(data) != "crash trace" {
+		t.Errorf("rotated stdout log = %q, want %q", data, "crash trace")
+	}
+	if _, err := os.Stat(vmEntry.QemuStdoutPath()); !os.IsNotExist(err) {
+		t.Errorf("Expected stdout log to be moved away, stat error = %v", err)
+	}
+	if data, _ := os.ReadFile(vmEntry.QemuStderrPath() + ".1"); string(data) != "older run" {
+		t.Errorf("rotated stderr log = %q, want %q", data, "older run")
+	}
+}